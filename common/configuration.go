@@ -104,6 +104,10 @@ func SetConfig(cfgFile string) error {
 		return err
 	}
 
+	if err := setGitStatusConfig(viper.GetStringMap("gitstatus")); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -156,6 +160,16 @@ domain = "hub.opshub.sh"
 [singular]
 provider = "digitalocean"
 token = "435a054fba66cb11d6b7abeaa3d89aac777d4d1d"
+
+# 6. Configurations for commit status of Git providers, the name after gitstatus is the address
+#    of the `commit-status` receiver in the flow. The type is github, gitlab or gitea.
+
+[gitstatus.github]
+type = "github"
+api = "https://api.github.com"
+token = "GITHUB_TOKEN"
+target = "https://pilotage.opshub.sh"
+context = "pilotage"
 */
 
 type DatabaseConfig struct {
@@ -209,6 +223,14 @@ type MailConfig struct {
 	Password    string `json:"password" yaml:"password"`
 }
 
+type GitStatusConfig struct {
+	Type    string `json:"type" yaml:"type" description:"The API type of Git provider, 'github', 'gitlab' or 'gitea'"`
+	API     string `json:"api" yaml:"api" description:"The API base URL, like https://gitlab.example.com/api/v4"`
+	Token   string `json:"token" yaml:"token"`
+	Target  string `json:"target" yaml:"target" description:"The URL of pilotage daemon linked by the commit status"`
+	Context string `json:"context" yaml:"context" description:"The context of commit status, the default is 'pilotage'"`
+}
+
 var Database DatabaseConfig
 var Web WebConfig
 var Storage StorageConfig
@@ -216,6 +238,7 @@ var Warship WarshipConfig
 var Singular SingularConfig
 var Assembling AssemblingConfig
 var Mail MailConfig
+var GitStatus map[string]GitStatusConfig
 
 func setDatabaseConfig(config map[string]interface{}) error {
 	bs, err := json.Marshal(&config)
@@ -288,3 +311,13 @@ func setMailConfig(config map[string]interface{}) error {
 	}
 	return nil
 }
+
+func setGitStatusConfig(config map[string]interface{}) error {
+	bs, err := json.Marshal(&config)
+	if err != nil {
+		return err
+	}
+
+	GitStatus = map[string]GitStatusConfig{}
+	return json.Unmarshal(bs, &GitStatus)
+}
@@ -281,12 +281,25 @@ func TokenMarshal(v interface{}, key string) ([]byte, error) {
 
 //TokenUnmarshal decryptes a token and save the original data to `v`.
 func TokenUnmarshal(token string, key string, v interface{}) error {
+	return TokenUnmarshalTTL(token, key, time.Hour, v)
+}
+
+// tokenMinLength is the decoded length of the fernet token with an empty message: version,
+// timestamp, IV, one padding block and HMAC. The fernet library panics on the shorter ones.
+const tokenMinLength = 1 + 8 + 16 + 16 + 32
+
+//TokenUnmarshalTTL decryptes a token signed in `ttl` and save the original data to `v`.
+func TokenUnmarshalTTL(token string, key string, ttl time.Duration, v interface{}) error {
 	k, err := fernet.DecodeKey(key)
 	if err != nil {
 		return err
 	}
 
-	msg := fernet.VerifyAndDecrypt([]byte(token), time.Hour, []*fernet.Key{k})
+	if decoded, err := base64.URLEncoding.DecodeString(token); err != nil || len(decoded) < tokenMinLength {
+		return errors.New("Invalid token")
+	}
+
+	msg := fernet.VerifyAndDecrypt([]byte(token), ttl, []*fernet.Key{k})
 	if msg == nil {
 		return errors.New("invalid or expired token")
 	}
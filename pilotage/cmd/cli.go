@@ -19,6 +19,7 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strconv"
 
 	. "github.com/logrusorgru/aurora"
 	"github.com/spf13/cobra"
@@ -44,10 +45,28 @@ https://kubernetes.io/docs/user-guide/kubectl-overview
 var runCliCmd = &cobra.Command{
 	Use:   "run",
 	Short: "Run a orchestration flow.",
-	Long:  ``,
-	Run:   runCliFlow,
+	Long: `Run a orchestration flow.
+
+With --dry-run, the engine prints the Kubernetes Jobs of all jobs in execution order as YAML and
+doesn't connect the Kubernetes cluster. The outputs of jobs are printed as placeholders.`,
+	Run: runCliFlow,
+}
+
+var rerunCliCmd = &cobra.Command{
+	Use:   "rerun <namespace/repository/flow> <tag> <number>",
+	Short: "Rerun a recorded flow run from a stage, action or job.",
+	Long: `Rerun a recorded flow run from the database. The stages, actions and jobs before the
+start point are reused with the outputs of the parent run, and the new run links to it.
+
+Without --stage, the rerun starts from the first stage not succeeded. In a parallel stage,
+--action reruns the action and all other actions not succeeded.`,
+	Run: rerunCliFlow,
 }
 
+var dryRun bool
+var parameters []string
+var rerunStage, rerunAction, rerunJob string
+
 // init()
 func init() {
 	// Add cli sub command.
@@ -56,6 +75,16 @@ func init() {
 	//Add run sub command to cli.
 	cliCmd.AddCommand(runCliCmd)
 
+	runCliCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the Kubernetes Jobs of flow without running.")
+	runCliCmd.Flags().StringArrayVar(&parameters, "param", []string{}, "Override a parameter of flow with key=value, referenced by ${{ parameters.key }}.")
+
+	//Add rerun sub command to cli.
+	cliCmd.AddCommand(rerunCliCmd)
+
+	rerunCliCmd.Flags().StringVar(&rerunStage, "stage", "", "The stage name rerun from.")
+	rerunCliCmd.Flags().StringVar(&rerunAction, "action", "", "The action name rerun from, requires --stage.")
+	rerunCliCmd.Flags().StringVar(&rerunJob, "job", "", "The job name rerun from, requires --action.")
+
 }
 
 // Run orchestration flow from a flow definition file.
@@ -75,6 +104,45 @@ func runCliFlow(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
+	if err := flow.SetParameters(parameters); err != nil {
+		cmd.Println(Red(fmt.Sprintf("Execute orchestration flow error: %s", err.Error())))
+		os.Exit(1)
+	}
+
+	if dryRun == true {
+		if err := flow.Render(os.Stdout); err != nil {
+			cmd.Println(Red(fmt.Sprintf("Render orchestration flow error: %s", err.Error())))
+			os.Exit(1)
+		}
+		return
+	}
+
 	flow.LocalRun(verbose, timestamp)
 
 }
+
+// Rerun a recorded flow run from a stage, action or job.
+func rerunCliFlow(cmd *cobra.Command, args []string) {
+	model.OpenDatabase(&common.Database)
+	model.Migrate()
+
+	if len(args) != 3 {
+		cmd.Println(Red("The flow URI, tag and run number are required."))
+		os.Exit(1)
+	}
+
+	number, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		cmd.Println(Red(fmt.Sprintf("Invalid flow run number: %s", args[2])))
+		os.Exit(1)
+	}
+
+	flow, err := module.NewRerun(args[0], args[1], number, rerunStage, rerunAction, rerunJob)
+	if err != nil {
+		cmd.Println(Red(fmt.Sprintf("Rerun orchestration flow error: %s", err.Error())))
+		os.Exit(1)
+	}
+	flow.Model = module.CliRun
+
+	flow.LocalRun(verbose, timestamp)
+}
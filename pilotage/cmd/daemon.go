@@ -33,8 +33,10 @@ import (
 
 	"github.com/Huawei/containerops/common"
 	"github.com/Huawei/containerops/common/utils"
+	"github.com/Huawei/containerops/pilotage/config"
 	"github.com/Huawei/containerops/pilotage/middleware"
 	"github.com/Huawei/containerops/pilotage/model"
+	"github.com/Huawei/containerops/pilotage/module"
 	"github.com/Huawei/containerops/pilotage/router"
 )
 
@@ -176,6 +178,13 @@ func startDaemonFlow(cmd *cobra.Command, args []string) {
 	model.OpenDatabase(&common.Database)
 	model.Migrate()
 
+	module.InitQueue(config.Queue.Workers, true, true)
+	if err := module.Recover(true, true); err != nil {
+		cmd.Println(Red("Recover the flow runs error: "), Red(err.Error()))
+	}
+	module.StartSweeper(config.GC.Sweep, true, true)
+	module.StartGitOps(true, true)
+
 	m := macaron.New()
 	middleware.SetStartDaemonMiddlewares(m, cfgFile)
 	router.SetStartDaemonRouters(m)
@@ -239,6 +248,7 @@ func startDaemonFlow(cmd *cobra.Command, args []string) {
 		defer cancel()
 		server.Shutdown(ctx)
 	}
+	module.FlushLogs()
 
 	cmd.Println(Green(("pilotage daemon gracefully stopped")))
 }
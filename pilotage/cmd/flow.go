@@ -0,0 +1,143 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	. "github.com/logrusorgru/aurora"
+	"github.com/spf13/cobra"
+
+	"github.com/Huawei/containerops/common/utils"
+	"github.com/Huawei/containerops/pilotage/module"
+)
+
+var flowCmd = &cobra.Command{
+	Use:   "flow",
+	Short: "pilotage flow definition tools",
+	Long: `Pilotage flow command checks the orchestration flow definition file without running it,
+and doesn't need the database or Kubernetes cluster.`,
+}
+
+var validateFlowCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate a orchestration flow file.",
+	Long: `Validate the schema and semantics of a orchestration flow file. All problems are printed
+with the YAML line number:
+
+1. The flow URI, stage types, sequencing and timeouts.
+2. The unique names of stages, actions and jobs.
+3. The subscriptions reference the outputs of jobs run before.
+4. The resource quantities, endpoint and script image references of jobs.
+5. The clusters of flow, stages and jobs are in the cluster config.`,
+	Run: validateFlow,
+}
+
+var graphFlowCmd = &cobra.Command{
+	Use:   "graph <file>",
+	Short: "Render the graph of a orchestration flow file.",
+	Long: `Render the stages, actions and jobs of a orchestration flow file as Graphviz DOT or SVG.
+The stages and actions are boxes, the bold edges are the running order, the solid edges are the
+subscriptions and the dashed edges are the outputs expressions, labelled with the output key.
+
+The SVG format requires the Graphviz dot command:
+
+  pilotage flow graph flow.yaml --format svg --output flow.svg`,
+	Run: graphFlow,
+}
+
+var graphFormat, graphOutput string
+
+// init()
+func init() {
+	// Add flow sub command.
+	RootCmd.AddCommand(flowCmd)
+
+	//Add validate sub command to flow.
+	flowCmd.AddCommand(validateFlowCmd)
+
+	//Add graph sub command to flow.
+	flowCmd.AddCommand(graphFlowCmd)
+
+	graphFlowCmd.Flags().StringVar(&graphFormat, "format", module.GraphDOT, "The graph format, dot or svg.")
+	graphFlowCmd.Flags().StringVar(&graphOutput, "output", "", "The graph file, the default is stdout.")
+}
+
+// Validate the orchestration flow definition file.
+func validateFlow(cmd *cobra.Command, args []string) {
+	if len(args) <= 0 || utils.IsFileExist(args[0]) == false {
+		cmd.Println(Red("The orchestration flow file is required."))
+		os.Exit(1)
+	}
+
+	data, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		cmd.Println(Red(fmt.Sprintf("Read orchestration flow file error: %s", err.Error())))
+		os.Exit(1)
+	}
+
+	flow := new(module.Flow)
+	if errs := flow.ParseYAML(data, args[0]); len(errs) > 0 {
+		for _, e := range errs {
+			cmd.Println(Red(fmt.Sprintf("%s: %s", args[0], e.Error())))
+		}
+		os.Exit(1)
+	}
+
+	cmd.Println(Green(fmt.Sprintf("%s is a valid orchestration flow.", args[0])))
+}
+
+// Render the graph of orchestration flow definition file.
+func graphFlow(cmd *cobra.Command, args []string) {
+	if len(args) <= 0 || utils.IsFileExist(args[0]) == false {
+		cmd.Println(Red("The orchestration flow file is required."))
+		os.Exit(1)
+	}
+
+	data, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		cmd.Println(Red(fmt.Sprintf("Read orchestration flow file error: %s", err.Error())))
+		os.Exit(1)
+	}
+
+	flow := new(module.Flow)
+	if errs := flow.ParseYAML(data, args[0]); len(errs) > 0 {
+		for _, e := range errs {
+			cmd.Println(Red(fmt.Sprintf("%s: %s", args[0], e.Error())))
+		}
+		os.Exit(1)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := flow.Graph(buf, graphFormat); err != nil {
+		cmd.Println(Red(fmt.Sprintf("Render orchestration flow graph error: %s", err.Error())))
+		os.Exit(1)
+	}
+
+	if graphOutput == "" {
+		os.Stdout.Write(buf.Bytes())
+		return
+	}
+
+	if err := ioutil.WriteFile(graphOutput, buf.Bytes(), 0644); err != nil {
+		cmd.Println(Red(fmt.Sprintf("Write orchestration flow graph error: %s", err.Error())))
+		os.Exit(1)
+	}
+}
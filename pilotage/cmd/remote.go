@@ -0,0 +1,422 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	. "github.com/logrusorgru/aurora"
+	"github.com/spf13/cobra"
+
+	"github.com/Huawei/containerops/common/utils"
+	"github.com/Huawei/containerops/pilotage/module"
+)
+
+var remoteCmd = &cobra.Command{
+	Use:   "remote",
+	Short: "pilotage remote client of daemon",
+	Long: `Pilotage remote command talks to the REST API of a pilotage daemon started by 'daemon start'.
+The server is an HTTPS or HTTP URL, or the socket file of daemon in unix mode:
+
+  pilotage remote runs --server https://pilotage.example.com:8443
+  pilotage remote runs --server unix:///var/run/pilotage.sock
+
+The daemon requiring API tokens is requested with --token, or the PILOTAGE_TOKEN environment.`,
+}
+
+var runRemoteCmd = &cobra.Command{
+	Use:   "run <file>",
+	Short: "Submit a orchestration flow file to the daemon.",
+	Long: `Submit a orchestration flow file to the daemon. The includes and templates of flow are
+expanded in local, so the relative includes work. With --follow, the logs of run are printed
+until it finishes.`,
+	Run: runRemoteFlow,
+}
+
+var runsRemoteCmd = &cobra.Command{
+	Use:   "runs",
+	Short: "List the pending and running flow runs of the daemon.",
+	Run:   listRemoteRuns,
+}
+
+var logsRemoteCmd = &cobra.Command{
+	Use:   "logs <id>",
+	Short: "Print the logs of a flow run.",
+	Run:   logsRemoteRun,
+}
+
+var cancelRemoteCmd = &cobra.Command{
+	Use:   "cancel <id>",
+	Short: "Cancel a pending or running flow run.",
+	Run:   cancelRemoteRun,
+}
+
+var approveRemoteCmd = &cobra.Command{
+	Use:   "approve <id> <stage>",
+	Short: "Approve a pause stage of a flow run.",
+	Run:   approveRemoteRun,
+}
+
+var outputsRemoteCmd = &cobra.Command{
+	Use:   "outputs <id>",
+	Short: "Print the outputs of jobs in a flow run.",
+	Run:   outputsRemoteRun,
+}
+
+var serverOption, caCertFile, tokenOption string
+var insecure, follow bool
+var remoteParameters []string
+
+// init()
+func init() {
+	// Add remote sub command.
+	RootCmd.AddCommand(remoteCmd)
+
+	remoteCmd.PersistentFlags().StringVar(&serverOption, "server", "", "The daemon URL https://host:port, or unix:///path/to/socket.")
+	remoteCmd.PersistentFlags().StringVar(&caCertFile, "cacert", "", "The CA cert file to verify the daemon in HTTPS mode.")
+	remoteCmd.PersistentFlags().BoolVar(&insecure, "insecure", false, "Skip the verification of daemon cert in HTTPS mode.")
+	remoteCmd.PersistentFlags().StringVar(&tokenOption, "token", os.Getenv("PILOTAGE_TOKEN"), "The API token of daemon, the default is the PILOTAGE_TOKEN environment.")
+
+	//Add sub commands to remote.
+	remoteCmd.AddCommand(runRemoteCmd)
+	remoteCmd.AddCommand(runsRemoteCmd)
+	remoteCmd.AddCommand(logsRemoteCmd)
+	remoteCmd.AddCommand(cancelRemoteCmd)
+	remoteCmd.AddCommand(approveRemoteCmd)
+	remoteCmd.AddCommand(outputsRemoteCmd)
+
+	runRemoteCmd.Flags().StringArrayVar(&remoteParameters, "param", []string{}, "Override a parameter of flow with key=value, referenced by ${{ parameters.key }}.")
+	runRemoteCmd.Flags().BoolVar(&follow, "follow", false, "Print the logs of run until it finishes.")
+	logsRemoteCmd.Flags().BoolVar(&follow, "follow", false, "Print the logs of run until it finishes.")
+}
+
+// remoteClient is the HTTP client of daemon REST API.
+type remoteClient struct {
+	base   string
+	token  string
+	client *http.Client
+}
+
+// newRemoteClient returns the client of the server, the unix socket server is requested with
+// the fake host 'pilotage'.
+func newRemoteClient(server, caCert, token string, insecure bool) (*remoteClient, error) {
+	if server == "" {
+		return nil, fmt.Errorf("The daemon server is required")
+	}
+
+	u, err := url.Parse(server)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid daemon server %s: %s", server, err.Error())
+	}
+
+	transport := &http.Transport{}
+	c := &remoteClient{base: strings.TrimSuffix(server, "/"), token: token, client: &http.Client{Transport: transport}}
+
+	switch u.Scheme {
+	case "http":
+	case "https":
+		config := &tls.Config{InsecureSkipVerify: insecure}
+		if caCert != "" {
+			pem, err := ioutil.ReadFile(caCert)
+			if err != nil {
+				return nil, fmt.Errorf("Read CA cert file error: %s", err.Error())
+			}
+			config.RootCAs = x509.NewCertPool()
+			if config.RootCAs.AppendCertsFromPEM(pem) == false {
+				return nil, fmt.Errorf("No cert in CA cert file %s", caCert)
+			}
+		}
+		transport.TLSClientConfig = config
+	case "unix":
+		socket := u.Path
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		}
+		c.base = "http://pilotage"
+	default:
+		return nil, fmt.Errorf("Unsupported daemon server scheme %q, it should be https, http or unix", u.Scheme)
+	}
+
+	return c, nil
+}
+
+// do requests the API and decodes the JSON response into result, the message of error
+// response is returned as error.
+func (c *remoteClient) do(method, path string, body io.Reader, result interface{}) error {
+	req, err := http.NewRequest(method, c.base+path, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.token))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		message := struct {
+			Message string                  `json:"message"`
+			Errors  module.ValidationErrors `json:"errors"`
+		}{}
+		if err := json.Unmarshal(data, &message); err != nil || message.Message == "" {
+			return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+		}
+		if len(message.Errors) > 0 {
+			return fmt.Errorf("%s: %s", message.Message, message.Errors.Error())
+		}
+		return fmt.Errorf("%s", message.Message)
+	}
+
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(data, result)
+}
+
+// remoteClientOrExit returns the client of --server, or exits.
+func remoteClientOrExit(cmd *cobra.Command) *remoteClient {
+	c, err := newRemoteClient(serverOption, caCertFile, tokenOption, insecure)
+	if err != nil {
+		cmd.Println(Red(err.Error()))
+		os.Exit(1)
+	}
+
+	return c
+}
+
+// Submit a flow file to the daemon.
+func runRemoteFlow(cmd *cobra.Command, args []string) {
+	if len(args) <= 0 || utils.IsFileExist(args[0]) == false {
+		cmd.Println(Red("The orchestration flow file is required."))
+		os.Exit(1)
+	}
+
+	c := remoteClientOrExit(cmd)
+
+	flow := new(module.Flow)
+	if err := flow.ParseFlowFromFile(args[0], module.DaemonRun, verbose, timestamp); err != nil {
+		cmd.Println(Red(fmt.Sprintf("Submit orchestration flow error: %s", err.Error())))
+		os.Exit(1)
+	}
+
+	namespace, repository, name, err := flow.URIs()
+	if err != nil {
+		cmd.Println(Red(fmt.Sprintf("Submit orchestration flow error: %s", err.Error())))
+		os.Exit(1)
+	}
+
+	data, err := flow.Snapshot()
+	if err != nil {
+		cmd.Println(Red(fmt.Sprintf("Submit orchestration flow error: %s", err.Error())))
+		os.Exit(1)
+	}
+
+	query := url.Values{}
+	for _, p := range remoteParameters {
+		query.Add("param", p)
+	}
+
+	path := fmt.Sprintf("/flow/v1/%s/%s/%s/%s/json", namespace, repository, name, flow.Tag)
+	if len(query) > 0 {
+		path = fmt.Sprintf("%s?%s", path, query.Encode())
+	}
+
+	run := struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}{}
+	if err := c.do(http.MethodPost, path, bytes.NewReader(data), &run); err != nil {
+		cmd.Println(Red(fmt.Sprintf("Submit orchestration flow error: %s", err.Error())))
+		os.Exit(1)
+	}
+
+	cmd.Println(Green(fmt.Sprintf("Flow [%s] is submitted as run %s, status %s", flow.URI, run.ID, run.Status)))
+
+	if follow == true {
+		followRemoteLogs(cmd, c, run.ID)
+	}
+}
+
+// List the runs in the queue of daemon.
+func listRemoteRuns(cmd *cobra.Command, args []string) {
+	c := remoteClientOrExit(cmd)
+
+	runs := struct {
+		Workers int          `json:"workers"`
+		Pending []module.Run `json:"pending"`
+		Running []module.Run `json:"running"`
+	}{}
+	if err := c.do(http.MethodGet, "/flow/v1/runs", nil, &runs); err != nil {
+		cmd.Println(Red(fmt.Sprintf("List flow runs error: %s", err.Error())))
+		os.Exit(1)
+	}
+
+	cmd.Println(fmt.Sprintf("Workers: %d, running: %d, pending: %d", runs.Workers, len(runs.Running), len(runs.Pending)))
+	for _, r := range append(runs.Running, runs.Pending...) {
+		cmd.Println(fmt.Sprintf("%s\t%s:%s\t%s\t%s", r.ID, r.URI, r.Tag, r.Status, r.Queued.Format(time.RFC3339)))
+	}
+}
+
+// Print the logs of a run.
+func logsRemoteRun(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		cmd.Println(Red("The flow run id is required."))
+		os.Exit(1)
+	}
+
+	c := remoteClientOrExit(cmd)
+
+	if follow == true {
+		followRemoteLogs(cmd, c, args[0])
+		return
+	}
+
+	if _, _, err := printRemoteLogs(cmd, c, args[0], 0); err != nil {
+		cmd.Println(Red(fmt.Sprintf("Get flow run logs error: %s", err.Error())))
+		os.Exit(1)
+	}
+}
+
+// followRemoteLogs prints the logs of run until it isn't pending or running, and exits with 1
+// when the run doesn't succeed.
+func followRemoteLogs(cmd *cobra.Command, c *remoteClient, id string) {
+	offset := 0
+	for {
+		status, next, err := printRemoteLogs(cmd, c, id, offset)
+		if err != nil {
+			cmd.Println(Red(fmt.Sprintf("Get flow run logs error: %s", err.Error())))
+			os.Exit(1)
+		}
+		offset = next
+
+		switch status {
+		case module.Pending, module.Running:
+			time.Sleep(time.Second * 2)
+		case module.Success:
+			cmd.Println(Green(fmt.Sprintf("Flow run %s is %s", id, status)))
+			return
+		default:
+			cmd.Println(Red(fmt.Sprintf("Flow run %s is %s", id, status)))
+			os.Exit(1)
+		}
+	}
+}
+
+// printRemoteLogs prints the logs from the offset, returns the run status and the next offset.
+func printRemoteLogs(cmd *cobra.Command, c *remoteClient, id string, offset int) (string, int, error) {
+	logs := struct {
+		Status string   `json:"status"`
+		Offset int      `json:"offset"`
+		Logs   []string `json:"logs"`
+	}{}
+	if err := c.do(http.MethodGet, fmt.Sprintf("/flow/v1/runs/%s/logs?offset=%d", url.PathEscape(id), offset), nil, &logs); err != nil {
+		return "", offset, err
+	}
+
+	for _, l := range logs.Logs {
+		cmd.Println(l)
+	}
+
+	return logs.Status, logs.Offset, nil
+}
+
+// Cancel a run.
+func cancelRemoteRun(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		cmd.Println(Red("The flow run id is required."))
+		os.Exit(1)
+	}
+
+	c := remoteClientOrExit(cmd)
+
+	if err := c.do(http.MethodPost, fmt.Sprintf("/flow/v1/runs/%s/cancel", url.PathEscape(args[0])), nil, nil); err != nil {
+		cmd.Println(Red(fmt.Sprintf("Cancel flow run error: %s", err.Error())))
+		os.Exit(1)
+	}
+
+	cmd.Println(Green(fmt.Sprintf("Flow run %s is canceled", args[0])))
+}
+
+// Approve a pause stage of run.
+func approveRemoteRun(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		cmd.Println(Red("The flow run id and the pause stage name are required."))
+		os.Exit(1)
+	}
+
+	c := remoteClientOrExit(cmd)
+
+	path := fmt.Sprintf("/flow/v1/runs/%s/approve?stage=%s", url.PathEscape(args[0]), url.QueryEscape(args[1]))
+	if err := c.do(http.MethodPost, path, nil, nil); err != nil {
+		cmd.Println(Red(fmt.Sprintf("Approve flow run error: %s", err.Error())))
+		os.Exit(1)
+	}
+
+	cmd.Println(Green(fmt.Sprintf("Stage [%s] of flow run %s is approved", args[1], args[0])))
+}
+
+// Print the outputs of jobs in a run.
+func outputsRemoteRun(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		cmd.Println(Red("The flow run id is required."))
+		os.Exit(1)
+	}
+
+	c := remoteClientOrExit(cmd)
+
+	outputs := map[string]string{}
+	if err := c.do(http.MethodGet, fmt.Sprintf("/flow/v1/runs/%s/outputs", url.PathEscape(args[0])), nil, &outputs); err != nil {
+		cmd.Println(Red(fmt.Sprintf("Get flow run outputs error: %s", err.Error())))
+		os.Exit(1)
+	}
+
+	keys := []string{}
+	for k := range outputs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		cmd.Println(fmt.Sprintf("%s=%s", k, outputs[k]))
+	}
+}
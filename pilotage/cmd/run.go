@@ -0,0 +1,172 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	. "github.com/logrusorgru/aurora"
+	"github.com/spf13/cobra"
+
+	"github.com/Huawei/containerops/common"
+	"github.com/Huawei/containerops/pilotage/model"
+	"github.com/Huawei/containerops/pilotage/module"
+)
+
+var runCmd = &cobra.Command{
+	Use:   "run",
+	Short: "pilotage flow run archive",
+	Long: `Pilotage run command exports a finished flow run with its definition, parameters, outputs,
+timings of stages, actions and jobs, test results and logs into a tar.gz archive, and imports
+the archive into the history of another pilotage:
+
+  pilotage run export cncf/demo/hello latest 3 --output hello-3.tar.gz
+  pilotage run import hello-3.tar.gz
+
+The imported run gets the next run number of the flow.`,
+}
+
+var exportRunCmd = &cobra.Command{
+	Use:   "export <run id> | <namespace/repository/flow> <tag> <number>",
+	Short: "Export a flow run archive.",
+	Run:   exportRun,
+}
+
+var importRunCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Import a flow run archive.",
+	Run:   importRun,
+}
+
+var archiveOutput string
+
+// init()
+func init() {
+	// Add run sub command.
+	RootCmd.AddCommand(runCmd)
+
+	//Add sub commands to run.
+	runCmd.AddCommand(exportRunCmd)
+	runCmd.AddCommand(importRunCmd)
+
+	exportRunCmd.Flags().StringVarP(&archiveOutput, "output", "o", "", "The archive file, - is the stdout. The default is <namespace>-<repository>-<flow>-<tag>-<number>.tar.gz.")
+}
+
+// Export a flow run archive.
+func exportRun(cmd *cobra.Command, args []string) {
+	if len(args) != 1 && len(args) != 3 {
+		cmd.Println(Red("The run id, or the flow URI, tag and run number are required."))
+		os.Exit(1)
+	}
+
+	model.OpenDatabase(&common.Database)
+	model.Migrate()
+
+	data := new(model.FlowDataV1)
+	if len(args) == 1 {
+		id, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			cmd.Println(Red(fmt.Sprintf("Invalid flow run id: %s", args[0])))
+			os.Exit(1)
+		}
+
+		if err := data.GetByID(id); err != nil {
+			cmd.Println(Red(fmt.Sprintf("Export flow run error: %s", err.Error())))
+			os.Exit(1)
+		}
+	} else {
+		number, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			cmd.Println(Red(fmt.Sprintf("Invalid flow run number: %s", args[2])))
+			os.Exit(1)
+		}
+
+		if data, err = module.FindRun(args[0], args[1], number); err != nil {
+			cmd.Println(Red(fmt.Sprintf("Export flow run error: %s", err.Error())))
+			os.Exit(1)
+		}
+	}
+
+	output := archiveOutput
+	if output == "" {
+		flow := new(model.FlowV1)
+		if err := flow.GetByID(data.FlowID); err != nil {
+			cmd.Println(Red(fmt.Sprintf("Export flow run error: %s", err.Error())))
+			os.Exit(1)
+		}
+		output = fmt.Sprintf("%s-%s-%s-%s-%d.tar.gz", flow.Namespace, flow.Repository, flow.Name, flow.Tag, data.Number)
+	}
+
+	var w io.Writer = os.Stdout
+	if output != "-" {
+		file, err := os.Create(output)
+		if err != nil {
+			cmd.Println(Red(fmt.Sprintf("Create archive file error: %s", err.Error())))
+			os.Exit(1)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if err := module.ExportRun(w, data); err != nil {
+		if output != "-" {
+			os.Remove(output)
+		}
+		cmd.Println(Red(fmt.Sprintf("Export flow run error: %s", err.Error())))
+		os.Exit(1)
+	}
+
+	if output != "-" {
+		cmd.Println(Green(fmt.Sprintf("Flow run %d is exported to %s.", data.Number, output)))
+	}
+}
+
+// Import a flow run archive.
+func importRun(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		cmd.Println(Red("The archive file is required."))
+		os.Exit(1)
+	}
+
+	var r io.Reader = os.Stdin
+	if args[0] != "-" {
+		file, err := os.Open(args[0])
+		if err != nil {
+			cmd.Println(Red(fmt.Sprintf("Open archive file error: %s", err.Error())))
+			os.Exit(1)
+		}
+		defer file.Close()
+		r = file
+	}
+
+	model.OpenDatabase(&common.Database)
+	model.Migrate()
+
+	data, err := module.ImportRun(r)
+	if err != nil {
+		cmd.Println(Red(fmt.Sprintf("Import flow run error: %s", err.Error())))
+		os.Exit(1)
+	}
+
+	flow := new(model.FlowV1)
+	flow.GetByID(data.FlowID)
+	cmd.Println(Green(fmt.Sprintf("Flow run is imported as %s/%s/%s:%s run %d.", flow.Namespace, flow.Repository,
+		flow.Name, flow.Tag, data.Number)))
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/logrusorgru/aurora"
+	"github.com/spf13/cobra"
+
+	"github.com/Huawei/containerops/common"
+	"github.com/Huawei/containerops/pilotage/model"
+	"github.com/Huawei/containerops/pilotage/module"
+)
+
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "pilotage API token management",
+	Long: `Pilotage token command manages the API tokens of daemon. The daemon requires tokens when
+the key of auth section is set in the config, and the tokens are signed with the key:
+
+  pilotage token key
+  pilotage token create --name ci --namespace cncf --action run,read --expires 720h
+
+A token is allowed to do the actions run, cancel, approve and read in its namespaces, the
+namespace * allows all namespaces and the global APIs like /flow/v1/analytics.`,
+}
+
+var keyTokenCmd = &cobra.Command{
+	Use:   "key",
+	Short: "Generate a key for the auth config.",
+	Run:   generateTokenKey,
+}
+
+var createTokenCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create an API token, it's only printed once.",
+	Run:   createToken,
+}
+
+var listTokenCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the API tokens.",
+	Run:   listTokens,
+}
+
+var revokeTokenCmd = &cobra.Command{
+	Use:   "revoke <id>",
+	Short: "Revoke an API token.",
+	Run:   revokeToken,
+}
+
+var tokenName string
+var tokenNamespaces, tokenActions []string
+var tokenExpires time.Duration
+
+// init()
+func init() {
+	// Add token sub command.
+	RootCmd.AddCommand(tokenCmd)
+
+	//Add sub commands to token.
+	tokenCmd.AddCommand(keyTokenCmd)
+	tokenCmd.AddCommand(createTokenCmd)
+	tokenCmd.AddCommand(listTokenCmd)
+	tokenCmd.AddCommand(revokeTokenCmd)
+
+	createTokenCmd.Flags().StringVar(&tokenName, "name", "", "The name of token.")
+	createTokenCmd.Flags().StringSliceVar(&tokenNamespaces, "namespace", []string{}, "The namespaces of token, * is all namespaces.")
+	createTokenCmd.Flags().StringSliceVar(&tokenActions, "action", []string{module.ActionRead}, "The actions of token: run, cancel, approve or read.")
+	createTokenCmd.Flags().DurationVar(&tokenExpires, "expires", 0, "The lifetime of token like 720h, 0 never expires.")
+}
+
+// Generate a fernet key for the auth config.
+func generateTokenKey(cmd *cobra.Command, args []string) {
+	key, err := module.GenerateTokenKey()
+	if err != nil {
+		cmd.Println(Red(fmt.Sprintf("Generate key error: %s", err.Error())))
+		os.Exit(1)
+	}
+
+	cmd.Println(key)
+}
+
+// Create an API token.
+func createToken(cmd *cobra.Command, args []string) {
+	if tokenName == "" {
+		cmd.Println(Red("The token name is required."))
+		os.Exit(1)
+	}
+
+	model.OpenDatabase(&common.Database)
+	model.Migrate()
+
+	signed, token, err := module.NewToken(tokenName, tokenNamespaces, tokenActions, tokenExpires)
+	if err != nil {
+		cmd.Println(Red(fmt.Sprintf("Create token error: %s", err.Error())))
+		os.Exit(1)
+	}
+
+	cmd.Println(Green(fmt.Sprintf("Token %d [%s] is created, it's only printed once:", token.ID, token.Name)))
+	cmd.Println(signed)
+}
+
+// List the API tokens.
+func listTokens(cmd *cobra.Command, args []string) {
+	model.OpenDatabase(&common.Database)
+	model.Migrate()
+
+	tokens, err := new(model.TokenV1).List()
+	if err != nil {
+		cmd.Println(Red(fmt.Sprintf("List tokens error: %s", err.Error())))
+		os.Exit(1)
+	}
+
+	for _, t := range tokens {
+		status := "active"
+		if t.RevokedAt != nil {
+			status = "revoked"
+		} else if t.ExpiresAt != nil && t.ExpiresAt.Before(time.Now()) {
+			status = "expired"
+		}
+
+		expires := "never"
+		if t.ExpiresAt != nil {
+			expires = t.ExpiresAt.Format(time.RFC3339)
+		}
+
+		cmd.Println(fmt.Sprintf("%d\t%s\t%s\t%s\t%s\t%s", t.ID, t.Name, strings.Replace(t.Namespaces, ",", " ", -1),
+			strings.Replace(t.Actions, ",", " ", -1), expires, status))
+	}
+}
+
+// Revoke an API token.
+func revokeToken(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		cmd.Println(Red("The token id is required."))
+		os.Exit(1)
+	}
+
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		cmd.Println(Red(fmt.Sprintf("Invalid token id: %s", args[0])))
+		os.Exit(1)
+	}
+
+	model.OpenDatabase(&common.Database)
+	model.Migrate()
+
+	token := new(model.TokenV1)
+	if err := token.Revoke(id); err != nil {
+		cmd.Println(Red(fmt.Sprintf("Revoke token error: %s", err.Error())))
+		os.Exit(1)
+	}
+
+	cmd.Println(Green(fmt.Sprintf("Token %d [%s] is revoked.", token.ID, token.Name)))
+}
@@ -13,9 +13,72 @@ type WebHookConfig struct {
 	Binary      string `json:"binary"`
 	Tag         string `json:"tag"`
 	FlowBaseDir string `json:"flowBaseDir"` // Temporary, engine will find flow in database in the future.
+	Token       string `json:"token"`       // The API token of forwarded flows when the daemon has no auth key but the host requires tokens.
+}
+
+// QueueConfig is the run queue setting of the pilotage daemon.
+type QueueConfig struct {
+	Workers int `json:"workers"` // The number of flow runs executing at the same time.
+	Actions int `json:"actions"` // The max running actions of a parallel stage, 0 is unlimited.
+}
+
+// GCConfig is the cleanup policy of the Kubernetes resources created by flow runs.
+type GCConfig struct {
+	Success int `json:"success"` // Seconds to keep the resources of a succeeded run, 0 deletes them at once.
+	Failure int `json:"failure"` // Seconds to keep the resources of a failed or canceled run, 0 deletes them at once.
+	Sweep   int `json:"sweep"`   // Seconds between two sweeps of the daemon, 0 is the default interval.
+}
+
+// LogConfig is the persistence and retention policy of the flow run logs.
+type LogConfig struct {
+	Batch  int `json:"batch"`  // The max log lines inserted in one statement, 0 is the default size.
+	Flush  int `json:"flush"`  // Milliseconds between two inserts of the buffered log lines, 0 is the default interval.
+	Memory int `json:"memory"` // The log lines kept in memory of a flow, stage, action or job, 0 is the default limit.
+	Days   int `json:"days"`   // Days to keep the logs in database, 0 keeps them forever.
+	Runs   int `json:"runs"`   // The last runs of a flow whose logs are kept in database, 0 keeps all runs.
+}
+
+// AuthConfig is the API token authentication of the pilotage daemon.
+type AuthConfig struct {
+	Key       string   `json:"key"`       // The fernet key signing the API tokens, the daemon accepts any request when it's empty.
+	Anonymous []string `json:"anonymous"` // The actions allowed without token, like read for the status badges.
+}
+
+// ClusterConfig is a Kubernetes cluster running the jobs, the name after cluster in the config
+// is the cluster of flows, stages and jobs.
+type ClusterConfig struct {
+	Kubeconfig  string `json:"kubeconfig"`  // The kube config file, the default is ~/.kube/config.
+	Context     string `json:"context"`     // The context of kube config, the default is the current context.
+	Namespace   string `json:"namespace"`   // The namespace of the jobs and run owners, the default is the default namespace.
+	Concurrency int    `json:"concurrency"` // The max running jobs on the cluster, 0 is unlimited.
+	Health      int    `json:"health"`      // Seconds a health check of the cluster is trusted, 0 is the default interval.
+}
+
+// DaemonConfig is the identity of the pilotage daemon.
+type DaemonConfig struct {
+	Instance string `json:"instance"` // The daemon name owning its runs, unique and stable across restarts, the default is the host name.
+}
+
+// GitOpsConfig is the sync of flow definitions from a git repository or a local directory into
+// the flow store of the start daemon.
+type GitOpsConfig struct {
+	Repository string `json:"repository"` // The git repository URL, the local directory is synced when it's empty.
+	Revision   string `json:"revision"`   // The branch, tag or commit pinned, the default is the default branch.
+	Directory  string `json:"directory"`  // The directory of flow files in the repository, or the local directory.
+	Pattern    string `json:"pattern"`    // The file name pattern of flow files like *.flow.yml, the default is the .yml and .yaml files.
+	Workdir    string `json:"workdir"`    // The path of the repository clone, the default is in the temp directory.
+	Interval   int    `json:"interval"`   // Seconds between two syncs, 0 is the default interval.
+	Empty      bool   `json:"empty"`      // Delete all the synced flows when no flow file is found, they're kept by default.
 }
 
 var WebHook WebHookConfig
+var Queue QueueConfig
+var GC GCConfig
+var Log LogConfig
+var Auth AuthConfig
+var Clusters map[string]ClusterConfig
+var GitOps GitOpsConfig
+var Daemon DaemonConfig
 
 func InitConfig(cfgFile string) error {
 	viper.SetConfigFile(cfgFile)
@@ -23,11 +86,42 @@ func InitConfig(cfgFile string) error {
 		return err
 	}
 
-	hookMap := viper.GetStringMap("hook")
-	bs, err := json.Marshal(hookMap)
+	if err := setConfig("hook", &WebHook); err != nil {
+		return err
+	}
+
+	if err := setConfig("queue", &Queue); err != nil {
+		return err
+	}
+
+	if err := setConfig("gc", &GC); err != nil {
+		return err
+	}
+
+	if err := setConfig("log", &Log); err != nil {
+		return err
+	}
+
+	if err := setConfig("auth", &Auth); err != nil {
+		return err
+	}
+
+	if err := setConfig("cluster", &Clusters); err != nil {
+		return err
+	}
+
+	if err := setConfig("gitops", &GitOps); err != nil {
+		return err
+	}
+
+	return setConfig("daemon", &Daemon)
+}
+
+func setConfig(key string, v interface{}) error {
+	bs, err := json.Marshal(viper.GetStringMap(key))
 	if err != nil {
 		return err
 	}
 
-	return json.Unmarshal(bs, &WebHook)
+	return json.Unmarshal(bs, v)
 }
@@ -17,15 +17,15 @@ limitations under the License.
 package handler
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"gopkg.in/macaron.v1"
-	"gopkg.in/yaml.v2"
-
-	"time"
 
+	"github.com/Huawei/containerops/pilotage/model"
 	"github.com/Huawei/containerops/pilotage/module"
 )
 
@@ -85,6 +85,11 @@ type PostFlowResponse struct {
 	Status     string `json:"status"`
 }
 
+type InvalidFlowResponse struct {
+	Message string                  `json:"message"`
+	Errors  module.ValidationErrors `json:"errors"`
+}
+
 func PostFlowRuntime(ctx *macaron.Context) (int, []byte) {
 	namespace := ctx.Params("namespace")
 	repository := ctx.Params("repository")
@@ -93,41 +98,450 @@ func PostFlowRuntime(ctx *macaron.Context) (int, []byte) {
 
 
 	f := module.Flow{Number: 1, Status: module.Pending}
+	var errs module.ValidationErrors
 	switch ctx.Params("type") {
 	case "json":
-		if err := json.Unmarshal(data, &f); err != nil {
-			info:=fmt.Sprintf("Unmarshal the flow file error: %s", err.Error())
+		// The JSON is decoded strictly like the YAML, an unknown field is an error.
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&f); err != nil {
+			info := fmt.Sprintf("Unmarshal the flow file error: %s", err.Error())
 			f.Log(info, true, true)
 			result, _ := json.Marshal(map[string]string{"message": info})
 			return http.StatusBadRequest, result
 		}
-
-	case "yaml":
-		if err := yaml.Unmarshal(data, &f); err != nil {
-			info:=fmt.Sprintf("Unmarshal the flow file error: %s", err.Error())
-			f.Log(info, true, true)
-			result, _ := json.Marshal(map[string]string{"message": info})
-			return http.StatusBadRequest, result
+		if errs = f.Expand("", nil); len(errs) == 0 {
+			errs = f.ValidatePosted(nil)
 		}
+	case "yaml":
+		errs = f.ParseYAML(data, "")
 	default:
 		result, _ := json.Marshal(map[string]string{
 			"message": fmt.Sprintf("Unsupport type: %s", ctx.Params("type"))})
 		return http.StatusBadRequest, result
 	}
 
-	go func() {
-		f.LocalRun(true, true)
-	}()
-	// Sleep one second to wait the init status change of flow
-	time.Sleep(1 * time.Second)
-	resp := PostFlowResponse{Namespace: namespace, Repository: repository, Name: flowName, Tag: f.Tag,
-		Version: f.Version, Title: f.Title, Status: f.Status}
+	if err := f.SetParameters(ctx.QueryStrings("param")); err != nil {
+		errs = append(errs, module.ValidationError{Path: "parameters", Message: err.Error()})
+	}
+
+	// The commit statuses are posted with the token of Git provider, so the commit is only set
+	// by the webhook with the signed commit of push, never by the body of client.
+	f.Commit = nil
+	if signed := ctx.Req.Header.Get(module.CommitHeader); signed != "" {
+		commit, err := module.VerifyCommit(signed)
+		if err != nil {
+			errs = append(errs, module.ValidationError{Path: "commit", Message: err.Error()})
+		}
+		f.Commit = commit
+	}
+
+	if len(errs) > 0 {
+		f.Log(fmt.Sprintf("Invalid flow definition: %s", errs.Error()), true, true)
+		result, _ := json.Marshal(InvalidFlowResponse{Message: "Invalid flow definition", Errors: errs})
+		return http.StatusBadRequest, result
+	}
+
+	// The token is authorized with the namespace of route, not the flow URI of body.
+	if err := f.CheckURI(namespace, repository, flowName); err != nil {
+		f.Log(err.Error(), true, true)
+		result, _ := json.Marshal(map[string]string{"message": err.Error()})
+		return http.StatusBadRequest, result
+	}
+
+	// The flows triggered by the run are limited to the namespaces of token.
+	if token, ok := ctx.Data["token"].(*module.Token); ok {
+		f.LimitNamespaces(token.Namespaces)
+		if err := f.CheckTriggers(); err != nil {
+			result, _ := json.Marshal(map[string]string{"message": err.Error()})
+			return http.StatusForbidden, result
+		}
+	}
+
+	// Render the pods of flow without running.
+	if ctx.Query("dry_run") == "true" {
+		buf := new(bytes.Buffer)
+		if err := f.Render(buf); err != nil {
+			result, _ := json.Marshal(map[string]string{"message": fmt.Sprintf("Render the flow error: %s", err.Error())})
+			return http.StatusBadRequest, result
+		}
+		return http.StatusOK, buf.Bytes()
+	}
+
+	run, err := module.RunQueue.Submit(&f)
+	if err != nil {
+		result, _ := json.Marshal(map[string]string{"message": fmt.Sprintf("Submit the flow run error: %s", err.Error())})
+		return http.StatusBadRequest, result
+	}
+
+	resp := PostFlowResponse{ID: run.ID, Namespace: namespace, Repository: repository, Name: flowName, Tag: f.Tag,
+		Version: f.Version, Title: f.Title, Status: run.Status}
 	result, _ := json.Marshal(resp)
 	return http.StatusCreated, result
 }
 
+type GetFlowRunsResponse struct {
+	Workers int          `json:"workers"`
+	Pending []module.Run `json:"pending"`
+	Running []module.Run `json:"running"`
+}
+
+// GetFlowRuns is return the pending and running flow runs in the queue.
+func GetFlowRuns(ctx *macaron.Context) (int, []byte) {
+	pending, running := module.RunQueue.List()
+
+	// The token only reads the runs of its namespaces.
+	if token, ok := ctx.Data["token"].(*module.Token); ok {
+		pending, running = readableRuns(token, pending), readableRuns(token, running)
+	}
+
+	result, _ := json.Marshal(GetFlowRunsResponse{Workers: module.RunQueue.Workers(), Pending: pending, Running: running})
+	return http.StatusOK, result
+}
+
+func readableRuns(token *module.Token, runs []module.Run) []module.Run {
+	result := []module.Run{}
+	for _, run := range runs {
+		if token.Allows(module.Namespace(run.URI), module.ActionRead) {
+			result = append(result, run)
+		}
+	}
+	return result
+}
+
+type GetFlowRunResponse struct {
+	module.Run
+	Flow json.RawMessage `json:"flow"`
+}
+
+// GetFlowRun is return a run in the queue with the status of all stages, actions and jobs.
+func GetFlowRun(ctx *macaron.Context) (int, []byte) {
+	run, err := module.RunQueue.Get(ctx.Params("id"))
+	if err != nil {
+		result, _ := json.Marshal(map[string]string{"message": err.Error()})
+		return http.StatusNotFound, result
+	}
+
+	snapshot, err := run.Flow().Snapshot()
+	if err != nil {
+		result, _ := json.Marshal(map[string]string{"message": fmt.Sprintf("Get the flow run status error: %s", err.Error())})
+		return http.StatusBadRequest, result
+	}
+
+	result, _ := json.Marshal(GetFlowRunResponse{Run: run, Flow: snapshot})
+	return http.StatusOK, result
+}
+
+type GetFlowRunLogsResponse struct {
+	Status string   `json:"status"`
+	Offset int      `json:"offset"`
+	Logs   []string `json:"logs"`
+}
+
+// GetFlowRunLogs is return the flow logs of a run from the query offset, the client follows
+// the logs with the returned offset until the run isn't pending or running.
+func GetFlowRunLogs(ctx *macaron.Context) (int, []byte) {
+	run, err := module.RunQueue.Get(ctx.Params("id"))
+	if err != nil {
+		result, _ := json.Marshal(map[string]string{"message": err.Error()})
+		return http.StatusNotFound, result
+	}
+
+	logs, next := run.Flow().LogsFrom(ctx.QueryInt("offset"))
+
+	result, _ := json.Marshal(GetFlowRunLogsResponse{Status: run.Status, Offset: next, Logs: logs})
+	return http.StatusOK, result
+}
+
+// GetFlowRunOutputs is return the outputs of jobs in a run, the key is stage.action.job[KEY].
+func GetFlowRunOutputs(ctx *macaron.Context) (int, []byte) {
+	run, err := module.RunQueue.Get(ctx.Params("id"))
+	if err != nil {
+		result, _ := json.Marshal(map[string]string{"message": err.Error()})
+		return http.StatusNotFound, result
+	}
+
+	result, _ := json.Marshal(run.Flow().GetOutputs())
+	return http.StatusOK, result
+}
+
+// PostFlowRunCancel is cancel a pending or running run.
+func PostFlowRunCancel(ctx *macaron.Context) (int, []byte) {
+	if err := module.RunQueue.Cancel(ctx.Params("id")); err != nil {
+		result, _ := json.Marshal(map[string]string{"message": err.Error()})
+		return http.StatusNotFound, result
+	}
+
+	result, _ := json.Marshal(map[string]string{"message": fmt.Sprintf("Run [%s] is canceled", ctx.Params("id"))})
+	return http.StatusOK, result
+}
+
+// PostFlowRunApprove is continue a run paused by the pause stage in the query.
+func PostFlowRunApprove(ctx *macaron.Context) (int, []byte) {
+	run, err := module.RunQueue.Get(ctx.Params("id"))
+	if err != nil {
+		result, _ := json.Marshal(map[string]string{"message": err.Error()})
+		return http.StatusNotFound, result
+	}
+
+	if err := run.Flow().Approve(ctx.Query("stage")); err != nil {
+		result, _ := json.Marshal(map[string]string{"message": err.Error()})
+		return http.StatusBadRequest, result
+	}
+
+	result, _ := json.Marshal(map[string]string{"message": fmt.Sprintf("Stage [%s] of run [%s] is approved", ctx.Query("stage"), run.ID)})
+	return http.StatusOK, result
+}
+
+type PostFlowRerunResponse struct {
+	ID     string `json:"id"`
+	URI    string `json:"uri"`
+	Tag    string `json:"tag"`
+	Title  string `json:"title"`
+	Parent int64  `json:"parent"`
+	Status string `json:"status"`
+}
+
+// PostFlowRerun reruns a recorded flow run from the stage, action or job in the query,
+// the units before are reused with the outputs of the parent run.
+func PostFlowRerun(ctx *macaron.Context) (int, []byte) {
+	uri := fmt.Sprintf("%s/%s/%s", ctx.Params("namespace"), ctx.Params("repository"), ctx.Params("flow"))
+
+	number, err := strconv.ParseInt(ctx.Params("number"), 10, 64)
+	if err != nil {
+		result, _ := json.Marshal(map[string]string{"message": fmt.Sprintf("Invalid flow run number: %s", ctx.Params("number"))})
+		return http.StatusBadRequest, result
+	}
+
+	f, err := module.NewRerun(uri, ctx.Params("tag"), number, ctx.Query("stage"), ctx.Query("action"), ctx.Query("job"))
+	if err != nil {
+		result, _ := json.Marshal(map[string]string{"message": fmt.Sprintf("Rerun the flow error: %s", err.Error())})
+		return http.StatusBadRequest, result
+	}
+
+	if err := f.CheckURI(ctx.Params("namespace"), ctx.Params("repository"), ctx.Params("flow")); err != nil {
+		result, _ := json.Marshal(map[string]string{"message": err.Error()})
+		return http.StatusBadRequest, result
+	}
+
+	// The flows triggered by the run are limited to the namespaces of token.
+	if token, ok := ctx.Data["token"].(*module.Token); ok {
+		f.LimitNamespaces(token.Namespaces)
+		if err := f.CheckTriggers(); err != nil {
+			result, _ := json.Marshal(map[string]string{"message": err.Error()})
+			return http.StatusForbidden, result
+		}
+	}
+
+	run, err := module.RunQueue.Submit(f)
+	if err != nil {
+		result, _ := json.Marshal(map[string]string{"message": fmt.Sprintf("Submit the flow run error: %s", err.Error())})
+		return http.StatusBadRequest, result
+	}
+
+	result, _ := json.Marshal(PostFlowRerunResponse{ID: run.ID, URI: f.URI, Tag: f.Tag, Title: f.Title, Parent: f.Parent, Status: run.Status})
+	return http.StatusCreated, result
+}
+
+// GetFlowHistory is return the recorded runs of a flow, a rerun links to its parent run.
+func GetFlowHistory(ctx *macaron.Context) (int, []byte) {
+	flow := new(model.FlowV1)
+	if err := flow.GetWithDeleted(ctx.Params("namespace"), ctx.Params("repository"), ctx.Params("flow"), ctx.Params("tag")); err != nil {
+		result, _ := json.Marshal(map[string]string{"message": err.Error()})
+		return http.StatusNotFound, result
+	}
+
+	runs, err := new(model.FlowDataV1).List(flow.ID)
+	if err != nil {
+		result, _ := json.Marshal(map[string]string{"message": fmt.Sprintf("List the flow runs error: %s", err.Error())})
+		return http.StatusBadRequest, result
+	}
+
+	result, _ := json.Marshal(runs)
+	return http.StatusOK, result
+}
+
+// GetFlowAnalytics is return the durations, success rates and flakiness of a flow, its stages
+// and jobs in the window of query since and until.
+func GetFlowAnalytics(ctx *macaron.Context) (int, []byte) {
+	since, until, err := module.AnalyticsWindow(ctx.Query("since"), ctx.Query("until"))
+	if err != nil {
+		result, _ := json.Marshal(map[string]string{"message": err.Error()})
+		return http.StatusBadRequest, result
+	}
+
+	uri := fmt.Sprintf("%s/%s/%s", ctx.Params("namespace"), ctx.Params("repository"), ctx.Params("flow"))
+	analytics, err := module.Analyze(uri, ctx.Params("tag"), since, until)
+	if err != nil {
+		result, _ := json.Marshal(map[string]string{"message": fmt.Sprintf("Analyze the flow runs error: %s", err.Error())})
+		return http.StatusBadRequest, result
+	}
+
+	result, _ := json.Marshal(analytics)
+	return http.StatusOK, result
+}
+
+// GetAnalytics is return the durations and success rates of all flows run in the window.
+func GetAnalytics(ctx *macaron.Context) (int, []byte) {
+	since, until, err := module.AnalyticsWindow(ctx.Query("since"), ctx.Query("until"))
+	if err != nil {
+		result, _ := json.Marshal(map[string]string{"message": err.Error()})
+		return http.StatusBadRequest, result
+	}
+
+	analytics, err := module.AnalyzeAll(since, until)
+	if err != nil {
+		result, _ := json.Marshal(map[string]string{"message": fmt.Sprintf("Analyze the flow runs error: %s", err.Error())})
+		return http.StatusBadRequest, result
+	}
+
+	result, _ := json.Marshal(analytics)
+	return http.StatusOK, result
+}
+
+// GetFlowRunTests is return the test results of a flow run number, with the tests newly failing
+// since the previous run.
+func GetFlowRunTests(ctx *macaron.Context) (int, []byte) {
+	number, err := strconv.ParseInt(ctx.Params("number"), 10, 64)
+	if err != nil {
+		result, _ := json.Marshal(map[string]string{"message": fmt.Sprintf("Invalid flow run number: %s", ctx.Params("number"))})
+		return http.StatusBadRequest, result
+	}
+
+	flow := new(model.FlowV1)
+	if err := flow.GetWithDeleted(ctx.Params("namespace"), ctx.Params("repository"), ctx.Params("flow"), ctx.Params("tag")); err != nil {
+		result, _ := json.Marshal(map[string]string{"message": err.Error()})
+		return http.StatusNotFound, result
+	}
+
+	data := new(model.FlowDataV1)
+	if err := data.Get(flow.ID, number); err != nil {
+		result, _ := json.Marshal(map[string]string{"message": err.Error()})
+		return http.StatusNotFound, result
+	}
+
+	tests, err := module.LoadRunTests(flow.ID, data.ID)
+	if err != nil {
+		result, _ := json.Marshal(map[string]string{"message": fmt.Sprintf("Load the test results error: %s", err.Error())})
+		return http.StatusBadRequest, result
+	}
+
+	result, _ := json.Marshal(tests)
+	return http.StatusOK, result
+}
+
+// GetFlowRunArchive is return the tar.gz archive of a finished flow run number, it's imported by
+// the pilotage run import command.
+func GetFlowRunArchive(ctx *macaron.Context) (int, []byte) {
+	number, err := strconv.ParseInt(ctx.Params("number"), 10, 64)
+	if err != nil {
+		result, _ := json.Marshal(map[string]string{"message": fmt.Sprintf("Invalid flow run number: %s", ctx.Params("number"))})
+		return http.StatusBadRequest, result
+	}
+
+	flow := new(model.FlowV1)
+	if err := flow.GetWithDeleted(ctx.Params("namespace"), ctx.Params("repository"), ctx.Params("flow"), ctx.Params("tag")); err != nil {
+		result, _ := json.Marshal(map[string]string{"message": err.Error()})
+		return http.StatusNotFound, result
+	}
+
+	data := new(model.FlowDataV1)
+	if err := data.Get(flow.ID, number); err != nil {
+		result, _ := json.Marshal(map[string]string{"message": err.Error()})
+		return http.StatusNotFound, result
+	}
+
+	buf := new(bytes.Buffer)
+	if err := module.ExportRun(buf, data); err != nil {
+		result, _ := json.Marshal(map[string]string{"message": fmt.Sprintf("Export the flow run error: %s", err.Error())})
+		return http.StatusBadRequest, result
+	}
+
+	ctx.Resp.Header().Set("Content-Type", module.ArchiveContentType)
+	ctx.Resp.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s-%s-%s-%s-%d.tar.gz\"",
+		flow.Namespace, flow.Repository, flow.Name, flow.Tag, number))
+	return http.StatusOK, buf.Bytes()
+}
+
+// GetFlowTests is return the test history of a flow, newest first. The query job, class and name
+// filter the test cases, and the limit is 100 by default.
+func GetFlowTests(ctx *macaron.Context) (int, []byte) {
+	limit := ctx.QueryInt("limit")
+	if limit <= 0 {
+		limit = 100
+	}
+
+	cases, err := module.TestHistory(ctx.Params("namespace"), ctx.Params("repository"), ctx.Params("flow"), ctx.Params("tag"),
+		ctx.Query("job"), ctx.Query("class"), ctx.Query("name"), limit)
+	if err != nil {
+		result, _ := json.Marshal(map[string]string{"message": fmt.Sprintf("List the test history error: %s", err.Error())})
+		return http.StatusBadRequest, result
+	}
+
+	result, _ := json.Marshal(cases)
+	return http.StatusOK, result
+}
+
+// GetFlowBadge is return the SVG badge of the latest run status of a flow, the query label is
+// the left text of badge.
+func GetFlowBadge(ctx *macaron.Context) (int, []byte) {
+	label := ctx.Query("label")
+	if label == "" {
+		label = module.DefaultBadgeLabel
+	}
+
+	status := module.LatestStatus(ctx.Params("namespace"), ctx.Params("repository"), ctx.Params("flow"), ctx.Params("tag"))
+
+	ctx.Resp.Header().Set("Content-Type", module.BadgeContentType)
+	ctx.Resp.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	return http.StatusOK, module.Badge(label, status)
+}
+
 // GetFlowJobLog is return log of a Job
 func GetFlowJobLog(ctx *macaron.Context) (int, []byte) {
 	result, _ := json.Marshal(map[string]string{})
 	return http.StatusOK, result
 }
+
+// GetClusters is return the health and running jobs of the clusters running jobs.
+func GetClusters(ctx *macaron.Context) (int, []byte) {
+	result, _ := json.Marshal(module.ClusterStatuses())
+	return http.StatusOK, result
+}
+
+// GetSync is return the status of the last sync of the gitops flow files.
+func GetSync(ctx *macaron.Context) (int, []byte) {
+	if module.GitOpsEnabled() == false {
+		result, _ := json.Marshal(map[string]string{"message": "The gitops sync isn't configured"})
+		return http.StatusNotFound, result
+	}
+
+	status := module.LastSync()
+	if status == nil {
+		result, _ := json.Marshal(map[string]string{"message": "The flow files aren't synced yet"})
+		return http.StatusNotFound, result
+	}
+
+	result, _ := json.Marshal(status)
+	return http.StatusOK, result
+}
+
+// PostSync is sync the gitops flow files at once, like after a push to the repository.
+func PostSync(ctx *macaron.Context) (int, []byte) {
+	if module.GitOpsEnabled() == false {
+		result, _ := json.Marshal(map[string]string{"message": "The gitops sync isn't configured"})
+		return http.StatusNotFound, result
+	}
+
+	result, _ := json.Marshal(module.SyncFlows())
+	return http.StatusOK, result
+}
+
+// GetMetrics is return the metrics of flow engine in Prometheus text exposition format.
+func GetMetrics(ctx *macaron.Context) (int, []byte) {
+	buf := new(bytes.Buffer)
+	module.WriteMetrics(buf)
+
+	ctx.Resp.Header().Set("Content-Type", module.MetricsContentType)
+	return http.StatusOK, buf.Bytes()
+}
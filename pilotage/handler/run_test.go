@@ -0,0 +1,144 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gopkg.in/macaron.v1"
+
+	"github.com/Huawei/containerops/pilotage/model"
+	"github.com/Huawei/containerops/pilotage/module"
+)
+
+// pausedFlow waits the approval of its pause stage in the daemon, so its run holds a worker
+// until it's approved or canceled.
+const pausedFlow = `uri: cncf/demo/%s
+tag: latest
+title: Paused flow
+version: 1
+concurrency:
+  max: 1
+  on_conflict: %s
+stages:
+  - type: pause
+    name: approve
+`
+
+func queueServer() *macaron.Macaron {
+	model.DisableDB = true
+	module.InitQueue(2, false, false)
+
+	m := macaron.New()
+	m.Get("/flow/v1/runs", GetFlowRuns)
+	m.Post("/flow/v1/runs/:id/cancel", PostFlowRunCancel)
+	m.Post("/flow/v1/runs/:id/approve", PostFlowRunApprove)
+	m.Post("/flow/v1/:namespace/:repository/:flow/:tag/:type", PostFlowRuntime)
+	return m
+}
+
+func request(t *testing.T, m *macaron.Macaron, method, path, body string, status int) []byte {
+	req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+	resp := httptest.NewRecorder()
+	m.ServeHTTP(resp, req)
+
+	if resp.Code != status {
+		t.Fatalf("%s %s returns %d, want %d: %s", method, path, resp.Code, status, resp.Body.String())
+	}
+	return resp.Body.Bytes()
+}
+
+func postFlow(t *testing.T, m *macaron.Macaron, name, conflict string) string {
+	body := request(t, m, "POST", fmt.Sprintf("/flow/v1/cncf/demo/%s/latest/yaml", name), fmt.Sprintf(pausedFlow, name, conflict), http.StatusCreated)
+
+	var resp PostFlowResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("Unmarshal the posted run error: %s", err.Error())
+	}
+	return resp.ID
+}
+
+// waitRuns polls the run queue until the runs are running and pending, by the run ids.
+func waitRuns(t *testing.T, m *macaron.Macaron, running, pending []string) {
+	var runs GetFlowRunsResponse
+	for start := time.Now(); time.Now().Sub(start) < 2*time.Second; time.Sleep(10 * time.Millisecond) {
+		runs = GetFlowRunsResponse{}
+		json.Unmarshal(request(t, m, "GET", "/flow/v1/runs", "", http.StatusOK), &runs)
+		if sameRuns(runs.Running, running) && sameRuns(runs.Pending, pending) {
+			return
+		}
+	}
+	t.Fatalf("Runs are running %v and pending %v, want running %v and pending %v", runIDs(runs.Running), runIDs(runs.Pending), running, pending)
+}
+
+func runIDs(runs []module.Run) []string {
+	ids := []string{}
+	for _, r := range runs {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
+
+func sameRuns(runs []module.Run, ids []string) bool {
+	found := map[string]bool{}
+	for _, id := range runIDs(runs) {
+		found[id] = true
+	}
+	for _, id := range ids {
+		if found[id] == false {
+			return false
+		}
+	}
+	return len(runs) == len(ids)
+}
+
+func TestRunQueueThroughHandlers(t *testing.T) {
+	m := queueServer()
+
+	// The second run of a flow with max 1 waits for the first, the run of other flow takes the other worker.
+	first, second := postFlow(t, m, "hello", module.QueueConflict), postFlow(t, m, "hello", module.QueueConflict)
+	waitRuns(t, m, []string{first}, []string{second})
+	other := postFlow(t, m, "world", module.QueueConflict)
+	waitRuns(t, m, []string{first, other}, []string{second})
+
+	// A third run waits for a free worker even if its flow isn't running.
+	third := postFlow(t, m, "bye", module.QueueConflict)
+	waitRuns(t, m, []string{first, other}, []string{second, third})
+
+	// The approved run finishes, the pending run of the same flow starts before the run of other flow.
+	request(t, m, "POST", fmt.Sprintf("/flow/v1/runs/%s/approve?stage=approve", first), "", http.StatusOK)
+	waitRuns(t, m, []string{second, other}, []string{third})
+
+	request(t, m, "POST", fmt.Sprintf("/flow/v1/runs/%s/cancel", other), "", http.StatusOK)
+	waitRuns(t, m, []string{second, third}, []string{})
+
+	// The new run of a cancel-previous flow cancels the running run of the flow.
+	latest := postFlow(t, m, "hello", module.CancelPreviousConflict)
+	waitRuns(t, m, []string{latest, third}, []string{})
+
+	for _, id := range []string{latest, third} {
+		request(t, m, "POST", fmt.Sprintf("/flow/v1/runs/%s/cancel", id), "", http.StatusOK)
+	}
+	waitRuns(t, m, []string{}, []string{})
+	request(t, m, "POST", fmt.Sprintf("/flow/v1/runs/%s/cancel", first), "", http.StatusNotFound)
+}
@@ -41,23 +41,36 @@ func WebHook(ctx *macaron.Context) (int, []byte) {
 
 	url := fmt.Sprintf("%s/flow/v1/%s/%s/%s/%s/%s", config.WebHook.Host, namespace, repository, flowName, tag, "yaml")
 
-	flowYamlPath := fmt.Sprintf("%s/%s/%s/%s.yml", config.WebHook.FlowBaseDir, namespace, repository, tag)
+	// The flows synced from the gitops repository replace the flow files of FlowBaseDir.
 	f := &module.Flow{}
-	if err := f.ParseFlowFromFile(flowYamlPath, module.DaemonRun, false, true); err != nil {
-		log.Error(err)
-		return http.StatusInternalServerError, []byte("Failed to parse flow yaml file")
+	if module.GitOpsEnabled() {
+		stored, err := module.StoredFlow(namespace, repository, flowName, tag)
+		if err != nil {
+			log.Error(err)
+			return http.StatusNotFound, []byte("Flow isn't found in the flow store")
+		}
+		f = stored
+	} else {
+		flowYamlPath := fmt.Sprintf("%s/%s/%s/%s.yml", config.WebHook.FlowBaseDir, namespace, repository, tag)
+		if err := f.ParseFlowFromFile(flowYamlPath, module.DaemonRun, false, true); err != nil {
+			log.Error(err)
+			if errs, ok := err.(module.ValidationErrors); ok {
+				return http.StatusBadRequest, []byte(fmt.Sprintf("Invalid flow yaml file:\n%s", errs.Error()))
+			}
+			return http.StatusInternalServerError, []byte("Failed to parse flow yaml file")
+		}
 	}
 
-	ns, repo, name, err := f.URIs()
-	if err != nil {
+	// The token is authorized with the namespace of route, the flow of another namespace never runs.
+	if err := f.CheckURI(namespace, repository, flowName); err != nil {
 		log.Error(err)
-		return http.StatusInternalServerError, []byte("Failed to validate flow URI")
-	}
-	if ns != namespace || repo != repository || name != flowName {
-		log.Error("Parameters in yaml not equal to those in URL")
-		return http.StatusInternalServerError, []byte("Invalid flow URI")
+		return http.StatusBadRequest, []byte("Invalid flow URI")
 	}
 
+	// The commit status is reported to the commit of push.
+	payload, _ := ctx.Req.Body().Bytes()
+	f.Commit = module.ParsePushCommit(ctx.Req.Header, payload)
+
 	yamlBytes, err := f.YAML()
 	if err != nil {
 		log.Error(err)
@@ -67,6 +80,23 @@ func WebHook(ctx *macaron.Context) (int, []byte) {
 
 	client := http.Client{}
 	req, _ := http.NewRequest(http.MethodPost, url, bytesReader)
+	if f.Commit != nil {
+		signed, err := module.SignCommit(f.Commit)
+		if err != nil {
+			log.Error(err)
+			return http.StatusInternalServerError, []byte("Failed to sign the commit of push")
+		}
+		req.Header.Set(module.CommitHeader, signed)
+	}
+	// The flow is posted with the token of caller, so the namespaces of caller limit the flow and
+	// its triggers. The token of config is only sent when the daemon accepts any request.
+	token := config.WebHook.Token
+	if module.AuthEnabled() {
+		token, _ = ctx.Data["signed"].(string)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
 	res, err := client.Do(req)
 	if err != nil {
 		log.Error(err)
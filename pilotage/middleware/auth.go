@@ -0,0 +1,124 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gopkg.in/macaron.v1"
+
+	"github.com/Huawei/containerops/pilotage/module"
+)
+
+// Authorize returns the handler checking the API token of request is allowed to do the action
+// in the namespace of route, or the namespace of the run in the run queue. The routes without
+// namespace are only allowed with the tokens of all namespaces. The token is the bearer token
+// of Authorization header, or the query token for the Git webhooks which can't set headers.
+func Authorize(action string) macaron.Handler {
+	return func(ctx *macaron.Context) {
+		token, ok := authenticate(ctx, action)
+		if ok == false || token == nil {
+			return
+		}
+
+		namespace := ctx.Params("namespace")
+		if namespace == "" && ctx.Params("id") != "" && module.RunQueue != nil {
+			if run, err := module.RunQueue.Get(ctx.Params("id")); err == nil {
+				namespace = module.Namespace(run.URI)
+			}
+		}
+
+		if token.Allows(namespace, action) == false {
+			abort(ctx, http.StatusForbidden, fmt.Sprintf("Token %s isn't allowed to %s in namespace %q", token.Name, action, namespace))
+		}
+	}
+}
+
+// Authenticate returns the handler checking the API token of request is allowed to do the
+// action in any namespace, the handler filters the results by the token in ctx.Data["token"].
+func Authenticate(action string) macaron.Handler {
+	return func(ctx *macaron.Context) {
+		token, ok := authenticate(ctx, action)
+		if ok == false || token == nil {
+			return
+		}
+
+		if token.HasAction(action) == false {
+			abort(ctx, http.StatusForbidden, fmt.Sprintf("Token %s isn't allowed to %s", token.Name, action))
+		}
+	}
+}
+
+// authenticate verifies the token of request and sets it into ctx.Data["token"], and the signed
+// token into ctx.Data["signed"] for the requests forwarded by the handlers. It returns false when
+// the request is aborted, and a nil token when the action needs no token.
+func authenticate(ctx *macaron.Context, action string) (*module.Token, bool) {
+	if module.AuthEnabled() == false {
+		return nil, true
+	}
+
+	signed := ctx.Query("token")
+	if header := ctx.Req.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		signed = strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	}
+
+	if signed == "" {
+		if module.AnonymousAllows(action) {
+			return nil, true
+		}
+
+		ctx.Resp.Header().Set("WWW-Authenticate", `Bearer realm="pilotage"`)
+		abort(ctx, http.StatusUnauthorized, "API token is required")
+		return nil, false
+	}
+
+	token, err := module.ParseToken(signed)
+	if err != nil {
+		ctx.Resp.Header().Set("WWW-Authenticate", `Bearer realm="pilotage", error="invalid_token"`)
+		abort(ctx, http.StatusUnauthorized, err.Error())
+		return nil, false
+	}
+
+	ctx.Data["token"], ctx.Data["signed"] = token, signed
+	return token, true
+}
+
+// RedactToken hides the query token in the request URI of the access log, the query of request
+// is not changed.
+func RedactToken(ctx *macaron.Context) {
+	query := ctx.Req.URL.Query()
+	if query.Get("token") == "" {
+		return
+	}
+
+	query.Set("token", "REDACTED")
+	uri := *ctx.Req.URL
+	uri.RawQuery = query.Encode()
+	ctx.Req.RequestURI = uri.RequestURI()
+}
+
+// abort writes the error message, and the following handlers are skipped.
+func abort(ctx *macaron.Context, status int, message string) {
+	result, _ := json.Marshal(map[string]string{"message": message})
+
+	ctx.Resp.Header().Set("Content-Type", "application/json")
+	ctx.Resp.WriteHeader(status)
+	ctx.Resp.Write(result)
+}
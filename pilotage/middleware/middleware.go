@@ -49,7 +49,8 @@ func SetRunDaemonMiddlewares(m *macaron.Macaron, cfgFile, flowFile string) {
 		ctx.Data["mode"] = module.DaemonRun
 	})
 
-	//
+	// The query token of webhooks isn't written into the access log.
+	m.Use(RedactToken)
 	m.Use(macaron.Logger())
 
 	// Set recovery handler to returns a middleware that recovers from any panics
@@ -63,7 +64,8 @@ func SetStartDaemonMiddlewares(m *macaron.Macaron, cfgFile string) {
 		ctx.Data["mode"] = module.DaemonStart
 	})
 
-	//
+	// The query token of webhooks isn't written into the access log.
+	m.Use(RedactToken)
 	m.Use(macaron.Logger())
 
 	// Set recovery handler to returns a middleware that recovers from any panics
@@ -16,6 +16,7 @@ type ActionDataV1 struct {
 	ID       int64     `json:"id" gorm:"primary_key" gorm:"column:id"`
 	ActionID int64     `json:"action_id" sql:"not null;type:bigint(20)" gorm:"column:action_id"`
 	Number   int64     `json:"number" sql:"not null;type:bigint(20)" gorm:"column:number"`
+	RunID    int64     `json:"run_id" sql:"type:bigint(20);default:0;index" gorm:"column:run_id"`
 	Result   string    `json:"result" sql:"type:varchar(255)" gorm:"column:result"`
 	Start    time.Time `json:"start" sql:"" gorm:"column:start"`
 	End      time.Time `json:"end" sql:"" gorm:"column:end"`
@@ -54,11 +55,12 @@ func (a *ActionV1) Put(stageID int64, name, title string) (actionID int64, err e
 	return actionID, nil
 }
 
-func (ad *ActionDataV1) Put(actionID, number int64, result string, start, end time.Time) error {
+// Put records an action run, the runID is the flow data id of the run.
+func (ad *ActionDataV1) Put(actionID, number, runID int64, result string, start, end time.Time) error {
 	if DisableDB {
 		return nil
 	}
-	ad.ActionID, ad.Number, ad.Result, ad.Start, ad.End = actionID, number, result, start, end
+	ad.ActionID, ad.Number, ad.RunID, ad.Result, ad.Start, ad.End = actionID, number, runID, result, start, end
 
 	tx := DB.Begin()
 	if err := tx.Create(&ad).Error; err != nil {
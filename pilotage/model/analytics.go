@@ -0,0 +1,87 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// UnitDataV1 is a recorded run of a stage or job with the names of its units.
+type UnitDataV1 struct {
+	FlowID int64     `json:"flow_id" gorm:"column:flow_id"`
+	Stage  string    `json:"stage" gorm:"column:stage"`
+	Action string    `json:"action,omitempty" gorm:"column:action"`
+	Job    string    `json:"job,omitempty" gorm:"column:job"`
+	Result string    `json:"result" gorm:"column:result"`
+	Inputs string    `json:"inputs,omitempty" gorm:"column:inputs"`
+	Start  time.Time `json:"start" gorm:"column:start"`
+	End    time.Time `json:"end" gorm:"column:end"`
+}
+
+// List returns all the flows.
+func (f *FlowV1) List() ([]FlowV1, error) {
+	flows := []FlowV1{}
+	if DisableDB {
+		return flows, nil
+	}
+
+	if err := DB.Select("id, namespace, repository, name, tag, version, title, timeout").Order("id").Find(&flows).Error; err != nil {
+		return nil, err
+	}
+
+	return flows, nil
+}
+
+// ListByWindow returns the runs of a flow started in the window [since, until), without the
+// content and outputs.
+func (fd *FlowDataV1) ListByWindow(flowID int64, since, until time.Time) ([]FlowDataV1, error) {
+	runs := []FlowDataV1{}
+	if DisableDB {
+		return runs, fmt.Errorf("Database is disabled")
+	}
+
+	if err := DB.Select("id, flow_id, number, parent_id, result, start, end").
+		Where("flow_id = ? AND start >= ? AND start < ?", flowID, since, until).Order("start").Find(&runs).Error; err != nil {
+		return nil, err
+	}
+
+	return runs, nil
+}
+
+// ListStageData returns the stage runs of a flow started in the window [since, until).
+func ListStageData(flowID int64, since, until time.Time) ([]UnitDataV1, error) {
+	records := []UnitDataV1{}
+	if DisableDB {
+		return records, fmt.Errorf("Database is disabled")
+	}
+
+	if err := DB.Table("stage_data_v1").
+		Select("stage_v1.flow_id, stage_v1.name AS stage, stage_data_v1.result, stage_data_v1.start, stage_data_v1.end").
+		Joins("JOIN stage_v1 ON stage_v1.id = stage_data_v1.stage_id").
+		Where("stage_v1.flow_id = ? AND stage_data_v1.start >= ? AND stage_data_v1.start < ?", flowID, since, until).
+		Order("stage_data_v1.start").Scan(&records).Error; err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// ListJobData returns the job runs of a flow started in the window [since, until).
+func ListJobData(flowID int64, since, until time.Time) ([]UnitDataV1, error) {
+	records := []UnitDataV1{}
+	if DisableDB {
+		return records, fmt.Errorf("Database is disabled")
+	}
+
+	if err := DB.Table("job_data_v1").
+		Select("stage_v1.flow_id, stage_v1.name AS stage, action_v1.name AS action, job_v1.name AS job, "+
+			"job_data_v1.result, job_data_v1.inputs, job_data_v1.start, job_data_v1.end").
+		Joins("JOIN job_v1 ON job_v1.id = job_data_v1.job_id").
+		Joins("JOIN action_v1 ON action_v1.id = job_v1.action_id").
+		Joins("JOIN stage_v1 ON stage_v1.id = action_v1.stage_id").
+		Where("stage_v1.flow_id = ? AND job_data_v1.start >= ? AND job_data_v1.start < ?", flowID, since, until).
+		Order("job_data_v1.start").Scan(&records).Error; err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
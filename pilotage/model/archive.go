@@ -0,0 +1,128 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// RunUnitsV1 is the recorded stage, action and job runs of a flow run.
+type RunUnitsV1 struct {
+	Stages  []UnitDataV1 `json:"stages"`
+	Actions []UnitDataV1 `json:"actions"`
+	Jobs    []UnitDataV1 `json:"jobs"`
+}
+
+// ListRunUnits returns the stage, action and job runs of a flow run. The units recorded without
+// run id are found by the window [start, end] of the run.
+func ListRunUnits(flowID, runID int64, start, end time.Time) (*RunUnitsV1, error) {
+	units := &RunUnitsV1{Stages: []UnitDataV1{}, Actions: []UnitDataV1{}, Jobs: []UnitDataV1{}}
+	if DisableDB {
+		return units, fmt.Errorf("Database is disabled")
+	}
+
+	if err := DB.Table("stage_data_v1").
+		Select("stage_v1.flow_id, stage_v1.name AS stage, stage_data_v1.result, stage_data_v1.start, stage_data_v1.end").
+		Joins("JOIN stage_v1 ON stage_v1.id = stage_data_v1.stage_id").
+		Where("stage_v1.flow_id = ? AND (stage_data_v1.run_id = ? OR (stage_data_v1.run_id = 0 AND stage_data_v1.start >= ? AND stage_data_v1.start <= ?))",
+			flowID, runID, start, end).
+		Order("stage_data_v1.start").Scan(&units.Stages).Error; err != nil {
+		return nil, err
+	}
+
+	if err := DB.Table("action_data_v1").
+		Select("stage_v1.flow_id, stage_v1.name AS stage, action_v1.name AS action, "+
+			"action_data_v1.result, action_data_v1.start, action_data_v1.end").
+		Joins("JOIN action_v1 ON action_v1.id = action_data_v1.action_id").
+		Joins("JOIN stage_v1 ON stage_v1.id = action_v1.stage_id").
+		Where("stage_v1.flow_id = ? AND (action_data_v1.run_id = ? OR (action_data_v1.run_id = 0 AND action_data_v1.start >= ? AND action_data_v1.start <= ?))",
+			flowID, runID, start, end).
+		Order("action_data_v1.start").Scan(&units.Actions).Error; err != nil {
+		return nil, err
+	}
+
+	if err := DB.Table("job_data_v1").
+		Select("stage_v1.flow_id, stage_v1.name AS stage, action_v1.name AS action, job_v1.name AS job, "+
+			"job_data_v1.result, job_data_v1.inputs, job_data_v1.start, job_data_v1.end").
+		Joins("JOIN job_v1 ON job_v1.id = job_data_v1.job_id").
+		Joins("JOIN action_v1 ON action_v1.id = job_v1.action_id").
+		Joins("JOIN stage_v1 ON stage_v1.id = action_v1.stage_id").
+		Where("stage_v1.flow_id = ? AND (job_data_v1.run_id = ? OR (job_data_v1.run_id = 0 AND job_data_v1.start >= ? AND job_data_v1.start <= ?))",
+			flowID, runID, start, end).
+		Order("job_data_v1.start").Scan(&units.Jobs).Error; err != nil {
+		return nil, err
+	}
+
+	return units, nil
+}
+
+// UnitNames returns the names of the stages, actions and jobs of a flow by their ids, the keys
+// are the log phases STAGE, ACTION and JOB. The names are stage, stage.action and
+// stage.action.job.
+func UnitNames(flowID int64) (map[string]map[int64]string, error) {
+	names := map[string]map[int64]string{STAGE: {}, ACTION: {}, JOB: {}}
+	if DisableDB {
+		return names, fmt.Errorf("Database is disabled")
+	}
+
+	type unit struct {
+		ID     int64
+		Stage  string
+		Action string
+		Job    string
+	}
+
+	stages := []unit{}
+	if err := DB.Table("stage_v1").Select("stage_v1.id, stage_v1.name AS stage").
+		Where("stage_v1.flow_id = ?", flowID).Scan(&stages).Error; err != nil {
+		return nil, err
+	}
+	for _, s := range stages {
+		names[STAGE][s.ID] = s.Stage
+	}
+
+	actions := []unit{}
+	if err := DB.Table("action_v1").Select("action_v1.id, stage_v1.name AS stage, action_v1.name AS action").
+		Joins("JOIN stage_v1 ON stage_v1.id = action_v1.stage_id").
+		Where("stage_v1.flow_id = ?", flowID).Scan(&actions).Error; err != nil {
+		return nil, err
+	}
+	for _, a := range actions {
+		names[ACTION][a.ID] = fmt.Sprintf("%s.%s", a.Stage, a.Action)
+	}
+
+	jobs := []unit{}
+	if err := DB.Table("job_v1").Select("job_v1.id, stage_v1.name AS stage, action_v1.name AS action, job_v1.name AS job").
+		Joins("JOIN action_v1 ON action_v1.id = job_v1.action_id").
+		Joins("JOIN stage_v1 ON stage_v1.id = action_v1.stage_id").
+		Where("stage_v1.flow_id = ?", flowID).Scan(&jobs).Error; err != nil {
+		return nil, err
+	}
+	for _, j := range jobs {
+		names[JOB][j.ID] = fmt.Sprintf("%s.%s.%s", j.Stage, j.Action, j.Job)
+	}
+
+	return names, nil
+}
+
+// DeleteRun removes a flow run with its stage, action and job runs, logs and test cases, like the
+// run partly imported from an archive.
+func DeleteRun(runID int64) error {
+	if DisableDB {
+		return nil
+	}
+
+	tx := DB.Begin()
+	for _, table := range []string{"stage_data_v1", "action_data_v1", "job_data_v1", "log_v1", "test_case_v1"} {
+		if err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE run_id = ?", table), runID).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Exec("DELETE FROM flow_data_v1 WHERE id = ?", runID).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	tx.Commit()
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// CacheV1 is the outputs of a succeeded job recorded with its cache key.
+type CacheV1 struct {
+	ID        int64     `json:"id" gorm:"primary_key" gorm:"column:id"`
+	Key       string    `json:"key" sql:"not null;type:varchar(64);unique_index" gorm:"column:key"`
+	URI       string    `json:"uri" sql:"type:varchar(255)" gorm:"column:uri"`
+	Job       string    `json:"job" sql:"type:varchar(255)" gorm:"column:job"`
+	RunID     int64     `json:"run_id" sql:"type:bigint(20);default:0" gorm:"column:run_id"`
+	Outputs   string    `json:"outputs" sql:"type:text" gorm:"column:outputs"`
+	CreatedAt time.Time `json:"created_at" sql:"" gorm:"column:created_at"`
+	UpdatedAt time.Time `json:"updated_at" sql:"" gorm:"column:updated_at"`
+}
+
+func (c *CacheV1) TableName() string {
+	return "cache_v1"
+}
+
+// Get finds the cache of the key.
+func (c *CacheV1) Get(key string) error {
+	if DisableDB {
+		return fmt.Errorf("Database is disabled")
+	}
+
+	if tmp := DB.Where("`key` = ?", key).First(&c); tmp.RecordNotFound() {
+		return fmt.Errorf("Cache %s not found", key)
+	} else if tmp.Error != nil {
+		return tmp.Error
+	}
+
+	return nil
+}
+
+// Put records the outputs of the key, the cache of the same key is replaced.
+func (c *CacheV1) Put(key, uri, job string, runID int64, outputs string) error {
+	if DisableDB {
+		return nil
+	}
+
+	tx := DB.Begin()
+	if tx.Where("`key` = ?", key).First(&c).RecordNotFound() {
+		c.Key, c.URI, c.Job, c.RunID, c.Outputs, c.CreatedAt = key, uri, job, runID, outputs, time.Now()
+		if err := tx.Create(&c).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	} else {
+		if err := tx.Model(&c).Updates(map[string]interface{}{"uri": uri, "job": job, "run_id": runID, "outputs": outputs}).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	tx.Commit()
+
+	return nil
+}
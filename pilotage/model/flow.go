@@ -1,6 +1,7 @@
 package model
 
 import (
+	"fmt"
 	"time"
 )
 
@@ -14,18 +15,26 @@ type FlowV1 struct {
 	Title      string     `json:"title" sql:"type:text" gorm:"column:title"`
 	Timeout    int64      `json:"timeout" sql:"default:0" gorm:"column:timeout"`
 	Content    string     `json:"content" sql:"type:text" gorm:"column:content"`
+	Source     string     `json:"source,omitempty" sql:"type:varchar(255)" gorm:"column:source"`
 	CreatedAt  time.Time  `json:"created_at" sql:"" gorm:"column:created_at"`
 	UpdatedAt  time.Time  `json:"updated_at" sql:"" gorm:"column:updated_at"`
 	DeletedAt  *time.Time `json:"deleted_at" sql:"index" gorm:"column:deleted_at"`
 }
 
 type FlowDataV1 struct {
-	ID     int64     `json:"id" gorm:"primary_key" gorm:"column:id"`
-	FlowID int64     `json:"flow_id" sql:"not null;type:bigint(20)" gorm:"column:flow_id"`
-	Number int64     `json:"number" sql:"not null;type:bigint(20)" gorm:"column:number"`
-	Result string    `json:"result" sql:"type:varchar(255)" gorm:"column:result"`
-	Start  time.Time `json:"start" sql:"" gorm:"column:start"`
-	End    time.Time `json:"end" sql:"" gorm:"column:end"`
+	ID         int64     `json:"id" gorm:"primary_key" gorm:"column:id"`
+	FlowID     int64     `json:"flow_id" sql:"not null;type:bigint(20)" gorm:"column:flow_id"`
+	Number     int64     `json:"number" sql:"not null;type:bigint(20)" gorm:"column:number"`
+	ParentID   int64     `json:"parent_id" sql:"type:bigint(20);default:0" gorm:"column:parent_id"`
+	TriggerID  int64     `json:"trigger_id" sql:"type:bigint(20);default:0" gorm:"column:trigger_id"`
+	Result     string    `json:"result" sql:"type:varchar(255)" gorm:"column:result"`
+	Content    string    `json:"content,omitempty" sql:"type:text" gorm:"column:content"`
+	Outputs    string    `json:"outputs,omitempty" sql:"type:text" gorm:"column:outputs"`
+	Start      time.Time `json:"start" sql:"" gorm:"column:start"`
+	End        time.Time `json:"end" sql:"" gorm:"column:end"`
+	Namespaces string    `json:"namespaces,omitempty" sql:"type:text" gorm:"column:namespaces"`
+	Mode       string    `json:"mode,omitempty" sql:"type:varchar(255)" gorm:"column:mode"`
+	Owner      string    `json:"owner,omitempty" sql:"type:varchar(255)" gorm:"column:owner"`
 }
 
 func (f *FlowV1) TableName() string {
@@ -44,18 +53,26 @@ func (f *FlowV1) Put(namespace, repository, name, tag, title, content string, ve
 	f.Namespace, f.Repository, f.Name, f.Tag, f.Title, f.Content = namespace, repository, name, tag, title, content
 	f.Version, f.Timeout = version, timeout
 
+	// The deleted flow is restored, so its runs are numbered after the runs before deleted.
 	tx := DB.Begin()
-	if tx.Where("namespace = ? AND repository = ? AND name = ? AND tag = ?", namespace, repository, name, tag).First(&f).RecordNotFound() {
+	if tx.Unscoped().Where("namespace = ? AND repository = ? AND name = ? AND tag = ?", namespace, repository, name, tag).First(&f).RecordNotFound() {
 		f.CreatedAt = time.Now()
 		if err := tx.Create(&f).Error; err != nil {
 			tx.Rollback()
 			return 0, err
 		}
 	} else {
-		if err := tx.Model(&f).Updates(FlowV1{Version: version, Title: title, Content: content, Timeout: timeout}).Error; err != nil {
+		if err := tx.Unscoped().Model(&f).Updates(FlowV1{Version: version, Title: title, Content: content, Timeout: timeout}).Error; err != nil {
 			tx.Rollback()
 			return 0, err
 		}
+		if f.DeletedAt != nil {
+			if err := tx.Unscoped().Model(&f).Update("deleted_at", nil).Error; err != nil {
+				tx.Rollback()
+				return 0, err
+			}
+			f.DeletedAt = nil
+		}
 	}
 	tx.Commit()
 
@@ -64,14 +81,121 @@ func (f *FlowV1) Put(namespace, repository, name, tag, title, content string, ve
 	return flowID, nil
 }
 
-func (fd *FlowDataV1) Put(flowID, number int64, result string, start, end time.Time) error {
+func (f *FlowV1) Get(namespace, repository, name, tag string) error {
+	if DisableDB {
+		return fmt.Errorf("Database is disabled")
+	}
+
+	if tmp := DB.Where("namespace = ? AND repository = ? AND name = ? AND tag = ?", namespace, repository, name, tag).First(&f); tmp.RecordNotFound() {
+		return fmt.Errorf("Flow %s/%s/%s:%s not found", namespace, repository, name, tag)
+	} else if tmp.Error != nil {
+		return tmp.Error
+	}
+
+	return nil
+}
+
+// GetWithDeleted finds the flow including the deleted flows, whose runs are kept in the history.
+func (f *FlowV1) GetWithDeleted(namespace, repository, name, tag string) error {
+	if DisableDB {
+		return fmt.Errorf("Database is disabled")
+	}
+
+	if tmp := DB.Unscoped().Where("namespace = ? AND repository = ? AND name = ? AND tag = ?", namespace, repository, name, tag).First(&f); tmp.RecordNotFound() {
+		return fmt.Errorf("Flow %s/%s/%s:%s not found", namespace, repository, name, tag)
+	} else if tmp.Error != nil {
+		return tmp.Error
+	}
+
+	return nil
+}
+
+// GetByID finds the flow of the id, the deleted flows are found for their runs.
+func (f *FlowV1) GetByID(id int64) error {
+	if DisableDB {
+		return fmt.Errorf("Database is disabled")
+	}
+
+	if tmp := DB.Unscoped().Where("id = ?", id).First(&f); tmp.RecordNotFound() {
+		return fmt.Errorf("Flow %d not found", id)
+	} else if tmp.Error != nil {
+		return tmp.Error
+	}
+
+	return nil
+}
+
+// SetSource records where the flow definition comes from, it's empty for the posted flows.
+func (f *FlowV1) SetSource(source string) error {
+	if DisableDB {
+		return fmt.Errorf("Database is disabled")
+	}
+
+	tx := DB.Begin()
+	if err := tx.Model(&f).Update("source", source).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	tx.Commit()
+
+	return nil
+}
+
+// ListBySource returns the flows whose source starts with the prefix, without the content.
+func (f *FlowV1) ListBySource(prefix string) ([]FlowV1, error) {
+	flows := []FlowV1{}
+	if DisableDB {
+		return flows, fmt.Errorf("Database is disabled")
+	}
+
+	if err := DB.Select("id, namespace, repository, name, tag, version, title, timeout, source").
+		Where("source LIKE ?", prefix+"%").Order("id").Find(&flows).Error; err != nil {
+		return nil, err
+	}
+
+	return flows, nil
+}
+
+// Delete removes the flow from the flow store, its runs are kept.
+func (f *FlowV1) Delete() error {
+	if DisableDB {
+		return fmt.Errorf("Database is disabled")
+	}
+
+	tx := DB.Begin()
+	if err := tx.Delete(&f).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	tx.Commit()
+
+	return nil
+}
+
+// Put records a run with the next number of the flow, the parentID is the run rerun by it and the
+// triggerID is the run triggering it. The number is allocated in the transaction locking the flow,
+// so the runs started at the same time never get the same number.
+func (fd *FlowDataV1) Put(flowID, parentID, triggerID int64, result, content, outputs string, start, end time.Time) error {
 	if DisableDB {
 		return nil
 	}
 
-	fd.FlowID, fd.Number, fd.Result, fd.Start, fd.End = flowID, number, result, start, end
+	fd.FlowID, fd.ParentID, fd.TriggerID, fd.Result, fd.Start, fd.End = flowID, parentID, triggerID, result, start, end
+	fd.Content, fd.Outputs = content, outputs
 
 	tx := DB.Begin()
+	if err := tx.Unscoped().Set("gorm:query_option", "FOR UPDATE").Where("id = ?", flowID).First(&FlowV1{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	var number int64
+	if err := tx.Model(&FlowDataV1{}).Where("flow_id = ?", flowID).Select("COALESCE(MAX(number), 0)").Row().Scan(&number); err != nil {
+		tx.Rollback()
+		return err
+	}
+	fd.Number = number + 1
+
 	if err := tx.Create(&fd).Error; err != nil {
 		tx.Rollback()
 		return err
@@ -95,3 +219,94 @@ func (fd *FlowDataV1) GetNumbers(flowID int64) (int64, error) {
 	}
 	return tmp.RowsAffected, nil
 }
+
+func (fd *FlowDataV1) Get(flowID, number int64) error {
+	if DisableDB {
+		return fmt.Errorf("Database is disabled")
+	}
+
+	if tmp := DB.Where("flow_id = ? AND number = ?", flowID, number).First(&fd); tmp.RecordNotFound() {
+		return fmt.Errorf("Flow run %d not found", number)
+	} else if tmp.Error != nil {
+		return tmp.Error
+	}
+
+	return nil
+}
+
+// GetByID finds the run of the id.
+func (fd *FlowDataV1) GetByID(id int64) error {
+	if DisableDB {
+		return fmt.Errorf("Database is disabled")
+	}
+
+	if tmp := DB.Where("id = ?", id).First(&fd); tmp.RecordNotFound() {
+		return fmt.Errorf("Flow run %d not found", id)
+	} else if tmp.Error != nil {
+		return tmp.Error
+	}
+
+	return nil
+}
+
+// List returns the runs of a flow order by number, without the content and outputs.
+func (fd *FlowDataV1) List(flowID int64) ([]FlowDataV1, error) {
+	runs := []FlowDataV1{}
+	if DisableDB {
+		return runs, nil
+	}
+
+	if err := DB.Select("id, flow_id, number, parent_id, trigger_id, result, start, end").Where("flow_id = ?", flowID).Order("number").Find(&runs).Error; err != nil {
+		return nil, err
+	}
+
+	return runs, nil
+}
+
+// Latest gets the latest run of a flow, without the content and outputs.
+func (fd *FlowDataV1) Latest(flowID int64) error {
+	if DisableDB {
+		return fmt.Errorf("Database is disabled")
+	}
+
+	if tmp := DB.Select("id, flow_id, number, parent_id, trigger_id, result, start, end").Where("flow_id = ?", flowID).Order("number desc").First(&fd); tmp.RecordNotFound() {
+		return fmt.Errorf("Flow never runs")
+	} else if tmp.Error != nil {
+		return tmp.Error
+	}
+
+	return nil
+}
+
+// Update saves the result, status snapshot and outputs of a run, the end is the time of last update.
+func (fd *FlowDataV1) Update(result, content, outputs string, end time.Time) error {
+	if DisableDB {
+		return nil
+	}
+
+	fd.Result, fd.Content, fd.Outputs, fd.End = result, content, outputs, end
+
+	tx := DB.Begin()
+	if err := tx.Model(&fd).Updates(map[string]interface{}{"result": result, "content": content, "outputs": outputs, "end": end}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	tx.Commit()
+
+	return nil
+}
+
+// ListByOwner returns the runs of all flows started in the mode by the owner with one of the
+// results, ordered by id.
+func (fd *FlowDataV1) ListByOwner(mode, owner string, results ...string) ([]FlowDataV1, error) {
+	runs := []FlowDataV1{}
+	if DisableDB {
+		return runs, nil
+	}
+
+	if err := DB.Where("mode = ? AND owner = ? AND result in (?)", mode, owner, results).Order("id").Find(&runs).Error; err != nil {
+		return nil, err
+	}
+
+	return runs, nil
+}
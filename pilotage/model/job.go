@@ -22,7 +22,9 @@ type JobDataV1 struct {
 	ID     int64     `json:"id" gorm:"primary_key" gorm:"column:id"`
 	JobID  int64     `json:"job_id" sql:"not null;type:bigint(20)" gorm:"column:job_id"`
 	Number int64     `json:"number" sql:"not null;type:bigint(20)" gorm:"column:number"`
+	RunID  int64     `json:"run_id" sql:"type:bigint(20);default:0;index" gorm:"column:run_id"`
 	Result string    `json:"result" sql:"type:varchar(255)" gorm:"column:result"`
+	Inputs string    `json:"inputs" sql:"type:varchar(64)" gorm:"column:inputs"`
 	Start  time.Time `json:"start" sql:"" gorm:"column:start"`
 	End    time.Time `json:"end" sql:"" gorm:"column:end"`
 }
@@ -63,12 +65,13 @@ func (j *JobV1) Put(actionID, timeout int64, name, jobType, endpoint, resources,
 	return jobID, nil
 }
 
-func (jd *JobDataV1) Put(jobID, number int64, result string, start, end time.Time) error {
+// Put records a job run, the runID is the flow data id of the run.
+func (jd *JobDataV1) Put(jobID, number, runID int64, result, inputs string, start, end time.Time) error {
 	if DisableDB {
 		return nil
 	}
 
-	jd.JobID, jd.Number, jd.Result, jd.Start, jd.End = jobID, number, result, start, end
+	jd.JobID, jd.Number, jd.RunID, jd.Result, jd.Inputs, jd.Start, jd.End = jobID, number, runID, result, inputs, start, end
 
 	tx := DB.Begin()
 	if err := tx.Create(&jd).Error; err != nil {
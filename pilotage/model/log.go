@@ -1,7 +1,8 @@
 package model
 
 import (
-	"sync"
+	"fmt"
+	"strings"
 	"time"
 )
 
@@ -24,10 +25,12 @@ type LogV1 struct {
 	ID    int64  `json:"id" gorm:"primary_key" gorm:"column:id"`
 	Level string `json:"level" sql:"not null;type:varchar(255)" gorm:"column:level"`
 	//Phase must be one of 'flow','stage','action' or 'job'
-	Phase     string    `json:"phase" sql:"type:varchar(255)" gorm:"column:phase"`
-	PhaseID   int64     `json:"phase_id" sql:"type:bigint(20)" gorm:"column:phase_id"`
+	Phase   string `json:"phase" sql:"type:varchar(255)" gorm:"column:phase"`
+	PhaseID int64  `json:"phase_id" sql:"type:bigint(20)" gorm:"column:phase_id"`
+	//RunID is the flow data id of the run, 0 when the log isn't in a run
+	RunID     int64     `json:"run_id" sql:"type:bigint(20);default:0;index" gorm:"column:run_id"`
 	Content   string    `json:"content" sql:"type:text" gorm:"column:content"`
-	EventTime time.Time `json:"envent_time" sql:"" gorm:"column:envent_time"`
+	EventTime time.Time `json:"envent_time" sql:"index" gorm:"column:envent_time"`
 }
 
 func (l *LogV1) TableName() string {
@@ -42,17 +45,80 @@ func (l *LogV1) Create(level, phase string, phaseID int64, content string) error
 	l.Level, l.Phase, l.PhaseID, l.Content = level, phase, phaseID, content
 	l.EventTime = time.Now()
 
-	tx := DB.Begin()
+	return DB.Create(&l).Error
+}
+
+// CreateBatch inserts the logs with one statement.
+func CreateBatch(logs []LogV1) error {
+	if DisableDB || len(logs) == 0 {
+		return nil
+	}
+
+	values := []string{}
+	args := []interface{}{}
+	for _, l := range logs {
+		values = append(values, "(?, ?, ?, ?, ?, ?)")
+		args = append(args, l.Level, l.Phase, l.PhaseID, l.RunID, l.Content, l.EventTime)
+	}
+
+	sql := fmt.Sprintf("INSERT INTO log_v1 (level, phase, phase_id, run_id, content, envent_time) VALUES %s", strings.Join(values, ", "))
+	return DB.Exec(sql, args...).Error
+}
+
+// ListByRun returns the logs of a run in order.
+func (l *LogV1) ListByRun(runID int64) ([]LogV1, error) {
+	logs := []LogV1{}
+	if DisableDB {
+		return logs, fmt.Errorf("Database is disabled")
+	}
 
-	mutex := &sync.Mutex{}
-	mutex.Lock()
-	defer mutex.Unlock()
+	if err := DB.Where("run_id = ?", runID).Order("id").Find(&logs).Error; err != nil {
+		return nil, err
+	}
+
+	return logs, nil
+}
 
-	if err := tx.Create(&l).Error; err != nil {
-		tx.Rollback()
-		return err
+// LastTime returns the time of the last log of the unit in the run, it's zero when the unit has
+// no log.
+func (l *LogV1) LastTime(phase string, phaseID, runID int64) (time.Time, error) {
+	if DisableDB {
+		return time.Time{}, fmt.Errorf("Database is disabled")
+	}
+
+	last := LogV1{}
+	if tmp := DB.Where("phase = ? AND phase_id = ? AND run_id = ?", phase, phaseID, runID).Order("id desc").First(&last); tmp.RecordNotFound() {
+		return time.Time{}, nil
+	} else if tmp.Error != nil {
+		return time.Time{}, tmp.Error
+	}
+
+	return last.EventTime, nil
+}
+
+// PruneBefore deletes the logs before the time, returns the number of deleted logs.
+func (l *LogV1) PruneBefore(t time.Time) (int64, error) {
+	if DisableDB {
+		return 0, nil
+	}
+
+	tmp := DB.Where("envent_time < ?", t).Delete(LogV1{})
+	return tmp.RowsAffected, tmp.Error
+}
+
+// PruneRuns deletes the logs of runs except the last runs of each flow, returns the number of
+// deleted logs. The logs not in a run are kept.
+func (l *LogV1) PruneRuns(keep int) (int64, error) {
+	if DisableDB {
+		return 0, nil
 	}
 
-	tx.Commit()
-	return nil
+	tmp := DB.Exec(`DELETE FROM log_v1 WHERE run_id > 0 AND run_id NOT IN (
+		SELECT id FROM (
+			SELECT d1.id FROM flow_data_v1 d1 WHERE (
+				SELECT COUNT(*) FROM flow_data_v1 d2 WHERE d2.flow_id = d1.flow_id AND d2.id > d1.id
+			) < ?
+		) AS kept
+	)`, keep)
+	return tmp.RowsAffected, tmp.Error
 }
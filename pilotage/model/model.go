@@ -49,4 +49,7 @@ func Migrate() {
 	DB.AutoMigrate(&ActionV1{}, &ActionDataV1{})
 	DB.AutoMigrate(&JobV1{}, &JobDataV1{})
 	DB.AutoMigrate(&LogV1{})
+	DB.AutoMigrate(&CacheV1{})
+	DB.AutoMigrate(&TestCaseV1{})
+	DB.AutoMigrate(&TokenV1{})
 }
@@ -18,6 +18,7 @@ type StageDataV1 struct {
 	ID      int64     `json:"id" gorm:"primary_key" gorm:"column:id"`
 	StageID int64     `json:"stage_id" sql:"not null;type:bigint(20)" gorm:"column:stage_id"`
 	Number  int64     `json:"number" sql:"not null;type:bigint(20)" gorm:"column:number"`
+	RunID   int64     `json:"run_id" sql:"type:bigint(20);default:0;index" gorm:"column:run_id"`
 	Result  string    `json:"result" sql:"type:varchar(255)" gorm:"column:result"`
 	Start   time.Time `json:"start" sql:"" gorm:"column:start"`
 	End     time.Time `json:"end" sql:"" gorm:"column:end"`
@@ -59,12 +60,13 @@ func (s *StageV1) Put(flowID int64, stageType, name, title, sequencing string) (
 	return stageID, nil
 }
 
-func (sd *StageDataV1) Put(stageID, number int64, result string, start, end time.Time) error {
+// Put records a stage run, the runID is the flow data id of the run.
+func (sd *StageDataV1) Put(stageID, number, runID int64, result string, start, end time.Time) error {
 	if DisableDB {
 		return nil
 	}
 
-	sd.StageID, sd.Number, sd.Result, sd.Start, sd.End = stageID, number, result, start, end
+	sd.StageID, sd.Number, sd.RunID, sd.Result, sd.Start, sd.End = stageID, number, runID, result, start, end
 
 	tx := DB.Begin()
 	if err := tx.Create(&sd).Error; err != nil {
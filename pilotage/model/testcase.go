@@ -0,0 +1,102 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// TestCaseV1 is the result of a test case in the JUnit report of a job run.
+type TestCaseV1 struct {
+	ID        int64     `json:"id" gorm:"primary_key" gorm:"column:id"`
+	FlowID    int64     `json:"flow_id" sql:"not null;type:bigint(20);index" gorm:"column:flow_id"`
+	RunID     int64     `json:"run_id" sql:"not null;type:bigint(20);index" gorm:"column:run_id"`
+	JobDataID int64     `json:"job_data_id" sql:"not null;type:bigint(20);index" gorm:"column:job_data_id"`
+	Job       string    `json:"job" sql:"type:varchar(255)" gorm:"column:job"`
+	Suite     string    `json:"suite" sql:"type:varchar(255)" gorm:"column:suite"`
+	Class     string    `json:"class" sql:"type:varchar(255)" gorm:"column:class"`
+	Name      string    `json:"name" sql:"type:varchar(255)" gorm:"column:name"`
+	Result    string    `json:"result" sql:"type:varchar(255)" gorm:"column:result"`
+	Duration  float64   `json:"duration" sql:"default:0" gorm:"column:duration"`
+	Message   string    `json:"message,omitempty" sql:"type:text" gorm:"column:message"`
+	CreatedAt time.Time `json:"created_at" sql:"" gorm:"column:created_at"`
+}
+
+func (t *TestCaseV1) TableName() string {
+	return "test_case_v1"
+}
+
+// CreateTestCases inserts the test cases of a job run.
+func CreateTestCases(cases []TestCaseV1) error {
+	if DisableDB || len(cases) == 0 {
+		return nil
+	}
+
+	tx := DB.Begin()
+	for i, _ := range cases {
+		if err := tx.Create(&cases[i]).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	tx.Commit()
+
+	return nil
+}
+
+// ListByRun returns the test cases of a flow run order by id.
+func (t *TestCaseV1) ListByRun(runID int64) ([]TestCaseV1, error) {
+	cases := []TestCaseV1{}
+	if DisableDB {
+		return cases, nil
+	}
+
+	if err := DB.Where("run_id = ?", runID).Order("id").Find(&cases).Error; err != nil {
+		return nil, err
+	}
+
+	return cases, nil
+}
+
+// PreviousRun returns the latest run of the flow with test cases before the run, 0 when there
+// isn't one.
+func (t *TestCaseV1) PreviousRun(flowID, runID int64) (int64, error) {
+	if DisableDB {
+		return 0, fmt.Errorf("Database is disabled")
+	}
+
+	var previous struct {
+		RunID int64 `gorm:"column:run_id"`
+	}
+	if err := DB.Table(t.TableName()).Select("MAX(run_id) AS run_id").
+		Where("flow_id = ? AND run_id < ?", flowID, runID).Scan(&previous).Error; err != nil {
+		return 0, err
+	}
+
+	return previous.RunID, nil
+}
+
+// History returns the latest results of the test cases of a flow, newest first. The job, class
+// and name filter the test cases when they aren't empty.
+func (t *TestCaseV1) History(flowID int64, job, class, name string, limit int) ([]TestCaseV1, error) {
+	cases := []TestCaseV1{}
+	if DisableDB {
+		return cases, fmt.Errorf("Database is disabled")
+	}
+
+	query := DB.Where("flow_id = ?", flowID)
+	if job != "" {
+		query = query.Where("job = ?", job)
+	}
+	if class != "" {
+		query = query.Where("class = ?", class)
+	}
+	if name != "" {
+		query = query.Where("name = ?", name)
+	}
+
+	if err := query.Order("run_id desc, id").Limit(limit).Find(&cases).Error; err != nil {
+		return nil, err
+	}
+
+	return cases, nil
+}
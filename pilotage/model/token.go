@@ -0,0 +1,87 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// TokenV1 is an API token of the pilotage daemon, the token itself isn't stored. The Namespaces
+// and Actions are separated by comma, and the token is rejected after it's revoked.
+type TokenV1 struct {
+	ID         int64      `json:"id" gorm:"primary_key" gorm:"column:id"`
+	Name       string     `json:"name" sql:"type:varchar(255)" gorm:"column:name"`
+	Namespaces string     `json:"namespaces" sql:"type:text" gorm:"column:namespaces"`
+	Actions    string     `json:"actions" sql:"type:varchar(255)" gorm:"column:actions"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty" sql:"" gorm:"column:expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" sql:"" gorm:"column:revoked_at"`
+	CreatedAt  time.Time  `json:"created_at" sql:"" gorm:"column:created_at"`
+}
+
+func (t *TokenV1) TableName() string {
+	return "token_v1"
+}
+
+// Create records a new token.
+func (t *TokenV1) Create(name, namespaces, actions string, expires *time.Time) error {
+	if DisableDB {
+		return fmt.Errorf("Database is disabled")
+	}
+
+	t.Name, t.Namespaces, t.Actions, t.ExpiresAt, t.CreatedAt = name, namespaces, actions, expires, time.Now()
+
+	tx := DB.Begin()
+	if err := tx.Create(&t).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	tx.Commit()
+
+	return nil
+}
+
+// Get finds the token of the id.
+func (t *TokenV1) Get(id int64) error {
+	if DisableDB {
+		return fmt.Errorf("Database is disabled")
+	}
+
+	if tmp := DB.Where("id = ?", id).First(&t); tmp.RecordNotFound() {
+		return fmt.Errorf("Token %d not found", id)
+	} else if tmp.Error != nil {
+		return tmp.Error
+	}
+
+	return nil
+}
+
+// List returns all the tokens order by id.
+func (t *TokenV1) List() ([]TokenV1, error) {
+	tokens := []TokenV1{}
+	if DisableDB {
+		return tokens, fmt.Errorf("Database is disabled")
+	}
+
+	if err := DB.Order("id").Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// Revoke rejects the token of the id from now on.
+func (t *TokenV1) Revoke(id int64) error {
+	if err := t.Get(id); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	tx := DB.Begin()
+	if err := tx.Model(&t).Updates(map[string]interface{}{"revoked_at": now}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	tx.Commit()
+	t.RevokedAt = &now
+
+	return nil
+}
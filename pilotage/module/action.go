@@ -1,6 +1,7 @@
 package module
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"time"
@@ -17,19 +18,27 @@ const (
 
 // Action is
 type Action struct {
-	ID     int64    `json:"-" yaml:"-"`
-	Name   string   `json:"name" yaml:"name"`
-	Title  string   `json:"title" yaml:"title"`
-	Status string   `json:"status,omitempty" yaml:"status,omitempty"`
-	Jobs   []Job    `json:"jobs,omitempty" yaml:"jobs,omitempty"`
-	Logs   []string `json:"logs,omitempty" yaml:"logs,omitempty"`
+	ID       int64             `json:"-" yaml:"-"`
+	Name     string            `json:"name" yaml:"name"`
+	Title    string            `json:"title" yaml:"title"`
+	Template string            `json:"template,omitempty" yaml:"template,omitempty"`
+	With     map[string]string `json:"with,omitempty" yaml:"with,omitempty"`
+	Status   string            `json:"status,omitempty" yaml:"status,omitempty"`
+	Jobs     []Job             `json:"jobs,omitempty" yaml:"jobs,omitempty"`
+	Logs     []string          `json:"logs,omitempty" yaml:"logs,omitempty"`
+
+	// skip is true when the action is reused from the parent run.
+	skip bool
+	// run is the flow data id of the run, the logs are pruned with it.
+	run int64
 }
 
 // TODO filter the log print with different color.
 func (a *Action) Log(log string, verbose, timestamp bool) {
-	a.Logs = append(a.Logs, fmt.Sprintf("[%s] %s", time.Now().String(), log))
-	l := new(model.LogV1)
-	l.Create(model.INFO, model.ACTION, a.ID, log)
+	logsLock.Lock()
+	a.Logs, _ = appendLog(a.Logs, fmt.Sprintf("[%s] %s", time.Now().String(), log))
+	logsLock.Unlock()
+	persistLog(model.ACTION, a.ID, a.run, log)
 
 	if verbose == true {
 		if timestamp == true {
@@ -40,8 +49,8 @@ func (a *Action) Log(log string, verbose, timestamp bool) {
 	}
 }
 
-func (a *Action) Run(verbose, timestamp bool, f *Flow, stageIndex, actionIndex int) (string, error) {
-	a.Status = Running
+func (a *Action) Run(ctx context.Context, verbose, timestamp bool, f *Flow, stageIndex, actionIndex int) (string, error) {
+	setStatus(&a.Status, Running)
 
 	a.Log(fmt.Sprintf("Action [%s] status change to %s", a.Name, a.Status), false, timestamp)
 	f.Log(fmt.Sprintf("Action [%s] status change to %s", a.Name, a.Status), verbose, timestamp)
@@ -52,7 +61,7 @@ func (a *Action) Run(verbose, timestamp bool, f *Flow, stageIndex, actionIndex i
 	if err != nil {
 		a.Log(fmt.Sprintf("Save Action [%s] error: %s", a.Name, err.Error()), false, timestamp)
 	}
-	a.ID = actionID
+	a.ID, a.run = actionID, f.dataID()
 
 	// Record stage data
 	actionData := new(model.ActionDataV1)
@@ -61,27 +70,53 @@ func (a *Action) Run(verbose, timestamp bool, f *Flow, stageIndex, actionIndex i
 	for i, _ := range a.Jobs {
 		job := &a.Jobs[i]
 
+		if ctx.Err() != nil {
+			setStatus(&a.Status, Cancel)
+			a.Log(fmt.Sprintf("Action [%s] is canceled before job: %s", a.Name, job.Name), false, timestamp)
+			break
+		}
+
+		if job.skip {
+			setStatus(&a.Status, job.Status)
+			f.Log(fmt.Sprintf("The Number [%d] job is reused from the recorded run with status %s", i, job.Status), verbose, timestamp)
+			if a.Status == Failure || a.Status == Cancel {
+				break
+			}
+			continue
+		}
+
 		a.Log(fmt.Sprintf("The Number [%d] job is running: %s", i, a.Title), false, timestamp)
 		f.Log(fmt.Sprintf("The Number [%d] job is running: %s", i, a.Title), verbose, timestamp)
 
 		var status string
 		var err error
+		jobStart := time.Now()
 		//If user specific a URL or yaml file in kubectl , excute yaml in kubernetes cluster
 		if job.Kubectl != "" {
-			status, err = job.RunKubectl(a.Name, verbose, timestamp, f, stageIndex, actionIndex)
+			status, err = job.RunKubectl(ctx, a.Name, verbose, timestamp, f, stageIndex, actionIndex)
 
 		} else {
-			status, err = job.Run(a.Name, verbose, timestamp, f, stageIndex, actionIndex)
+			status, err = job.Run(ctx, a.Name, verbose, timestamp, f, stageIndex, actionIndex)
 		}
 
 		if err != nil {
-			a.Status = Failure
+			setStatus(&a.Status, Failure)
+			setStatus(&job.Status, Failure)
 			a.Log(fmt.Sprintf("Job [%d] run error: %s", i, err.Error()), false, timestamp)
 			f.Log(fmt.Sprintf("Job [%d] run error: %s", i, err.Error()), verbose, timestamp)
 
 		} else {
-			a.Status = status
+			setStatus(&a.Status, status)
+		}
+		job.SaveData(jobStart, verbose, timestamp)
+		if job.JUnit != "" {
+			job.SaveTests(f, stageIndex, actionIndex, verbose, timestamp)
 		}
+		if job.Cached == false {
+			jobDuration.since(jobStart, f.URI, f.Stages[stageIndex].Name, a.Name, job.Name, a.Status)
+		}
+
+		f.Checkpoint()
 
 		if a.Status == Failure || a.Status == Cancel {
 			break
@@ -93,9 +128,10 @@ func (a *Action) Run(verbose, timestamp bool, f *Flow, stageIndex, actionIndex i
 	if err != nil {
 		f.Log(fmt.Sprintf("Get action Data [%s] Numbers error: %s", a.Name, err.Error()), verbose, timestamp)
 	}
-	if err := actionData.Put(a.ID, currentNumber+1, a.Status, startTime, time.Now()); err != nil {
+	if err := actionData.Put(a.ID, currentNumber+1, a.run, a.Status, startTime, time.Now()); err != nil {
 		a.Log(fmt.Sprintf("Save Action Data [%s] error: %s", a.Name, err.Error()), false, timestamp)
 	}
+	actionDuration.since(startTime, f.URI, f.Stages[stageIndex].Name, a.Name, a.Status)
 
 	return a.Status, nil
 }
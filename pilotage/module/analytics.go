@@ -0,0 +1,301 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Huawei/containerops/pilotage/model"
+)
+
+const (
+	// DefaultAnalyticsWindow is the window of analytics when the since is empty.
+	DefaultAnalyticsWindow = 7 * 24 * time.Hour
+)
+
+// Summary is the statistics of the finished runs of a flow, stage or job in a window. The
+// cached jobs are successful runs, but not counted in the durations.
+type Summary struct {
+	Name        string  `json:"name"`
+	Runs        int     `json:"runs"`
+	Success     int     `json:"success"`
+	Failure     int     `json:"failure"`
+	Cancel      int     `json:"cancel"`
+	Cached      int     `json:"cached,omitempty"`
+	SuccessRate float64 `json:"success_rate"`
+	P50         float64 `json:"p50_seconds"`
+	P95         float64 `json:"p95_seconds"`
+
+	durations []float64
+}
+
+// JobSummary is the statistics of a job. Flakiness is the ratio of the result changes between
+// success and failure in the consecutive runs with the same inputs, 0 is stable and 1 alternates
+// in every run.
+type JobSummary struct {
+	Summary
+	Stage     string  `json:"stage"`
+	Action    string  `json:"action"`
+	Flakiness float64 `json:"flakiness"`
+}
+
+// Analytics is the statistics of a flow in the window [Since, Until).
+type Analytics struct {
+	URI    string       `json:"uri"`
+	Tag    string       `json:"tag"`
+	Since  time.Time    `json:"since"`
+	Until  time.Time    `json:"until"`
+	Flow   Summary      `json:"flow"`
+	Stages []Summary    `json:"stages,omitempty"`
+	Jobs   []JobSummary `json:"jobs,omitempty"`
+}
+
+// AnalyticsWindow parses the window of analytics in RFC3339, the default is the last 7 days.
+func AnalyticsWindow(since, until string) (time.Time, time.Time, error) {
+	end := time.Now()
+	if until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("Invalid until %q, it should be RFC3339", until)
+		}
+		end = t
+	}
+
+	start := end.Add(-DefaultAnalyticsWindow)
+	if since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("Invalid since %q, it should be RFC3339", since)
+		}
+		start = t
+	}
+
+	if start.After(end) {
+		return time.Time{}, time.Time{}, fmt.Errorf("The since %s is after the until %s", since, until)
+	}
+
+	return start, end, nil
+}
+
+// Analyze returns the statistics of the flow, its stages and jobs in the window.
+func Analyze(uri, tag string, since, until time.Time) (*Analytics, error) {
+	array := strings.Split(uri, "/")
+	if len(array) != 3 {
+		return nil, fmt.Errorf("Invalid flow URI: %s", uri)
+	}
+
+	flow := new(model.FlowV1)
+	if err := flow.GetWithDeleted(array[0], array[1], array[2], tag); err != nil {
+		return nil, err
+	}
+
+	return analyzeFlow(flow, true, since, until)
+}
+
+// AnalyzeAll returns the statistics of all flows with runs in the window, without the stages and jobs.
+func AnalyzeAll(since, until time.Time) ([]Analytics, error) {
+	flows, err := new(model.FlowV1).List()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Analytics{}
+	for i, _ := range flows {
+		a, err := analyzeFlow(&flows[i], false, since, until)
+		if err != nil {
+			return nil, err
+		}
+		if a.Flow.Runs > 0 {
+			result = append(result, *a)
+		}
+	}
+
+	return result, nil
+}
+
+func analyzeFlow(flow *model.FlowV1, units bool, since, until time.Time) (*Analytics, error) {
+	a := &Analytics{
+		URI:   fmt.Sprintf("%s/%s/%s", flow.Namespace, flow.Repository, flow.Name),
+		Tag:   flow.Tag,
+		Since: since,
+		Until: until,
+		Flow:  Summary{Name: flow.Name},
+	}
+
+	runs, err := new(model.FlowDataV1).ListByWindow(flow.ID, since, until)
+	if err != nil {
+		return nil, err
+	}
+	for _, run := range runs {
+		a.Flow.add(run.Result, run.Start, run.End)
+	}
+	a.Flow.finish()
+
+	if units == false {
+		return a, nil
+	}
+
+	stages, err := model.ListStageData(flow.ID, since, until)
+	if err != nil {
+		return nil, err
+	}
+
+	a.Stages = summarizeStages(stages)
+
+	jobs, err := model.ListJobData(flow.ID, since, until)
+	if err != nil {
+		return nil, err
+	}
+	a.Jobs = summarizeJobs(jobs)
+
+	return a, nil
+}
+
+// summarizeStages returns the summaries of the stage records by the stage name.
+func summarizeStages(records []model.UnitDataV1) []Summary {
+	summaries := map[string]*Summary{}
+	for _, record := range records {
+		if _, ok := summaries[record.Stage]; ok == false {
+			summaries[record.Stage] = &Summary{Name: record.Stage}
+		}
+		summaries[record.Stage].add(record.Result, record.Start, record.End)
+	}
+
+	var result []Summary
+	for _, s := range summaries {
+		s.finish()
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+
+	return result
+}
+
+// summarizeJobs returns the summaries of the job records by the stage, action and job, the
+// flakiest jobs are first.
+func summarizeJobs(records []model.UnitDataV1) []JobSummary {
+	summaries := map[string]*JobSummary{}
+	jobRecords := map[string][]model.UnitDataV1{}
+	for _, record := range records {
+		key := fmt.Sprintf("%s.%s.%s", record.Stage, record.Action, record.Job)
+		if _, ok := summaries[key]; ok == false {
+			summaries[key] = &JobSummary{Summary: Summary{Name: record.Job}, Stage: record.Stage, Action: record.Action}
+		}
+		summaries[key].add(record.Result, record.Start, record.End)
+		jobRecords[key] = append(jobRecords[key], record)
+	}
+
+	var result []JobSummary
+	for key, j := range summaries {
+		j.finish()
+		j.Flakiness = flakiness(jobRecords[key])
+		result = append(result, *j)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Flakiness != result[j].Flakiness {
+			return result[i].Flakiness > result[j].Flakiness
+		}
+		return fmt.Sprintf("%s.%s.%s", result[i].Stage, result[i].Action, result[i].Name) <
+			fmt.Sprintf("%s.%s.%s", result[j].Stage, result[j].Action, result[j].Name)
+	})
+
+	return result
+}
+
+// add counts a run, the unfinished runs are ignored.
+func (s *Summary) add(result string, start, end time.Time) {
+	switch result {
+	case Success:
+		s.Success++
+	case Failure:
+		s.Failure++
+	case Cancel:
+		s.Cancel++
+	case Cached:
+		s.Cached++
+	default:
+		return
+	}
+
+	s.Runs++
+	if result != Cached && end.After(start) {
+		s.durations = append(s.durations, end.Sub(start).Seconds())
+	}
+}
+
+// finish calculates the success rate and percentiles.
+func (s *Summary) finish() {
+	if s.Runs > 0 {
+		s.SuccessRate = round(float64(s.Success+s.Cached) / float64(s.Runs))
+	}
+
+	sort.Float64s(s.durations)
+	s.P50, s.P95 = percentile(s.durations, 50), percentile(s.durations, 95)
+}
+
+// flakiness is the ratio of result changes in the consecutive runs with the same inputs, the
+// records are ordered by the start time. The runs without inputs or canceled are ignored.
+func flakiness(records []model.UnitDataV1) float64 {
+	last := map[string]string{}
+	pairs, changes := 0, 0
+
+	for _, record := range records {
+		result := record.Result
+		if result == Cached {
+			result = Success
+		}
+		if record.Inputs == "" || (result != Success && result != Failure) {
+			continue
+		}
+
+		if previous, ok := last[record.Inputs]; ok {
+			pairs++
+			if previous != result {
+				changes++
+			}
+		}
+		last[record.Inputs] = result
+	}
+
+	if pairs == 0 {
+		return 0
+	}
+
+	return round(float64(changes) / float64(pairs))
+}
+
+// percentile returns the nearest rank percentile of the sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	return round(sorted[rank-1])
+}
+
+func round(value float64) float64 {
+	return math.Floor(value*1000+0.5) / 1000
+}
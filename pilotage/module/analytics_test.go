@@ -0,0 +1,203 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Huawei/containerops/pilotage/model"
+)
+
+func TestFlakiness(t *testing.T) {
+	records := func(results ...string) []model.UnitDataV1 {
+		units := []model.UnitDataV1{}
+		for i := 0; i+1 < len(results); i += 2 {
+			units = append(units, model.UnitDataV1{Inputs: results[i], Result: results[i+1]})
+		}
+		return units
+	}
+
+	tests := []struct {
+		name    string
+		records []model.UnitDataV1
+		want    float64
+	}{
+		{"no runs", records(), 0},
+		{"one run", records("a", Failure), 0},
+		{"stable", records("a", Success, "a", Success, "a", Success), 0},
+		{"always failing", records("a", Failure, "a", Failure), 0},
+		{"alternating", records("a", Success, "a", Failure, "a", Success), 1},
+		{"one change in three pairs", records("a", Success, "a", Success, "a", Failure, "a", Failure), 0.333},
+		{"changed inputs are not flaky", records("a", Success, "b", Failure, "c", Success), 0},
+		{"inputs are compared separately", records("a", Success, "b", Failure, "a", Success, "b", Failure), 0},
+		{"cached is success", records("a", Failure, "a", Cached), 1},
+		{"canceled and no inputs are ignored", records("a", Success, "a", Cancel, "", Failure, "a", Success), 0},
+	}
+
+	for _, test := range tests {
+		if got := flakiness(test.records); got != test.want {
+			t.Errorf("%s: flakiness = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	tests := []struct {
+		sorted []float64
+		p      float64
+		want   float64
+	}{
+		{nil, 50, 0},
+		{[]float64{3}, 50, 3},
+		{[]float64{3}, 95, 3},
+		{[]float64{1, 2, 3, 4}, 50, 2},
+		{[]float64{1, 2, 3, 4}, 95, 4},
+		{[]float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 50, 5},
+		{[]float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 95, 10},
+		{[]float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 0, 1},
+		{[]float64{0.1234, 0.5678}, 50, 0.123},
+	}
+
+	for _, test := range tests {
+		if got := percentile(test.sorted, test.p); got != test.want {
+			t.Errorf("percentile(%v, %v) = %v, want %v", test.sorted, test.p, got, test.want)
+		}
+	}
+}
+
+func TestSummaryAdd(t *testing.T) {
+	start := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	runs := []struct {
+		result  string
+		seconds int
+	}{
+		{Success, 10}, {Success, 20}, {Failure, 40}, {Cancel, 30}, {Cached, 0}, {Running, 50}, {Pending, 0},
+	}
+
+	s := &Summary{Name: "build"}
+	for _, run := range runs {
+		s.add(run.result, start, start.Add(time.Duration(run.seconds)*time.Second))
+	}
+	s.finish()
+
+	if s.Runs != 5 || s.Success != 2 || s.Failure != 1 || s.Cancel != 1 || s.Cached != 1 {
+		t.Errorf("Summary counts %d runs, %d success, %d failure, %d cancel, %d cached, want 5, 2, 1, 1, 1",
+			s.Runs, s.Success, s.Failure, s.Cancel, s.Cached)
+	}
+	if s.SuccessRate != 0.6 {
+		t.Errorf("Summary success rate = %v, want 0.6", s.SuccessRate)
+	}
+	// The cached and unfinished runs are not in the durations.
+	if s.P50 != 20 || s.P95 != 40 {
+		t.Errorf("Summary p50 = %v, p95 = %v, want 20, 40", s.P50, s.P95)
+	}
+}
+
+func TestAnalyticsWindow(t *testing.T) {
+	tests := []struct {
+		since  string
+		until  string
+		days   float64
+		errors bool
+	}{
+		{"", "2018-01-08T00:00:00Z", 7, false},
+		{"2018-01-01T00:00:00Z", "2018-01-03T00:00:00Z", 2, false},
+		{"2018-01-03T00:00:00Z", "2018-01-01T00:00:00Z", 0, true},
+		{"yesterday", "", 0, true},
+		{"", "2018-01-08", 0, true},
+	}
+
+	for _, test := range tests {
+		since, until, err := AnalyticsWindow(test.since, test.until)
+		if (err != nil) != test.errors {
+			t.Errorf("AnalyticsWindow(%q, %q) error = %v, want error %t", test.since, test.until, err, test.errors)
+		} else if err == nil && until.Sub(since).Hours()/24 != test.days {
+			t.Errorf("AnalyticsWindow(%q, %q) is %v days, want %v", test.since, test.until, until.Sub(since).Hours()/24, test.days)
+		}
+	}
+}
+
+func TestSummarizeJobHistory(t *testing.T) {
+	// A week of nightly runs: the unit tests fail now and then on the same commit, the
+	// build is cached on the unchanged commits and the lint of the two actions never fails.
+	night := time.Date(2018, 1, 1, 2, 0, 0, 0, time.UTC)
+	commits := []string{"a1", "a1", "a1", "b2", "b2", "b2", "c3"}
+	tests := []string{Success, Failure, Success, Success, Failure, Failure, Success}
+
+	jobs, stages := []model.UnitDataV1{}, []model.UnitDataV1{}
+	for day, commit := range commits {
+		start := night.Add(time.Duration(day) * 24 * time.Hour)
+		inputs := `{"commit":"` + commit + `"}`
+
+		build := Success
+		if day > 0 && commits[day-1] == commit {
+			build = Cached
+		}
+		jobs = append(jobs,
+			model.UnitDataV1{Stage: "build", Action: "compile", Job: "go-build", Inputs: inputs, Result: build, Start: start, End: start.Add(2 * time.Minute)},
+			model.UnitDataV1{Stage: "test", Action: "unit", Job: "go-test", Inputs: inputs, Result: tests[day], Start: start, End: start.Add(5 * time.Minute)},
+			model.UnitDataV1{Stage: "test", Action: "unit", Job: "lint", Inputs: inputs, Result: Success, Start: start, End: start.Add(time.Minute)},
+			model.UnitDataV1{Stage: "test", Action: "style", Job: "lint", Inputs: inputs, Result: Success, Start: start, End: start.Add(time.Minute)},
+		)
+		stages = append(stages,
+			model.UnitDataV1{Stage: "test", Result: tests[day], Start: start, End: start.Add(5 * time.Minute)},
+			model.UnitDataV1{Stage: "build", Result: Success, Start: start, End: start.Add(2 * time.Minute)},
+		)
+	}
+
+	summaries := summarizeJobs(jobs)
+	if len(summaries) != 4 {
+		t.Fatalf("The history has %d job summaries, want 4: %+v", len(summaries), summaries)
+	}
+
+	// The flaky job is first, then the stable jobs by the stage, action and name.
+	order := []string{}
+	for _, s := range summaries {
+		order = append(order, s.Stage+"."+s.Action+"."+s.Name)
+	}
+	if strings.Join(order, " ") != "test.unit.go-test build.compile.go-build test.style.lint test.unit.lint" {
+		t.Errorf("The job summaries are ordered %v", order)
+	}
+
+	// On a1 success, failure, success and on b2 success, failure, failure: 3 changes in 4 pairs.
+	flaky := summaries[0]
+	if flaky.Flakiness != 0.75 || flaky.Runs != 7 || flaky.Failure != 3 || flaky.SuccessRate != 0.571 {
+		t.Errorf("The unit tests are %v flaky in %d runs with %d failures and %v success rate, want 0.75, 7, 3, 0.571",
+			flaky.Flakiness, flaky.Runs, flaky.Failure, flaky.SuccessRate)
+	}
+
+	build := summaries[1]
+	if build.Flakiness != 0 || build.Success != 3 || build.Cached != 4 || build.SuccessRate != 1 || build.P95 != 120 {
+		t.Errorf("The build has %d success and %d cached with %v flakiness, %v success rate and p95 %v, want 3, 4, 0, 1, 120",
+			build.Success, build.Cached, build.Flakiness, build.SuccessRate, build.P95)
+	}
+	for _, lint := range summaries[2:] {
+		if lint.Runs != 7 || lint.Flakiness != 0 {
+			t.Errorf("The lint of %s has %d runs and %v flakiness, want 7 and 0", lint.Action, lint.Runs, lint.Flakiness)
+		}
+	}
+
+	s := summarizeStages(stages)
+	if len(s) != 2 || s[0].Name != "build" || s[1].Name != "test" || s[1].Failure != 3 || s[1].P50 != 300 {
+		t.Errorf("The stage summaries are %+v, want build, then test with 3 failures and p50 300", s)
+	}
+	if summarizeJobs(nil) != nil || summarizeStages(nil) != nil {
+		t.Errorf("The summaries of no records should be empty")
+	}
+}
@@ -0,0 +1,489 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Huawei/containerops/pilotage/model"
+)
+
+const (
+	// ArchiveVersion is the version of the run archive layout.
+	ArchiveVersion = 1
+
+	// ArchiveContentType is the content type of the run archive.
+	ArchiveContentType = "application/gzip"
+
+	// Files of the run archive
+	ArchiveManifest   = "manifest.json"
+	ArchiveFlowJSON   = "flow.json"
+	ArchiveFlowYAML   = "flow.yaml"
+	ArchiveParameters = "parameters.json"
+	ArchiveOutputs    = "outputs.json"
+	ArchiveTimings    = "timings.json"
+	ArchiveTests      = "tests.json"
+	ArchiveLogs       = "logs.jsonl"
+
+	// archiveLogBatch is the log lines inserted in one statement when a run is imported.
+	archiveLogBatch = 500
+)
+
+// Manifest describes the run of a run archive. The Run, Parent and TriggeredBy are the run ids in
+// the exporting pilotage, they aren't kept when the archive is imported.
+type Manifest struct {
+	Version     int       `json:"version"`
+	Exported    time.Time `json:"exported"`
+	URI         string    `json:"uri"`
+	Tag         string    `json:"tag"`
+	Run         int64     `json:"run"`
+	Number      int64     `json:"number"`
+	Parent      int64     `json:"parent,omitempty"`
+	TriggeredBy int64     `json:"triggered_by,omitempty"`
+	Result      string    `json:"result"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+}
+
+// ArchiveLog is a log line of the run, the Unit is the stage, stage.action or stage.action.job of
+// the log phase, it's empty for the flow logs.
+type ArchiveLog struct {
+	Phase   string    `json:"phase"`
+	Unit    string    `json:"unit,omitempty"`
+	Level   string    `json:"level"`
+	Time    time.Time `json:"time"`
+	Content string    `json:"content"`
+}
+
+// FindRun returns the recorded run of the flow uri, tag and number.
+func FindRun(uri, tag string, number int64) (*model.FlowDataV1, error) {
+	namespace, repository, name, err := (&Flow{URI: uri}).URIs()
+	if err != nil {
+		return nil, err
+	}
+
+	flow := new(model.FlowV1)
+	if err := flow.GetWithDeleted(namespace, repository, name, tag); err != nil {
+		return nil, err
+	}
+
+	data := new(model.FlowDataV1)
+	if err := data.Get(flow.ID, number); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// ExportRun writes the tar.gz archive of a finished run, with the resolved flow definition, the
+// parameters, outputs, the timings of stages, actions and jobs, the test results and all logs.
+func ExportRun(w io.Writer, data *model.FlowDataV1) error {
+	if data.Result == Pending || data.Result == Running {
+		return fmt.Errorf("Flow run %d is %s, only the finished runs are exported", data.Number, data.Result)
+	}
+
+	flow := new(model.FlowV1)
+	if err := flow.GetByID(data.FlowID); err != nil {
+		return err
+	}
+
+	f := new(Flow)
+	if err := json.Unmarshal([]byte(data.Content), f); err != nil {
+		return fmt.Errorf("Unmarshal the flow of run %d error: %s", data.Number, err.Error())
+	}
+
+	manifest := Manifest{Version: ArchiveVersion, Exported: time.Now(), URI: f.URI, Tag: flow.Tag, Run: data.ID,
+		Number: data.Number, Parent: data.ParentID, TriggeredBy: data.TriggerID, Result: data.Result, Start: data.Start, End: data.End}
+
+	definition, err := f.YAML()
+	if err != nil {
+		return err
+	}
+
+	parameters := f.Parameters
+	if parameters == nil {
+		parameters = map[string]string{}
+	}
+
+	outputs := map[string]string{}
+	if data.Outputs != "" {
+		if err := json.Unmarshal([]byte(data.Outputs), &outputs); err != nil {
+			return fmt.Errorf("Unmarshal the outputs of run %d error: %s", data.Number, err.Error())
+		}
+	}
+
+	units, err := model.ListRunUnits(data.FlowID, data.ID, data.Start, data.End)
+	if err != nil {
+		return err
+	}
+
+	records, err := new(model.TestCaseV1).ListByRun(data.ID)
+	if err != nil {
+		return err
+	}
+
+	logs, err := runLogs(data)
+	if err != nil {
+		return err
+	}
+
+	dir := fmt.Sprintf("%s-%s-%d", strings.Replace(f.URI, "/", "-", -1), flow.Tag, data.Number)
+	return writeArchive(w, dir, manifest.Exported, []archiveFile{
+		{ArchiveManifest, manifest},
+		{ArchiveFlowJSON, json.RawMessage(data.Content)},
+		{ArchiveFlowYAML, definition},
+		{ArchiveParameters, parameters},
+		{ArchiveOutputs, outputs},
+		{ArchiveTimings, units},
+		{ArchiveTests, testCases(records)},
+		{ArchiveLogs, logs},
+	})
+}
+
+// archiveFile is a file of the run archive, the value is the raw content or marshaled as JSON.
+type archiveFile struct {
+	name string
+	v    interface{}
+}
+
+// writeArchive writes the files into the directory of a tar.gz archive.
+func writeArchive(w io.Writer, dir string, modTime time.Time, files []archiveFile) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	for _, file := range files {
+		var content []byte
+		switch v := file.v.(type) {
+		case []byte:
+			content = v
+		default:
+			var err error
+			if content, err = json.MarshalIndent(v, "", "  "); err != nil {
+				return err
+			}
+		}
+
+		header := &tar.Header{Name: path.Join(dir, file.name), Mode: 0644, Size: int64(len(content)), ModTime: modTime}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(content); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// runLogs returns the logs of run as JSON lines, the flow logs are recorded with the flow id and
+// the unit logs with the unit ids.
+func runLogs(data *model.FlowDataV1) ([]byte, error) {
+	names, err := model.UnitNames(data.FlowID)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := new(model.LogV1).ListByRun(data.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	encoder := json.NewEncoder(buf)
+	for _, r := range records {
+		line := ArchiveLog{Phase: r.Phase, Unit: names[r.Phase][r.PhaseID], Level: r.Level, Time: r.EventTime, Content: r.Content}
+		if err := encoder.Encode(line); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// ImportRun loads a run archive into the history as a new run of the flow. The flow is created
+// with the archived definition when it isn't recorded, and the run gets the next number of the
+// flow. The archive is parsed before it's recorded, and the run partly recorded is deleted when
+// the import fails. It returns the imported run.
+func ImportRun(r io.Reader) (*model.FlowDataV1, error) {
+	files, err := readArchive(r)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := Manifest{}
+	if err := json.Unmarshal(files[ArchiveManifest], &manifest); err != nil {
+		return nil, fmt.Errorf("Invalid run archive %s: %s", ArchiveManifest, err.Error())
+	}
+	if manifest.Version != ArchiveVersion {
+		return nil, fmt.Errorf("Unsupported run archive version %d", manifest.Version)
+	}
+
+	content := files[ArchiveFlowJSON]
+	f := new(Flow)
+	if err := json.Unmarshal(content, f); err != nil {
+		return nil, fmt.Errorf("Invalid run archive %s: %s", ArchiveFlowJSON, err.Error())
+	}
+
+	namespace, repository, name, err := f.URIs()
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := "{}"
+	if data, ok := files[ArchiveOutputs]; ok {
+		if err := json.Unmarshal(data, &map[string]string{}); err != nil {
+			return nil, fmt.Errorf("Invalid run archive %s: %s", ArchiveOutputs, err.Error())
+		}
+		outputs = string(data)
+	}
+
+	units := model.RunUnitsV1{}
+	if timings := files[ArchiveTimings]; len(timings) > 0 {
+		if err := json.Unmarshal(timings, &units); err != nil {
+			return nil, fmt.Errorf("Invalid run archive %s: %s", ArchiveTimings, err.Error())
+		}
+	}
+
+	logs, err := archiveLogs(files[ArchiveLogs])
+	if err != nil {
+		return nil, err
+	}
+
+	cases := []TestCase{}
+	if tests := files[ArchiveTests]; len(tests) > 0 {
+		if err := json.Unmarshal(tests, &cases); err != nil {
+			return nil, fmt.Errorf("Invalid run archive %s: %s", ArchiveTests, err.Error())
+		}
+	}
+
+	flow := new(model.FlowV1)
+	if err := flow.GetWithDeleted(namespace, repository, name, manifest.Tag); err != nil {
+		if _, err := flow.Put(namespace, repository, name, manifest.Tag, f.Title, string(content), f.Version, f.Timeout); err != nil {
+			return nil, fmt.Errorf("Save flow %s:%s error: %s", f.URI, manifest.Tag, err.Error())
+		}
+	}
+
+	data := new(model.FlowDataV1)
+	if err := data.Put(flow.ID, 0, 0, manifest.Result, string(content), outputs, manifest.Start, manifest.End); err != nil {
+		return nil, fmt.Errorf("Save flow run error: %s", err.Error())
+	}
+
+	if err := f.importRun(flow.ID, data.ID, manifest.End, &units, logs, cases); err != nil {
+		if e := model.DeleteRun(data.ID); e != nil {
+			return nil, fmt.Errorf("%s, and delete the partly imported run %d error: %s", err.Error(), data.ID, e.Error())
+		}
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// importRun records the units, logs and test cases of the imported run.
+func (f *Flow) importRun(flowID, runID int64, end time.Time, units *model.RunUnitsV1, logs []ArchiveLog, cases []TestCase) error {
+	ids, err := f.importUnits(flowID, runID, units)
+	if err != nil {
+		return err
+	}
+
+	if err := importLogs(flowID, runID, ids, logs); err != nil {
+		return err
+	}
+
+	return importTests(flowID, runID, end, cases)
+}
+
+func readArchive(r io.Reader) (map[string][]byte, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid run archive: %s", err.Error())
+	}
+	defer gr.Close()
+
+	files := map[string][]byte{}
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("Invalid run archive: %s", err.Error())
+		}
+
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid run archive: %s", err.Error())
+		}
+		files[path.Base(header.Name)] = content
+	}
+
+	for _, name := range []string{ArchiveManifest, ArchiveFlowJSON} {
+		if _, ok := files[name]; ok == false {
+			return nil, fmt.Errorf("Invalid run archive: %s is missing", name)
+		}
+	}
+
+	return files, nil
+}
+
+// importUnits records the stages, actions and jobs of the flow and their archived timings with
+// the run. It returns the unit ids by the log phase and unit name.
+func (f *Flow) importUnits(flowID, runID int64, units *model.RunUnitsV1) (map[string]map[string]int64, error) {
+	ids := map[string]map[string]int64{model.STAGE: {}, model.ACTION: {}, model.JOB: {}}
+
+	for _, stage := range f.Stages {
+		stageID, err := new(model.StageV1).Put(flowID, stage.T, stage.Name, stage.Title, stage.Sequencing)
+		if err != nil {
+			return nil, err
+		}
+		ids[model.STAGE][stage.Name] = stageID
+
+		for _, action := range stage.Actions {
+			actionID, err := new(model.ActionV1).Put(stageID, action.Name, action.Title)
+			if err != nil {
+				return nil, err
+			}
+			ids[model.ACTION][fmt.Sprintf("%s.%s", stage.Name, action.Name)] = actionID
+
+			for i, _ := range action.Jobs {
+				jobID, err := action.Jobs[i].record(actionID)
+				if err != nil {
+					return nil, err
+				}
+				ids[model.JOB][fmt.Sprintf("%s.%s.%s", stage.Name, action.Name, action.Jobs[i].Name)] = jobID
+			}
+		}
+	}
+
+	for _, u := range units.Stages {
+		if id, ok := ids[model.STAGE][u.Stage]; ok {
+			number, _ := new(model.StageDataV1).GetNumbers(id)
+			if err := new(model.StageDataV1).Put(id, number+1, runID, u.Result, u.Start, u.End); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, u := range units.Actions {
+		if id, ok := ids[model.ACTION][fmt.Sprintf("%s.%s", u.Stage, u.Action)]; ok {
+			number, _ := new(model.ActionDataV1).GetNumbers(id)
+			if err := new(model.ActionDataV1).Put(id, number+1, runID, u.Result, u.Start, u.End); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, u := range units.Jobs {
+		if id, ok := ids[model.JOB][fmt.Sprintf("%s.%s.%s", u.Stage, u.Action, u.Job)]; ok {
+			number, _ := new(model.JobDataV1).GetNumbers(id)
+			if err := new(model.JobDataV1).Put(id, number+1, runID, u.Result, u.Inputs, u.Start, u.End); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return ids, nil
+}
+
+// archiveLogs parses the log lines of archive.
+func archiveLogs(logs []byte) ([]ArchiveLog, error) {
+	lines := []ArchiveLog{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(logs))
+	scanner.Buffer(make([]byte, 64*1024), len(logs)+64*1024)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		line := ArchiveLog{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return nil, fmt.Errorf("Invalid run archive %s: %s", ArchiveLogs, err.Error())
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Invalid run archive %s: %s", ArchiveLogs, err.Error())
+	}
+
+	return lines, nil
+}
+
+// importLogs records the archived logs with the run in batches.
+func importLogs(flowID, runID int64, ids map[string]map[string]int64, logs []ArchiveLog) error {
+	records := logRecords(flowID, runID, ids, logs)
+	for len(records) > 0 {
+		n := archiveLogBatch
+		if n > len(records) {
+			n = len(records)
+		}
+		if err := model.CreateBatch(records[:n]); err != nil {
+			return err
+		}
+		records = records[n:]
+	}
+
+	return nil
+}
+
+// logRecords returns the log records of the archived logs in the run, the logs of units not in
+// the flow are the flow logs.
+func logRecords(flowID, runID int64, ids map[string]map[string]int64, logs []ArchiveLog) []model.LogV1 {
+	records := []model.LogV1{}
+	for _, line := range logs {
+		phase, phaseID := model.FLOW, flowID
+		if id, ok := ids[line.Phase][line.Unit]; ok {
+			phase, phaseID = line.Phase, id
+		}
+
+		records = append(records, model.LogV1{Level: line.Level, Phase: phase, PhaseID: phaseID, RunID: runID,
+			Content: line.Content, EventTime: line.Time})
+	}
+
+	return records
+}
+
+// importTests records the archived test cases with the run.
+func importTests(flowID, runID int64, end time.Time, cases []TestCase) error {
+	if len(cases) == 0 {
+		return nil
+	}
+
+	records := []model.TestCaseV1{}
+	for _, c := range cases {
+		records = append(records, model.TestCaseV1{FlowID: flowID, RunID: runID, Job: c.Job, Suite: c.Suite, Class: c.Class,
+			Name: c.Name, Result: c.Result, Duration: c.Duration, Message: c.Message, CreatedAt: end})
+	}
+
+	return model.CreateTestCases(records)
+}
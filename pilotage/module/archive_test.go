@@ -0,0 +1,248 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Huawei/containerops/pilotage/model"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	exported := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	manifest := Manifest{Version: ArchiveVersion, Exported: exported, URI: "cncf/demo/hello", Tag: "latest", Number: 3, Result: Success}
+
+	buffer := new(bytes.Buffer)
+	if err := writeArchive(buffer, "cncf-demo-hello-latest-3", exported, []archiveFile{
+		{ArchiveManifest, manifest},
+		{ArchiveFlowYAML, []byte("uri: cncf/demo/hello\n")},
+		{ArchiveOutputs, map[string]string{"KEY": "value"}},
+	}); err != nil {
+		t.Fatalf("writeArchive error: %s", err.Error())
+	}
+
+	gr, err := gzip.NewReader(bytes.NewReader(buffer.Bytes()))
+	if err != nil {
+		t.Fatalf("gzip error: %s", err.Error())
+	}
+	tr := tar.NewReader(gr)
+	for _, name := range []string{ArchiveManifest, ArchiveFlowYAML, ArchiveOutputs} {
+		header, err := tr.Next()
+		if err != nil {
+			t.Fatalf("tar error: %s", err.Error())
+		}
+		if header.Name != "cncf-demo-hello-latest-3/"+name || header.ModTime.Equal(exported) == false {
+			t.Errorf("header = %s %s, want %s %s", header.Name, header.ModTime, "cncf-demo-hello-latest-3/"+name, exported)
+		}
+	}
+
+	_, err = readArchive(bytes.NewReader(buffer.Bytes()))
+	if err == nil || err.Error() != "Invalid run archive: flow.json is missing" {
+		t.Errorf("readArchive error = %v, want flow.json is missing", err)
+	}
+
+	buffer.Reset()
+	writeArchive(buffer, "cncf-demo-hello-latest-3", exported, []archiveFile{
+		{ArchiveManifest, manifest},
+		{ArchiveFlowJSON, []byte(`{"uri":"cncf/demo/hello"}`)},
+		{ArchiveFlowYAML, []byte("uri: cncf/demo/hello\n")},
+		{ArchiveOutputs, map[string]string{"KEY": "value"}},
+	})
+
+	files, err := readArchive(bytes.NewReader(buffer.Bytes()))
+	if err != nil {
+		t.Fatalf("readArchive error: %s", err.Error())
+	}
+	expected := map[string]string{
+		ArchiveFlowJSON: `{"uri":"cncf/demo/hello"}`,
+		ArchiveFlowYAML: "uri: cncf/demo/hello\n",
+		ArchiveOutputs:  "{\n  \"KEY\": \"value\"\n}",
+	}
+	for name, content := range expected {
+		if string(files[name]) != content {
+			t.Errorf("%s = %q, want %q", name, files[name], content)
+		}
+	}
+	if strings.Contains(string(files[ArchiveManifest]), `"version": 1`) == false {
+		t.Errorf("%s = %s, want the version 1", ArchiveManifest, files[ArchiveManifest])
+	}
+}
+
+func TestReadArchive(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries map[string]string
+		gzip    bool
+		files   int
+		err     string
+	}{
+		{"complete", map[string]string{"run/": "", "run/manifest.json": "{}", "run/flow.json": "{}"}, true, 2, ""},
+		{"no manifest", map[string]string{"run/flow.json": "{}"}, true, 0, "Invalid run archive: manifest.json is missing"},
+		{"no flow", map[string]string{"run/manifest.json": "{}"}, true, 0, "Invalid run archive: flow.json is missing"},
+		{"not gzip", map[string]string{"run/manifest.json": "{}", "run/flow.json": "{}"}, false, 0, "Invalid run archive: gzip: invalid header"},
+	}
+
+	for _, test := range tests {
+		files, err := readArchive(bytes.NewReader(tarball(t, test.entries, test.gzip)))
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: error = %v, want %s", test.name, err, test.err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: error = %s", test.name, err.Error())
+		} else if len(files) != test.files {
+			t.Errorf("%s: files = %d, want %d", test.name, len(files), test.files)
+		}
+	}
+}
+
+func TestImportRunInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		flow     string
+		err      string
+	}{
+		{"manifest", "{", "{}", "Invalid run archive manifest.json: unexpected end of JSON input"},
+		{"version", `{"version":2}`, "{}", "Unsupported run archive version 2"},
+		{"flow", `{"version":1}`, "[", "Invalid run archive flow.json: unexpected end of JSON input"},
+	}
+
+	for _, test := range tests {
+		entries := map[string]string{"run/manifest.json": test.manifest, "run/flow.json": test.flow}
+		_, err := ImportRun(bytes.NewReader(tarball(t, entries, true)))
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%s: error = %v, want %s", test.name, err, test.err)
+		}
+	}
+}
+
+// tarball returns the tar archive of entries, the names ending with / are the directories.
+func tarball(t *testing.T, entries map[string]string, compress bool) []byte {
+	buffer := new(bytes.Buffer)
+	tw := tar.NewWriter(buffer)
+	for name, content := range entries {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if strings.HasSuffix(name, "/") {
+			header.Mode, header.Size, header.Typeflag = 0755, 0, tar.TypeDir
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatalf("tar error: %s", err.Error())
+		}
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+
+	if compress == false {
+		return buffer.Bytes()
+	}
+
+	compressed := new(bytes.Buffer)
+	gw := gzip.NewWriter(compressed)
+	gw.Write(buffer.Bytes())
+	gw.Close()
+	return compressed.Bytes()
+}
+
+// The archive is parsed before the run is recorded, an invalid file records nothing.
+func TestImportRunInvalidFiles(t *testing.T) {
+	manifest := `{"version":1,"tag":"latest","result":"success"}`
+	flow := `{"uri":"cncf/demo/hello"}`
+
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"outputs.json", "[]", "Invalid run archive outputs.json"},
+		{"timings.json", "{", "Invalid run archive timings.json"},
+		{"logs.jsonl", "{\"phase\":\"flow\"}\nnot json\n", "Invalid run archive logs.jsonl"},
+		{"tests.json", "{}", "Invalid run archive tests.json"},
+	}
+
+	for _, test := range tests {
+		entries := map[string]string{"run/manifest.json": manifest, "run/flow.json": flow, "run/" + test.name: test.content}
+		_, err := ImportRun(bytes.NewReader(tarball(t, entries, true)))
+		if err == nil || strings.HasPrefix(err.Error(), test.err) == false {
+			t.Errorf("%s: error = %v, want %s", test.name, err, test.err)
+		}
+	}
+}
+
+// The logs exported from a run are imported into the flow where the go-vet job has been renamed
+// to go-lint, the logs of go-vet are kept as the flow logs.
+func TestImportRunLogs(t *testing.T) {
+	start := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	exported := []ArchiveLog{
+		{Phase: model.FLOW, Level: model.INFO, Content: "Flow [cncf/demo/hello] start"},
+		{Phase: model.STAGE, Unit: "build", Level: model.INFO, Content: "Stage [build] start"},
+		{Phase: model.ACTION, Unit: "build.compile", Level: model.INFO, Content: "Action [compile] start"},
+		{Phase: model.JOB, Unit: "build.compile.go-build", Level: model.INFO, Content: strings.Repeat("compile ", 20000)},
+		{Phase: model.JOB, Unit: "build.compile.go-vet", Level: model.ERROR, Content: "vet: unreachable code"},
+		{Phase: model.STAGE, Unit: "deploy", Level: model.INFO, Content: "Stage [deploy] start"},
+	}
+
+	buffer := new(bytes.Buffer)
+	encoder := json.NewEncoder(buffer)
+	for i, _ := range exported {
+		exported[i].Time = start.Add(time.Duration(i) * time.Second)
+		encoder.Encode(exported[i])
+		if i == 2 {
+			buffer.WriteString("\n")
+		}
+	}
+
+	logs, err := archiveLogs(buffer.Bytes())
+	if err != nil {
+		t.Fatalf("Parse the exported logs error: %s", err.Error())
+	}
+	if len(logs) != len(exported) {
+		t.Fatalf("The exported logs have %d lines, want %d", len(logs), len(exported))
+	}
+
+	ids := map[string]map[string]int64{
+		model.STAGE:  {"build": 11, "deploy": 12},
+		model.ACTION: {"build.compile": 21},
+		model.JOB:    {"build.compile.go-build": 31, "build.compile.go-lint": 32},
+	}
+	records := logRecords(1, 9, ids, logs)
+
+	want := []struct {
+		phase string
+		id    int64
+	}{
+		{model.FLOW, 1}, {model.STAGE, 11}, {model.ACTION, 21}, {model.JOB, 31}, {model.FLOW, 1}, {model.STAGE, 12},
+	}
+	for i, r := range records {
+		if r.Phase != want[i].phase || r.PhaseID != want[i].id || r.RunID != 9 {
+			t.Errorf("The log %q is recorded as %s %d of run %d, want %s %d of run 9",
+				exported[i].Unit, r.Phase, r.PhaseID, r.RunID, want[i].phase, want[i].id)
+		}
+		if r.Content != exported[i].Content || r.Level != exported[i].Level || r.EventTime.Equal(exported[i].Time) == false {
+			t.Errorf("The log %d isn't recorded as exported: %s %s", i, r.Level, r.EventTime)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/Huawei/containerops/pilotage/model"
+)
+
+const (
+	// BadgeContentType is the content type of status badge.
+	BadgeContentType = "image/svg+xml; charset=utf-8"
+	// DefaultBadgeLabel is the left text of badge.
+	DefaultBadgeLabel = "pilotage"
+	// Unknown is the badge status of flow never run.
+	Unknown = "unknown"
+)
+
+var (
+	// badgeColors are the colors of run results.
+	badgeColors = map[string]string{
+		Success: "#4c1",
+		Failure: "#e05d44",
+		Cancel:  "#9f9f9f",
+		Running: "#dfb317",
+		Pending: "#dfb317",
+		Unknown: "#9f9f9f",
+	}
+)
+
+// LatestStatus returns the result of the latest run of flow, Unknown when it never runs.
+func LatestStatus(namespace, repository, name, tag string) string {
+	flow := new(model.FlowV1)
+	if err := flow.Get(namespace, repository, name, tag); err != nil {
+		return Unknown
+	}
+
+	data := new(model.FlowDataV1)
+	if err := data.Latest(flow.ID); err != nil || data.Result == "" {
+		return Unknown
+	}
+
+	return data.Result
+}
+
+// Badge returns the SVG badge of the status in the flat style of shields.io.
+func Badge(label, status string) []byte {
+	color, ok := badgeColors[status]
+	if ok == false {
+		color = badgeColors[Unknown]
+	}
+
+	// The text width is estimated from 11px Verdana.
+	left, right := badgeWidth(label), badgeWidth(status)
+	width := left + right
+
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="20" role="img" aria-label="%s: %s">`, width, html.EscapeString(label), html.EscapeString(status))
+	fmt.Fprintf(buf, `<title>%s: %s</title>`, html.EscapeString(label), html.EscapeString(status))
+	fmt.Fprint(buf, `<linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>`)
+	fmt.Fprintf(buf, `<clipPath id="r"><rect width="%d" height="20" rx="3" fill="#fff"/></clipPath>`, width)
+	fmt.Fprintf(buf, `<g clip-path="url(#r)"><rect width="%d" height="20" fill="#555"/><rect x="%d" width="%d" height="20" fill="%s"/><rect width="%d" height="20" fill="url(#s)"/></g>`,
+		left, left, right, color, width)
+	fmt.Fprint(buf, `<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">`)
+	fmt.Fprintf(buf, `<text x="%d" y="15" fill="#010101" fill-opacity=".3">%s</text><text x="%d" y="14">%s</text>`,
+		left/2, html.EscapeString(label), left/2, html.EscapeString(label))
+	fmt.Fprintf(buf, `<text x="%d" y="15" fill="#010101" fill-opacity=".3">%s</text><text x="%d" y="14">%s</text>`,
+		left+right/2, html.EscapeString(status), left+right/2, html.EscapeString(status))
+	fmt.Fprint(buf, `</g></svg>`)
+
+	return buf.Bytes()
+}
+
+func badgeWidth(text string) int {
+	width := 10
+	for _, r := range text {
+		switch {
+		case strings.ContainsRune("iljtf.:|!' ", r):
+			width += 4
+		case strings.ContainsRune("mwMW", r):
+			width += 10
+		default:
+			width += 7
+		}
+	}
+
+	return width
+}
@@ -0,0 +1,387 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Huawei/containerops/pilotage/model"
+)
+
+const (
+	// DockerHubRegistry is the registry of image without domain.
+	DockerHubRegistry = "registry-1.docker.io"
+)
+
+var (
+	// manifestMediaTypes are the manifests accepted when resolving the image digest.
+	manifestMediaTypes = []string{
+		"application/vnd.docker.distribution.manifest.list.v2+json",
+		"application/vnd.docker.distribution.manifest.v2+json",
+		"application/vnd.oci.image.index.v1+json",
+		"application/vnd.oci.image.manifest.v1+json",
+	}
+
+	// challengeRegexp matches the parameters of Bearer challenge in the WWW-Authenticate header.
+	challengeRegexp = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+	// artifactClient checks the artifacts referenced by the outputs of a cached job.
+	artifactClient = &http.Client{Timeout: 30 * time.Second}
+)
+
+// Cache opts in the job result caching. The Key is the inputs of job not in the image or
+// environments, like the git tree SHA of the source, and could have expressions.
+type Cache struct {
+	Key string `json:"key" yaml:"key"`
+}
+
+// CacheKey returns the cache key of job from the pod would run. It's the hash of the resolved
+// cache key, the image digests, the environments, the script and the declared outputs of job.
+func (j *Job) CacheKey(pod *apiv1.Pod, f *Flow, outputs map[string]string) (string, error) {
+	key, err := f.Scope(j, outputs).Interpolate(j.Cache.Key)
+	if err != nil {
+		return "", fmt.Errorf("cache key: %s", err.Error())
+	}
+
+	inputs := []string{fmt.Sprintf("key=%s", key)}
+	for _, container := range pod.Spec.Containers {
+		digest, err := ImageDigest(container.Image)
+		if err != nil {
+			return "", err
+		}
+		inputs = append(inputs, fmt.Sprintf("image=%s", digest))
+		inputs = append(inputs, containerEnvs(container)...)
+		if len(container.Command) > 0 {
+			inputs = append(inputs, fmt.Sprintf("command=%s", strings.Join(container.Command, " ")))
+		}
+	}
+
+	declared := append([]string{}, j.Outputs...)
+	sort.Strings(declared)
+	inputs = append(inputs, fmt.Sprintf("outputs=%s", strings.Join(declared, ",")))
+
+	sum := sha256.Sum256([]byte(strings.Join(inputs, "\n")))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// InputsHash returns the hash of the images, environments and scripts of pod, the runs of a job
+// with the same hash have the same inputs.
+func InputsHash(pod *apiv1.Pod) string {
+	inputs := []string{}
+	for _, container := range pod.Spec.Containers {
+		inputs = append(inputs, fmt.Sprintf("image=%s", container.Image))
+		inputs = append(inputs, containerEnvs(container)...)
+		if len(container.Command) > 0 {
+			inputs = append(inputs, fmt.Sprintf("command=%s", strings.Join(container.Command, " ")))
+		}
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(inputs, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// containerEnvs returns the sorted environments of container.
+func containerEnvs(container apiv1.Container) []string {
+	envs := []string{}
+	for _, env := range container.Env {
+		envs = append(envs, fmt.Sprintf("env=%s=%s", env.Name, env.Value))
+	}
+	sort.Strings(envs)
+
+	return envs
+}
+
+// ImageDigest returns the manifest digest of image from its registry. The tag could be moved,
+// so the job is never cached by the image reference.
+func ImageDigest(image string) (string, error) {
+	if i := strings.Index(image, "@"); i > 0 {
+		return image[i+1:], nil
+	}
+
+	domain, path, tag := DockerHubRegistry, image, "latest"
+	if i := strings.Index(image, "/"); i > 0 {
+		if first := image[:i]; strings.ContainsAny(first, ".:") || first == "localhost" {
+			domain, path = first, image[i+1:]
+		}
+	}
+	if i := strings.LastIndex(path, ":"); i > 0 {
+		path, tag = path[:i], path[i+1:]
+	}
+	if domain == DockerHubRegistry && strings.Contains(path, "/") == false {
+		path = "library/" + path
+	}
+
+	uri := fmt.Sprintf("https://%s/v2/%s/manifests/%s", domain, path, tag)
+	resp, err := headManifest(uri, "")
+	if err != nil {
+		return "", fmt.Errorf("Get digest of image %s error: %s", image, err.Error())
+	}
+
+	// The registry requires an anonymous token.
+	if resp.StatusCode == http.StatusUnauthorized {
+		token, err := registryToken(resp.Header.Get("WWW-Authenticate"))
+		if err != nil {
+			return "", fmt.Errorf("Get digest of image %s error: %s", image, err.Error())
+		}
+		if resp, err = headManifest(uri, token); err != nil {
+			return "", fmt.Errorf("Get digest of image %s error: %s", image, err.Error())
+		}
+	}
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if resp.StatusCode != http.StatusOK || digest == "" {
+		return "", fmt.Errorf("Get digest of image %s error: %s", image, resp.Status)
+	}
+
+	return digest, nil
+}
+
+func headManifest(uri, token string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodHead, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	if token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	return resp, nil
+}
+
+// registryToken gets the anonymous pull token of the Bearer challenge.
+func registryToken(challenge string) (string, error) {
+	if strings.HasPrefix(challenge, "Bearer ") == false {
+		return "", fmt.Errorf("unsupported registry authentication %q", challenge)
+	}
+
+	params := map[string]string{}
+	for _, matches := range challengeRegexp.FindAllStringSubmatch(challenge, -1) {
+		params[matches[1]] = matches[2]
+	}
+	if params["realm"] == "" {
+		return "", fmt.Errorf("no realm in registry authentication %q", challenge)
+	}
+
+	query := url.Values{}
+	for _, k := range []string{"service", "scope"} {
+		if params[k] != "" {
+			query.Set(k, params[k])
+		}
+	}
+
+	resp, err := http.Get(fmt.Sprintf("%s?%s", params["realm"], query.Encode()))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("get registry token error: %s", resp.Status)
+	}
+
+	body := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	return body.AccessToken, nil
+}
+
+// RestoreCache restores the outputs of job from the cache of key, it returns nil when the key
+// never succeeded before, and error when the cache is useless.
+func (j *Job) RestoreCache(key string, f *Flow, stageIndex, actionIndex int) (*model.CacheV1, error) {
+	cache := new(model.CacheV1)
+	if err := cache.Get(key); err != nil {
+		return nil, nil
+	}
+
+	values := map[string]string{}
+	if cache.Outputs != "" {
+		if err := json.Unmarshal([]byte(cache.Outputs), &values); err != nil {
+			return nil, err
+		}
+	}
+
+	// The cache is useless when an output is missing.
+	for _, o := range j.Outputs {
+		if _, ok := values[o]; ok == false {
+			return nil, fmt.Errorf("Output %s isn't in the cache", o)
+		}
+	}
+
+	// The job runs again to publish the artifacts when one of them is gone.
+	if err := j.CheckArtifacts(values); err != nil {
+		return nil, err
+	}
+
+	for _, o := range j.Outputs {
+		f.SetOutput(j.outputKey(f, stageIndex, actionIndex, o), values[o])
+	}
+
+	return cache, nil
+}
+
+// CheckArtifacts returns error when an artifact published by the job is gone, like the Dockyard
+// binary URL or the JUnit report URL in the cached outputs. The outputs not an URL have no
+// artifact.
+func (j *Job) CheckArtifacts(values map[string]string) error {
+	for _, o := range j.Outputs {
+		value := strings.TrimSpace(values[o])
+		if strings.HasPrefix(value, "http://") == false && strings.HasPrefix(value, "https://") == false {
+			continue
+		}
+
+		resp, err := artifactClient.Head(value)
+		if err != nil {
+			return fmt.Errorf("Artifact %s of output %s is unreachable: %s", value, o, err.Error())
+		}
+		resp.Body.Close()
+
+		// Some servers don't allow HEAD, the artifact is there as long as it's not missing.
+		if resp.StatusCode >= http.StatusBadRequest && resp.StatusCode != http.StatusMethodNotAllowed {
+			return fmt.Errorf("Artifact %s of output %s is gone: %s", value, o, resp.Status)
+		}
+	}
+
+	return nil
+}
+
+// SaveCache records the outputs of the succeeded job with the key.
+func (j *Job) SaveCache(key string, f *Flow, stageIndex, actionIndex int) error {
+	outputs := f.GetOutputs()
+
+	values := map[string]string{}
+	for _, o := range j.Outputs {
+		if value, ok := outputs[j.outputKey(f, stageIndex, actionIndex, o)]; ok {
+			values[o] = value
+		}
+	}
+
+	data, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+
+	var runID int64
+	if f.data != nil {
+		runID = f.data.ID
+	}
+
+	return new(model.CacheV1).Put(key, f.URI, j.Name, runID, string(data))
+}
+
+// outputKey returns the key of job output stage.action.job[KEY].
+func (j *Job) outputKey(f *Flow, stageIndex, actionIndex int, output string) string {
+	return fmt.Sprintf("%s.%s.%s[%s]", f.Stages[stageIndex].Name, f.Stages[stageIndex].Actions[actionIndex].Name, j.Name, output)
+}
+
+// lookupCache finds the cache of job before it runs. It returns the cache key, which is empty
+// when the job isn't cached, and true when the outputs are restored from a succeeded run.
+func (j *Job) lookupCache(pod *apiv1.Pod, f *Flow, outputs map[string]string, verbose, timestamp bool, stageIndex, actionIndex int) (string, bool) {
+	if j.Cache == nil {
+		return "", false
+	}
+
+	key, err := j.CacheKey(pod, f, outputs)
+	if err != nil {
+		j.Log(fmt.Sprintf("Job %s runs without cache: %s", j.Name, err.Error()), verbose, timestamp)
+		return "", false
+	}
+
+	cache, err := j.RestoreCache(key, f, stageIndex, actionIndex)
+	if err != nil {
+		j.Log(fmt.Sprintf("Job %s runs again, the cache of key %s is useless: %s", j.Name, key, err.Error()), verbose, timestamp)
+		return key, false
+	}
+	if cache == nil {
+		return key, false
+	}
+
+	logsLock.Lock()
+	j.Status, j.Cached = Success, true
+	logsLock.Unlock()
+	j.Log(fmt.Sprintf("Job %s is cached from the run %d of [%s] with key %s", j.Name, cache.RunID, cache.URI, key), verbose, timestamp)
+	f.Checkpoint()
+
+	return key, true
+}
+
+// storeCache records the outputs of job when its pod succeeded.
+func (j *Job) storeCache(key string, f *Flow, verbose, timestamp bool, stageIndex, actionIndex int) {
+	if key == "" {
+		return
+	}
+
+	// The job container of pod with services exited with 0, and the pod is deleted.
+	if len(j.Services) > 0 {
+		if err := j.SaveCache(key, f, stageIndex, actionIndex); err != nil {
+			j.Log(fmt.Sprintf("Save cache of job %s error: %s", j.Name, err.Error()), verbose, timestamp)
+		}
+		return
+	}
+
+	p, err := PodClient(f.ClusterOf(stageIndex, j))
+	if err != nil {
+		j.Log(fmt.Sprintf("Save cache of job %s error: %s", j.Name, err.Error()), verbose, timestamp)
+		return
+	}
+
+	// The phase is updated a while after the log stream ends.
+	for i := 0; i < 30; i++ {
+		pod, err := p.Get(j.Pod, metav1.GetOptions{})
+		if err != nil {
+			j.Log(fmt.Sprintf("Save cache of job %s error: %s", j.Name, err.Error()), verbose, timestamp)
+			return
+		}
+
+		switch pod.Status.Phase {
+		case apiv1.PodSucceeded:
+			if err := j.SaveCache(key, f, stageIndex, actionIndex); err != nil {
+				j.Log(fmt.Sprintf("Save cache of job %s error: %s", j.Name, err.Error()), verbose, timestamp)
+			}
+			return
+		case apiv1.PodFailed, apiv1.PodUnknown:
+			return
+		}
+		time.Sleep(time.Second * 2)
+	}
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckArtifacts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/binary/hello":
+			w.WriteHeader(http.StatusOK)
+		case "/no-head/report.xml":
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		outputs []string
+		values  map[string]string
+		gone    bool
+	}{
+		{"no url", []string{"VERSION"}, map[string]string{"VERSION": "1.0.0"}, false},
+		{"artifact exists", []string{"CO_URL"}, map[string]string{"CO_URL": server.URL + "/binary/hello"}, false},
+		{"head not allowed", []string{"REPORT"}, map[string]string{"REPORT": server.URL + "/no-head/report.xml"}, false},
+		{"artifact gone", []string{"VERSION", "CO_URL"}, map[string]string{"VERSION": "1.0.0", "CO_URL": server.URL + "/binary/removed"}, true},
+		{"server down", []string{"CO_URL"}, map[string]string{"CO_URL": "http://127.0.0.1:1/binary/hello"}, true},
+	}
+
+	for _, test := range tests {
+		j := &Job{Name: "build", Outputs: test.outputs}
+		if err := j.CheckArtifacts(test.values); (err != nil) != test.gone {
+			t.Errorf("%s: CheckArtifacts() error = %v, want gone %t", test.name, err, test.gone)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	homeDir "github.com/mitchellh/go-homedir"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/Huawei/containerops/pilotage/config"
+)
+
+const (
+	// DefaultCluster runs the jobs without cluster. It's the cluster of ~/.kube/config unless
+	// the default cluster is in the cluster config.
+	DefaultCluster = "default"
+
+	// DefaultHealthInterval is the seconds a health check is trusted when the config is empty.
+	DefaultHealthInterval = 30
+)
+
+var (
+	clusters     = map[string]*Cluster{}
+	clustersLock sync.Mutex
+)
+
+// Cluster is a Kubernetes cluster running jobs in its namespace. The running jobs on the cluster
+// are limited by the concurrency of config, and the jobs fail at once when the cluster is unhealthy.
+type Cluster struct {
+	Name        string
+	Namespace   string
+	Concurrency int
+
+	rest     *rest.Config
+	client   kubernetes.Interface
+	slots    chan struct{}
+	interval time.Duration
+	running  int64
+
+	lock     sync.Mutex
+	checked  time.Time
+	checking bool
+	health   error
+}
+
+// ClusterStatus is the health and the running jobs of a cluster.
+type ClusterStatus struct {
+	Name        string    `json:"name"`
+	Healthy     bool      `json:"healthy"`
+	Error       string    `json:"error,omitempty"`
+	Checked     time.Time `json:"checked"`
+	Running     int64     `json:"running"`
+	Concurrency int       `json:"concurrency"`
+}
+
+// GetCluster returns the cluster of the name, the client is created at the first use.
+func GetCluster(name string) (*Cluster, error) {
+	if name == "" {
+		name = DefaultCluster
+	}
+
+	clustersLock.Lock()
+	defer clustersLock.Unlock()
+
+	if c, ok := clusters[name]; ok {
+		return c, nil
+	}
+
+	setting, ok := config.Clusters[name]
+	if ok == false && name != DefaultCluster {
+		return nil, fmt.Errorf("Cluster %s isn't in the cluster config", name)
+	}
+
+	restConfig, err := clusterConfig(setting)
+	if err != nil {
+		return nil, countKubeError("client", fmt.Errorf("Load the kube config of cluster %s error: %s", name, err.Error()))
+	}
+
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, countKubeError("client", err)
+	}
+
+	c := &Cluster{Name: name, Namespace: setting.Namespace, Concurrency: setting.Concurrency, rest: restConfig, client: client,
+		interval: time.Duration(DefaultHealthInterval) * time.Second}
+	if c.Namespace == "" {
+		c.Namespace = apiv1.NamespaceDefault
+	}
+	if setting.Health > 0 {
+		c.interval = time.Duration(setting.Health) * time.Second
+	}
+	if setting.Concurrency > 0 {
+		c.slots = make(chan struct{}, setting.Concurrency)
+	}
+
+	clusters[name] = c
+	return c, nil
+}
+
+// ClusterClient returns the Kubernetes client of the cluster.
+func ClusterClient(name string) (kubernetes.Interface, error) {
+	c, err := GetCluster(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.client, nil
+}
+
+// ClusterNames returns the default cluster and the clusters in the config, in order.
+func ClusterNames() []string {
+	names := []string{DefaultCluster}
+	for name, _ := range config.Clusters {
+		if name != DefaultCluster {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names[1:])
+
+	return names
+}
+
+// ClusterConfigured is true when the jobs could run on the cluster.
+func ClusterConfigured(name string) bool {
+	if name == "" || name == DefaultCluster {
+		return true
+	}
+
+	_, ok := config.Clusters[name]
+	return ok
+}
+
+func clusterConfig(setting config.ClusterConfig) (*rest.Config, error) {
+	path := setting.Kubeconfig
+	if path == "" {
+		home, _ := homeDir.Dir()
+		path = fmt.Sprintf("%s/.kube/config", home)
+	} else if expanded, err := homeDir.Expand(path); err == nil {
+		path = expanded
+	}
+
+	if setting.Context == "" {
+		return clientcmd.BuildConfigFromFlags("", path)
+	}
+
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: path},
+		&clientcmd.ConfigOverrides{CurrentContext: setting.Context}).ClientConfig()
+}
+
+// Client returns the Kubernetes client of the cluster.
+func (c *Cluster) Client() kubernetes.Interface {
+	return c.client
+}
+
+// Check returns the error of the last health check, the API server is checked again when the
+// last check is older than the health interval. The API server is called without the lock, so a
+// slow server never blocks the status of cluster, and the last result is returned while another
+// check is running.
+func (c *Cluster) Check() error {
+	c.lock.Lock()
+	if c.checked.IsZero() == false && (c.checking || time.Now().Sub(c.checked) <= c.interval) {
+		defer c.lock.Unlock()
+		return c.health
+	}
+	c.checking = true
+	c.lock.Unlock()
+
+	_, err := c.client.Discovery().ServerVersion()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.checked, c.checking, c.health = time.Now(), false, countKubeError("server_version", err)
+	return c.health
+}
+
+// Full is true when the running jobs reach the concurrency of cluster.
+func (c *Cluster) Full() bool {
+	return c.slots != nil && len(c.slots) == cap(c.slots)
+}
+
+// Acquire waits a slot of the cluster for a job, it returns ErrCanceled when the job is canceled
+// while waiting. The slot is returned by Release.
+func (c *Cluster) Acquire(ctx context.Context) error {
+	if c.slots != nil {
+		select {
+		case c.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ErrCanceled
+		}
+	}
+
+	atomic.AddInt64(&c.running, 1)
+	return nil
+}
+
+// Release returns the slot of a finished job.
+func (c *Cluster) Release() {
+	atomic.AddInt64(&c.running, -1)
+
+	if c.slots != nil {
+		<-c.slots
+	}
+}
+
+// Status checks the health of cluster, and returns it with the running jobs.
+func (c *Cluster) Status() ClusterStatus {
+	err := c.Check()
+
+	c.lock.Lock()
+	status := ClusterStatus{Name: c.Name, Healthy: err == nil, Checked: c.checked,
+		Running: atomic.LoadInt64(&c.running), Concurrency: c.Concurrency}
+	c.lock.Unlock()
+
+	if err != nil {
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// ClusterStatuses returns the status of all clusters.
+func ClusterStatuses() []ClusterStatus {
+	statuses := []ClusterStatus{}
+	for _, name := range ClusterNames() {
+		c, err := GetCluster(name)
+		if err != nil {
+			statuses = append(statuses, ClusterStatus{Name: name, Error: err.Error()})
+			continue
+		}
+
+		statuses = append(statuses, c.Status())
+	}
+
+	return statuses
+}
+
+// ClusterOf returns the cluster running the job, it's the cluster of the job, its stage or the
+// flow in order.
+func (f *Flow) ClusterOf(stageIndex int, j *Job) string {
+	switch {
+	case j.Cluster != "":
+		return j.Cluster
+	case f.Stages[stageIndex].Cluster != "":
+		return f.Stages[stageIndex].Cluster
+	case f.Cluster != "":
+		return f.Cluster
+	}
+
+	return DefaultCluster
+}
+
+// Clusters returns the clusters running the jobs of flow, in order.
+func (f *Flow) Clusters() []string {
+	names := map[string]bool{}
+	for i, _ := range f.Stages {
+		for _, action := range f.Stages[i].Actions {
+			for k, _ := range action.Jobs {
+				names[f.ClusterOf(i, &action.Jobs[k])] = true
+			}
+		}
+	}
+
+	result := []string{}
+	for name, _ := range names {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+
+	return result
+}
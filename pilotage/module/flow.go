@@ -17,14 +17,17 @@ limitations under the License.
 package module
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"strings"
+	"sync"
 	"time"
 
 	. "github.com/logrusorgru/aurora"
 	"gopkg.in/yaml.v2"
+	apiv1 "k8s.io/api/core/v1"
 
 	"github.com/Huawei/containerops/pilotage/model"
 )
@@ -34,6 +37,10 @@ const (
 	CliRun      = "CliRun"
 	DaemonRun   = "DaemonRun"
 	DaemonStart = "DaemonStart"
+
+	// Concurrency Conflict Policy
+	QueueConflict          = "queue"
+	CancelPreviousConflict = "cancel-previous"
 )
 
 // Flow is DevOps orchestration flow struct.
@@ -47,11 +54,60 @@ type Flow struct {
 	Tag          string              `json:"tag" yaml:"tag"`
 	Timeout      int64               `json:"timeout" yaml:"timeout"`
 	Namespace    string              `json:"namespace" yaml:"namespace"`
+	Cluster      string              `json:"cluster,omitempty" yaml:"cluster,omitempty"`
 	Environments []map[string]string `json:"environments" yaml:"environments"`
+	Parameters   map[string]string   `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+	Include      []string            `json:"include,omitempty" yaml:"include,omitempty"`
+	Templates    map[string]Template `json:"templates,omitempty" yaml:"templates,omitempty"`
 	Status       string              `json:"status,omitempty" yaml:"status,omitempty"`
 	Logs         []string            `json:"logs,omitempty" yaml:"logs,omitempty"`
 	Stages       []Stage             `json:"stages,omitempty" yaml:"stages,omitempty"`
 	Receivers    []Receiver          `json:"receivers,omitempty" yaml:"receivers,omitempty"`
+	Commit       *Commit             `json:"commit,omitempty" yaml:"commit,omitempty"`
+	Triggers     []Trigger           `json:"triggers,omitempty" yaml:"triggers,omitempty"`
+	TriggeredBy  int64               `json:"triggered_by,omitempty" yaml:"triggered_by,omitempty"`
+	Concurrency  *Concurrency        `json:"concurrency,omitempty" yaml:"concurrency,omitempty"`
+	Parent       int64               `json:"parent,omitempty" yaml:"parent,omitempty"`
+	Outputs      map[string]string   `json:"outputs,omitempty" yaml:"outputs,omitempty"`
+
+	once   sync.Once
+	ctx    context.Context
+	cancel context.CancelFunc
+	lock   sync.RWMutex
+
+	// cleanup is the context of cleanup stages, it's canceled in CleanupTimeout after the flow
+	// is canceled, or at once when the flow is canceled again.
+	cleanup       context.Context
+	cancelCleanup context.CancelFunc
+
+	// data is the run data checkpointed after each status transition.
+	data       *model.FlowDataV1
+	checkpoint sync.Mutex
+
+	// runID identifies the run without database, owners own the Kubernetes Jobs of the run in
+	// each cluster.
+	runID  string
+	owners map[string]*apiv1.ConfigMap
+
+	// approvals are the pause stages waiting approval.
+	approvals map[string]chan struct{}
+	// dropped is the number of the flow logs dropped from memory.
+	dropped int
+	// queued is the id of run in the daemon run queue.
+	queued string
+	// chain is the flows triggered one by one before the flow, as uri:tag.
+	chain []string
+	// namespaces are the namespaces of the token submitting the run, the run only triggers the
+	// flows in them. It's nil when the run isn't submitted with a token.
+	namespaces []string
+}
+
+// Concurrency limits the runs of the same flow in the daemon run queue, Max 0 is unlimited.
+// When the number of running runs reaches Max, OnConflict decides to queue the new run or
+// cancel the previous runs.
+type Concurrency struct {
+	Max        int    `json:"max" yaml:"max"`
+	OnConflict string `json:"on_conflict" yaml:"on_conflict"`
 }
 
 // Receiver receives the flow execution result
@@ -81,11 +137,132 @@ func (f *Flow) URIs() (namespace, repository, name string, err error) {
 	return namespace, repository, name, nil
 }
 
+// Context returns the context which is done when the flow run is canceled.
+func (f *Flow) Context() context.Context {
+	f.once.Do(func() {
+		f.ctx, f.cancel = context.WithCancel(context.Background())
+		f.cleanup, f.cancelCleanup = context.WithCancel(context.Background())
+	})
+
+	return f.ctx
+}
+
+// CleanupContext returns the context of the cleanup stages. It isn't done when the flow is
+// canceled, so the teardown work finishes, but the cleanup stages are canceled CleanupTimeout
+// after the flow is canceled, or when the flow is canceled again.
+func (f *Flow) CleanupContext() context.Context {
+	f.Context()
+	return f.cleanup
+}
+
+// Cancel stops the flow run, the running job pod will be deleted. Canceling a canceled flow
+// stops its cleanup stages.
+func (f *Flow) Cancel() {
+	if f.Canceled() {
+		f.cancelCleanup()
+		return
+	}
+
+	f.cancel()
+	time.AfterFunc(CleanupTimeout, f.cancelCleanup)
+}
+
+// Canceled is true after the flow run is canceled.
+func (f *Flow) Canceled() bool {
+	return f.Context().Err() != nil
+}
+
+// SetOutput saves the output of a job, the key is stage.action.job[KEY].
+func (f *Flow) SetOutput(key, value string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.Outputs == nil {
+		f.Outputs = make(map[string]string)
+	}
+	f.Outputs[key] = value
+}
+
+// GetOutputs returns a copy of the outputs of jobs.
+func (f *Flow) GetOutputs() map[string]string {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	outputs := make(map[string]string, len(f.Outputs))
+	for k, v := range f.Outputs {
+		outputs[k] = v
+	}
+	return outputs
+}
+
+// Snapshot returns the flow JSON with the status of all units but without logs, which is
+// saved with the run data.
+func (f *Flow) Snapshot() ([]byte, error) {
+	f.lock.RLock()
+	logsLock.RLock()
+	data, err := f.JSON()
+	logsLock.RUnlock()
+	f.lock.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var content interface{}
+	if err := json.Unmarshal(data, &content); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(removeLogs(content))
+}
+
+// Checkpoint saves the status of all units and the outputs into the run data, so the run
+// could be recovered after the daemon restarts. The result of run data keeps running until
+// the flow finishes.
+func (f *Flow) Checkpoint() {
+	f.save(Running)
+}
+
+// save updates the run data with the result.
+func (f *Flow) save(result string) {
+	if f.data == nil {
+		return
+	}
+
+	f.checkpoint.Lock()
+	defer f.checkpoint.Unlock()
+
+	content, _ := f.Snapshot()
+	outputs, _ := json.Marshal(f.GetOutputs())
+	if err := f.data.Update(result, string(content), string(outputs), time.Now()); err != nil {
+		f.Log(fmt.Sprintf("Save Flow Data [%s] error: %s", f.URI, err.Error()), false, false)
+	}
+}
+
+// removeLogs removes the logs fields of flow, stages, actions and jobs.
+func removeLogs(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		delete(value, "logs")
+		for k, child := range value {
+			value[k] = removeLogs(child)
+		}
+	case []interface{}:
+		for i, child := range value {
+			value[i] = removeLogs(child)
+		}
+	}
+
+	return v
+}
+
 // TODO filter the log print with different color.
 func (f *Flow) Log(log string, verbose, timestamp bool) {
-	f.Logs = append(f.Logs, fmt.Sprintf("[%s] %s", time.Now().String(), log))
-	l := new(model.LogV1)
-	l.Create(model.INFO, model.FLOW, f.ID, log)
+	f.lock.Lock()
+	var dropped int
+	f.Logs, dropped = appendLog(f.Logs, fmt.Sprintf("[%s] %s", time.Now().String(), log))
+	f.dropped += dropped
+	f.lock.Unlock()
+	persistLog(model.FLOW, f.ID, f.dataID(), log)
 
 	if verbose == true {
 		if timestamp == true {
@@ -96,6 +273,33 @@ func (f *Flow) Log(log string, verbose, timestamp bool) {
 	}
 }
 
+// LogsFrom returns a copy of the flow logs from the offset and the offset of next line. The
+// offset counts the lines dropped from memory, the logs start from the oldest line in memory.
+func (f *Flow) LogsFrom(offset int) ([]string, int) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	next := f.dropped + len(f.Logs)
+	start := offset - f.dropped
+	if start < 0 {
+		start = 0
+	}
+	if start >= len(f.Logs) {
+		return []string{}, next
+	}
+
+	return append([]string{}, f.Logs[start:]...), next
+}
+
+// dataID returns the id of the run data, 0 when it isn't recorded.
+func (f *Flow) dataID() int64 {
+	if f.data != nil {
+		return f.data.ID
+	}
+
+	return 0
+}
+
 // ParseFlowFromFile is init flow definition from a file.
 // It's only used in CliRun or DaemonRun, and run with local kubectl.
 func (f *Flow) ParseFlowFromFile(flowFile, runMode string, verbose, timestamp bool) error {
@@ -106,9 +310,11 @@ func (f *Flow) ParseFlowFromFile(flowFile, runMode string, verbose, timestamp bo
 		f.Log(fmt.Sprintf("Read orchestration flow file %s error: %s", flowFile, err.Error()), verbose, timestamp)
 		return err
 	} else {
-		if err := yaml.Unmarshal(data, &f); err != nil {
-			f.Log(fmt.Sprintf("Unmarshal the flow file error: %s", err.Error()), verbose, timestamp)
-			return err
+		if errs := f.ParseYAML(data, flowFile); len(errs) > 0 {
+			for _, e := range errs {
+				f.Log(fmt.Sprintf("Invalid flow file %s: %s", flowFile, e.Error()), verbose, timestamp)
+			}
+			return errs
 		}
 	}
 
@@ -133,15 +339,62 @@ func (f *Flow) LocalRun(verbose, timestamp bool) error {
 	}
 	f.ID = flowID
 
-	// Record flow data
-	flowData := new(model.FlowDataV1)
-	startTime := time.Now()
+	// Record flow data, a recovered run continues the run data saved before the daemon restarts.
+	if f.data == nil {
+		flowData := new(model.FlowDataV1)
+		content, _ = f.Snapshot()
+		startTime := time.Now()
+		flowData.Namespaces = strings.Join(f.namespaces, ",")
+		flowData.Mode, flowData.Owner = f.Model, Instance()
+		if err := flowData.Put(f.ID, f.Parent, f.TriggeredBy, Running, string(content), "{}", startTime, startTime); err != nil {
+			f.Log(fmt.Sprintf("Save Flow Data [%s] error: %s", f.URI, err.Error()), verbose, timestamp)
+		} else {
+			f.data = flowData
+		}
+	}
+
+	// All Kubernetes Jobs of the run are owned by the run owner, and cleaned up with it.
+	if err := f.InitOwner(); err != nil {
+		f.Log(fmt.Sprintf("Create the owner of Flow [%s] run error: %s", f.URI, err.Error()), verbose, timestamp)
+	}
+
+	// Notify the receivers watching the run start
+	for _, receiver := range f.Receivers {
+		if n, ok := Notifiers[receiver.Type].(StartNotifier); ok {
+			if err := n.NotifyStart(f, []string{receiver.Address}); err != nil {
+				f.Log(fmt.Sprintf("Notify User Error: %s", err.Error()), verbose, timestamp)
+			}
+		}
+	}
 
 	for i, _ := range f.Stages {
 		stage := &f.Stages[i]
 
+		// After a stage failed or the flow is canceled, only the cleanup stages run.
+		failed := f.Status == Failure || f.Status == Cancel
+		if f.Canceled() && failed == false {
+			f.Status, failed = Cancel, true
+			f.Log(fmt.Sprintf("Flow [%s] is canceled before stage: %s", f.URI, stage.Name), verbose, timestamp)
+		}
+
+		if stage.ShouldRun(failed) == false {
+			if failed {
+				f.Log(fmt.Sprintf("Stage [%s] is skipped, the flow is %s", stage.Name, f.Status), verbose, timestamp)
+			} else {
+				f.Log(fmt.Sprintf("Stage [%s] is skipped, it only runs on failure", stage.Name), verbose, timestamp)
+			}
+			continue
+		}
+
+		if stage.skip {
+			f.Log(fmt.Sprintf("Stage [%s] is reused from the recorded run with status %s", stage.Name, stage.Status), verbose, timestamp)
+			f.Status = stage.Status
+			continue
+		}
+
 		f.Log(fmt.Sprintf("The Number [%d] stage is running: %s", i, stage.Title), verbose, timestamp)
 
+		result := ""
 		switch stage.T {
 		case StartStage:
 			f.Log("Start stage don't need any trigger in cli or daemon run mode.", verbose, timestamp)
@@ -149,40 +402,49 @@ func (f *Flow) LocalRun(verbose, timestamp bool) error {
 			switch stage.Sequencing {
 			case Parallel:
 				if status, err := stage.ParallelRun(verbose, timestamp, f, i); err != nil {
-					f.Status = Failure
+					result = Failure
 					f.Log(fmt.Sprintf("Stage [%s] run error: %s", stage.Name, err.Error()), verbose, timestamp)
 				} else {
-					f.Status = status
+					result = status
 				}
 			case Sequencing:
 				if status, err := stage.SequencingRun(verbose, timestamp, f, i); err != nil {
-					f.Status = Failure
+					result = Failure
 					f.Log(fmt.Sprintf("Stage [%s] run error: %s", stage.Name, err.Error()), verbose, timestamp)
 				} else {
-					f.Status = status
+					result = status
 				}
 			default:
-				f.Status = Failure
+				result = Failure
 				f.Log(fmt.Sprintf("Stage [%s] has unknown sequencing type: %s", stage.Name, stage.T), verbose, timestamp)
 			}
 		case PauseStage:
-			// TODO Pause running
+			result = stage.Pause(verbose, timestamp, f)
 		case EndStage:
 			f.Log("End stage don't trigger any other flow.", verbose, timestamp)
 		}
 
-		// if status is failure or cancel, break the for loop.
-		if f.Status == Failure || f.Status == Cancel {
-			break
+		// The cleanup stages never change the status of a failed or canceled flow.
+		if result != "" {
+			if failed == false {
+				f.Status = result
+			} else if result != Success {
+				f.Log(fmt.Sprintf("Cleanup stage [%s] is %s", stage.Name, result), verbose, timestamp)
+			}
 		}
+
+		f.Checkpoint()
 	}
 
-	currentNumber, err := flowData.GetNumbers(flowID)
-	if err != nil {
-		f.Log(fmt.Sprintf("Get Flow Data [%s] Numbers error: %s", f.URI, err.Error()), verbose, timestamp)
+	// The flow without normal stages keeps running status, the finished run must not be recovered.
+	if f.Status == Running {
+		f.Status = Success
 	}
-	if err := flowData.Put(f.ID, currentNumber+1, f.Status, startTime, time.Now()); err != nil {
-		f.Log(fmt.Sprintf("Save Flow Data [%s] error: %s", f.URI, err.Error()), verbose, timestamp)
+	f.save(f.Status)
+	flowRuns.inc(f.URI, f.Status)
+
+	if err := f.ReleaseOwner(f.Status); err != nil {
+		f.Log(fmt.Sprintf("Release the owner of Flow [%s] run error: %s", f.URI, err.Error()), verbose, timestamp)
 	}
 
 	// Notify result to receivers
@@ -197,5 +459,11 @@ func (f *Flow) LocalRun(verbose, timestamp bool) error {
 		}
 	}
 
+	// Start the flows triggered by the result
+	f.RunTriggers(verbose, timestamp)
+
+	// The cli process exits after the run, so the queued logs are inserted before return.
+	FlushLogs()
+
 	return nil
 }
@@ -0,0 +1,103 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"testing"
+
+	"github.com/Huawei/containerops/pilotage/model"
+)
+
+// cleanupFlow has a main stage, a stage after it, and the cleanup stages. The jobs on a cluster
+// not in the config fail at once, so a stage which ran is failed and a skipped stage has no status.
+func cleanupFlow(main Stage) *Flow {
+	return &Flow{URI: "cncf/demo/hello", Tag: "latest", Model: CliRun, Stages: []Stage{
+		main,
+		{T: NormalStage, Name: "deploy", Sequencing: Sequencing, Actions: []Action{
+			{Name: "helm", Jobs: []Job{parallelJob("helm-upgrade", "missing")}},
+		}},
+		{T: NormalStage, Name: "notify", Sequencing: Sequencing, Run: RunOnFailure, Actions: []Action{
+			{Name: "slack", Jobs: []Job{parallelJob("slack-notify", "missing")}},
+		}},
+		{T: NormalStage, Name: "teardown", Sequencing: Sequencing, Run: RunAlways, Actions: []Action{
+			{Name: "clean", Jobs: []Job{parallelJob("kubectl-delete", "missing")}},
+		}},
+	}}
+}
+
+func TestCleanupStagesAfterFailure(t *testing.T) {
+	model.DisableDB = true
+
+	f := cleanupFlow(Stage{T: NormalStage, Name: "build", Sequencing: Sequencing, Actions: []Action{
+		{Name: "compile", Jobs: []Job{parallelJob("go-build", "missing")}},
+	}})
+	f.LocalRun(false, false)
+
+	if f.Status != Failure {
+		t.Errorf("Flow is %s after a stage failed, want %s", f.Status, Failure)
+	}
+	if deploy := f.Stages[1]; deploy.Status != "" {
+		t.Errorf("Stage [%s] runs after a stage failed", deploy.Name)
+	}
+	for _, stage := range f.Stages[2:] {
+		if stage.Status == "" {
+			t.Errorf("Cleanup stage [%s] doesn't run after a stage failed", stage.Name)
+		}
+	}
+}
+
+func TestCleanupStagesAfterSuccess(t *testing.T) {
+	model.DisableDB = true
+
+	// The pause stage is approved at once out of the daemon, so the flow succeeds without a cluster.
+	f := cleanupFlow(Stage{T: PauseStage, Name: "approve"})
+	f.Stages = append(f.Stages[:1], f.Stages[2:]...)
+	f.LocalRun(false, false)
+
+	if notify := f.Stages[1]; notify.Status != "" {
+		t.Errorf("The on_failure stage [%s] runs after the flow succeeded", notify.Name)
+	}
+	// The always stage of a succeeded flow is a part of the result, its failure fails the flow.
+	if teardown := f.Stages[2]; teardown.Status != Failure || f.Status != Failure {
+		t.Errorf("The always stage is %q and the flow is %s, both should fail", teardown.Status, f.Status)
+	}
+}
+
+func TestCleanupStagesAfterCancel(t *testing.T) {
+	model.DisableDB = true
+
+	f := cleanupFlow(Stage{T: NormalStage, Name: "build", Sequencing: Sequencing, Actions: []Action{
+		{Name: "compile", Jobs: []Job{parallelJob("go-build", "missing")}},
+	}})
+	f.Cancel()
+	f.LocalRun(false, false)
+
+	if f.Status != Cancel {
+		t.Errorf("Flow is %s after it's canceled, want %s", f.Status, Cancel)
+	}
+	for _, stage := range f.Stages[:2] {
+		if stage.Status != "" {
+			t.Errorf("Stage [%s] runs after the flow is canceled", stage.Name)
+		}
+	}
+	// The jobs of cleanup stages aren't canceled with the flow, they run and fail on the cluster.
+	for _, stage := range f.Stages[2:] {
+		if stage.Status != Failure {
+			t.Errorf("Cleanup stage [%s] is %q after the flow is canceled, it should run", stage.Name, stage.Status)
+		}
+	}
+}
@@ -0,0 +1,440 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/logrusorgru/aurora"
+	"gopkg.in/yaml.v2"
+
+	"github.com/Huawei/containerops/pilotage/config"
+	"github.com/Huawei/containerops/pilotage/model"
+)
+
+const (
+	// GitOpsSource is the source prefix of the flows synced from the flow files, it's followed by
+	// the path of flow file.
+	GitOpsSource = "gitops:"
+
+	// DefaultSyncInterval is the seconds between two syncs when the gitops config is empty.
+	DefaultSyncInterval = 60
+)
+
+var (
+	// syncLock runs one sync at a time, statusLock guards the last sync status.
+	syncLock   sync.Mutex
+	statusLock sync.RWMutex
+	lastSync   *SyncStatus
+)
+
+// SyncStatus is the result of a sync of the flow files. The flows are the uri:tag of the flows
+// created, updated or deleted. The flows not in the flow files are only deleted when all flow
+// files are valid, so an invalid file never deletes its flow. The conflicts are the flow files
+// whose flow is posted to the daemon, the posted flow is kept.
+type SyncStatus struct {
+	Source    string      `json:"source"`
+	Revision  string      `json:"revision,omitempty"`
+	Commit    string      `json:"commit,omitempty"`
+	Start     time.Time   `json:"start"`
+	End       time.Time   `json:"end"`
+	Flows     int         `json:"flows"`
+	Created   []string    `json:"created"`
+	Updated   []string    `json:"updated"`
+	Deleted   []string    `json:"deleted"`
+	Skipped   []string    `json:"skipped"`
+	Conflicts []SyncError `json:"conflicts"`
+	Errors    []SyncError `json:"errors"`
+}
+
+// SyncError is a problem of sync, the File is the path of flow file in the directory.
+type SyncError struct {
+	File    string `json:"file,omitempty"`
+	Message string `json:"message"`
+}
+
+func (s *SyncStatus) fail(file, message string) {
+	s.Errors = append(s.Errors, SyncError{File: file, Message: message})
+}
+
+func (s *SyncStatus) conflict(file, message string) {
+	s.Conflicts = append(s.Conflicts, SyncError{File: file, Message: message})
+}
+
+// syncConflict returns the conflict when the flow recorded with the source isn't synced from a
+// flow file, the flow synced from another file is taken over like a renamed file.
+func syncConflict(key, source string) error {
+	if source == "" {
+		return fmt.Errorf("Flow %s is posted to the daemon, the flow file isn't synced", key)
+	}
+	if strings.HasPrefix(source, GitOpsSource) == false {
+		return fmt.Errorf("Flow %s is recorded from %s, the flow file isn't synced", key, source)
+	}
+	return nil
+}
+
+// pruneAllowed returns error when a sync without any flow file would delete all the synced flows,
+// like an emptied directory or a wrong revision, unless the empty config allows it.
+func pruneAllowed(synced, flows int) error {
+	if synced == 0 && flows > 0 && config.GitOps.Empty == false {
+		return fmt.Errorf("No flow file is found, the %d flows synced before are kept", flows)
+	}
+	return nil
+}
+
+// GitOpsEnabled is true when the daemon syncs the flows from a git repository or a local directory.
+func GitOpsEnabled() bool {
+	return config.GitOps.Repository != "" || config.GitOps.Directory != ""
+}
+
+// LastSync returns the status of the last sync, it's nil before the first sync.
+func LastSync() *SyncStatus {
+	statusLock.RLock()
+	defer statusLock.RUnlock()
+
+	return lastSync
+}
+
+// StartGitOps syncs the flow files into the flow store periodically until the daemon exits.
+func StartGitOps(verbose, timestamp bool) {
+	if GitOpsEnabled() == false {
+		return
+	}
+
+	interval := config.GitOps.Interval
+	if interval <= 0 {
+		interval = DefaultSyncInterval
+	}
+
+	go func() {
+		for {
+			status := SyncFlows()
+			if len(status.Conflicts)+len(status.Errors) > 0 && verbose {
+				for _, e := range append(status.Conflicts, status.Errors...) {
+					message := fmt.Sprintf("Sync the flows of %s error: %s", status.Source, e.Message)
+					if e.File != "" {
+						message = fmt.Sprintf("Sync the flow file %s error: %s", e.File, e.Message)
+					}
+
+					if timestamp {
+						fmt.Println(Red(fmt.Sprintf("[%s] %s", time.Now().String(), message)))
+					} else {
+						fmt.Println(Red(message))
+					}
+				}
+			}
+			time.Sleep(time.Duration(interval) * time.Second)
+		}
+	}()
+}
+
+// SyncFlows checks out the pinned revision of repository, and reconciles the flow files in the
+// directory into the flow store. The flows are created or updated by their uri and tag, and the
+// flows synced before but removed from the directory are deleted.
+func SyncFlows() *SyncStatus {
+	syncLock.Lock()
+	defer syncLock.Unlock()
+
+	status := &SyncStatus{Source: config.GitOps.Directory, Revision: config.GitOps.Revision, Start: time.Now(),
+		Created: []string{}, Updated: []string{}, Deleted: []string{}, Skipped: []string{}, Conflicts: []SyncError{}, Errors: []SyncError{}}
+
+	dir := config.GitOps.Directory
+	if config.GitOps.Repository != "" {
+		status.Source = config.GitOps.Repository
+
+		workdir, commit, err := checkoutRepository()
+		if err != nil {
+			status.fail("", err.Error())
+		}
+		dir, status.Commit = filepath.Join(workdir, config.GitOps.Directory), commit
+	}
+
+	switch {
+	case GitOpsEnabled() == false:
+		status.fail("", "The gitops config is empty")
+	case model.DisableDB:
+		status.fail("", "The flow store requires the database")
+	case len(status.Errors) == 0:
+		reconcileFlows(dir, status)
+	}
+
+	status.End = time.Now()
+
+	statusLock.Lock()
+	lastSync = status
+	statusLock.Unlock()
+
+	return status
+}
+
+// checkoutRepository clones or fetches the repository, and checks out the pinned revision. It
+// returns the clone path and the commit checked out.
+func checkoutRepository() (string, string, error) {
+	workdir := config.GitOps.Workdir
+	if workdir == "" {
+		workdir = filepath.Join(os.TempDir(), "pilotage-gitops")
+	}
+
+	if _, err := os.Stat(filepath.Join(workdir, ".git")); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(workdir), 0755); err != nil {
+			return "", "", err
+		}
+		if _, err := git("", "clone", "--no-checkout", config.GitOps.Repository, workdir); err != nil {
+			return "", "", err
+		}
+	} else if _, err := git(workdir, "remote", "set-url", "origin", config.GitOps.Repository); err != nil {
+		return "", "", err
+	}
+
+	if _, err := git(workdir, "fetch", "--prune", "--tags", "--force", "origin"); err != nil {
+		return "", "", err
+	}
+
+	// A branch is checked out at the head of the remote branch, a tag or commit as it is.
+	revision := "origin/HEAD"
+	if config.GitOps.Revision != "" {
+		revision = config.GitOps.Revision
+		if _, err := git(workdir, "rev-parse", "--verify", "--quiet", "origin/"+revision+"^{commit}"); err == nil {
+			revision = "origin/" + revision
+		}
+	}
+
+	commit, err := git(workdir, "rev-parse", "--verify", revision+"^{commit}")
+	if err != nil {
+		return "", "", fmt.Errorf("Revision %s isn't found: %s", revision, err.Error())
+	}
+
+	if _, err := git(workdir, "checkout", "--force", "--detach", commit); err != nil {
+		return "", "", err
+	}
+
+	return workdir, commit, nil
+}
+
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s error: %s %s", args[0], err.Error(), strings.TrimSpace(string(output)))
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+// reconcileFlows creates or updates the flows of the flow files in the directory, and deletes the
+// flows synced before which aren't in the directory when all the flow files are valid.
+func reconcileFlows(dir string, status *SyncStatus) {
+	files, err := flowFiles(dir, config.GitOps.Pattern)
+	if err != nil {
+		status.fail("", err.Error())
+		return
+	}
+
+	synced := map[string]string{}
+	for _, file := range files {
+		name, _ := filepath.Rel(dir, file)
+
+		key, err := syncFlow(file, name, synced, status)
+		if err == errConflict {
+			continue
+		}
+		if err != nil {
+			status.fail(name, err.Error())
+			continue
+		}
+		if key == "" {
+			status.Skipped = append(status.Skipped, name)
+			continue
+		}
+		synced[key] = name
+	}
+	status.Flows = len(synced)
+
+	if len(status.Errors) > 0 {
+		return
+	}
+
+	flows, err := new(model.FlowV1).ListBySource(GitOpsSource)
+	if err != nil {
+		status.fail("", err.Error())
+		return
+	}
+
+	if err := pruneAllowed(len(synced), len(flows)); err != nil {
+		status.fail("", err.Error())
+		return
+	}
+
+	for i, _ := range flows {
+		flow := &flows[i]
+		key := fmt.Sprintf("%s/%s/%s:%s", flow.Namespace, flow.Repository, flow.Name, flow.Tag)
+		if _, ok := synced[key]; ok {
+			continue
+		}
+
+		if err := flow.Delete(); err != nil {
+			status.fail(strings.TrimPrefix(flow.Source, GitOpsSource), fmt.Sprintf("Delete flow %s error: %s", key, err.Error()))
+			continue
+		}
+		status.Deleted = append(status.Deleted, key)
+	}
+}
+
+// errConflict is returned by syncFlow when the flow file is reported in the conflicts.
+var errConflict = errors.New("Flow conflict")
+
+// syncFlow parses and validates the flow file, and records the flow when it changes. It returns
+// the uri:tag of flow, or empty when the file isn't a flow file.
+func syncFlow(file, name string, synced map[string]string, status *SyncStatus) (string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+
+	if flow, err := isFlowFile(data); err != nil {
+		return "", err
+	} else if flow == false {
+		return "", nil
+	}
+
+	f := new(Flow)
+	if errs := f.ParseYAML(data, file); len(errs) > 0 {
+		return "", errs
+	}
+
+	key := fmt.Sprintf("%s:%s", f.URI, f.Tag)
+	if other, ok := synced[key]; ok {
+		return "", fmt.Errorf("Flow %s is defined in %s too", key, other)
+	}
+
+	namespace, repository, flowName, err := f.URIs()
+	if err != nil {
+		return "", err
+	}
+
+	content, err := f.JSON()
+	if err != nil {
+		return "", err
+	}
+
+	source := GitOpsSource + filepath.ToSlash(name)
+	record := new(model.FlowV1)
+	exists := record.Get(namespace, repository, flowName, f.Tag) == nil
+	if exists {
+		if err := syncConflict(key, record.Source); err != nil {
+			status.conflict(name, err.Error())
+			return "", errConflict
+		}
+	}
+	if exists && record.Content == string(content) && record.Source == source {
+		return key, nil
+	}
+
+	if _, err := record.Put(namespace, repository, flowName, f.Tag, f.Title, string(content), f.Version, f.Timeout); err != nil {
+		return "", fmt.Errorf("Save flow %s error: %s", key, err.Error())
+	}
+	if err := record.SetSource(source); err != nil {
+		return "", fmt.Errorf("Save flow %s error: %s", key, err.Error())
+	}
+
+	if exists {
+		status.Updated = append(status.Updated, key)
+	} else {
+		status.Created = append(status.Created, key)
+	}
+	return key, nil
+}
+
+// isFlowFile is true when the YAML file has the uri of flow, the other YAML files like the
+// Kubernetes manifests are skipped. The invalid YAML file returns error, it could be a flow file.
+func isFlowFile(data []byte) (bool, error) {
+	var document interface{}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return false, fmt.Errorf("Invalid YAML: %s", err.Error())
+	}
+
+	if fields, ok := document.(map[interface{}]interface{}); ok {
+		_, ok = fields["uri"]
+		return ok, nil
+	}
+	return false, nil
+}
+
+// flowFiles returns the YAML files, or the files matching the pattern, in the directory and its
+// sub directories in order. The hidden directories like .git are skipped.
+func flowFiles(dir, pattern string) ([]string, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("Invalid flow file pattern %q: %s", pattern, err.Error())
+	}
+
+	files := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if path != dir && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if pattern != "" {
+			if matched, _ := filepath.Match(pattern, info.Name()); matched {
+				files = append(files, path)
+			}
+		} else if ext := filepath.Ext(path); ext == ".yml" || ext == ".yaml" {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Read the flow directory %s error: %s", dir, err.Error())
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// StoredFlow returns the flow definition recorded in the flow store.
+func StoredFlow(namespace, repository, name, tag string) (*Flow, error) {
+	record := new(model.FlowV1)
+	if err := record.Get(namespace, repository, name, tag); err != nil {
+		return nil, err
+	}
+
+	f := &Flow{}
+	if err := json.Unmarshal([]byte(record.Content), f); err != nil {
+		return nil, fmt.Errorf("Unmarshal the flow %s/%s/%s:%s error: %s", namespace, repository, name, tag, err.Error())
+	}
+	f.Number, f.Status = 1, Pending
+
+	return f, nil
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Huawei/containerops/pilotage/config"
+)
+
+func TestIsFlowFile(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		flow    bool
+		invalid bool
+	}{
+		{"flow", "uri: cncf/demo/build\ntag: latest\nstages: []\n", true, false},
+		{"kubernetes manifest", "apiVersion: v1\nkind: Service\nmetadata:\n  name: demo\n", false, false},
+		{"docker compose", "version: '3'\nservices:\n  db:\n    image: mysql\n", false, false},
+		{"sequence", "- uri: cncf/demo/build\n", false, false},
+		{"empty", "", false, false},
+		{"invalid", "uri: [cncf\n", false, true},
+	}
+
+	for _, c := range cases {
+		flow, err := isFlowFile([]byte(c.content))
+		if c.invalid {
+			if err == nil {
+				t.Errorf("%s: invalid YAML is accepted", c.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: error: %s", c.name, err.Error())
+		} else if flow != c.flow {
+			t.Errorf("%s: flow file is %v, it should be %v", c.name, flow, c.flow)
+		}
+	}
+}
+
+func TestFlowFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitops")
+	if err != nil {
+		t.Fatalf("Create directory error: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"build.flow.yml", "deploy.yaml", "README.md", "k8s/service.yml", ".git/config.yml"} {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		ioutil.WriteFile(path, []byte{}, 0644)
+	}
+
+	cases := []struct {
+		pattern string
+		files   []string
+	}{
+		{"", []string{"build.flow.yml", "deploy.yaml", "k8s/service.yml"}},
+		{"*.flow.yml", []string{"build.flow.yml"}},
+	}
+
+	for _, c := range cases {
+		files, err := flowFiles(dir, c.pattern)
+		if err != nil {
+			t.Errorf("Pattern %q: error: %s", c.pattern, err.Error())
+			continue
+		}
+
+		names := []string{}
+		for _, file := range files {
+			name, _ := filepath.Rel(dir, file)
+			names = append(names, filepath.ToSlash(name))
+		}
+		if reflect.DeepEqual(names, c.files) == false {
+			t.Errorf("Pattern %q: files are %v, they should be %v", c.pattern, names, c.files)
+		}
+	}
+
+	if _, err := flowFiles(dir, "[flow"); err == nil {
+		t.Errorf("Invalid pattern is accepted")
+	}
+}
+
+func TestSyncConflict(t *testing.T) {
+	if err := syncConflict("cncf/demo/build:latest", ""); err == nil {
+		t.Errorf("The posted flow is taken over by the flow file")
+	}
+	if err := syncConflict("cncf/demo/build:latest", "import:run.tar.gz"); err == nil {
+		t.Errorf("The flow of another source is taken over by the flow file")
+	}
+	if err := syncConflict("cncf/demo/build:latest", GitOpsSource+"old/build.yml"); err != nil {
+		t.Errorf("The flow of a renamed flow file conflicts: %s", err.Error())
+	}
+}
+
+func TestPruneAllowed(t *testing.T) {
+	defer func(empty bool) { config.GitOps.Empty = empty }(config.GitOps.Empty)
+
+	config.GitOps.Empty = false
+	if err := pruneAllowed(0, 3); err == nil {
+		t.Errorf("A sync without flow file deletes all the synced flows")
+	}
+	if err := pruneAllowed(0, 0); err != nil {
+		t.Errorf("An empty directory without synced flows fails: %s", err.Error())
+	}
+	if err := pruneAllowed(1, 3); err != nil {
+		t.Errorf("A sync with flow files can't delete the removed flows: %s", err.Error())
+	}
+
+	config.GitOps.Empty = true
+	if err := pruneAllowed(0, 3); err != nil {
+		t.Errorf("The empty config doesn't delete all the synced flows: %s", err.Error())
+	}
+}
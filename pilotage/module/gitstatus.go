@@ -0,0 +1,307 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Huawei/containerops/common"
+	"github.com/Huawei/containerops/common/utils"
+	"github.com/Huawei/containerops/pilotage/config"
+)
+
+const (
+	// CommitStatusReceiver is the receiver type reporting the run status to the commit of push.
+	CommitStatusReceiver = "commit-status"
+
+	// Git provider types
+	GitHubProvider = "github"
+	GitLabProvider = "gitlab"
+	GiteaProvider  = "gitea"
+
+	// DefaultGitHubAPI is the API of github.com.
+	DefaultGitHubAPI = "https://api.github.com"
+	// DefaultStatusContext is the context of commit status when the config is empty.
+	DefaultStatusContext = "pilotage"
+
+	// CommitHeader is the header of the signed commit in the flow forwarded by the webhook.
+	CommitHeader = "X-Pilotage-Commit"
+	// commitTTL is the age of the signed commit, the forwarded flow is posted at once.
+	commitTTL = 10 * time.Minute
+)
+
+var (
+	// statusClient posts the commit status, the Git provider never blocks the run.
+	statusClient = &http.Client{Timeout: 30 * time.Second}
+
+	// processKey signs the commits forwarded to the daemon itself when there is no auth key.
+	processKey     string
+	processKeyErr  error
+	processKeyOnce sync.Once
+)
+
+func init() {
+	Register(CommitStatusReceiver, &CommitStatusNotifier{})
+}
+
+// Commit is the Git commit of the push triggering the flow. The Repository is owner/name, or
+// the project path of GitLab.
+type Commit struct {
+	Repository string `json:"repository" yaml:"repository"`
+	SHA        string `json:"sha" yaml:"sha"`
+	Ref        string `json:"ref,omitempty" yaml:"ref,omitempty"`
+}
+
+// CommitStatusNotifier posts the status of run to the commit triggering it. The receiver address
+// is the name of Git provider in the gitstatus section of config.
+type CommitStatusNotifier struct {
+}
+
+// NotifyStart posts the pending status when the flow starts running.
+func (c *CommitStatusNotifier) NotifyStart(flow *Flow, receivers []string) error {
+	return c.post(flow, receivers, Pending)
+}
+
+// Notify posts the result of the finished flow.
+func (c *CommitStatusNotifier) Notify(flow *Flow, receivers []string) error {
+	return c.post(flow, receivers, flow.Status)
+}
+
+func (c *CommitStatusNotifier) post(flow *Flow, receivers []string, status string) error {
+	// The flow isn't triggered by a push.
+	if flow.Commit == nil {
+		return nil
+	}
+
+	for _, receiver := range receivers {
+		provider, ok := common.GitStatus[receiver]
+		if ok == false {
+			return fmt.Errorf("Git provider %q not found in the gitstatus config", receiver)
+		}
+
+		if err := PostCommitStatus(provider, flow, status); err != nil {
+			return fmt.Errorf("Post commit status to %s error: %s", receiver, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// PostCommitStatus posts the status of flow run to the commit with the status API of provider.
+func PostCommitStatus(provider common.GitStatusConfig, flow *Flow, status string) error {
+	context := provider.Context
+	if context == "" {
+		context = DefaultStatusContext
+	}
+
+	description := fmt.Sprintf("Flow %s:%s is %s", flow.URI, flow.Tag, status)
+	target := ""
+	if provider.Target != "" && flow.queued != "" {
+		target = fmt.Sprintf("%s/flow/v1/runs/%s", strings.TrimSuffix(provider.Target, "/"), flow.queued)
+	}
+
+	var uri string
+	var body map[string]string
+	header := http.Header{}
+
+	switch provider.Type {
+	case GitHubProvider, GiteaProvider:
+		api := provider.API
+		if api == "" {
+			if provider.Type == GiteaProvider {
+				return fmt.Errorf("The API of Gitea provider is required")
+			}
+			api = DefaultGitHubAPI
+		}
+
+		uri = fmt.Sprintf("%s/repos/%s/statuses/%s", strings.TrimSuffix(api, "/"), flow.Commit.Repository, flow.Commit.SHA)
+		body = map[string]string{"state": githubState(status), "context": context, "description": description}
+		if target != "" {
+			body["target_url"] = target
+		}
+		header.Set("Authorization", fmt.Sprintf("token %s", provider.Token))
+	case GitLabProvider:
+		if provider.API == "" {
+			return fmt.Errorf("The API of GitLab provider is required")
+		}
+
+		uri = fmt.Sprintf("%s/projects/%s/statuses/%s", strings.TrimSuffix(provider.API, "/"),
+			url.PathEscape(flow.Commit.Repository), flow.Commit.SHA)
+		body = map[string]string{"state": gitlabState(status), "name": context, "description": description}
+		if target != "" {
+			body["target_url"] = target
+		}
+		if flow.Commit.Ref != "" {
+			body["ref"] = strings.TrimPrefix(flow.Commit.Ref, "refs/heads/")
+		}
+		header.Set("PRIVATE-TOKEN", provider.Token)
+	default:
+		return fmt.Errorf("Unsupported Git provider type %q", provider.Type)
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, uri, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header = header
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := statusClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	return nil
+}
+
+// githubState returns the state of GitHub and Gitea status API.
+func githubState(status string) string {
+	switch status {
+	case Success:
+		return "success"
+	case Failure:
+		return "failure"
+	case Cancel:
+		return "error"
+	default:
+		return "pending"
+	}
+}
+
+// gitlabState returns the state of GitLab status API, the pending run is running after the
+// status posted when it starts.
+func gitlabState(status string) string {
+	switch status {
+	case Success:
+		return "success"
+	case Failure:
+		return "failed"
+	case Cancel:
+		return "canceled"
+	default:
+		return "running"
+	}
+}
+
+// ParsePushCommit returns the commit of the push event in the webhook payload of GitHub, GitLab
+// or Gitea. It returns nil when the payload isn't a push of commit, like the branch deletion.
+func ParsePushCommit(header http.Header, payload []byte) *Commit {
+	push := struct {
+		Ref        string `json:"ref"`
+		After      string `json:"after"`
+		Repository struct {
+			FullName string `json:"full_name"`
+		} `json:"repository"`
+		Project struct {
+			PathWithNamespace string `json:"path_with_namespace"`
+		} `json:"project"`
+	}{}
+
+	switch {
+	case header.Get("X-Gitea-Event") != "":
+		if header.Get("X-Gitea-Event") != "push" {
+			return nil
+		}
+	case header.Get("X-GitHub-Event") != "":
+		if header.Get("X-GitHub-Event") != "push" {
+			return nil
+		}
+	case header.Get("X-Gitlab-Event") != "":
+		if header.Get("X-Gitlab-Event") != "Push Hook" {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	if err := json.Unmarshal(payload, &push); err != nil {
+		return nil
+	}
+
+	repository := push.Repository.FullName
+	if push.Project.PathWithNamespace != "" {
+		repository = push.Project.PathWithNamespace
+	}
+
+	if repository == "" || push.After == "" || strings.Trim(push.After, "0") == "" {
+		return nil
+	}
+
+	return &Commit{Repository: repository, SHA: push.After, Ref: push.Ref}
+}
+
+// SignCommit signs the commit of push, it's sent with the flow forwarded by the webhook in the
+// CommitHeader. The clients posting flows can't set the commit without the signing key, so
+// the statuses are only posted to the commits of Git provider webhooks.
+func SignCommit(commit *Commit) (string, error) {
+	key, err := commitKey()
+	if err != nil {
+		return "", err
+	}
+
+	signed, err := utils.TokenMarshal(commit, key)
+	if err != nil {
+		return "", err
+	}
+	return string(signed), nil
+}
+
+// VerifyCommit returns the commit signed by SignCommit.
+func VerifyCommit(signed string) (*Commit, error) {
+	key, err := commitKey()
+	if err != nil {
+		return nil, err
+	}
+
+	commit := new(Commit)
+	if err := utils.TokenUnmarshalTTL(signed, key, commitTTL, commit); err != nil {
+		return nil, fmt.Errorf("Invalid signed commit")
+	}
+	return commit, nil
+}
+
+// commitKey is the auth key shared by the daemons, or a key of the daemon process when the
+// daemon accepts any request.
+func commitKey() (string, error) {
+	if config.Auth.Key != "" {
+		return config.Auth.Key, nil
+	}
+
+	processKeyOnce.Do(func() {
+		processKey, processKeyErr = GenerateTokenKey()
+	})
+	return processKey, processKeyErr
+}
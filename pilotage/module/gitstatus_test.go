@@ -0,0 +1,222 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Huawei/containerops/common"
+	"github.com/Huawei/containerops/pilotage/config"
+	"github.com/Huawei/containerops/pilotage/model"
+)
+
+func TestParsePushCommit(t *testing.T) {
+	githubPush := `{"ref": "refs/heads/master", "after": "8b3f2e1", "repository": {"full_name": "cncf/demo"}}`
+	gitlabPush := `{"ref": "refs/heads/master", "after": "8b3f2e1", "repository": {"name": "demo"}, "project": {"path_with_namespace": "group/cncf/demo"}}`
+
+	tests := []struct {
+		name    string
+		header  string
+		event   string
+		payload string
+		commit  *Commit
+	}{
+		{"github push", "X-GitHub-Event", "push", githubPush, &Commit{Repository: "cncf/demo", SHA: "8b3f2e1", Ref: "refs/heads/master"}},
+		{"gitea push", "X-Gitea-Event", "push", githubPush, &Commit{Repository: "cncf/demo", SHA: "8b3f2e1", Ref: "refs/heads/master"}},
+		{"gitlab push", "X-Gitlab-Event", "Push Hook", gitlabPush, &Commit{Repository: "group/cncf/demo", SHA: "8b3f2e1", Ref: "refs/heads/master"}},
+		{"github ping", "X-GitHub-Event", "ping", githubPush, nil},
+		{"gitlab tag push", "X-Gitlab-Event", "Tag Push Hook", gitlabPush, nil},
+		{"unknown provider", "X-Gogs-Event", "push", githubPush, nil},
+		{"branch deleted", "X-GitHub-Event", "push", `{"ref": "refs/heads/dev", "after": "0000000000000000000000000000000000000000", "repository": {"full_name": "cncf/demo"}}`, nil},
+		{"no repository", "X-GitHub-Event", "push", `{"ref": "refs/heads/dev", "after": "8b3f2e1"}`, nil},
+		{"invalid payload", "X-GitHub-Event", "push", `push`, nil},
+	}
+
+	for _, test := range tests {
+		header := http.Header{}
+		header.Set(test.header, test.event)
+
+		commit := ParsePushCommit(header, []byte(test.payload))
+		if test.commit == nil {
+			if commit != nil {
+				t.Errorf("%s: ParsePushCommit() = %+v, want nil", test.name, *commit)
+			}
+			continue
+		}
+		if commit == nil || *commit != *test.commit {
+			t.Errorf("%s: ParsePushCommit() = %+v, want %+v", test.name, commit, *test.commit)
+		}
+	}
+}
+
+func TestPostCommitStatus(t *testing.T) {
+	var path, token string
+	var body map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, token, body = r.URL.EscapedPath(), r.Header.Get("Authorization")+r.Header.Get("PRIVATE-TOKEN"), map[string]string{}
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	f := &Flow{URI: "cncf/demo/hello", Tag: "latest", queued: "run-1",
+		Commit: &Commit{Repository: "group/demo", SHA: "8b3f2e1", Ref: "refs/heads/master"}}
+
+	tests := []struct {
+		name     string
+		provider common.GitStatusConfig
+		status   string
+		path     string
+		token    string
+		body     map[string]string
+	}{
+		{"github", common.GitStatusConfig{Type: GitHubProvider, API: server.URL, Token: "secret"}, Cancel,
+			"/repos/group/demo/statuses/8b3f2e1", "token secret",
+			map[string]string{"state": "error", "context": "pilotage", "description": "Flow cncf/demo/hello:latest is cancel"}},
+		{"gitea", common.GitStatusConfig{Type: GiteaProvider, API: server.URL + "/api/v1/", Token: "secret", Context: "ci", Target: "http://pilotage/"}, Success,
+			"/api/v1/repos/group/demo/statuses/8b3f2e1", "token secret",
+			map[string]string{"state": "success", "context": "ci", "description": "Flow cncf/demo/hello:latest is success", "target_url": "http://pilotage/flow/v1/runs/run-1"}},
+		{"gitlab", common.GitStatusConfig{Type: GitLabProvider, API: server.URL + "/api/v4", Token: "secret"}, Pending,
+			"/api/v4/projects/group%2Fdemo/statuses/8b3f2e1", "secret",
+			map[string]string{"state": "running", "name": "pilotage", "description": "Flow cncf/demo/hello:latest is pending", "ref": "master"}},
+	}
+
+	for _, test := range tests {
+		if err := PostCommitStatus(test.provider, f, test.status); err != nil {
+			t.Errorf("%s: PostCommitStatus error: %s", test.name, err.Error())
+			continue
+		}
+		if path != test.path || token != test.token {
+			t.Errorf("%s: PostCommitStatus posts %s with %q, want %s with %q", test.name, path, token, test.path, test.token)
+		}
+		if len(body) != len(test.body) {
+			t.Errorf("%s: PostCommitStatus posts %v, want %v", test.name, body, test.body)
+		}
+		for k, v := range test.body {
+			if body[k] != v {
+				t.Errorf("%s: PostCommitStatus posts %s %q, want %q", test.name, k, body[k], v)
+			}
+		}
+	}
+
+	for _, provider := range []common.GitStatusConfig{{Type: GiteaProvider}, {Type: GitLabProvider}, {Type: "bitbucket", API: server.URL}} {
+		if err := PostCommitStatus(provider, f, Success); err == nil {
+			t.Errorf("PostCommitStatus to %+v succeeded, want error", provider)
+		}
+	}
+}
+
+func TestSignCommit(t *testing.T) {
+	defer func(key string) { config.Auth.Key = key }(config.Auth.Key)
+
+	commit := &Commit{Repository: "containerops/pilotage", SHA: "6dcb09b5b57875f334f61aebed695e2e4193db5e", Ref: "refs/heads/master"}
+
+	for _, key := range []string{"", "auth"} {
+		config.Auth.Key = ""
+		if key != "" {
+			config.Auth.Key, _ = GenerateTokenKey()
+		}
+
+		signed, err := SignCommit(commit)
+		if err != nil {
+			t.Fatalf("Sign commit error: %s", err.Error())
+		}
+		if verified, err := VerifyCommit(signed); err != nil {
+			t.Errorf("Verify commit with %q key error: %s", key, err.Error())
+		} else if *verified != *commit {
+			t.Errorf("Verified commit is %+v, want %+v", verified, commit)
+		}
+
+		// The commit posted by a client isn't signed.
+		for _, forged := range []string{`{"repository":"other/repo","sha":"1"}`, signed[:len(signed)-4] + "AAAA"} {
+			if _, err := VerifyCommit(forged); err == nil {
+				t.Errorf("Forged commit %q is verified with %q key", forged, key)
+			}
+		}
+	}
+}
+
+// A run triggered by a push reports the running and the failed states to the commit, and a
+// Git provider refusing the status doesn't block the run.
+func TestCommitStatusOfRun(t *testing.T) {
+	model.DisableDB = true
+
+	var lock sync.Mutex
+	states := []string{}
+	gitlab := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&body)
+
+		lock.Lock()
+		states = append(states, body["state"]+"@"+body["ref"])
+		lock.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer gitlab.Close()
+
+	github := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Bad credentials", http.StatusUnauthorized)
+	}))
+	defer github.Close()
+
+	defer func(providers map[string]common.GitStatusConfig) { common.GitStatus = providers }(common.GitStatus)
+	common.GitStatus = map[string]common.GitStatusConfig{
+		"gitlab": {Type: GitLabProvider, API: gitlab.URL, Token: "secret"},
+		"github": {Type: GitHubProvider, API: github.URL, Token: "expired"},
+	}
+
+	f := &Flow{URI: "cncf/demo/status", Tag: "latest", Model: CliRun,
+		Commit: &Commit{Repository: "cncf/demo", SHA: "8b3f2e1", Ref: "refs/heads/release"},
+		Receivers: []Receiver{
+			{Type: CommitStatusReceiver, Address: "github"},
+			{Type: CommitStatusReceiver, Address: "gitlab"},
+		},
+		Stages: []Stage{
+			{T: NormalStage, Name: "build", Sequencing: Sequencing, Actions: []Action{
+				{Name: "compile", Jobs: []Job{parallelJob("go-build", "missing")}},
+			}},
+		}}
+	f.LocalRun(false, false)
+
+	if f.Status != Failure {
+		t.Errorf("The run is %s, want %s", f.Status, Failure)
+	}
+
+	lock.Lock()
+	if strings.Join(states, " ") != "running@release failed@release" {
+		t.Errorf("GitLab receives the states %v, want running and failed of the release branch", states)
+	}
+	lock.Unlock()
+
+	logs, _ := f.LogsFrom(0)
+	if strings.Contains(strings.Join(logs, "\n"), "401 Unauthorized: Bad credentials") == false {
+		t.Errorf("The run doesn't log the refused status:\n%s", strings.Join(logs, "\n"))
+	}
+
+	// The same flow posted without a push has no commit to report.
+	states = states[:0]
+	f.Commit = nil
+	f.LocalRun(false, false)
+	if len(states) != 0 {
+		t.Errorf("The run without a commit posts the states %v", states)
+	}
+}
@@ -0,0 +1,243 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+const (
+	// GraphDOT is the Graphviz DOT format of flow graph.
+	GraphDOT = "dot"
+	// GraphSVG is the SVG format of flow graph, rendered by the Graphviz dot command.
+	GraphSVG = "svg"
+)
+
+// graph writes the DOT of a flow. The stages and actions are clusters, and the jobs are nodes.
+// Every cluster has an invisible anchor node, the edges between stages and sequential actions
+// are drawn between the anchors and clipped by the clusters.
+type graph struct {
+	buf   *bytes.Buffer
+	jobs  map[string]string
+	edges map[string]bool
+}
+
+// Graph writes the stages, actions, jobs and the output wiring of the flow in the format dot
+// or svg. The solid edges are the subscriptions and the dashed edges are the outputs
+// expressions, labelled with the output key.
+func (f *Flow) Graph(w io.Writer, format string) error {
+	switch format {
+	case GraphDOT:
+		_, err := w.Write(f.DOT())
+		return err
+	case GraphSVG:
+		if _, err := exec.LookPath("dot"); err != nil {
+			return fmt.Errorf("The Graphviz dot command is required to render SVG: %s", err.Error())
+		}
+
+		cmd := exec.Command("dot", "-Tsvg")
+		cmd.Stdin, cmd.Stdout = bytes.NewReader(f.DOT()), w
+		stderr := new(bytes.Buffer)
+		cmd.Stderr = stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("Render SVG error: %s %s", err.Error(), strings.TrimSpace(stderr.String()))
+		}
+		return nil
+	default:
+		return fmt.Errorf("Unsupported graph format %q, it should be %s or %s", format, GraphDOT, GraphSVG)
+	}
+}
+
+// DOT returns the Graphviz DOT of the flow.
+func (f *Flow) DOT() []byte {
+	g := &graph{buf: new(bytes.Buffer), jobs: map[string]string{}, edges: map[string]bool{}}
+
+	g.line("digraph %s {", quote(f.URI))
+	g.line("  label=%s;", quote(f.Title))
+	g.line("  labelloc=t;")
+	g.line("  rankdir=LR;")
+	g.line("  compound=true;")
+	g.line("  fontname=Helvetica;")
+	g.line("  node [shape=box, style=rounded, fontname=Helvetica, fontsize=10];")
+	g.line("  edge [fontname=Helvetica, fontsize=9];")
+
+	anchors := []string{}
+	for si, _ := range f.Stages {
+		anchors = append(anchors, g.stage(&f.Stages[si], si))
+	}
+
+	// The stages run in order.
+	for i := 1; i < len(anchors); i++ {
+		g.order("  ", anchors[i-1], anchors[i], f.Stages[i-1].T == NormalStage, f.Stages[i].T == NormalStage)
+	}
+
+	for _, stage := range f.Stages {
+		for _, action := range stage.Actions {
+			for _, job := range action.Jobs {
+				g.wiring(&job, fmt.Sprintf("%s.%s.%s", stage.Name, action.Name, job.Name))
+			}
+		}
+	}
+
+	g.line("}")
+	return g.buf.Bytes()
+}
+
+// stage writes the stage and returns its anchor node. The start, end and pause stages are nodes.
+func (g *graph) stage(s *Stage, si int) string {
+	id := fmt.Sprintf("s%d", si)
+
+	switch s.T {
+	case StartStage, EndStage:
+		g.line("  %s [label=%s, shape=circle, style=filled, fillcolor=lightgrey];", id, quote(s.Name))
+		return id
+	case PauseStage:
+		g.line("  %s [label=%s, shape=octagon, style=filled, fillcolor=khaki];", id, quote(s.Name))
+		return id
+	}
+
+	g.line("  subgraph cluster_%s {", id)
+	g.line("    label=%s;", quote(fmt.Sprintf("%s (%s)", s.Name, s.Sequencing)))
+	g.line("    style=rounded;")
+	g.line("    %s [shape=point, style=invis, width=0, height=0, label=\"\"];", id)
+
+	anchors := []string{}
+	for ai, _ := range s.Actions {
+		anchors = append(anchors, g.action(s, &s.Actions[ai], si, ai))
+	}
+
+	// The actions of sequential stage run in order.
+	if s.Sequencing != Parallel {
+		for i := 1; i < len(anchors); i++ {
+			g.order("    ", anchors[i-1], anchors[i], true, true)
+		}
+	}
+
+	g.line("  }")
+	return id
+}
+
+// action writes the action cluster with the jobs, and returns its anchor node.
+func (g *graph) action(s *Stage, a *Action, si, ai int) string {
+	id := fmt.Sprintf("s%d_a%d", si, ai)
+
+	g.line("    subgraph cluster_%s {", id)
+	g.line("      label=%s;", quote(a.Name))
+	g.line("      style=\"rounded,dashed\";")
+	g.line("      %s [shape=point, style=invis, width=0, height=0, label=\"\"];", id)
+
+	for ji, job := range a.Jobs {
+		node := fmt.Sprintf("%s_j%d", id, ji)
+		g.jobs[fmt.Sprintf("%s.%s.%s", s.Name, a.Name, job.Name)] = node
+
+		label := job.Name
+		if len(job.Outputs) > 0 {
+			label = fmt.Sprintf("%s\n[%s]", job.Name, strings.Join(job.Outputs, ", "))
+		}
+		g.line("      %s [label=%s];", node, quote(label))
+	}
+
+	g.line("    }")
+	return id
+}
+
+// order writes the edge between two units run one after another, the cluster anchors are clipped.
+func (g *graph) order(indent, from, to string, fromCluster, toCluster bool) {
+	attributes := []string{"style=bold", "color=grey"}
+	if fromCluster {
+		attributes = append(attributes, fmt.Sprintf("ltail=cluster_%s", from))
+	}
+	if toCluster {
+		attributes = append(attributes, fmt.Sprintf("lhead=cluster_%s", to))
+	}
+
+	g.line("%s%s -> %s [%s];", indent, from, to, strings.Join(attributes, ", "))
+}
+
+// wiring writes the edges from the jobs whose outputs the job subscribes or references.
+func (g *graph) wiring(j *Job, name string) {
+	consumer := g.jobs[name]
+
+	keys := []string{}
+	for _, subscription := range j.Subscriptions {
+		for key := range subscription {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		g.output(key, consumer, "solid")
+	}
+
+	values := []string{j.Endpoint, j.Image, j.Script, j.Kubectl}
+	if j.Cache != nil {
+		values = append(values, j.Cache.Key)
+	}
+	for _, environment := range j.Environments {
+		for _, value := range environment {
+			values = append(values, value)
+		}
+	}
+
+	keys = []string{}
+	for _, value := range values {
+		for _, expression := range Expressions(value) {
+			if scope, key, err := ParseExpression(expression); err == nil && scope == OutputsScope {
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		g.output(key, consumer, "dashed")
+	}
+}
+
+// output writes the edge of an output key stage.action.job[KEY] to the consumer job once.
+func (g *graph) output(key, consumer, style string) {
+	matches := subscriptionRegexp.FindStringSubmatch(key)
+	if matches == nil {
+		return
+	}
+
+	producer, ok := g.jobs[fmt.Sprintf("%s.%s.%s", matches[1], matches[2], matches[3])]
+	if ok == false || consumer == "" {
+		return
+	}
+
+	edge := fmt.Sprintf("%s -> %s [label=%s, style=%s, color=steelblue, fontcolor=steelblue];", producer, consumer, quote(matches[4]), style)
+	if g.edges[edge] {
+		return
+	}
+	g.edges[edge] = true
+
+	g.line("  %s", edge)
+}
+
+func (g *graph) line(format string, args ...interface{}) {
+	fmt.Fprintf(g.buf, format+"\n", args...)
+}
+
+// quote returns the DOT string of the value.
+func quote(value string) string {
+	return fmt.Sprintf("\"%s\"", strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value))
+}
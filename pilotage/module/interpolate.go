@@ -0,0 +1,208 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Expression Scopes
+	OutputsScope    = "outputs"
+	ParametersScope = "parameters"
+	FlowScope       = "flow"
+	EnvScope        = "env"
+)
+
+var (
+	// expressionRegexp matches the expression ${{ scope.name }}.
+	expressionRegexp = regexp.MustCompile(`\$\{\{([^}]*)\}\}`)
+
+	// FlowFields are the flow metadata could be referenced by ${{ flow.FIELD }}.
+	FlowFields = []string{"uri", "tag", "title", "version", "namespace", "number", "run"}
+)
+
+// Scope is the values referenced by the expressions in a job. Outputs is keyed by
+// stage.action.job[KEY], Env has the flow environments overridden by the job environments.
+type Scope struct {
+	Outputs    map[string]string
+	Parameters map[string]string
+	Flow       map[string]string
+	Env        map[string]string
+}
+
+// Expressions returns the expressions in the string without the ${{ }}.
+func Expressions(s string) []string {
+	expressions := []string{}
+	for _, matches := range expressionRegexp.FindAllStringSubmatch(s, -1) {
+		expressions = append(expressions, strings.TrimSpace(matches[1]))
+	}
+
+	return expressions
+}
+
+// HasExpressions is true when the string has any expression.
+func HasExpressions(s string) bool {
+	return expressionRegexp.MatchString(s)
+}
+
+// ParseExpression splits the expression into scope and name, like outputs and
+// stage.action.job[KEY] of outputs.stage.action.job[KEY].
+func ParseExpression(expression string) (scope, name string, err error) {
+	i := strings.Index(expression, ".")
+	if i <= 0 || i == len(expression)-1 {
+		return "", "", fmt.Errorf("invalid expression %q, it should be scope.name", expression)
+	}
+
+	scope, name = expression[:i], expression[i+1:]
+	switch scope {
+	case OutputsScope, ParametersScope, FlowScope, EnvScope:
+		return scope, name, nil
+	}
+
+	return "", "", fmt.Errorf("unknown scope %q in expression %q, it should be %s, %s, %s or %s",
+		scope, expression, OutputsScope, ParametersScope, FlowScope, EnvScope)
+}
+
+// Lookup returns the value of an expression.
+func (s *Scope) Lookup(expression string) (string, error) {
+	scope, name, err := ParseExpression(expression)
+	if err != nil {
+		return "", err
+	}
+
+	var values map[string]string
+	switch scope {
+	case OutputsScope:
+		values = s.Outputs
+	case ParametersScope:
+		values = s.Parameters
+	case FlowScope:
+		values = s.Flow
+	case EnvScope:
+		values = s.Env
+	}
+
+	if value, ok := values[name]; ok {
+		return value, nil
+	}
+
+	return "", fmt.Errorf("unresolved reference %q", expression)
+}
+
+// Interpolate replaces all the expressions in the string, the unresolved references are
+// returned in one error.
+func (s *Scope) Interpolate(str string) (string, error) {
+	messages := []string{}
+	result := expressionRegexp.ReplaceAllStringFunc(str, func(m string) string {
+		value, err := s.Lookup(strings.TrimSpace(m[3 : len(m)-2]))
+		if err != nil {
+			messages = append(messages, err.Error())
+			return m
+		}
+		return value
+	})
+
+	if len(messages) > 0 {
+		return "", errors.New(strings.Join(messages, "; "))
+	}
+
+	return result, nil
+}
+
+// SetParameters overrides the parameters of flow with the key=value pairs.
+func (f *Flow) SetParameters(pairs []string) error {
+	for _, pair := range pairs {
+		i := strings.Index(pair, "=")
+		if i <= 0 {
+			return fmt.Errorf("invalid parameter %q, it should be key=value", pair)
+		}
+
+		if f.Parameters == nil {
+			f.Parameters = map[string]string{}
+		}
+		f.Parameters[pair[:i]] = pair[i+1:]
+	}
+
+	return nil
+}
+
+// Scope returns the values could be referenced by the expressions in the job.
+func (f *Flow) Scope(j *Job, outputs map[string]string) *Scope {
+	number := f.Number
+	if f.data != nil && f.data.Number > 0 {
+		number = f.data.Number
+	}
+
+	s := &Scope{
+		Outputs:    outputs,
+		Parameters: f.Parameters,
+		Flow: map[string]string{
+			"uri":       f.URI,
+			"tag":       f.Tag,
+			"title":     f.Title,
+			"version":   strconv.FormatInt(f.Version, 10),
+			"namespace": f.Namespace,
+			"number":    strconv.FormatInt(number, 10),
+			"run":       f.RunID(),
+		},
+		Env: map[string]string{},
+	}
+
+	for _, environment := range f.Environments {
+		for k, v := range environment {
+			s.Env[k] = v
+		}
+	}
+	for _, environment := range j.Environments {
+		for k, v := range environment {
+			s.Env[k] = v
+		}
+	}
+
+	// The expressions in environments are resolved without env scope, so they never reference each other.
+	raw := &Scope{Outputs: s.Outputs, Parameters: s.Parameters, Flow: s.Flow}
+	for k, v := range s.Env {
+		if value, err := raw.Interpolate(v); err == nil {
+			s.Env[k] = value
+		}
+	}
+
+	return s
+}
+
+// interpolateEnvironments returns the environments with the expressions resolved.
+func (s *Scope) interpolateEnvironments(environments []map[string]string) ([]map[string]string, error) {
+	result := []map[string]string{}
+	for _, environment := range environments {
+		resolved := map[string]string{}
+		for k, v := range environment {
+			value, err := s.Interpolate(v)
+			if err != nil {
+				return nil, fmt.Errorf("environment %s: %s", k, err.Error())
+			}
+			resolved[k] = value
+		}
+		result = append(result, resolved)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"testing"
+)
+
+func TestInterpolate(t *testing.T) {
+	s := &Scope{
+		Outputs:    map[string]string{"build.compile.go-build[CO_URL]": "http://dockyard/hello"},
+		Parameters: map[string]string{"VERSION": "1.0.0"},
+		Flow:       map[string]string{"uri": "cncf/demo/hello", "number": "3"},
+		Env:        map[string]string{"CO_DATA": "go-build"},
+	}
+
+	tests := []struct {
+		str    string
+		want   string
+		errors string
+	}{
+		{"no expression", "no expression", ""},
+		{"${{ parameters.VERSION }}", "1.0.0", ""},
+		{"${{parameters.VERSION}}", "1.0.0", ""},
+		{"${{ outputs.build.compile.go-build[CO_URL] }}/bin", "http://dockyard/hello/bin", ""},
+		{"${{ flow.uri }} run ${{ flow.number }} with ${{ env.CO_DATA }}", "cncf/demo/hello run 3 with go-build", ""},
+		{"${{ parameters.MISSING }}", "", `unresolved reference "parameters.MISSING"`},
+		{"${{ flow.tag }} and ${{ env.CO_ENV }}", "", `unresolved reference "flow.tag"; unresolved reference "env.CO_ENV"`},
+		{"${{ secrets.TOKEN }}", "", `unknown scope "secrets" in expression "secrets.TOKEN", it should be outputs, parameters, flow or env`},
+		{"${{ VERSION }}", "", `invalid expression "VERSION", it should be scope.name`},
+		{"${{ parameters. }}", "", `invalid expression "parameters.", it should be scope.name`},
+		{"${ parameters.VERSION }", "${ parameters.VERSION }", ""},
+	}
+
+	for _, test := range tests {
+		result, err := s.Interpolate(test.str)
+		if test.errors != "" {
+			if err == nil || err.Error() != test.errors {
+				t.Errorf("Interpolate(%q) error = %v, want %q", test.str, err, test.errors)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Interpolate(%q) error: %s", test.str, err.Error())
+		} else if result != test.want {
+			t.Errorf("Interpolate(%q) = %q, want %q", test.str, result, test.want)
+		}
+	}
+}
+
+func TestFlowScope(t *testing.T) {
+	f := &Flow{
+		URI:          "cncf/demo/hello",
+		Tag:          "latest",
+		Number:       2,
+		Parameters:   map[string]string{"VERSION": "1.0.0"},
+		Environments: []map[string]string{{"CO_ENV": "test"}, {"CO_VERSION": "${{ parameters.VERSION }}"}},
+		runID:        "hello",
+	}
+	j := &Job{Name: "go-build", Environments: []map[string]string{{"CO_ENV": "prod"}, {"CO_SELF": "${{ env.CO_ENV }}"}}}
+
+	s := f.Scope(j, map[string]string{})
+
+	tests := []struct {
+		expression string
+		want       string
+	}{
+		{"env.CO_ENV", "prod"},
+		{"env.CO_VERSION", "1.0.0"},
+		// The environments never reference each other.
+		{"env.CO_SELF", "${{ env.CO_ENV }}"},
+		{"flow.number", "2"},
+		{"flow.run", "hello"},
+		{"flow.version", "0"},
+	}
+
+	for _, test := range tests {
+		if value, err := s.Lookup(test.expression); err != nil {
+			t.Errorf("Lookup(%q) error: %s", test.expression, err.Error())
+		} else if value != test.want {
+			t.Errorf("Lookup(%q) = %q, want %q", test.expression, value, test.want)
+		}
+	}
+}
+
+// The output of a job reaches the image and the environments of a later job through the pod.
+func TestOutputsIntoPod(t *testing.T) {
+	f := &Flow{URI: "cncf/demo/hello", Tag: "latest", Parameters: map[string]string{"REGISTRY": "hub.opshub.sh"}}
+	deploy := &Job{T: ComponentJob, Name: "helm-upgrade", Endpoint: "${{ parameters.REGISTRY }}/containerops/helm:latest",
+		Resources:    Resource{CPU: "1", Memory: "1Gi"},
+		Environments: []map[string]string{{"CO_IMAGE": "${{ outputs.build.compile.go-build[CO_IMAGE] }}"}}}
+
+	// The output isn't produced yet, the pod isn't created with an unresolved value.
+	if _, err := deploy.PodTemplates("helm-upgrade", f, f.GetOutputs()); err == nil {
+		t.Fatalf("The pod is created with an unresolved output")
+	}
+
+	f.SetOutput("build.compile.go-build[CO_IMAGE]", "hub.opshub.sh/cncf/hello:1")
+	pod, err := deploy.PodTemplates("helm-upgrade", f, f.GetOutputs())
+	if err != nil {
+		t.Fatalf("Pod templates error: %s", err.Error())
+	}
+
+	container := pod.Spec.Containers[0]
+	if container.Image != "hub.opshub.sh/containerops/helm:latest" {
+		t.Errorf("The image is %s, the parameter isn't resolved", container.Image)
+	}
+	value := ""
+	for _, env := range container.Env {
+		if env.Name == "CO_IMAGE" {
+			value = env.Value
+		}
+	}
+	if value != "hub.opshub.sh/cncf/hello:1" {
+		t.Errorf("The env CO_IMAGE is %q, the output isn't resolved", value)
+	}
+}
@@ -2,6 +2,7 @@ package module
 
 import (
 	"bufio"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -10,26 +11,37 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
 
 	. "github.com/logrusorgru/aurora"
-	homeDir "github.com/mitchellh/go-homedir"
 	apiv1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
-	"k8s.io/client-go/tools/clientcmd"
 
 	"github.com/Huawei/containerops/common/utils"
 	"github.com/Huawei/containerops/pilotage/model"
 )
 
+const (
+	// Job types
+	ComponentJob = "component"
+	ScriptJob    = "script"
+
+	// ScriptShell runs the script of script job, it exits at the first failed command.
+	ScriptShell = "/bin/sh"
+
+	// streamRetries is the times to open the log stream of job container.
+	streamRetries = 5
+)
+
 var (
-	RWlock        sync.RWMutex
-	GlobalOutputs map[string]string
+	// ErrCanceled is returned when the flow or stage is canceled while the job is running.
+	ErrCanceled = errors.New("Job run is canceled")
 )
 
 // Job is
@@ -39,6 +51,9 @@ type Job struct {
 	Name          string              `json:"name" yaml:"name,omitempty"`
 	Kubectl       string              `json:"kubectl" yaml:"kubectl"`
 	Endpoint      string              `json:"endpoint" yaml:"endpoint"`
+	Image         string              `json:"image,omitempty" yaml:"image,omitempty"`
+	Script        string              `json:"run,omitempty" yaml:"run,omitempty"`
+	Cluster       string              `json:"cluster,omitempty" yaml:"cluster,omitempty"`
 	Timeout       int64               `json:"timeout" yaml:"timeout"`
 	Status        string              `json:"status,omitempty" yaml:"status,omitempty"`
 	Resources     Resource            `json:"resources" yaml:"resources"`
@@ -46,6 +61,26 @@ type Job struct {
 	Environments  []map[string]string `json:"environments" yaml:"environments"`
 	Outputs       []string            `json:"outputs,omitempty" yaml:"outputs,omitempty"`
 	Subscriptions []map[string]string `json:"subscriptions,omitempty" yaml:"subscriptions,omitempty"`
+	Pod           string              `json:"pod,omitempty" yaml:"pod,omitempty"`
+	Cache         *Cache              `json:"cache,omitempty" yaml:"cache,omitempty"`
+	Services      []Service           `json:"services,omitempty" yaml:"services,omitempty"`
+	JUnit         string              `json:"junit,omitempty" yaml:"junit,omitempty"`
+	Tests         *TestSummary        `json:"tests,omitempty" yaml:"tests,omitempty"`
+	Cached        bool                `json:"cached,omitempty" yaml:"cached,omitempty"`
+
+	// skip is true when the job is reused from the parent run.
+	skip bool
+	// run is the flow data id of the run, the logs are pruned with it.
+	run int64
+	// data is the job data id of the run, the test cases are recorded with it.
+	data int64
+	// attach is true when the job follows the pod created before the daemon restarts.
+	attach bool
+	// inputs is the hash of image and environments of the pod.
+	inputs string
+	// since is the time of the last log recorded before the daemon restarts, the attached job
+	// only reads the logs of pod after it.
+	since time.Time
 }
 
 // Resources is
@@ -54,15 +89,12 @@ type Resource struct {
 	Memory string `json:"memory" yaml:"memory"`
 }
 
-func init() {
-	GlobalOutputs = make(map[string]string)
-}
-
 // TODO filter the log print with different color.
 func (j *Job) Log(log string, verbose, timestamp bool) {
-	j.Logs = append(j.Logs, fmt.Sprintf("[%s] %s", time.Now().String(), log))
-	l := new(model.LogV1)
-	l.Create(model.INFO, model.JOB, j.ID, log)
+	logsLock.Lock()
+	j.Logs, _ = appendLog(j.Logs, fmt.Sprintf("[%s] %s", time.Now().String(), log))
+	logsLock.Unlock()
+	persistLog(model.JOB, j.ID, j.run, log)
 
 	if verbose == true {
 		if timestamp == true {
@@ -73,197 +105,534 @@ func (j *Job) Log(log string, verbose, timestamp bool) {
 	}
 }
 
-func (j *Job) Run(name string, verbose, timestamp bool, f *Flow, stageIndex, actionIndex int) (string, error) {
+func (j *Job) Run(ctx context.Context, name string, verbose, timestamp bool, f *Flow, stageIndex, actionIndex int) (string, error) {
 
 	j.SaveDatabase(verbose, timestamp, f, stageIndex, actionIndex)
 
+	if j.attach {
+		return j.Attach(ctx, verbose, timestamp, f, stageIndex, actionIndex)
+	}
+
+	outputs := f.GetOutputs()
 	randomContainerName := fmt.Sprintf("%s-%s", name, utils.RandomString(10))
-	podTemplate := j.PodTemplates(randomContainerName, f)
+	podTemplate, err := j.PodTemplates(randomContainerName, f, outputs)
+	if err != nil {
+		setStatus(&j.Status, Failure)
+		return Failure, err
+	}
 
-	if err := j.InvokePod(podTemplate, randomContainerName, verbose, timestamp, f, stageIndex, actionIndex); err != nil {
+	j.inputs = InputsHash(podTemplate)
+	key, hit := j.lookupCache(podTemplate, f, outputs, verbose, timestamp, stageIndex, actionIndex)
+	if hit {
+		return Success, nil
+	}
+
+	if err := j.InvokePod(ctx, podTemplate, randomContainerName, verbose, timestamp, f, stageIndex, actionIndex); err != nil {
+		if err == ErrCanceled {
+			setStatus(&j.Status, Cancel)
+			return Cancel, nil
+		}
 		return Failure, err
 	}
 
-	j.Status = Success
+	setStatus(&j.Status, Success)
+	j.storeCache(key, f, verbose, timestamp, stageIndex, actionIndex)
 
 	return Success, nil
 }
 
-func (j *Job) RunKubectl(name string, verbose, timestamp bool, f *Flow, stageIndex, actionIndex int) (string, error) {
+func (j *Job) RunKubectl(ctx context.Context, name string, verbose, timestamp bool, f *Flow, stageIndex, actionIndex int) (string, error) {
 
 	j.SaveDatabase(verbose, timestamp, f, stageIndex, actionIndex)
 
-	originYaml := []byte{}
-	if u, err := url.Parse(j.Kubectl); err != nil {
+	if j.attach {
+		return j.Attach(ctx, verbose, timestamp, f, stageIndex, actionIndex)
+	}
+
+	outputs := f.GetOutputs()
+	base64Yaml, err := j.KubectlYaml(f, outputs)
+	if err != nil {
+		setStatus(&j.Status, Failure)
+		return Failure, err
+	}
+
+	apiServerInsecure, err := KubectlAPIServer(f.ClusterOf(stageIndex, j))
+	if err != nil {
+		setStatus(&j.Status, Failure)
+		return Failure, err
+	}
+	namespace := "default"
+	if f.Namespace != "" {
+		namespace = f.Namespace
+	}
+	randomContainerName := fmt.Sprintf("kubectl-create-%s", utils.RandomString(10))
+	podTemplate, err := j.KubectlPodTemplates(randomContainerName, apiServerInsecure, namespace, base64Yaml, f, outputs)
+	if err != nil {
+		setStatus(&j.Status, Failure)
 		return Failure, err
+	}
+
+	j.inputs = InputsHash(podTemplate)
+	key, hit := j.lookupCache(podTemplate, f, outputs, verbose, timestamp, stageIndex, actionIndex)
+	if hit {
+		return Success, nil
+	}
+
+	if err := j.InvokePod(ctx, podTemplate, randomContainerName, verbose, timestamp, f, stageIndex, actionIndex); err != nil {
+		if err == ErrCanceled {
+			setStatus(&j.Status, Cancel)
+			return Cancel, nil
+		}
+		return Failure, err
+	}
+
+	setStatus(&j.Status, Success)
+	j.storeCache(key, f, verbose, timestamp, stageIndex, actionIndex)
+	return Success, nil
+}
+
+// Attach follows the pod of job created before the daemon restarts.
+func (j *Job) Attach(ctx context.Context, verbose, timestamp bool, f *Flow, stageIndex, actionIndex int) (string, error) {
+	if since, err := new(model.LogV1).LastTime(model.JOB, j.ID, j.run); err == nil {
+		j.since = since
+	}
+	j.Log(fmt.Sprintf("Job %s attaches to the running pod %s", j.Name, j.Pod), verbose, timestamp)
+
+	if err := j.InvokePod(ctx, nil, j.Pod, verbose, timestamp, f, stageIndex, actionIndex); err != nil {
+		if err == ErrCanceled {
+			setStatus(&j.Status, Cancel)
+			return Cancel, nil
+		}
+		return Failure, err
+	}
+
+	setStatus(&j.Status, Success)
+	return Success, nil
+}
+
+// KubectlYaml reads the kubectl YAML file from local path or URL, and returns the base64 encoded content.
+// The expressions in the path are resolved from the outputs.
+func (j *Job) KubectlYaml(f *Flow, outputs map[string]string) (string, error) {
+	kubectl, err := f.Scope(j, outputs).Interpolate(j.Kubectl)
+	if err != nil {
+		return "", fmt.Errorf("kubectl: %s", err.Error())
+	}
+
+	originYaml := []byte{}
+	if u, err := url.Parse(kubectl); err != nil {
+		return "", err
 	} else {
 		if u.Scheme == "" {
-			if utils.IsFileExist(j.Kubectl) == true {
+			if utils.IsFileExist(kubectl) == true {
 				// Read YAML file from local
-				data, err := ioutil.ReadFile(j.Kubectl)
+				data, err := ioutil.ReadFile(kubectl)
 				if err != nil {
-					return Failure, err
+					return "", err
 				}
 				originYaml = data
 			} else {
-				return Failure, errors.New("Kubectl PATH is invalid")
+				return "", errors.New("Kubectl PATH is invalid")
 			}
 		} else {
 			// Download YAML from URL
-			resp, err := http.Get(j.Kubectl)
+			resp, err := http.Get(kubectl)
 			if err != nil {
-				return Failure, err
+				return "", err
 			}
+			defer resp.Body.Close()
 			body, err := ioutil.ReadAll(resp.Body)
 			if err != nil {
-				return Failure, err
+				return "", err
 			}
 			originYaml = body
 		}
 	}
-	base64Yaml := base64.StdEncoding.EncodeToString(originYaml)
 
+	return base64.StdEncoding.EncodeToString(originYaml), nil
+}
+
+// KubectlAPIServer returns the insecure API server address from the kube config of the cluster.
+func KubectlAPIServer(cluster string) (string, error) {
 	//TODO port and ip address can set from setting
-	home, _ := homeDir.Dir()
-	configFile, err := clientcmd.BuildConfigFromFlags("", fmt.Sprintf("%s/.kube/config", home))
+	c, err := GetCluster(cluster)
 	if err != nil {
-		return Failure, err
+		return "", err
 	}
-	apiServerInsecure := fmt.Sprintf("http:%s:8080", strings.Split(configFile.Host, ":")[1])
-	namespace := "default"
-	if f.Namespace != "" {
-		namespace = f.Namespace
-	}
-	randomContainerName := fmt.Sprintf("kubectl-create-%s", utils.RandomString(10))
-	podTemplate := j.KubectlPodTemplates(randomContainerName, apiServerInsecure, namespace, base64Yaml, f)
 
-	if err := j.InvokePod(podTemplate, randomContainerName, verbose, timestamp, f, stageIndex, actionIndex); err != nil {
-		return Failure, err
+	// The host of kube config is an URL or a host:port.
+	host := strings.Split(c.rest.Host, ":")[0]
+	if u, err := url.Parse(c.rest.Host); err == nil && u.Host != "" {
+		host = u.Hostname()
+	}
+	if host == "" {
+		return "", fmt.Errorf("The API server of cluster %s is unknown", cluster)
 	}
 
-	j.Status = Success
-	return Success, nil
+	return fmt.Sprintf("http://%s:8080", host), nil
 }
 
-func (j *Job) InvokePod(podTemplate *apiv1.Pod, randomContainerName string, verbose, timestamp bool, f *Flow, stageIndex, actionIndex int) error {
-	home, _ := homeDir.Dir()
-	if config, err := clientcmd.BuildConfigFromFlags("", fmt.Sprintf("%s/.kube/config", home)); err != nil {
+// InvokePod creates a Kubernetes Job with the pod template in the cluster of job and follows the
+// logs of its pod. When the pod template is nil, the pod recorded before the daemon restarts is
+// followed again. The job fails at once when the cluster is unhealthy, and waits while the
+// running jobs reach the concurrency of cluster.
+func (j *Job) InvokePod(ctx context.Context, podTemplate *apiv1.Pod, randomContainerName string, verbose, timestamp bool, f *Flow, stageIndex, actionIndex int) error {
+	cluster, err := GetCluster(f.ClusterOf(stageIndex, j))
+	if err != nil {
+		setStatus(&j.Status, Failure)
 		return err
-	} else {
-		if clientSet, err := kubernetes.NewForConfig(config); err != nil {
+	}
+	if err := cluster.Check(); err != nil {
+		setStatus(&j.Status, Failure)
+		return fmt.Errorf("Cluster %s is unhealthy: %s", cluster.Name, err.Error())
+	}
+
+	if cluster.Full() {
+		j.Log(fmt.Sprintf("Job %s waits for a slot of cluster %s, %d jobs are running", j.Name, cluster.Name, cluster.Concurrency), verbose, timestamp)
+	}
+	if err := cluster.Acquire(ctx); err != nil {
+		return err
+	}
+	defer cluster.Release()
+
+	p := cluster.Client().CoreV1().Pods(cluster.Namespace)
+
+	if podTemplate != nil {
+		if _, err := cluster.Client().BatchV1().Jobs(cluster.Namespace).Create(j.JobTemplates(podTemplate, f, stageIndex, actionIndex)); err != nil {
+			countKubeError("create_job", err)
+			setStatus(&j.Status, Failure)
 			return err
-		} else {
-			p := clientSet.CoreV1().Pods(apiv1.NamespaceDefault)
-			if _, err := p.Create(podTemplate); err != nil {
-				j.Status = Failure
-				return err
+		}
+
+		podName, err := JobPod(ctx, p, randomContainerName)
+		if err == ErrCanceled {
+			return j.CancelPod(cluster, randomContainerName, verbose, timestamp)
+		} else if err != nil {
+			setStatus(&j.Status, Failure)
+			return err
+		}
+
+		logsLock.Lock()
+		j.Pod, j.Status = podName, Pending
+		logsLock.Unlock()
+		f.Checkpoint()
+		time.Sleep(time.Second * 2)
+	}
+	podName := j.Pod
+
+	atomic.AddInt64(&activePods, 1)
+	defer atomic.AddInt64(&activePods, -1)
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	// The job container starts after the services are ready, so the pod is pending longer.
+	var pod *apiv1.Pod
+	start, pending := time.Now(), 3*time.Minute+j.servicesTimeout()
+ForLoop:
+	for {
+		if ctx.Err() != nil {
+			return j.CancelPod(cluster, podName, verbose, timestamp)
+		}
+		pod, err = p.Get(podName, metav1.GetOptions{})
+		if err != nil {
+			countKubeError("get_pod", err)
+			j.Log(err.Error(), false, timestamp)
+			return err
+		}
+		switch pod.Status.Phase {
+		case apiv1.PodPending:
+			j.Log(fmt.Sprintf("Job %s is %s", j.Name, pod.Status.Phase), verbose, timestamp)
+		case apiv1.PodRunning:
+			// The pod is running when a service starts, the logs are read after the job container starts.
+			if state := containerState(pod, jobContainer(pod)); state.Running != nil || state.Terminated != nil {
+				break ForLoop
+			}
+			j.Log(fmt.Sprintf("Job %s waits for its services", j.Name), verbose, timestamp)
+		case apiv1.PodSucceeded:
+			break ForLoop
+		case apiv1.PodUnknown:
+			state := containerState(pod, jobContainer(pod))
+			j.Log(fmt.Sprintf("Job %s is %s, Detail:[%s] \n", j.Name, pod.Status.Phase, state.String()), verbose, timestamp)
+		case apiv1.PodFailed:
+			state := containerState(pod, jobContainer(pod))
+			j.Log(fmt.Sprintf("Job %s is %s, Detail:[%s] \n", j.Name, pod.Status.Phase, state.String()), verbose, timestamp)
+			break ForLoop
+		}
+		duration := time.Now().Sub(start)
+		if duration > pending {
+			if len(pod.Spec.Containers) > 1 {
+				j.StopServices(cluster, podName, verbose, timestamp)
 			}
+			return errors.New(fmt.Sprintf("Job %s Pending more than %s", j.Name, pending.String()))
+		}
+		time.Sleep(time.Second * 2)
+	}
 
-			j.Status = Pending
-			time.Sleep(time.Second * 2)
+	// The job container is after the service containers.
+	container := jobContainer(pod)
+	if pod.Status.Phase == apiv1.PodFailed && containerState(pod, container).Terminated == nil {
+		if len(pod.Spec.Containers) > 1 {
+			j.StopServices(cluster, podName, verbose, timestamp)
+		}
+		setStatus(&j.Status, Failure)
+		return fmt.Errorf("Pod %s of job %s failed before the job container runs: %s", podName, j.Name, pod.Status.Message)
+	}
 
-			start := time.Now()
-		ForLoop:
-			for {
-				pod, err := p.Get(randomContainerName, metav1.GetOptions{})
-				if err != nil {
-					j.Log(err.Error(), false, timestamp)
-					return err
+	// The job timeout applies to reading the logs and waiting the job container, so the job hanging
+	// while it's printing logs is stopped too.
+	timeout := time.Duration(j.Timeout) * time.Second
+	runCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if read, err := streamLogs(runCtx, p, podName, container, j.since); err == ErrCanceled {
+		if ctx.Err() == nil {
+			return j.TimeoutPod(cluster, podName, timeout, verbose, timestamp)
+		}
+		return j.CancelPod(cluster, podName, verbose, timestamp)
+	} else if err != nil {
+		if len(pod.Spec.Containers) > 1 {
+			j.StopServices(cluster, podName, verbose, timestamp)
+		}
+		setStatus(&j.Status, Failure)
+		return fmt.Errorf("Read the logs of job %s error: %s", j.Name, err.Error())
+	} else {
+		// Stop reading the log stream when the job is canceled.
+		defer read.Close()
+		go func() {
+			select {
+			case <-runCtx.Done():
+				read.Close()
+			case <-stop:
+			}
+		}()
+
+		reader := bufio.NewReader(read)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				if ctx.Err() != nil {
+					return j.CancelPod(cluster, podName, verbose, timestamp)
+				} else if runCtx.Err() != nil {
+					return j.TimeoutPod(cluster, podName, timeout, verbose, timestamp)
 				}
-				switch pod.Status.Phase {
-				case apiv1.PodPending:
-					j.Log(fmt.Sprintf("Job %s is %s", j.Name, pod.Status.Phase), verbose, timestamp)
-				case apiv1.PodRunning, apiv1.PodSucceeded:
-					break ForLoop
-				case apiv1.PodUnknown:
-					j.Log(fmt.Sprintf("Job %s is %s, Detail:[%s] \n", j.Name, pod.Status.Phase, pod.Status.ContainerStatuses[0].State.String()), verbose, timestamp)
-				case apiv1.PodFailed:
-					j.Log(fmt.Sprintf("Job %s is %s, Detail:[%s] \n", j.Name, pod.Status.Phase, pod.Status.ContainerStatuses[0].State.String()), verbose, timestamp)
-					break ForLoop
+				// The job container is waited below when the stream breaks.
+				if err != io.EOF {
+					j.Log(fmt.Sprintf("Read the logs of job %s error: %s", j.Name, err.Error()), verbose, timestamp)
 				}
-				duration := time.Now().Sub(start)
-				if duration.Minutes() > 3 {
-					return errors.New(fmt.Sprintf("Job %s Pending more than 3 minutes", j.Name))
+				break
+			}
+			if j.since.IsZero() == false {
+				var logged bool
+				if line, logged = skipLogged(line, j.since); logged {
+					continue
 				}
-				time.Sleep(time.Second * 2)
+			}
+			if strings.Contains(line, "[COUT]") && len(j.Outputs) != 0 {
+				j.FetchOutputs(f, f.Stages[stageIndex].Name, f.Stages[stageIndex].Actions[actionIndex].Name, line)
 			}
 
-			req := p.GetLogs(randomContainerName, &apiv1.PodLogOptions{
-				Follow:     true,
-				Timestamps: false,
-			})
-
-			if read, err := req.Stream(); err != nil {
-				// TODO Parse ContainerCreating error
-			} else {
-				reader := bufio.NewReader(read)
-				for {
-					line, err := reader.ReadString('\n')
-					if err != nil {
-						if err == io.EOF {
-							break
-						}
-						j.Status = Failure
-						return err
-					}
-					if strings.Contains(line, "[COUT]") && len(j.Outputs) != 0 {
-						j.FetchOutputs(f.Stages[stageIndex].Name, f.Stages[stageIndex].Actions[actionIndex].Name, line)
-					}
-
-					j.Status = Running
-
-					j.Log(line, false, timestamp)
-					f.Log(line, verbose, timestamp)
-				}
+			if j.Status != Running {
+				setStatus(&j.Status, Running)
+				f.Checkpoint()
 			}
+
+			j.Log(line, false, timestamp)
+			f.Log(line, verbose, timestamp)
 		}
 	}
+
+	// The job fails when the job container exits with non-zero code, like a failed script. The
+	// log stream could end before the container, the container is waited in the job timeout.
+	state, err := j.WaitTerminated(runCtx, p, podName, container)
+	if err == ErrCanceled {
+		if ctx.Err() == nil {
+			return j.TimeoutPod(cluster, podName, timeout, verbose, timestamp)
+		}
+		return j.CancelPod(cluster, podName, verbose, timestamp)
+	}
+
+	// The services never exit, the pod stops when the job container terminated.
+	if len(pod.Spec.Containers) > 1 {
+		j.StopServices(cluster, podName, verbose, timestamp)
+	}
+
+	if err != nil {
+		setStatus(&j.Status, Failure)
+		return err
+	}
+	if state.ExitCode != 0 {
+		setStatus(&j.Status, Failure)
+		return fmt.Errorf("Container of job %s exits with code %d: %s", j.Name, state.ExitCode, state.Reason)
+	}
 	return nil
 }
 
+// WaitTerminated waits the container of pod terminated, and returns its terminated state. It
+// returns ErrCanceled when the context is done while waiting, the job is canceled or timeout.
+func (j *Job) WaitTerminated(ctx context.Context, p corev1.PodInterface, podName, container string) (*apiv1.ContainerStateTerminated, error) {
+	for {
+		pod, err := p.Get(podName, metav1.GetOptions{})
+		if err != nil {
+			countKubeError("get_pod", err)
+			return nil, err
+		}
+
+		if state := containerState(pod, container); state.Terminated != nil {
+			return state.Terminated, nil
+		}
+		if pod.Status.Phase == apiv1.PodFailed {
+			return nil, fmt.Errorf("Pod %s of job %s is failed: %s", podName, j.Name, pod.Status.Reason)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ErrCanceled
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// streamLogs opens the log stream of the container in pod, it's retried when the container
+// isn't ready to read logs. It returns ErrCanceled when the job is canceled while retrying.
+func streamLogs(ctx context.Context, p corev1.PodInterface, podName, container string, since time.Time) (io.ReadCloser, error) {
+	options := &apiv1.PodLogOptions{
+		Container:  container,
+		Follow:     true,
+		Timestamps: false,
+	}
+	// The logs since the time are read with their timestamps, the lines logged are skipped.
+	if since.IsZero() == false {
+		sinceTime := metav1.NewTime(since.Truncate(time.Second))
+		options.SinceTime, options.Timestamps = &sinceTime, true
+	}
+
+	var err error
+	for i := 0; i < streamRetries; i++ {
+		req := p.GetLogs(podName, options)
+
+		var read io.ReadCloser
+		if read, err = req.Stream(); err == nil {
+			return read, nil
+		}
+		countKubeError("stream_logs", err)
+
+		select {
+		case <-ctx.Done():
+			return nil, ErrCanceled
+		case <-time.After(2 * time.Second):
+		}
+	}
+
+	return nil, err
+}
+
+// skipLogged removes the timestamp of the log line read with timestamps, and it's true when the
+// line is written before the time, which is logged before the daemon restarts.
+func skipLogged(line string, since time.Time) (string, bool) {
+	fields := strings.SplitN(line, " ", 2)
+	t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(fields[0]))
+	if err != nil {
+		return line, false
+	}
+
+	line = "\n"
+	if len(fields) == 2 {
+		line = fields[1]
+	}
+	return line, t.After(since) == false
+}
+
+// containerState returns the state of the container in pod.
+func containerState(pod *apiv1.Pod, container string) apiv1.ContainerState {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == container {
+			return status.State
+		}
+	}
+	return apiv1.ContainerState{}
+}
+
+// TimeoutPod deletes the Kubernetes Job and pod of the job which isn't finished in the timeout,
+// and fails the job.
+func (j *Job) TimeoutPod(cluster *Cluster, podName string, timeout time.Duration, verbose, timestamp bool) error {
+	jobName := podJobName(cluster, podName)
+	j.Log(fmt.Sprintf("Job %s isn't finished in %s, delete %s", j.Name, timeout.String(), jobName), verbose, timestamp)
+	if err := countKubeError("delete_job", cluster.Client().BatchV1().Jobs(cluster.Namespace).Delete(jobName, backgroundDeletion())); err != nil {
+		j.Log(fmt.Sprintf("Delete job %s error: %s", jobName, err.Error()), verbose, timestamp)
+	}
+
+	setStatus(&j.Status, Failure)
+	return fmt.Errorf("Job %s isn't finished in %s", j.Name, timeout.String())
+}
+
+// CancelPod deletes the Kubernetes Job and pod of a canceled job. The name is the pod name, or the
+// Job name when the pod isn't created yet.
+func (j *Job) CancelPod(cluster *Cluster, name string, verbose, timestamp bool) error {
+	j.Log(fmt.Sprintf("Job %s is canceled, delete %s", j.Name, name), verbose, timestamp)
+
+	jobName := podJobName(cluster, name)
+	if err := countKubeError("delete_job", cluster.Client().BatchV1().Jobs(cluster.Namespace).Delete(jobName, backgroundDeletion())); err != nil {
+		j.Log(fmt.Sprintf("Delete job %s error: %s", jobName, err.Error()), verbose, timestamp)
+	}
+
+	return ErrCanceled
+}
+
 func (j *Job) SaveDatabase(verbose, timestamp bool, f *Flow, stageIndex, actionIndex int) {
 	// Save Job into database
+	jobID, err := j.record(f.Stages[stageIndex].Actions[actionIndex].ID)
+	if err != nil {
+		j.Log(fmt.Sprintf("Save Job [%s] errorK: %s", j.Name, err.Error()), false, timestamp)
+	}
+	j.ID, j.run = jobID, f.dataID()
+}
+
+// record saves the job definition of the action, and returns its id.
+func (j *Job) record(actionID int64) (int64, error) {
 	job := new(model.JobV1)
 	resources, _ := j.Resources.JSON()
 	environments, _ := json.Marshal(j.Environments)
 	outputs, _ := json.Marshal(j.Outputs)
 	subscriptions, _ := json.Marshal(j.Subscriptions)
-	jobID, err := job.Put(f.Stages[stageIndex].Actions[actionIndex].ID, j.Timeout, j.Name, j.T, j.Endpoint, string(resources), string(environments), string(outputs), string(subscriptions))
-	if err != nil {
-		j.Log(fmt.Sprintf("Save Job [%s] errorK: %s", j.Name, err.Error()), false, timestamp)
+
+	return job.Put(actionID, j.Timeout, j.Name, j.T, j.ContainerImage(), string(resources), string(environments), string(outputs), string(subscriptions))
+}
+
+// SaveData records the result of job run started at start. The cached job is recorded as cached,
+// and the inputs is the hash of image and environments to find the flaky jobs.
+func (j *Job) SaveData(start time.Time, verbose, timestamp bool) {
+	result := j.Status
+	if j.Cached {
+		result = Cached
 	}
-	j.ID = jobID
 
-	// Record Job data
 	jobData := new(model.JobDataV1)
-	startTime := time.Now()
-
-	defer func() {
-		currentNumber, err := jobData.GetNumbers(j.ID)
-		if err != nil {
-			j.Log(fmt.Sprintf("Get Job Data [%s] Numbers error: %s", j.Name, err.Error()), verbose, timestamp)
-		}
-		if err := jobData.Put(j.ID, currentNumber+1, j.Status, startTime, time.Now()); err != nil {
-			j.Log(fmt.Sprintf("Save Job Data [%s] error: %s", j.Name, err.Error()), false, timestamp)
-		}
-	}()
+	currentNumber, err := jobData.GetNumbers(j.ID)
+	if err != nil {
+		j.Log(fmt.Sprintf("Get Job Data [%s] Numbers error: %s", j.Name, err.Error()), verbose, timestamp)
+	}
+	if err := jobData.Put(j.ID, currentNumber+1, j.run, result, j.inputs, start, time.Now()); err != nil {
+		j.Log(fmt.Sprintf("Save Job Data [%s] error: %s", j.Name, err.Error()), false, timestamp)
+	}
+	j.data = jobData.ID
 }
 
-func (j *Job) FetchOutputs(stageName, actionName, log string) error {
+func (j *Job) FetchOutputs(f *Flow, stageName, actionName, log string) error {
 	output := strings.TrimPrefix(log, "[COUT]")
 	splits := strings.Split(output, "=")
 	for _, o := range j.Outputs {
 		if strings.TrimSpace(o) == strings.TrimSpace(splits[0]) {
 			key := fmt.Sprintf("%s.%s.%s[%s]", stageName, actionName, j.Name, o)
-			RWlock.Lock()
-			GlobalOutputs[key] = strings.TrimSpace(splits[1])
-			RWlock.Unlock()
+			f.SetOutput(key, strings.TrimSpace(splits[1]))
 		}
 	}
 	return nil
 }
 
-func (j *Job) KubectlPodTemplates(randomContainerName, apiServer, namespace, yamlContent string, f *Flow) *apiv1.Pod {
+func (j *Job) KubectlPodTemplates(randomContainerName, apiServer, namespace, yamlContent string, f *Flow, outputs map[string]string) (*apiv1.Pod, error) {
 	result := &apiv1.Pod{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "Pod",
@@ -288,36 +657,40 @@ func (j *Job) KubectlPodTemplates(randomContainerName, apiServer, namespace, yam
 	result.Spec.Containers[0].Env = append(result.Spec.Containers[0].Env, apiv1.EnvVar{Name: "CO_DATA", Value: coDataValue})
 	result.Spec.Containers[0].Env = append(result.Spec.Containers[0].Env, apiv1.EnvVar{Name: "YAML", Value: yamlContent})
 
-	//Add user defined enviroments
-	if len(j.Environments) > 0 {
-		for _, environment := range j.Environments {
-			for k, v := range environment {
-				env := apiv1.EnvVar{
-					Name:  k,
-					Value: v,
-				}
-				result.Spec.Containers[0].Env = append(result.Spec.Containers[0].Env, env)
-			}
-		}
+	//Add user defined and flow enviroments
+	envs, err := j.EnvVars(f.Scope(j, outputs), f)
+	if err != nil {
+		return nil, err
 	}
+	result.Spec.Containers[0].Env = append(result.Spec.Containers[0].Env, envs...)
 
-	//Add flow enviroments
-	if len(f.Environments) > 0 {
-		for _, environment := range f.Environments {
-			for k, v := range environment {
-				env := apiv1.EnvVar{
-					Name:  k,
-					Value: v,
-				}
-				result.Spec.Containers[0].Env = append(result.Spec.Containers[0].Env, env)
-			}
-		}
+	return result, nil
+}
+
+// PodTemplates returns the pod of job, the expressions and subscriptions are resolved from
+// the outputs. The unresolved references are returned as error.
+func (j *Job) PodTemplates(randomContainerName string, f *Flow, outputs map[string]string) (*apiv1.Pod, error) {
+	scope := f.Scope(j, outputs)
+
+	field := "endpoint"
+	if j.T == ScriptJob {
+		field = "image"
+	}
+	endpoint, err := scope.Interpolate(j.ContainerImage())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", field, err.Error())
 	}
 
-	return result
-}
+	// The recorded flows of rerun and recovery could have invalid quantities.
+	cpu, err := resource.ParseQuantity(j.Resources.CPU)
+	if err != nil {
+		return nil, fmt.Errorf("resources.cpu: invalid cpu quantity %q", j.Resources.CPU)
+	}
+	memory, err := resource.ParseQuantity(j.Resources.Memory)
+	if err != nil {
+		return nil, fmt.Errorf("resources.memory: invalid memory quantity %q", j.Resources.Memory)
+	}
 
-func (j *Job) PodTemplates(randomContainerName string, f *Flow) *apiv1.Pod {
 	result := &apiv1.Pod{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "Pod",
@@ -330,11 +703,11 @@ func (j *Job) PodTemplates(randomContainerName string, f *Flow) *apiv1.Pod {
 			Containers: []apiv1.Container{
 				{
 					Name:  randomContainerName,
-					Image: j.Endpoint,
+					Image: endpoint,
 					Resources: apiv1.ResourceRequirements{
 						Requests: apiv1.ResourceList{
-							apiv1.ResourceCPU:    resource.MustParse(j.Resources.CPU),
-							apiv1.ResourceMemory: resource.MustParse(j.Resources.Memory),
+							apiv1.ResourceCPU:    cpu,
+							apiv1.ResourceMemory: memory,
 						},
 					},
 				},
@@ -342,46 +715,97 @@ func (j *Job) PodTemplates(randomContainerName string, f *Flow) *apiv1.Pod {
 			RestartPolicy: apiv1.RestartPolicyNever,
 		},
 	}
-	//Add user defined enviroments
-	if len(j.Environments) > 0 {
-		for _, environment := range j.Environments {
-			for k, v := range environment {
-				env := apiv1.EnvVar{
-					Name:  k,
-					Value: v,
-				}
-				result.Spec.Containers[0].Env = append(result.Spec.Containers[0].Env, env)
-			}
+
+	//Run the inline script of script job instead of the image entrypoint
+	if j.T == ScriptJob {
+		script, err := scope.Interpolate(j.Script)
+		if err != nil {
+			return nil, fmt.Errorf("run: %s", err.Error())
 		}
+		result.Spec.Containers[0].Command = []string{ScriptShell, "-e", "-c", script}
+	}
+
+	//Add user defined and flow enviroments
+	envs, err := j.EnvVars(scope, f)
+	if err != nil {
+		return nil, err
 	}
-	//Add flow enviroments
-	if len(f.Environments) > 0 {
-		for _, environment := range f.Environments {
-			for k, v := range environment {
+	result.Spec.Containers[0].Env = append(result.Spec.Containers[0].Env, envs...)
+
+	//Add user defined subscrptions
+	if len(j.Subscriptions) > 0 {
+		for _, subscription := range j.Subscriptions {
+			for k, env_key := range subscription {
+				env_value, ok := outputs[k]
+				if ok == false {
+					return nil, fmt.Errorf("subscription %s: unresolved output %q", env_key, k)
+				}
 				env := apiv1.EnvVar{
-					Name:  k,
-					Value: v,
+					Name:  env_key,
+					Value: env_value,
 				}
 				result.Spec.Containers[0].Env = append(result.Spec.Containers[0].Env, env)
 			}
 		}
 	}
 
-	//Add user defined subscrptions
-	if len(j.Subscriptions) > 0 {
-		for _, subscription := range j.Subscriptions {
-			for k, env_key := range subscription {
-				if env_value, ok := GlobalOutputs[k]; ok {
-					env := apiv1.EnvVar{
-						Name:  env_key,
-						Value: env_value,
-					}
-					result.Spec.Containers[0].Env = append(result.Spec.Containers[0].Env, env)
+	//Start the services before the job container
+	if len(j.Services) > 0 {
+		services, err := j.ServiceContainers(scope)
+		if err != nil {
+			return nil, err
+		}
+		result.Spec.Containers = append(services, result.Spec.Containers...)
+	}
+	return result, nil
+}
+
+// ContainerImage returns the image of job, the image of script job or the component endpoint.
+func (j *Job) ContainerImage() string {
+	if j.T == ScriptJob {
+		return j.Image
+	}
+	return j.Endpoint
+}
+
+// podJobName returns the name of Kubernetes Job creating the pod, it's the name when the pod
+// isn't found.
+func podJobName(cluster *Cluster, name string) string {
+	if pod, err := cluster.Client().CoreV1().Pods(cluster.Namespace).Get(name, metav1.GetOptions{}); err == nil && pod.Labels["job-name"] != "" {
+		return pod.Labels["job-name"]
+	}
+	return name
+}
+
+// EnvVars returns the flow environments and the job environments with the expressions resolved.
+// The job environment overrides the flow environment of the same name, like the env of Scope.
+func (j *Job) EnvVars(scope *Scope, f *Flow) ([]apiv1.EnvVar, error) {
+	envs, indexes := []apiv1.EnvVar{}, map[string]int{}
+	for _, environments := range [][]map[string]string{f.Environments, j.Environments} {
+		resolved, err := scope.interpolateEnvironments(environments)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, environment := range resolved {
+			keys := []string{}
+			for k, _ := range environment {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+
+			for _, k := range keys {
+				if i, ok := indexes[k]; ok {
+					envs[i].Value = environment[k]
+					continue
 				}
+				indexes[k] = len(envs)
+				envs = append(envs, apiv1.EnvVar{Name: k, Value: environment[k]})
 			}
 		}
 	}
-	return result
+
+	return envs, nil
 }
 
 func (r *Resource) JSON() ([]byte, error) {
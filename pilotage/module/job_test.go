@@ -0,0 +1,166 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+)
+
+func TestSkipLogged(t *testing.T) {
+	since := time.Date(2018, 1, 2, 3, 4, 5, 500000000, time.UTC)
+
+	tests := []struct {
+		name   string
+		line   string
+		want   string
+		logged bool
+	}{
+		{"before", "2018-01-02T03:04:05.100000000Z build started\n", "build started\n", true},
+		{"same time", "2018-01-02T03:04:05.500000000Z build step 1\n", "build step 1\n", true},
+		{"after", "2018-01-02T03:04:05.600000000Z build step 2\n", "build step 2\n", false},
+		{"empty line", "2018-01-02T03:04:06Z \n", "\n", false},
+		{"no timestamp", "build finished\n", "build finished\n", false},
+	}
+
+	for _, test := range tests {
+		line, logged := skipLogged(test.line, since)
+		if line != test.want || logged != test.logged {
+			t.Errorf("%s: skipLogged(%q) = %q, %t, want %q, %t", test.name, test.line, line, logged, test.want, test.logged)
+		}
+	}
+}
+
+func TestSnapshotWithParallelStatus(t *testing.T) {
+	f := &Flow{
+		URI: "cncf/demo/hello",
+		Stages: []Stage{
+			{T: NormalStage, Name: "test", Sequencing: Parallel, Actions: []Action{
+				{Name: "unit", Jobs: []Job{{Name: "go-test"}}},
+				{Name: "lint", Jobs: []Job{{Name: "go-vet"}}},
+			}},
+		},
+	}
+
+	var wg sync.WaitGroup
+	for i, _ := range f.Stages[0].Actions {
+		wg.Add(1)
+		go func(action *Action) {
+			defer wg.Done()
+			for _, status := range []string{Running, Success} {
+				setStatus(&action.Status, status)
+				setStatus(&action.Jobs[0].Status, status)
+				if _, err := f.Snapshot(); err != nil {
+					t.Errorf("Snapshot error: %s", err.Error())
+				}
+			}
+		}(&f.Stages[0].Actions[i])
+	}
+	wg.Wait()
+
+	content, err := f.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot error: %s", err.Error())
+	}
+	snapshot := new(Flow)
+	if err := json.Unmarshal(content, snapshot); err != nil {
+		t.Fatalf("Unmarshal snapshot error: %s", err.Error())
+	}
+	for _, action := range snapshot.Stages[0].Actions {
+		if action.Status != Success || action.Jobs[0].Status != Success {
+			t.Errorf("Action [%s] is %s with job %s in snapshot, want %s", action.Name, action.Status, action.Jobs[0].Status, Success)
+		}
+	}
+}
+
+// The recorded flows of rerun and recovery aren't parsed from YAML, an invalid quantity in
+// them fails the job instead of the daemon.
+func TestPodTemplatesInvalidResources(t *testing.T) {
+	f := &Flow{URI: "cncf/demo/hello"}
+
+	job := &Job{T: ComponentJob, Name: "go-build", Endpoint: "hub.opshub.sh/containerops/go-build:latest",
+		Resources: Resource{CPU: "2", Memory: "two gigabytes"}}
+	if _, err := job.PodTemplates("go-build", f, map[string]string{}); err == nil || strings.Contains(err.Error(), "resources.memory") == false {
+		t.Errorf("Invalid memory quantity error = %v", err)
+	}
+
+	job.Resources = Resource{CPU: "2", Memory: "4Gi"}
+	job.Services = []Service{{Name: "mysql", Image: "mysql:5.7", Resources: Resource{CPU: "half"}}}
+	if _, err := job.PodTemplates("go-build", f, map[string]string{}); err == nil || strings.Contains(err.Error(), "service mysql") == false {
+		t.Errorf("Invalid service cpu quantity error = %v", err)
+	}
+
+	job.Services = nil
+	if pod, err := job.PodTemplates("go-build", f, map[string]string{}); err != nil {
+		t.Errorf("Pod templates error: %s", err.Error())
+	} else if memory := pod.Spec.Containers[0].Resources.Requests[apiv1.ResourceMemory]; memory.String() != "4Gi" {
+		t.Errorf("Memory request is %s, want 4Gi", memory.String())
+	}
+}
+
+// The job environment overrides the flow environment in both the expressions and the pod.
+func TestPodEnvPrecedence(t *testing.T) {
+	f := &Flow{URI: "cncf/demo/hello", Environments: []map[string]string{{"STAGE": "flow", "REGION": "us"}}}
+	job := &Job{T: ComponentJob, Name: "go-build", Endpoint: "hub.opshub.sh/containerops/go-build:latest",
+		Resources:    Resource{CPU: "1", Memory: "1Gi"},
+		Environments: []map[string]string{{"STAGE": "job", "ECHO": "${{ env.STAGE }}"}}}
+
+	pod, err := job.PodTemplates("go-build", f, map[string]string{})
+	if err != nil {
+		t.Fatalf("Pod templates error: %s", err.Error())
+	}
+
+	env := map[string][]string{}
+	for _, e := range pod.Spec.Containers[0].Env {
+		env[e.Name] = append(env[e.Name], e.Value)
+	}
+
+	expected := map[string]string{"STAGE": "job", "REGION": "us", "ECHO": "job"}
+	for name, value := range expected {
+		if len(env[name]) != 1 || env[name][0] != value {
+			t.Errorf("Pod env %s = %v, want [%s]", name, env[name], value)
+		}
+	}
+	if scope := f.Scope(job, nil); scope.Env["STAGE"] != "job" {
+		t.Errorf("Scope env STAGE = %s, want job", scope.Env["STAGE"])
+	}
+}
+
+// The recovered job finds its Kubernetes Job by the selector, which matches only the labels of its own Job.
+func TestJobSelector(t *testing.T) {
+	f := &Flow{URI: "cncf/demo/hello", Model: DaemonStart, runID: "42", Stages: []Stage{
+		{Name: "build", Actions: []Action{{Name: "compile", Jobs: []Job{{Name: "go-build"}, {Name: "go-test"}}}}},
+	}}
+	action := &f.Stages[0].Actions[0]
+
+	labels := action.Jobs[0].JobTemplates(&apiv1.Pod{}, f, 0, 0).Labels
+	for _, selector := range strings.Split(action.Jobs[0].Selector(f, 0, 0), ",") {
+		pair := strings.SplitN(selector, "=", 2)
+		if len(pair) != 2 || labels[pair[0]] != pair[1] {
+			t.Errorf("Selector %s doesn't match the Job labels %v", selector, labels)
+		}
+	}
+
+	if action.Jobs[0].Selector(f, 0, 0) == action.Jobs[1].Selector(f, 0, 0) {
+		t.Errorf("The jobs of an action have the same selector")
+	}
+}
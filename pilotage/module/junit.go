@@ -0,0 +1,343 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Huawei/containerops/pilotage/model"
+)
+
+const (
+	// Test case results
+	TestPassed  = "passed"
+	TestFailed  = "failed"
+	TestError   = "error"
+	TestSkipped = "skipped"
+
+	// MaxJUnitSize is the max bytes of a JUnit report.
+	MaxJUnitSize = 32 << 20
+	// maxTestMessage is the max characters of the failure message of a test case.
+	maxTestMessage = 4096
+)
+
+var (
+	// reportClient downloads the JUnit reports published as artifacts.
+	reportClient = &http.Client{Timeout: 60 * time.Second}
+)
+
+// TestCase is the result of a test case in a JUnit report, the Duration is in seconds.
+type TestCase struct {
+	Job      string  `json:"job"`
+	Suite    string  `json:"suite,omitempty"`
+	Class    string  `json:"class,omitempty"`
+	Name     string  `json:"name"`
+	Result   string  `json:"result"`
+	Duration float64 `json:"duration"`
+	Message  string  `json:"message,omitempty"`
+}
+
+// TestSummary counts the test cases of a job or a run, Failed are the failed and error cases
+// as class.name.
+type TestSummary struct {
+	Total    int      `json:"total" yaml:"total"`
+	Failures int      `json:"failures" yaml:"failures"`
+	Errors   int      `json:"errors" yaml:"errors"`
+	Skipped  int      `json:"skipped" yaml:"skipped"`
+	Failed   []string `json:"failed,omitempty" yaml:"failed,omitempty"`
+}
+
+// RunTests is the test results of a flow run. The NewFailures failed in the run, and didn't
+// fail in the previous run with test results.
+type RunTests struct {
+	Summary     TestSummary `json:"summary"`
+	NewFailures []TestCase  `json:"new_failures"`
+	Cases       []TestCase  `json:"cases"`
+}
+
+type junitSuite struct {
+	Name   string       `xml:"name,attr"`
+	Suites []junitSuite `xml:"testsuite"`
+	Cases  []junitCase  `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name    string       `xml:"name,attr"`
+	Class   string       `xml:"classname,attr"`
+	Time    string       `xml:"time,attr"`
+	Failure *junitResult `xml:"failure"`
+	Error   *junitResult `xml:"error"`
+	Skipped *junitResult `xml:"skipped"`
+}
+
+type junitResult struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// ParseJUnit returns the test cases of a JUnit XML report, the root is testsuites or testsuite.
+func ParseJUnit(data []byte) ([]TestCase, error) {
+	root := junitSuite{}
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("Parse JUnit report error: %s", err.Error())
+	}
+
+	cases := []TestCase{}
+	root.walk(&cases)
+	return cases, nil
+}
+
+func (s *junitSuite) walk(cases *[]TestCase) {
+	for _, c := range s.Cases {
+		tc := TestCase{Suite: s.Name, Class: c.Class, Name: c.Name, Result: TestPassed}
+		if duration, err := strconv.ParseFloat(strings.Replace(c.Time, ",", "", -1), 64); err == nil {
+			tc.Duration = duration
+		}
+
+		switch {
+		case c.Failure != nil:
+			tc.Result, tc.Message = TestFailed, c.Failure.message()
+		case c.Error != nil:
+			tc.Result, tc.Message = TestError, c.Error.message()
+		case c.Skipped != nil:
+			tc.Result, tc.Message = TestSkipped, c.Skipped.message()
+		}
+
+		*cases = append(*cases, tc)
+	}
+
+	for i, _ := range s.Suites {
+		s.Suites[i].walk(cases)
+	}
+}
+
+func (r *junitResult) message() string {
+	message := strings.TrimSpace(r.Message)
+	if message == "" {
+		message = strings.TrimSpace(r.Text)
+	}
+
+	if len(message) > maxTestMessage {
+		message = message[:maxTestMessage]
+	}
+	return message
+}
+
+// Summarize counts the test cases.
+func Summarize(cases []TestCase) TestSummary {
+	summary := TestSummary{Total: len(cases)}
+	for _, c := range cases {
+		switch c.Result {
+		case TestFailed:
+			summary.Failures++
+		case TestError:
+			summary.Errors++
+		case TestSkipped:
+			summary.Skipped++
+		}
+
+		if c.Result == TestFailed || c.Result == TestError {
+			summary.Failed = append(summary.Failed, c.FullName())
+		}
+	}
+
+	return summary
+}
+
+// FullName returns the class.name of test case.
+func (c *TestCase) FullName() string {
+	if c.Class == "" {
+		return c.Name
+	}
+	return fmt.Sprintf("%s.%s", c.Class, c.Name)
+}
+
+// ReadReport returns the JUnit report published by the output, it's the URL of the report
+// artifact or the base64 encoded report.
+func ReadReport(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+
+	if strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://") {
+		resp, err := reportClient.Get(value)
+		if err != nil {
+			return nil, fmt.Errorf("Download JUnit report error: %s", err.Error())
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("Download JUnit report error: %s", resp.Status)
+		}
+
+		data, err := ioutil.ReadAll(io.LimitReader(resp.Body, MaxJUnitSize+1))
+		if err != nil {
+			return nil, fmt.Errorf("Download JUnit report error: %s", err.Error())
+		}
+		if len(data) > MaxJUnitSize {
+			return nil, fmt.Errorf("JUnit report exceeds %d bytes", MaxJUnitSize)
+		}
+		return data, nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("JUnit report should be an URL or base64 encoded: %s", err.Error())
+	}
+	return data, nil
+}
+
+// SaveTests reads the JUnit report published by the job, and records its test cases with the
+// job run. It's called after the job run is recorded.
+func (j *Job) SaveTests(f *Flow, stageIndex, actionIndex int, verbose, timestamp bool) {
+	name := fmt.Sprintf("%s.%s.%s", f.Stages[stageIndex].Name, f.Stages[stageIndex].Actions[actionIndex].Name, j.Name)
+
+	value, ok := f.GetOutputs()[fmt.Sprintf("%s[%s]", name, j.JUnit)]
+	if ok == false || strings.TrimSpace(value) == "" {
+		j.Log(fmt.Sprintf("Job [%s] doesn't publish the JUnit report %s", j.Name, j.JUnit), verbose, timestamp)
+		return
+	}
+
+	data, err := ReadReport(value)
+	if err != nil {
+		j.Log(fmt.Sprintf("Read the JUnit report of job [%s] error: %s", j.Name, err.Error()), verbose, timestamp)
+		return
+	}
+
+	cases, err := ParseJUnit(data)
+	if err != nil {
+		j.Log(fmt.Sprintf("Read the JUnit report of job [%s] error: %s", j.Name, err.Error()), verbose, timestamp)
+		return
+	}
+
+	summary := Summarize(cases)
+	logsLock.Lock()
+	j.Tests = &summary
+	logsLock.Unlock()
+	j.Log(fmt.Sprintf("Job [%s] tests: %d total, %d failures, %d errors, %d skipped",
+		j.Name, summary.Total, summary.Failures, summary.Errors, summary.Skipped), verbose, timestamp)
+
+	records := []model.TestCaseV1{}
+	for _, c := range cases {
+		records = append(records, model.TestCaseV1{FlowID: f.ID, RunID: f.dataID(), JobDataID: j.data, Job: name,
+			Suite: c.Suite, Class: c.Class, Name: c.Name, Result: c.Result, Duration: c.Duration, Message: c.Message, CreatedAt: time.Now()})
+	}
+	if err := model.CreateTestCases(records); err != nil {
+		j.Log(fmt.Sprintf("Save the test cases of job [%s] error: %s", j.Name, err.Error()), verbose, timestamp)
+	}
+}
+
+// TestSummary counts the test cases of all jobs in the flow run.
+func (f *Flow) TestSummary() TestSummary {
+	logsLock.RLock()
+	defer logsLock.RUnlock()
+
+	summary := TestSummary{}
+	for _, stage := range f.Stages {
+		for _, action := range stage.Actions {
+			for _, job := range action.Jobs {
+				if job.Tests == nil {
+					continue
+				}
+
+				summary.Total += job.Tests.Total
+				summary.Failures += job.Tests.Failures
+				summary.Errors += job.Tests.Errors
+				summary.Skipped += job.Tests.Skipped
+				summary.Failed = append(summary.Failed, job.Tests.Failed...)
+			}
+		}
+	}
+
+	return summary
+}
+
+// LoadRunTests returns the recorded test results of a flow run, the new failures are found by
+// the previous run of the flow with test results.
+func LoadRunTests(flowID, runID int64) (*RunTests, error) {
+	t := new(model.TestCaseV1)
+
+	records, err := t.ListByRun(runID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RunTests{Cases: testCases(records)}
+	result.Summary = Summarize(result.Cases)
+
+	previous, err := t.PreviousRun(flowID, runID)
+	if err != nil {
+		return nil, err
+	}
+
+	last := []TestCase{}
+	if previous > 0 {
+		records, err := t.ListByRun(previous)
+		if err != nil {
+			return nil, err
+		}
+		last = testCases(records)
+	}
+	result.NewFailures = newFailures(result.Cases, last)
+
+	return result, nil
+}
+
+// newFailures returns the failed and error cases, which didn't fail in the previous run.
+func newFailures(cases, previous []TestCase) []TestCase {
+	failed := map[string]bool{}
+	for _, c := range previous {
+		if c.Result == TestFailed || c.Result == TestError {
+			failed[c.Job+"/"+c.FullName()] = true
+		}
+	}
+
+	result := []TestCase{}
+	for _, c := range cases {
+		if (c.Result == TestFailed || c.Result == TestError) && failed[c.Job+"/"+c.FullName()] == false {
+			result = append(result, c)
+		}
+	}
+
+	return result
+}
+
+// TestHistory returns the latest results of the test cases of a flow, newest first.
+func TestHistory(namespace, repository, name, tag, job, class, test string, limit int) ([]model.TestCaseV1, error) {
+	flow := new(model.FlowV1)
+	if err := flow.GetWithDeleted(namespace, repository, name, tag); err != nil {
+		return nil, err
+	}
+
+	return new(model.TestCaseV1).History(flow.ID, job, class, test, limit)
+}
+
+func testCases(records []model.TestCaseV1) []TestCase {
+	cases := []TestCase{}
+	for _, r := range records {
+		cases = append(cases, TestCase{Job: r.Job, Suite: r.Suite, Class: r.Class, Name: r.Name,
+			Result: r.Result, Duration: r.Duration, Message: r.Message})
+	}
+
+	return cases
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Huawei/containerops/pilotage/model"
+)
+
+const junitReport = `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="hello">
+    <testcase classname="hello.Greeter" name="TestHello" time="0.012"/>
+    <testcase classname="hello.Greeter" name="TestWorld" time="1,200.5">
+      <failure message="want world">greeter_test.go:12: got hello</failure>
+    </testcase>
+    <testsuite name="hello/internal">
+      <testcase classname="internal" name="TestPanic">
+        <error>panic: runtime error</error>
+      </testcase>
+      <testcase name="TestSlow" time="slow">
+        <skipped message="  short mode  "/>
+      </testcase>
+    </testsuite>
+  </testsuite>
+</testsuites>`
+
+func TestParseJUnit(t *testing.T) {
+	tests := []struct {
+		name   string
+		report string
+		cases  []TestCase
+		errors bool
+	}{
+		{"nested suites", junitReport, []TestCase{
+			{Suite: "hello", Class: "hello.Greeter", Name: "TestHello", Result: TestPassed, Duration: 0.012},
+			{Suite: "hello", Class: "hello.Greeter", Name: "TestWorld", Result: TestFailed, Duration: 1200.5, Message: "want world"},
+			{Suite: "hello/internal", Class: "internal", Name: "TestPanic", Result: TestError, Message: "panic: runtime error"},
+			{Suite: "hello/internal", Name: "TestSlow", Result: TestSkipped, Message: "short mode"},
+		}, false},
+		{"single suite", `<testsuite name="unit"><testcase classname="a" name="b" time="2"/></testsuite>`, []TestCase{
+			{Suite: "unit", Class: "a", Name: "b", Result: TestPassed, Duration: 2},
+		}, false},
+		{"no cases", `<testsuites></testsuites>`, []TestCase{}, false},
+		{"invalid", `<testsuites><testsuite>`, nil, true},
+		{"not xml", `PASS`, nil, true},
+	}
+
+	for _, test := range tests {
+		cases, err := ParseJUnit([]byte(test.report))
+		if (err != nil) != test.errors {
+			t.Errorf("%s: ParseJUnit() error = %v, want error %t", test.name, err, test.errors)
+		} else if err == nil && reflect.DeepEqual(cases, test.cases) == false {
+			t.Errorf("%s: ParseJUnit() = %+v, want %+v", test.name, cases, test.cases)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		name  string
+		cases []TestCase
+		want  TestSummary
+	}{
+		{"no cases", []TestCase{}, TestSummary{}},
+		{"all passed", []TestCase{{Name: "a", Result: TestPassed}, {Name: "b", Result: TestPassed}}, TestSummary{Total: 2}},
+		{"mixed", []TestCase{
+			{Class: "hello", Name: "TestHello", Result: TestPassed},
+			{Class: "hello", Name: "TestWorld", Result: TestFailed},
+			{Name: "TestPanic", Result: TestError},
+			{Name: "TestSlow", Result: TestSkipped},
+		}, TestSummary{Total: 4, Failures: 1, Errors: 1, Skipped: 1, Failed: []string{"hello.TestWorld", "TestPanic"}}},
+	}
+
+	for _, test := range tests {
+		if got := Summarize(test.cases); reflect.DeepEqual(got, test.want) == false {
+			t.Errorf("%s: Summarize() = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestReadReport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/report.xml" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(junitReport))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		value  string
+		errors bool
+	}{
+		{server.URL + "/report.xml", false},
+		{" " + base64.StdEncoding.EncodeToString([]byte(junitReport)) + "\n", false},
+		{server.URL + "/missing.xml", true},
+		{"<testsuites/>", true},
+	}
+
+	for _, test := range tests {
+		data, err := ReadReport(test.value)
+		if (err != nil) != test.errors {
+			t.Errorf("ReadReport(%q) error = %v, want error %t", test.value, err, test.errors)
+		} else if err == nil && string(data) != junitReport {
+			t.Errorf("ReadReport(%q) = %q, want the report", test.value, string(data))
+		}
+	}
+}
+
+// The unit tests publish the report as an artifact and the e2e tests inline, the lint doesn't
+// publish its report. The next run fixes a test and breaks another one.
+func TestRunTestResults(t *testing.T) {
+	model.DisableDB = true
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(junitReport))
+	}))
+	defer server.Close()
+
+	e2e := `<testsuite name="e2e"><testcase classname="e2e" name="TestDeploy" time="30"/></testsuite>`
+	f := &Flow{URI: "cncf/demo/hello", Tag: "latest", Stages: []Stage{
+		{Name: "test", Actions: []Action{{Name: "go", Jobs: []Job{
+			{Name: "unit", JUnit: "CO_JUNIT"},
+			{Name: "e2e", JUnit: "CO_JUNIT"},
+			{Name: "lint", JUnit: "CO_JUNIT"},
+		}}}},
+	}}
+	f.SetOutput("test.go.unit[CO_JUNIT]", server.URL+"/unit.xml")
+	f.SetOutput("test.go.e2e[CO_JUNIT]", base64.StdEncoding.EncodeToString([]byte(e2e)))
+
+	jobs := f.Stages[0].Actions[0].Jobs
+	for i, _ := range jobs {
+		jobs[i].SaveTests(f, 0, 0, false, false)
+	}
+
+	if unit := jobs[0].Tests; unit == nil || unit.Total != 4 || unit.Failures != 1 || unit.Errors != 1 || unit.Skipped != 1 {
+		t.Errorf("The unit tests are %+v, want 4 total, 1 failure, 1 error and 1 skipped", unit)
+	}
+	if e2e := jobs[1].Tests; e2e == nil || e2e.Total != 1 || len(e2e.Failed) != 0 {
+		t.Errorf("The e2e tests are %+v, want 1 passed", e2e)
+	}
+	if jobs[2].Tests != nil || strings.Contains(strings.Join(jobs[2].Logs, "\n"), "doesn't publish the JUnit report CO_JUNIT") == false {
+		t.Errorf("The lint without a report has the tests %+v and the logs %v", jobs[2].Tests, jobs[2].Logs)
+	}
+
+	summary := f.TestSummary()
+	if summary.Total != 5 || summary.Failures != 1 || summary.Errors != 1 ||
+		strings.Join(summary.Failed, ",") != "hello.Greeter.TestWorld,internal.TestPanic" {
+		t.Errorf("The run tests are %+v", summary)
+	}
+
+	// The next run fixes TestWorld, TestPanic still errors and TestDeploy fails. The same test
+	// name in another job is another test.
+	previous, _ := ParseJUnit([]byte(junitReport))
+	for i, _ := range previous {
+		previous[i].Job = "test.go.unit"
+	}
+	cases := []TestCase{
+		{Job: "test.go.unit", Class: "hello.Greeter", Name: "TestWorld", Result: TestPassed},
+		{Job: "test.go.unit", Class: "internal", Name: "TestPanic", Result: TestError},
+		{Job: "test.go.e2e", Class: "e2e", Name: "TestDeploy", Result: TestFailed},
+		{Job: "test.go.e2e", Class: "internal", Name: "TestPanic", Result: TestFailed},
+	}
+
+	names := []string{}
+	for _, c := range newFailures(cases, previous) {
+		names = append(names, c.Job+"/"+c.FullName())
+	}
+	if strings.Join(names, ",") != "test.go.e2e/e2e.TestDeploy,test.go.e2e/internal.TestPanic" {
+		t.Errorf("The new failures are %v", names)
+	}
+	if len(newFailures(cases, nil)) != 3 {
+		t.Errorf("All failures of the first run with tests should be new")
+	}
+}
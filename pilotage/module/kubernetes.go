@@ -0,0 +1,447 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/logrusorgru/aurora"
+	batchv1 "k8s.io/api/batch/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	"github.com/Huawei/containerops/common/utils"
+	"github.com/Huawei/containerops/pilotage/config"
+)
+
+const (
+	// Labels of the Kubernetes resources created by flow runs.
+	LabelManagedBy = "containerops.io/managed-by"
+	LabelMode      = "containerops.io/mode"
+	LabelInstance  = "containerops.io/instance"
+	LabelFlow      = "containerops.io/flow"
+	LabelRun       = "containerops.io/run"
+	LabelStage     = "containerops.io/stage"
+	LabelAction    = "containerops.io/action"
+	LabelJob       = "containerops.io/job"
+
+	// Annotations of the run owner after the run finished.
+	AnnotationFinished = "containerops.io/finished"
+	AnnotationResult   = "containerops.io/result"
+
+	// ManagedBy is the value of managed-by label.
+	ManagedBy = "pilotage"
+
+	// DefaultSweepInterval is the seconds between two sweeps when the gc config is empty.
+	DefaultSweepInterval = 300
+)
+
+var invalidLabelChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// LabelValue converts a name into a valid label value.
+func LabelValue(name string) string {
+	value := invalidLabelChars.ReplaceAllString(name, ".")
+	if len(value) > 63 {
+		value = value[:63]
+	}
+
+	return strings.Trim(value, "_.-")
+}
+
+// KubeClient returns the Kubernetes client of the default cluster.
+func KubeClient() (kubernetes.Interface, error) {
+	return ClusterClient(DefaultCluster)
+}
+
+// PodClient returns the pods client of the namespace of cluster.
+func PodClient(cluster string) (corev1.PodInterface, error) {
+	c, err := GetCluster(cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.client.CoreV1().Pods(c.Namespace), nil
+}
+
+// ownerClient returns the ConfigMaps client of the namespace of cluster, the run owners are in
+// the namespace of the Jobs they own.
+func ownerClient(cluster string) (corev1.ConfigMapInterface, error) {
+	c, err := GetCluster(cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.client.CoreV1().ConfigMaps(c.Namespace), nil
+}
+
+// RunID is the identity of flow run in the labels. It's the id of run data when the database is
+// enabled, otherwise a random string.
+func (f *Flow) RunID() string {
+	if f.data != nil && f.data.ID > 0 {
+		return strconv.FormatInt(f.data.ID, 10)
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.runID == "" {
+		f.runID = strings.ToLower(utils.RandomString(16))
+	}
+	return f.runID
+}
+
+// Labels returns the labels of the run resources.
+func (f *Flow) Labels() map[string]string {
+	return map[string]string{
+		LabelManagedBy: ManagedBy,
+		LabelMode:      LabelValue(f.Model),
+		LabelInstance:  LabelValue(Instance()),
+		LabelFlow:      LabelValue(f.URI),
+		LabelRun:       f.RunID(),
+	}
+}
+
+// ownerName is the name of ConfigMap owns all Kubernetes Jobs of the run.
+func (f *Flow) ownerName() string {
+	return fmt.Sprintf("pilotage-run-%s", f.RunID())
+}
+
+// InitOwner gets or creates the ConfigMaps own the Kubernetes Jobs of the run in the clusters of
+// its jobs, deleting the owners deletes all the Jobs and pods of the run by the garbage collector.
+func (f *Flow) InitOwner() error {
+	var result error
+	for _, cluster := range f.Clusters() {
+		if err := f.initOwner(cluster); err != nil {
+			result = fmt.Errorf("Cluster %s: %s", cluster, err.Error())
+		}
+	}
+
+	return result
+}
+
+// owner returns the run owner in the cluster, it's nil before the owner is created.
+func (f *Flow) owner(cluster string) *apiv1.ConfigMap {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	return f.owners[cluster]
+}
+
+func (f *Flow) setOwner(cluster string, owner *apiv1.ConfigMap) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.owners == nil {
+		f.owners = map[string]*apiv1.ConfigMap{}
+	}
+	f.owners[cluster] = owner
+}
+
+func (f *Flow) initOwner(cluster string) error {
+	c, err := ownerClient(cluster)
+	if err != nil {
+		return err
+	}
+
+	if owner, err := c.Get(f.ownerName(), metav1.GetOptions{}); err == nil {
+		f.setOwner(cluster, owner)
+		return nil
+	}
+
+	owner, err := c.Create(&apiv1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ConfigMap",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   f.ownerName(),
+			Labels: f.Labels(),
+		},
+		Data: map[string]string{"uri": f.URI, "tag": f.Tag},
+	})
+	if err != nil {
+		return countKubeError("create_configmap", err)
+	}
+
+	f.setOwner(cluster, owner)
+	return nil
+}
+
+// ReleaseOwner marks the run owners finished. When the retention of result is 0, the owners are
+// deleted with all the Jobs and pods of the run, otherwise the sweeper deletes them later.
+func (f *Flow) ReleaseOwner(result string) error {
+	var err error
+	for _, cluster := range f.Clusters() {
+		if owner := f.owner(cluster); owner != nil {
+			if e := releaseOwner(cluster, owner, result); e != nil {
+				err = fmt.Errorf("Cluster %s: %s", cluster, e.Error())
+			}
+		}
+	}
+
+	return err
+}
+
+func releaseOwner(cluster string, owner *apiv1.ConfigMap, result string) error {
+	c, err := ownerClient(cluster)
+	if err != nil {
+		return err
+	}
+
+	if retention(result) == 0 {
+		return countKubeError("delete_configmap", c.Delete(owner.Name, backgroundDeletion()))
+	}
+
+	if owner.Annotations == nil {
+		owner.Annotations = map[string]string{}
+	}
+	owner.Annotations[AnnotationFinished] = time.Now().Format(time.RFC3339)
+	owner.Annotations[AnnotationResult] = result
+
+	_, err = c.Update(owner)
+	return countKubeError("update_configmap", err)
+}
+
+// DeleteOwner deletes the run owners with all the Jobs and pods of the run.
+func (f *Flow) DeleteOwner() error {
+	var err error
+	for _, cluster := range f.Clusters() {
+		c, e := ownerClient(cluster)
+		if e == nil {
+			e = countKubeError("delete_configmap", c.Delete(f.ownerName(), backgroundDeletion()))
+		}
+		if e != nil {
+			err = fmt.Errorf("Cluster %s: %s", cluster, e.Error())
+		}
+	}
+
+	return err
+}
+
+// Labels returns the labels of the Kubernetes Job of job, the run labels with its stage, action
+// and job.
+func (j *Job) Labels(f *Flow, stageIndex, actionIndex int) map[string]string {
+	stage, action := &f.Stages[stageIndex], &f.Stages[stageIndex].Actions[actionIndex]
+
+	labels := f.Labels()
+	labels[LabelStage], labels[LabelAction], labels[LabelJob] = LabelValue(stage.Name), LabelValue(action.Name), LabelValue(j.Name)
+	return labels
+}
+
+// Selector returns the label selector of the Kubernetes Job of job.
+func (j *Job) Selector(f *Flow, stageIndex, actionIndex int) string {
+	labels := j.Labels(f, stageIndex, actionIndex)
+
+	keys := []string{}
+	for key, _ := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	selectors := []string{}
+	for _, key := range keys {
+		selectors = append(selectors, fmt.Sprintf("%s=%s", key, labels[key]))
+	}
+	return strings.Join(selectors, ",")
+}
+
+// JobTemplates wraps the pod of job into a Kubernetes Job, which is labelled with the flow, run,
+// stage, action and job, and owned by the run owner in the cluster of job.
+func (j *Job) JobTemplates(pod *apiv1.Pod, f *Flow, stageIndex, actionIndex int) *batchv1.Job {
+	labels := j.Labels(f, stageIndex, actionIndex)
+
+	backoffLimit := int32(0)
+	result := &batchv1.Job{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Job",
+			APIVersion: "batch/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   pod.Name,
+			Labels: labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: apiv1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: pod.Spec,
+			},
+		},
+	}
+
+	// The TTL controller deletes the Job even if the daemon is down, it never deletes before the retention.
+	if ttl := maxInt(config.GC.Success, config.GC.Failure); ttl > 0 {
+		seconds := int32(ttl)
+		result.Spec.TTLSecondsAfterFinished = &seconds
+	}
+
+	if owner := f.owner(f.ClusterOf(stageIndex, j)); owner != nil {
+		result.OwnerReferences = []metav1.OwnerReference{
+			{
+				APIVersion: "v1",
+				Kind:       "ConfigMap",
+				Name:       owner.Name,
+				UID:        owner.UID,
+			},
+		}
+	}
+
+	return result
+}
+
+// JobPod waits the pod of a Kubernetes Job created by the Job controller, and returns its name.
+func JobPod(ctx context.Context, p corev1.PodInterface, jobName string) (string, error) {
+	start := time.Now()
+	for {
+		if ctx.Err() != nil {
+			return "", ErrCanceled
+		}
+
+		pods, err := p.List(metav1.ListOptions{LabelSelector: fmt.Sprintf("job-name=%s", jobName)})
+		if err != nil {
+			return "", countKubeError("list_pods", err)
+		}
+		if len(pods.Items) > 0 {
+			return pods.Items[0].Name, nil
+		}
+
+		if time.Now().Sub(start).Minutes() > 3 {
+			return "", fmt.Errorf("Pod of job %s isn't created in 3 minutes", jobName)
+		}
+		time.Sleep(time.Second * 2)
+	}
+}
+
+// Sweep deletes the run owners of the daemon after the retention of their results, and the
+// owners of daemon runs which are neither finished nor in the run queue, which are left by
+// crashed runs. The owners of other daemons and cli runs are labelled with other instances, and
+// never swept. All the clusters and owners are swept even if some of them fail.
+func Sweep() error {
+	errs := []string{}
+	for _, cluster := range ClusterNames() {
+		if e := sweepCluster(cluster); e != nil {
+			errs = append(errs, fmt.Sprintf("Cluster %s: %s", cluster, e.Error()))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func sweepCluster(cluster string) error {
+	c, err := ownerClient(cluster)
+	if err != nil {
+		return err
+	}
+
+	selector := fmt.Sprintf("%s=%s,%s=%s", LabelManagedBy, ManagedBy, LabelInstance, LabelValue(Instance()))
+	owners, err := c.List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return countKubeError("list_configmaps", err)
+	}
+
+	errs := []string{}
+	for _, owner := range owners.Items {
+		if sweepable(&owner, time.Now()) == false {
+			continue
+		}
+
+		if err := c.Delete(owner.Name, backgroundDeletion()); err != nil {
+			countKubeError("delete_configmap", err)
+			errs = append(errs, fmt.Sprintf("Delete run owner %s error: %s", owner.Name, err.Error()))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// sweepable is true when the run owner is finished longer than the retention of its result, or
+// it's left by a crashed daemon run which is neither finished nor in the run queue.
+func sweepable(owner *apiv1.ConfigMap, now time.Time) bool {
+	if finished, ok := owner.Annotations[AnnotationFinished]; ok {
+		t, err := time.Parse(time.RFC3339, finished)
+		return err != nil || now.Sub(t) >= time.Duration(retention(owner.Annotations[AnnotationResult]))*time.Second
+	}
+
+	// The running cli runs of the same instance are not swept.
+	return owner.Labels[LabelMode] == DaemonStart && RunQueue != nil && RunQueue.Active(owner.Labels[LabelRun]) == false
+}
+
+// StartSweeper sweeps the run owners and prunes the expired logs periodically until the daemon exits.
+func StartSweeper(interval int, verbose, timestamp bool) {
+	if interval <= 0 {
+		interval = DefaultSweepInterval
+	}
+
+	go func() {
+		for {
+			if err := Sweep(); err != nil && verbose {
+				if timestamp {
+					fmt.Println(Red(fmt.Sprintf("[%s] Sweep the flow run resources error: %s", time.Now().String(), err.Error())))
+				} else {
+					fmt.Println(Red(fmt.Sprintf("Sweep the flow run resources error: %s", err.Error())))
+				}
+			}
+			if err := PruneLogs(); err != nil && verbose {
+				if timestamp {
+					fmt.Println(Red(fmt.Sprintf("[%s] Prune the flow run logs error: %s", time.Now().String(), err.Error())))
+				} else {
+					fmt.Println(Red(fmt.Sprintf("Prune the flow run logs error: %s", err.Error())))
+				}
+			}
+			time.Sleep(time.Duration(interval) * time.Second)
+		}
+	}()
+}
+
+// retention returns the seconds to keep the resources of a finished run.
+func retention(result string) int {
+	if result == Success {
+		return config.GC.Success
+	}
+
+	return config.GC.Failure
+}
+
+// backgroundDeletion deletes the dependents of a object by the garbage collector.
+func backgroundDeletion() *metav1.DeleteOptions {
+	policy := metav1.DeletePropagationBackground
+	return &metav1.DeleteOptions{PropagationPolicy: &policy}
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"testing"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Huawei/containerops/pilotage/config"
+)
+
+func finishedOwner(result string, finished time.Time) *apiv1.ConfigMap {
+	return &apiv1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+		Name:        "pilotage-run-1",
+		Labels:      map[string]string{LabelMode: DaemonStart, LabelRun: "1"},
+		Annotations: map[string]string{AnnotationFinished: finished.Format(time.RFC3339), AnnotationResult: result},
+	}}
+}
+
+func TestSweepFinishedOwners(t *testing.T) {
+	gc := config.GC
+	defer func() { config.GC = gc }()
+	config.GC.Success, config.GC.Failure = 60, 3600
+
+	now := time.Now()
+	finished := now.Add(-10 * time.Minute)
+
+	// The succeeded run is kept for a minute, the failed run for an hour.
+	if sweepable(finishedOwner(Success, finished), now) == false {
+		t.Errorf("The succeeded run isn't swept after its retention")
+	}
+	if sweepable(finishedOwner(Failure, finished), now) {
+		t.Errorf("The failed run is swept before its retention")
+	}
+	if sweepable(finishedOwner(Cancel, now.Add(-2*time.Hour)), now) == false {
+		t.Errorf("The canceled run isn't swept after the failure retention")
+	}
+
+	broken := finishedOwner(Success, now)
+	broken.Annotations[AnnotationFinished] = "yesterday"
+	if sweepable(broken, now) == false {
+		t.Errorf("The owner with an invalid finished time is never swept")
+	}
+}
+
+func TestSweepUnfinishedOwners(t *testing.T) {
+	queue := RunQueue
+	defer func() { RunQueue = queue }()
+	RunQueue = testQueue()
+
+	f := &Flow{URI: "cncf/demo/hello", Tag: "latest", Model: DaemonStart}
+	if _, err := RunQueue.Submit(f); err != nil {
+		t.Fatalf("Submit error: %s", err.Error())
+	}
+
+	owner := &apiv1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: f.ownerName(), Labels: f.Labels()}}
+	if sweepable(owner, time.Now()) {
+		t.Errorf("The owner of a run in the queue is swept")
+	}
+
+	owner.Labels[LabelRun] = "crashed"
+	if sweepable(owner, time.Now()) == false {
+		t.Errorf("The owner left by a crashed run isn't swept")
+	}
+
+	owner.Labels[LabelMode] = CliRun
+	if sweepable(owner, time.Now()) {
+		t.Errorf("The owner of a running cli run is swept")
+	}
+}
+
+func TestJobTemplatesOwner(t *testing.T) {
+	gc := config.GC
+	defer func() { config.GC = gc }()
+	config.GC.Success, config.GC.Failure = 0, 600
+
+	f := &Flow{URI: "cncf/demo/hello", Model: DaemonStart, runID: "7", Stages: []Stage{
+		{Name: "build", Actions: []Action{{Name: "compile", Jobs: []Job{{Name: "go-build"}}}}},
+	}}
+	f.setOwner(DefaultCluster, &apiv1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: f.ownerName(), UID: "owner-uid"}})
+
+	job := f.Stages[0].Actions[0].Jobs[0].JobTemplates(&apiv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "compile-x1"}}, f, 0, 0)
+	if job.Spec.TTLSecondsAfterFinished == nil || *job.Spec.TTLSecondsAfterFinished != 600 {
+		t.Errorf("The TTL of Job isn't the longest retention")
+	}
+	if job.Spec.BackoffLimit == nil || *job.Spec.BackoffLimit != 0 {
+		t.Errorf("The failed pod of Job is retried")
+	}
+	if len(job.OwnerReferences) != 1 || job.OwnerReferences[0].UID != "owner-uid" {
+		t.Errorf("The Job isn't owned by the run owner: %v", job.OwnerReferences)
+	}
+	if job.Labels[LabelRun] != "7" || job.Spec.Template.Labels[LabelJob] != "go-build" {
+		t.Errorf("The Job and its pod aren't labelled with the run and job: %v", job.Labels)
+	}
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Huawei/containerops/pilotage/config"
+	"github.com/Huawei/containerops/pilotage/model"
+)
+
+const (
+	// DefaultLogBatch is the max log lines inserted in one statement when the log config is empty.
+	DefaultLogBatch = 500
+	// DefaultLogFlush is the milliseconds between two inserts when the log config is empty.
+	DefaultLogFlush = 1000
+	// DefaultMemoryLogs is the log lines kept in memory of a unit when the log config is empty.
+	DefaultMemoryLogs = 10000
+
+	// logBuffer is the log lines waiting insert, the units logging block when it's full.
+	logBuffer = 8192
+)
+
+var (
+	sink     *logSink
+	sinkOnce sync.Once
+
+	// logsLock protects the logs and status of stages, actions and jobs, which are read by the
+	// checkpoint of parallel actions. The flow logs are protected by the flow lock.
+	logsLock sync.RWMutex
+)
+
+// setStatus sets the status of a stage, action or job with the logs lock.
+func setStatus(status *string, value string) {
+	logsLock.Lock()
+	*status = value
+	logsLock.Unlock()
+}
+
+// logSink buffers the log lines of all runs, and inserts them into database in batches.
+type logSink struct {
+	lines    chan model.LogV1
+	flush    chan chan struct{}
+	batch    int
+	interval time.Duration
+	insert   func([]model.LogV1) error
+}
+
+func startLogSink() {
+	batch, interval := config.Log.Batch, config.Log.Flush
+	if batch <= 0 {
+		batch = DefaultLogBatch
+	}
+	if interval <= 0 {
+		interval = DefaultLogFlush
+	}
+
+	sink = &logSink{
+		lines:    make(chan model.LogV1, logBuffer),
+		flush:    make(chan chan struct{}),
+		batch:    batch,
+		interval: time.Duration(interval) * time.Millisecond,
+		insert:   model.CreateBatch,
+	}
+	go sink.loop()
+}
+
+func (s *logSink) loop() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	buffer := []model.LogV1{}
+	for {
+		select {
+		case l := <-s.lines:
+			buffer = append(buffer, l)
+			if len(buffer) >= s.batch {
+				buffer = s.write(buffer)
+			}
+		case <-ticker.C:
+			buffer = s.write(buffer)
+		case done := <-s.flush:
+			for drained := false; drained == false; {
+				select {
+				case l := <-s.lines:
+					buffer = append(buffer, l)
+				default:
+					drained = true
+				}
+			}
+			buffer = s.write(buffer)
+			close(done)
+		}
+	}
+}
+
+// write inserts the buffered lines in batches. The lines are dropped when the database fails,
+// so the runs never wait for the logging.
+func (s *logSink) write(buffer []model.LogV1) []model.LogV1 {
+	for len(buffer) > 0 {
+		n := s.batch
+		if n > len(buffer) {
+			n = len(buffer)
+		}
+		s.insert(buffer[:n])
+		buffer = buffer[n:]
+	}
+
+	return buffer[:0]
+}
+
+// persistLog queues a log line of the unit in the run to be inserted into database.
+func persistLog(phase string, phaseID, runID int64, content string) {
+	if model.DisableDB {
+		return
+	}
+
+	sinkOnce.Do(startLogSink)
+	sink.lines <- model.LogV1{Level: model.INFO, Phase: phase, PhaseID: phaseID, RunID: runID, Content: content, EventTime: time.Now()}
+}
+
+// FlushLogs inserts all the queued log lines, it's called before the process exits.
+func FlushLogs() {
+	if sink == nil {
+		return
+	}
+
+	done := make(chan struct{})
+	sink.flush <- done
+	<-done
+}
+
+// appendLog appends the line to the logs in memory, the oldest lines over the limit are dropped.
+// It returns the logs and the number of the dropped lines.
+func appendLog(logs []string, line string) ([]string, int) {
+	limit := config.Log.Memory
+	if limit <= 0 {
+		limit = DefaultMemoryLogs
+	}
+
+	logs = append(logs, line)
+	if len(logs) <= limit {
+		return logs, 0
+	}
+
+	dropped := len(logs) - limit
+	return logs[dropped:], dropped
+}
+
+// PruneLogs deletes the logs in database out of the retention by days or runs in the log config.
+func PruneLogs() error {
+	l := new(model.LogV1)
+
+	if config.Log.Days > 0 {
+		if _, err := l.PruneBefore(time.Now().AddDate(0, 0, -config.Log.Days)); err != nil {
+			return err
+		}
+	}
+
+	if config.Log.Runs > 0 {
+		if _, err := l.PruneRuns(config.Log.Runs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Huawei/containerops/pilotage/config"
+	"github.com/Huawei/containerops/pilotage/model"
+)
+
+func TestAppendLog(t *testing.T) {
+	memory := config.Log.Memory
+	defer func() { config.Log.Memory = memory }()
+
+	tests := []struct {
+		memory  int
+		logs    []string
+		want    []string
+		dropped int
+	}{
+		{3, []string{}, []string{"new"}, 0},
+		{3, []string{"a", "b"}, []string{"a", "b", "new"}, 0},
+		{3, []string{"a", "b", "c"}, []string{"b", "c", "new"}, 1},
+		{2, []string{"a", "b", "c"}, []string{"c", "new"}, 2},
+		{0, []string{"a", "b", "c"}, []string{"a", "b", "c", "new"}, 0},
+	}
+
+	for _, test := range tests {
+		config.Log.Memory = test.memory
+		logs, dropped := appendLog(append([]string{}, test.logs...), "new")
+		if strings.Join(logs, ",") != strings.Join(test.want, ",") || dropped != test.dropped {
+			t.Errorf("appendLog(%v) with memory %d = %v, %d, want %v, %d", test.logs, test.memory, logs, dropped, test.want, test.dropped)
+		}
+	}
+}
+
+func TestLogsFrom(t *testing.T) {
+	memory := config.Log.Memory
+	defer func() { config.Log.Memory = memory }()
+	config.Log.Memory, model.DisableDB = 3, true
+
+	f := &Flow{URI: "cncf/demo/hello"}
+	for i := 0; i < 5; i++ {
+		f.Log(fmt.Sprintf("line %d", i), false, false)
+	}
+
+	tests := []struct {
+		offset int
+		lines  []string
+	}{
+		// The lines 0 and 1 are dropped from memory, the logs start from the oldest line kept.
+		{0, []string{"line 2", "line 3", "line 4"}},
+		{2, []string{"line 2", "line 3", "line 4"}},
+		{3, []string{"line 3", "line 4"}},
+		{4, []string{"line 4"}},
+		{5, []string{}},
+		{9, []string{}},
+	}
+
+	for _, test := range tests {
+		lines, next := f.LogsFrom(test.offset)
+		if next != 5 {
+			t.Errorf("LogsFrom(%d) next = %d, want 5", test.offset, next)
+		}
+		if len(lines) != len(test.lines) {
+			t.Errorf("LogsFrom(%d) = %v, want %v", test.offset, lines, test.lines)
+			continue
+		}
+		for i, _ := range lines {
+			if strings.HasSuffix(lines[i], "] "+test.lines[i]) == false {
+				t.Errorf("LogsFrom(%d) line %d = %q, want %q", test.offset, i, lines[i], test.lines[i])
+			}
+		}
+	}
+
+	// The logs returned are a copy.
+	lines, _ := f.LogsFrom(4)
+	lines[0] = "changed"
+	if again, _ := f.LogsFrom(4); strings.HasSuffix(again[0], "] line 4") == false {
+		t.Errorf("LogsFrom returns the logs in memory")
+	}
+}
+
+func TestLogSinkBatches(t *testing.T) {
+	var lock sync.Mutex
+	batches := [][]string{}
+	inserted := make(chan struct{}, 10)
+
+	s := &logSink{
+		lines:    make(chan model.LogV1, logBuffer),
+		flush:    make(chan chan struct{}),
+		batch:    3,
+		interval: time.Hour,
+		insert: func(logs []model.LogV1) error {
+			lock.Lock()
+			defer lock.Unlock()
+
+			batch := []string{}
+			for _, l := range logs {
+				batch = append(batch, l.Content)
+			}
+			batches = append(batches, batch)
+			inserted <- struct{}{}
+
+			// The lines of a failed insert are dropped, the later lines are still inserted.
+			if len(batches) == 1 {
+				return fmt.Errorf("Database is gone")
+			}
+			return nil
+		},
+	}
+	go s.loop()
+
+	// Seven lines fill two batches, the last line waits for the interval or the flush.
+	for i := 0; i < 7; i++ {
+		s.lines <- model.LogV1{Phase: model.JOB, RunID: 1, Content: fmt.Sprintf("line %d", i)}
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-inserted:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("The full batches are not inserted")
+		}
+	}
+
+	select {
+	case <-inserted:
+		t.Fatalf("The last line is inserted before the flush")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	done := make(chan struct{})
+	s.flush <- done
+	<-done
+
+	lock.Lock()
+	defer lock.Unlock()
+	got := []string{}
+	for _, batch := range batches {
+		got = append(got, strings.Join(batch, ","))
+	}
+	want := []string{"line 0,line 1,line 2", "line 3,line 4,line 5", "line 6"}
+	if strings.Join(got, " | ") != strings.Join(want, " | ") {
+		t.Errorf("The sink inserts %q, want %q", got, want)
+	}
+}
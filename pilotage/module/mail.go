@@ -3,6 +3,7 @@ package module
 import (
 	"bytes"
 	"fmt"
+	"html"
 	"net/mail"
 	"net/smtp"
 
@@ -24,6 +25,7 @@ func (m *MailNotifier) Notify(flow *Flow, receivers []string) error {
 
 	subject := fmt.Sprintf("[ContainerOps] Excution Result of Flow: %s is [%s] ", flow.URI, strings.ToUpper(flow.Status))
 	htmlBody := fmt.Sprintf("Flow URI: %s <br /> Tag: %s <br /> Title: %s <br /> Result: %s", flow.URI, flow.Tag, flow.Title, flow.Status)
+	htmlBody += testsBody(flow)
 	msg := email.NewHTMLMessage(subject, htmlBody)
 	msg.From = mail.Address{Name: "ContainerOps", Address: common.Mail.User}
 	msg.To = receivers
@@ -53,3 +55,24 @@ func (m *MailNotifier) Notify(flow *Flow, receivers []string) error {
 
 	return nil
 }
+
+// testsBody returns the test summary of the flow run, and the tests newly failing in the run.
+func testsBody(flow *Flow) string {
+	summary := flow.TestSummary()
+	if summary.Total == 0 {
+		return ""
+	}
+
+	body := fmt.Sprintf(" <br /> Tests: %d total, %d failures, %d errors, %d skipped",
+		summary.Total, summary.Failures, summary.Errors, summary.Skipped)
+
+	if tests, err := LoadRunTests(flow.ID, flow.dataID()); err == nil && len(tests.NewFailures) > 0 {
+		names := []string{}
+		for _, c := range tests.NewFailures {
+			names = append(names, html.EscapeString(fmt.Sprintf("%s %s", c.Job, c.FullName())))
+		}
+		body += fmt.Sprintf(" <br /> Newly failing: <br /> %s", strings.Join(names, " <br /> "))
+	}
+
+	return body
+}
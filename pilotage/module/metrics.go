@@ -0,0 +1,230 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// MetricsContentType is the content type of Prometheus text exposition format.
+	MetricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+var (
+	// DurationBuckets are the upper bounds in seconds of duration histograms.
+	DurationBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1200, 1800, 3600, 7200}
+
+	flowRuns = newCounterVec("pilotage_flow_runs_total",
+		"Finished flow runs by flow and status.", "flow", "status")
+	stageDuration = newHistogramVec("pilotage_stage_duration_seconds",
+		"Duration of stage runs in seconds.", "flow", "stage", "status")
+	actionDuration = newHistogramVec("pilotage_action_duration_seconds",
+		"Duration of action runs in seconds.", "flow", "stage", "action", "status")
+	jobDuration = newHistogramVec("pilotage_job_duration_seconds",
+		"Duration of job runs in seconds, the cached jobs are not observed.", "flow", "stage", "action", "job", "status")
+	kubeErrors = newCounterVec("pilotage_kubernetes_api_errors_total",
+		"Errors of Kubernetes API requests by operation.", "operation")
+
+	// activePods is the number of pods being followed by jobs.
+	activePods int64
+)
+
+// sample is the value of a label set.
+type sample struct {
+	labels []string
+	value  float64
+}
+
+// counterVec is a counter partitioned by labels.
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+	lock   sync.Mutex
+	values map[string]*sample
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: map[string]*sample{}}
+}
+
+func (c *counterVec) inc(values ...string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	key := strings.Join(values, "\xff")
+	if _, ok := c.values[key]; ok == false {
+		c.values[key] = &sample{labels: values}
+	}
+	c.values[key].value++
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range sortedKeys(c.values) {
+		s := c.values[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, s.labels), formatValue(s.value))
+	}
+}
+
+// histogramSample is the buckets of a label set, the counts are not cumulative.
+type histogramSample struct {
+	labels []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// histogramVec is a histogram partitioned by labels.
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	lock    sync.Mutex
+	values  map[string]*histogramSample
+}
+
+func newHistogramVec(name, help string, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: DurationBuckets, values: map[string]*histogramSample{}}
+}
+
+func (h *histogramVec) observe(value float64, values ...string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	key := strings.Join(values, "\xff")
+	s, ok := h.values[key]
+	if ok == false {
+		s = &histogramSample{labels: values, counts: make([]uint64, len(h.buckets))}
+		h.values[key] = s
+	}
+
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+			break
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+// since observes the seconds from start.
+func (h *histogramVec) since(start time.Time, values ...string) {
+	h.observe(time.Now().Sub(start).Seconds(), values...)
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedKeys(h.values) {
+		s := h.values[key]
+		names := append(append([]string{}, h.labels...), "le")
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(names, append(append([]string{}, s.labels...), formatValue(bound))), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(names, append(append([]string{}, s.labels...), "+Inf")), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, s.labels), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, s.labels), s.count)
+	}
+}
+
+// writeGauge writes a gauge without labels.
+func writeGauge(w io.Writer, name, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, formatValue(value))
+}
+
+// countKubeError counts the error of a Kubernetes API request and returns it.
+func countKubeError(operation string, err error) error {
+	if err != nil {
+		kubeErrors.inc(operation)
+	}
+
+	return err
+}
+
+// WriteMetrics writes the metrics of the flow engine in Prometheus text exposition format.
+func WriteMetrics(w io.Writer) {
+	flowRuns.write(w)
+	stageDuration.write(w)
+	actionDuration.write(w)
+	jobDuration.write(w)
+
+	pending, running, workers := 0, 0, 0
+	if RunQueue != nil {
+		p, r := RunQueue.List()
+		pending, running, workers = len(p), len(r), RunQueue.Workers()
+	}
+	writeGauge(w, "pilotage_queue_pending", "Flow runs pending in the run queue.", float64(pending))
+	writeGauge(w, "pilotage_queue_running", "Flow runs running in the run queue.", float64(running))
+	writeGauge(w, "pilotage_queue_workers", "Workers of the run queue.", float64(workers))
+	writeGauge(w, "pilotage_active_pods", "Pods of jobs being followed.", float64(atomic.LoadInt64(&activePods)))
+
+	kubeErrors.write(w)
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := []string{}
+	for i, name := range names {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(values[i])
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, value))
+	}
+
+	return fmt.Sprintf("{%s}", strings.Join(pairs, ","))
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func sortedKeys(values interface{}) []string {
+	keys := []string{}
+	switch m := values.(type) {
+	case map[string]*sample:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]*histogramSample:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Huawei/containerops/pilotage/model"
+)
+
+func TestCounterVecWrite(t *testing.T) {
+	c := newCounterVec("pilotage_test_total", "Test counter.", "flow", "status")
+	c.inc("cncf/demo/hello", Success)
+	c.inc("cncf/demo/hello", Success)
+	c.inc("cncf/demo/hello", Failure)
+	c.inc(`cncf/"demo"\hello`+"\n", Success)
+
+	var buffer bytes.Buffer
+	c.write(&buffer)
+
+	want := `# HELP pilotage_test_total Test counter.
+# TYPE pilotage_test_total counter
+pilotage_test_total{flow="cncf/\"demo\"\\hello\n",status="success"} 1
+pilotage_test_total{flow="cncf/demo/hello",status="failure"} 1
+pilotage_test_total{flow="cncf/demo/hello",status="success"} 2
+`
+	if buffer.String() != want {
+		t.Errorf("counter writes:\n%s\nwant:\n%s", buffer.String(), want)
+	}
+}
+
+func TestHistogramVecWrite(t *testing.T) {
+	h := newHistogramVec("pilotage_test_seconds", "Test histogram.", "stage")
+	h.buckets = []float64{1, 5, 30}
+	for _, value := range []float64{0.5, 1, 3, 10, 120} {
+		h.observe(value, "build")
+	}
+
+	var buffer bytes.Buffer
+	h.write(&buffer)
+
+	want := `# HELP pilotage_test_seconds Test histogram.
+# TYPE pilotage_test_seconds histogram
+pilotage_test_seconds_bucket{stage="build",le="1"} 2
+pilotage_test_seconds_bucket{stage="build",le="5"} 3
+pilotage_test_seconds_bucket{stage="build",le="30"} 4
+pilotage_test_seconds_bucket{stage="build",le="+Inf"} 5
+pilotage_test_seconds_sum{stage="build"} 134.5
+pilotage_test_seconds_count{stage="build"} 5
+`
+	if buffer.String() != want {
+		t.Errorf("histogram writes:\n%s\nwant:\n%s", buffer.String(), want)
+	}
+}
+
+func TestWriteMetrics(t *testing.T) {
+	var buffer bytes.Buffer
+	WriteMetrics(&buffer)
+
+	for _, metric := range []string{
+		"# TYPE pilotage_flow_runs_total counter",
+		"# TYPE pilotage_stage_duration_seconds histogram",
+		"# TYPE pilotage_job_duration_seconds histogram",
+		"pilotage_queue_pending 0",
+		"pilotage_queue_workers 0",
+		"# TYPE pilotage_active_pods gauge",
+		"# TYPE pilotage_kubernetes_api_errors_total counter",
+	} {
+		if strings.Contains(buffer.String(), metric+"\n") == false {
+			t.Errorf("Metrics don't have %q", metric)
+		}
+	}
+}
+
+// A finished run is observed by the flow, stage, action and job metrics of its units.
+func TestRunMetrics(t *testing.T) {
+	model.DisableDB = true
+
+	f := &Flow{URI: "cncf/demo/metrics", Tag: "latest", Model: CliRun, Stages: []Stage{
+		{T: NormalStage, Name: "build", Sequencing: Sequencing, Actions: []Action{
+			{Name: "compile", Jobs: []Job{parallelJob("go-build", "missing")}},
+		}},
+	}}
+
+	// The metrics are counted by the process, the runs are counted from the values before them.
+	samples := func() map[string]string {
+		var buffer bytes.Buffer
+		WriteMetrics(&buffer)
+
+		values := map[string]string{}
+		for _, line := range strings.Split(buffer.String(), "\n") {
+			if i := strings.LastIndex(line, " "); i > 0 && strings.HasPrefix(line, "#") == false {
+				values[line[:i]] = line[i+1:]
+			}
+		}
+		return values
+	}
+
+	before := samples()
+	f.LocalRun(false, false)
+	f.LocalRun(false, false)
+	after := samples()
+
+	for _, sample := range []string{
+		`pilotage_flow_runs_total{flow="cncf/demo/metrics",status="failure"}`,
+		`pilotage_stage_duration_seconds_count{flow="cncf/demo/metrics",stage="build",status="failure"}`,
+		`pilotage_action_duration_seconds_count{flow="cncf/demo/metrics",stage="build",action="compile",status="failure"}`,
+		`pilotage_job_duration_seconds_count{flow="cncf/demo/metrics",stage="build",action="compile",job="go-build",status="failure"}`,
+	} {
+		count, _ := strconv.Atoi(before[sample])
+		if after[sample] != strconv.Itoa(count+2) {
+			t.Errorf("Metrics %s = %q after 2 runs, was %q", sample, after[sample], before[sample])
+		}
+	}
+	if _, ok := after[`pilotage_flow_runs_total{flow="cncf/demo/metrics",status="success"}`]; ok {
+		t.Errorf("The failed runs are counted as succeeded")
+	}
+}
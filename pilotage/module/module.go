@@ -23,4 +23,7 @@ const (
 	Running = "running"
 	Failure = "failure"
 	Success = "success"
+
+	// Cached is the recorded result of a job skipped by the cache, its status is success.
+	Cached = "cached"
 )
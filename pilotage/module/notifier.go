@@ -10,6 +10,11 @@ type Notifier interface {
 	Notify(flow *Flow, receivers []string) error
 }
 
+// StartNotifier is the notifier notified when the flow starts running too.
+type StartNotifier interface {
+	NotifyStart(flow *Flow, receivers []string) error
+}
+
 func Register(name string, notifier Notifier) error {
 	if _, ok := Notifiers[name]; ok {
 		return fmt.Errorf("Notifier %s already exist", name)
@@ -0,0 +1,314 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+const (
+	// DefaultWorkers is the number of workers when the queue config is empty.
+	DefaultWorkers = 4
+
+	// FinishedRuns is the number of finished runs kept in the queue for the remote clients.
+	FinishedRuns = 100
+)
+
+// RunQueue is the global run queue of the daemon start mode.
+var RunQueue *Queue
+
+// Run is a flow run in the run queue.
+type Run struct {
+	ID      string    `json:"id"`
+	URI     string    `json:"uri"`
+	Tag     string    `json:"tag"`
+	Title   string    `json:"title"`
+	Status  string    `json:"status"`
+	Queued  time.Time `json:"queued"`
+	Started time.Time `json:"started"`
+
+	flow *Flow
+}
+
+// Flow returns the flow of the run.
+func (r *Run) Flow() *Flow {
+	return r.flow
+}
+
+// key is the identity of flow used by concurrency control.
+func (r *Run) key() string {
+	return fmt.Sprintf("%s:%s", r.URI, r.Tag)
+}
+
+// Queue runs flows with a fixed number of workers. The runs exceed the worker number or
+// the concurrency of the flow are pending in the queue by the submitted order.
+type Queue struct {
+	mutex    sync.Mutex
+	workers  int
+	verbose  bool
+	stamp    bool
+	pending  []*Run
+	running  map[string]*Run
+	finished []*Run
+	signal   chan struct{}
+}
+
+// InitQueue creates the global run queue and starts the workers.
+func InitQueue(workers int, verbose, timestamp bool) {
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+
+	RunQueue = &Queue{
+		workers: workers,
+		verbose: verbose,
+		stamp:   timestamp,
+		running: make(map[string]*Run),
+		signal:  make(chan struct{}, 1),
+	}
+
+	for i := 0; i < workers; i++ {
+		go RunQueue.work()
+	}
+}
+
+// Submit adds the flow into the queue. If the flow concurrency policy is cancel-previous,
+// the runs of same flow in queue are canceled. It returns a copy of the pending run, the run
+// in queue is changed by the workers.
+func (q *Queue) Submit(f *Flow) (Run, error) {
+	if f.Concurrency != nil {
+		if f.Concurrency.Max < 0 {
+			return Run{}, fmt.Errorf("Invalid concurrency max of flow [%s]: %d", f.URI, f.Concurrency.Max)
+		}
+
+		switch f.Concurrency.OnConflict {
+		case "", QueueConflict, CancelPreviousConflict:
+		default:
+			return Run{}, fmt.Errorf("Unknown concurrency conflict policy of flow [%s]: %s", f.URI, f.Concurrency.OnConflict)
+		}
+	}
+
+	// The run queue only works in the daemon start mode.
+	f.Model = DaemonStart
+
+	r := &Run{ID: uuid.NewV4().String(), URI: f.URI, Tag: f.Tag, Title: f.Title, Status: Pending, Queued: time.Now(), flow: f}
+	f.queued = r.ID
+
+	q.mutex.Lock()
+	if f.Concurrency != nil && f.Concurrency.OnConflict == CancelPreviousConflict {
+		q.cancelPrevious(r.key(), f.Concurrency.Max)
+	}
+	q.pending = append(q.pending, r)
+	submitted := *r
+	q.mutex.Unlock()
+
+	q.notify()
+
+	return submitted, nil
+}
+
+// cancelPrevious removes the pending runs with the same key, and cancels the oldest running
+// runs which will exceed the concurrency max. The caller must hold the mutex.
+func (q *Queue) cancelPrevious(key string, max int) {
+	pending := q.pending[:0]
+	for _, r := range q.pending {
+		if r.key() == key {
+			r.Status = Cancel
+			r.flow.Status = Cancel
+			r.flow.Log(fmt.Sprintf("Pending run [%s] is canceled by a new run", r.ID), q.verbose, q.stamp)
+			q.finish(r)
+			continue
+		}
+		pending = append(pending, r)
+	}
+	q.pending = pending
+
+	if max <= 0 {
+		return
+	}
+
+	running := []*Run{}
+	for _, r := range q.running {
+		if r.key() == key && r.flow.Canceled() == false {
+			running = append(running, r)
+		}
+	}
+	sort.Slice(running, func(i, j int) bool { return running[i].Started.Before(running[j].Started) })
+
+	for i := 0; i <= len(running)-max; i++ {
+		running[i].flow.Log(fmt.Sprintf("Running run [%s] is canceled by a new run", running[i].ID), q.verbose, q.stamp)
+		running[i].flow.Cancel()
+	}
+}
+
+// Cancel cancels a pending or running run.
+func (q *Queue) Cancel(id string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for i, r := range q.pending {
+		if r.ID == id {
+			r.Status = Cancel
+			r.flow.Status = Cancel
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			q.finish(r)
+			return nil
+		}
+	}
+
+	if r, ok := q.running[id]; ok {
+		r.flow.Cancel()
+		return nil
+	}
+
+	return fmt.Errorf("Run [%s] not found in queue", id)
+}
+
+// List returns the pending and running runs.
+func (q *Queue) List() (pending []Run, running []Run) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	pending, running = []Run{}, []Run{}
+	for _, r := range q.pending {
+		pending = append(pending, *r)
+	}
+	for _, r := range q.running {
+		running = append(running, *r)
+	}
+
+	return pending, running
+}
+
+// Get returns a copy of the pending, running or recently finished run of the id.
+func (q *Queue) Get(id string) (Run, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for _, r := range q.pending {
+		if r.ID == id {
+			return *r, nil
+		}
+	}
+	if r, ok := q.running[id]; ok {
+		return *r, nil
+	}
+	for _, r := range q.finished {
+		if r.ID == id {
+			return *r, nil
+		}
+	}
+
+	return Run{}, fmt.Errorf("Run [%s] not found in queue", id)
+}
+
+// Active is true when the run of the run id is pending or running in the queue.
+func (q *Queue) Active(runID string) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for _, r := range q.pending {
+		if r.flow.RunID() == runID {
+			return true
+		}
+	}
+	for _, r := range q.running {
+		if r.flow.RunID() == runID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// finish keeps the finished run for the remote clients. The caller must hold the mutex.
+func (q *Queue) finish(r *Run) {
+	q.finished = append(q.finished, r)
+	if len(q.finished) > FinishedRuns {
+		q.finished = q.finished[len(q.finished)-FinishedRuns:]
+	}
+}
+
+// Workers returns the number of workers.
+func (q *Queue) Workers() int {
+	return q.workers
+}
+
+// notify wakes up a idle worker.
+func (q *Queue) notify() {
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+// next pops the first pending run which doesn't exceed the concurrency of its flow.
+func (q *Queue) next() *Run {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for i, r := range q.pending {
+		if c := r.flow.Concurrency; c != nil && c.Max > 0 {
+			count := 0
+			for _, running := range q.running {
+				if running.key() == r.key() {
+					count++
+				}
+			}
+			if count >= c.Max {
+				continue
+			}
+		}
+
+		q.pending = append(q.pending[:i], q.pending[i+1:]...)
+		r.Status, r.Started = Running, time.Now()
+		q.running[r.ID] = r
+		return r
+	}
+
+	return nil
+}
+
+// work executes the runs from queue until the daemon exits.
+func (q *Queue) work() {
+	for {
+		r := q.next()
+		if r == nil {
+			<-q.signal
+			continue
+		}
+
+		// Other workers may pick the rest pending runs.
+		q.notify()
+
+		r.flow.LocalRun(q.verbose, q.stamp)
+
+		q.mutex.Lock()
+		delete(q.running, r.ID)
+		r.Status = r.flow.Status
+		q.finish(r)
+		q.mutex.Unlock()
+
+		// A run of the flow finished, the pending runs of same flow could be started.
+		q.notify()
+	}
+}
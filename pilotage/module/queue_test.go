@@ -0,0 +1,168 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"testing"
+
+	"github.com/Huawei/containerops/pilotage/model"
+)
+
+// testQueue returns a queue without workers, so the runs stay in queue.
+func testQueue() *Queue {
+	model.DisableDB = true
+	return &Queue{workers: 1, running: make(map[string]*Run), signal: make(chan struct{}, 1)}
+}
+
+func queueFlow(uri string, concurrency *Concurrency) *Flow {
+	return &Flow{URI: uri, Tag: "latest", Concurrency: concurrency}
+}
+
+func TestQueueNext(t *testing.T) {
+	tests := []struct {
+		name        string
+		concurrency *Concurrency
+		runs        int
+		started     int
+	}{
+		{"unlimited", nil, 3, 3},
+		{"max 0 is unlimited", &Concurrency{Max: 0}, 3, 3},
+		{"max 1", &Concurrency{Max: 1, OnConflict: QueueConflict}, 3, 1},
+		{"max 2", &Concurrency{Max: 2, OnConflict: QueueConflict}, 3, 2},
+	}
+
+	for _, test := range tests {
+		q := testQueue()
+		for i := 0; i < test.runs; i++ {
+			if _, err := q.Submit(queueFlow("cncf/demo/hello", test.concurrency)); err != nil {
+				t.Fatalf("%s: Submit error: %s", test.name, err.Error())
+			}
+		}
+
+		started := 0
+		for r := q.next(); r != nil; r = q.next() {
+			if r.Status != Running {
+				t.Errorf("%s: next run is %s, want %s", test.name, r.Status, Running)
+			}
+			started++
+		}
+		if started != test.started || len(q.pending) != test.runs-test.started {
+			t.Errorf("%s: %d runs started and %d pending, want %d started", test.name, started, len(q.pending), test.started)
+		}
+	}
+}
+
+func TestQueueNextSkipsFullFlow(t *testing.T) {
+	q := testQueue()
+	one := &Concurrency{Max: 1, OnConflict: QueueConflict}
+
+	first, _ := q.Submit(queueFlow("cncf/demo/hello", one))
+	second, _ := q.Submit(queueFlow("cncf/demo/hello", one))
+	other, _ := q.Submit(queueFlow("cncf/demo/world", one))
+
+	if r := q.next(); r == nil || r.ID != first.ID {
+		t.Fatalf("The first run isn't started first")
+	}
+	// The second run of the same flow waits, the run of other flow starts.
+	if r := q.next(); r == nil || r.ID != other.ID {
+		t.Errorf("The run of other flow isn't started while the flow is full")
+	}
+	if r := q.next(); r != nil {
+		t.Errorf("Run [%s] is started over the concurrency of flow", r.ID)
+	}
+
+	delete(q.running, first.ID)
+	if r := q.next(); r == nil || r.ID != second.ID {
+		t.Errorf("The pending run isn't started after the running run finished")
+	}
+}
+
+func TestQueueCancelPrevious(t *testing.T) {
+	tests := []struct {
+		name     string
+		max      int
+		running  int
+		pending  int
+		canceled int
+	}{
+		// The pending runs of the flow are always canceled by a new run.
+		{"max 0 keeps running runs", 0, 2, 2, 0},
+		{"max 1 cancels all running runs", 1, 2, 2, 2},
+		{"max 2 cancels the oldest running run", 2, 2, 2, 1},
+		{"max 3 keeps running runs", 3, 2, 2, 0},
+	}
+
+	for _, test := range tests {
+		q := testQueue()
+		policy := &Concurrency{Max: test.max, OnConflict: CancelPreviousConflict}
+
+		running := []Run{}
+		for i := 0; i < test.running; i++ {
+			r, _ := q.Submit(queueFlow("cncf/demo/hello", &Concurrency{}))
+			q.next()
+			running = append(running, r)
+		}
+		pending := []Run{}
+		for i := 0; i < test.pending; i++ {
+			r, _ := q.Submit(queueFlow("cncf/demo/hello", &Concurrency{Max: 1}))
+			pending = append(pending, r)
+		}
+		// The runs of other flows are never canceled.
+		other, _ := q.Submit(queueFlow("cncf/demo/world", nil))
+
+		latest, err := q.Submit(queueFlow("cncf/demo/hello", policy))
+		if err != nil {
+			t.Fatalf("%s: Submit error: %s", test.name, err.Error())
+		}
+
+		for _, r := range pending {
+			if r, _ := q.Get(r.ID); r.Status != Cancel {
+				t.Errorf("%s: pending run is %s, want %s", test.name, r.Status, Cancel)
+			}
+		}
+		if len(q.pending) != 2 || q.pending[0].ID != other.ID || q.pending[1].ID != latest.ID {
+			t.Errorf("%s: %d runs pending after the new run, want the other flow and the new run", test.name, len(q.pending))
+		}
+
+		canceled := 0
+		for i, r := range running {
+			if r.flow.Canceled() {
+				canceled++
+				// The oldest runs are canceled first.
+				if i >= test.canceled {
+					t.Errorf("%s: running run %d is canceled before the older runs", test.name, i)
+				}
+			}
+		}
+		if canceled != test.canceled {
+			t.Errorf("%s: %d running runs are canceled, want %d", test.name, canceled, test.canceled)
+		}
+	}
+}
+
+func TestQueueSubmitInvalidConcurrency(t *testing.T) {
+	q := testQueue()
+
+	for _, c := range []*Concurrency{{Max: -1}, {Max: 1, OnConflict: "replace"}} {
+		if _, err := q.Submit(queueFlow("cncf/demo/hello", c)); err == nil {
+			t.Errorf("Submit with concurrency %+v succeeded, want error", *c)
+		}
+	}
+	if len(q.pending) != 0 {
+		t.Errorf("%d invalid runs are pending", len(q.pending))
+	}
+}
@@ -0,0 +1,290 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Huawei/containerops/pilotage/config"
+	"github.com/Huawei/containerops/pilotage/model"
+)
+
+// Instance is the name of the daemon owning its runs, it's the instance of daemon config or the
+// host name.
+func Instance() string {
+	if config.Daemon.Instance != "" {
+		return config.Daemon.Instance
+	}
+
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		return hostname
+	}
+
+	return ManagedBy
+}
+
+// Recover resumes the runs of the daemon not finished before it restarts. Only the runs started
+// by the start daemon of the same instance are recovered, the runs of other daemons sharing the
+// database and the cli runs are left to their owners. When the pods of running jobs still exist,
+// the jobs attach to the pods and the run continues in the run queue. Otherwise the run is
+// orphaned, the pods left are deleted and the run is marked failed.
+func Recover(verbose, timestamp bool) error {
+	runs, err := new(model.FlowDataV1).ListByOwner(DaemonStart, Instance(), Pending, Running)
+	if err != nil {
+		return err
+	}
+
+	if len(runs) == 0 {
+		return nil
+	}
+
+	for i, _ := range runs {
+		data := &runs[i]
+
+		f := new(Flow)
+		if data.Content == "" {
+			if err := data.Update(Failure, data.Content, data.Outputs, time.Now()); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := json.Unmarshal([]byte(data.Content), f); err != nil {
+			if err := data.Update(Failure, data.Content, data.Outputs, time.Now()); err != nil {
+				return err
+			}
+			continue
+		}
+		f.ID, f.data = data.FlowID, data
+		if data.Namespaces != "" {
+			f.namespaces = strings.Split(data.Namespaces, ",")
+		}
+
+		outputs := map[string]string{}
+		if data.Outputs != "" {
+			json.Unmarshal([]byte(data.Outputs), &outputs)
+		}
+
+		// The recorded flow isn't validated by the run, an invalid one fails rather than the daemon.
+		if errs := f.ValidatePosted(nil); len(errs) > 0 {
+			f.Log(fmt.Sprintf("Flow [%s] run %d is invalid after restart: %s", f.URI, data.Number, errs.Error()), verbose, timestamp)
+			f.orphan(verbose, timestamp)
+			continue
+		}
+
+		if err := f.checkPods(); err != nil {
+			f.Log(fmt.Sprintf("Flow [%s] run %d is orphaned after restart: %s", f.URI, data.Number, err.Error()), verbose, timestamp)
+			f.orphan(verbose, timestamp)
+			continue
+		}
+
+		f.recover(outputs)
+		f.Log(fmt.Sprintf("Flow [%s] run %d is recovered after restart", f.URI, data.Number), verbose, timestamp)
+
+		if _, err := RunQueue.Submit(f); err != nil {
+			f.Log(fmt.Sprintf("Submit the recovered flow run error: %s", err.Error()), verbose, timestamp)
+			f.orphan(verbose, timestamp)
+		}
+	}
+
+	return nil
+}
+
+// checkPods returns error when the pod of a running job is gone or failed, or the cluster of
+// the pod is unreachable. A pending job without pod could be stopped after its Kubernetes Job
+// was created, the pod of the Job is adopted by the job, and the Job without pod is deleted, so
+// the job never runs twice.
+func (f *Flow) checkPods() error {
+	for i, _ := range f.Stages {
+		for j, _ := range f.Stages[i].Actions {
+			for k, _ := range f.Stages[i].Actions[j].Jobs {
+				job := &f.Stages[i].Actions[j].Jobs[k]
+				if job.Status != Pending && job.Status != Running {
+					continue
+				}
+
+				cluster, err := GetCluster(f.ClusterOf(i, job))
+				if err != nil {
+					return err
+				}
+				p := cluster.Client().CoreV1().Pods(cluster.Namespace)
+
+				if job.Pod == "" {
+					if err := f.adoptPod(cluster, i, j, job); err != nil {
+						return err
+					}
+					if job.Pod == "" {
+						continue
+					}
+				}
+
+				pod, err := p.Get(job.Pod, metav1.GetOptions{})
+				if err != nil {
+					return fmt.Errorf("Get pod %s of job [%s] error: %s", job.Pod, job.Name, err.Error())
+				}
+				if pod.Status.Phase == apiv1.PodFailed || pod.Status.Phase == apiv1.PodUnknown {
+					return fmt.Errorf("Pod %s of job [%s] is %s", job.Pod, job.Name, pod.Status.Phase)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// adoptPod finds the Kubernetes Jobs of the job by its labels. The pod of a Job is recorded as
+// the pod of job, and the Jobs without pod are deleted.
+func (f *Flow) adoptPod(cluster *Cluster, stageIndex, actionIndex int, job *Job) error {
+	jobs, err := cluster.Client().BatchV1().Jobs(cluster.Namespace).List(metav1.ListOptions{LabelSelector: job.Selector(f, stageIndex, actionIndex)})
+	if err != nil {
+		return fmt.Errorf("List the Kubernetes Jobs of job [%s] error: %s", job.Name, countKubeError("list_jobs", err).Error())
+	}
+
+	p := cluster.Client().CoreV1().Pods(cluster.Namespace)
+	for _, item := range jobs.Items {
+		pods, err := p.List(metav1.ListOptions{LabelSelector: fmt.Sprintf("job-name=%s", item.Name)})
+		if err != nil {
+			return fmt.Errorf("List the pods of job [%s] error: %s", job.Name, countKubeError("list_pods", err).Error())
+		}
+
+		if len(pods.Items) > 0 && job.Pod == "" {
+			job.Pod = pods.Items[0].Name
+			continue
+		}
+
+		if err := countKubeError("delete_job", cluster.Client().BatchV1().Jobs(cluster.Namespace).Delete(item.Name, backgroundDeletion())); err != nil {
+			return fmt.Errorf("Delete Kubernetes Job %s of job [%s] error: %s", item.Name, job.Name, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// recover marks the finished units reused, and the running jobs attach to their pods. The jobs
+// not started are reset and run after the recovered run continues.
+func (f *Flow) recover(outputs map[string]string) {
+	f.Status, f.Logs, f.Outputs = Pending, nil, nil
+
+	for i, _ := range f.Stages {
+		stage := &f.Stages[i]
+		if stage.T != NormalStage {
+			// The approved pause stage doesn't wait again.
+			if stage.T == PauseStage && stage.Status == Success {
+				stage.skip = true
+			}
+			continue
+		}
+
+		if stage.succeeded() {
+			stage.Status, stage.skip = Success, true
+			for j, _ := range stage.Actions {
+				stage.Actions[j].Status = Success
+				f.reuseAction(stage, &stage.Actions[j], outputs)
+			}
+			continue
+		}
+
+		stage.Status, stage.Logs = "", nil
+		for j, _ := range stage.Actions {
+			action := &stage.Actions[j]
+			if action.succeeded() {
+				action.Status = Success
+				f.reuseAction(stage, action, outputs)
+				continue
+			}
+
+			action.Status, action.Logs = "", nil
+			for k, _ := range action.Jobs {
+				job := &action.Jobs[k]
+
+				switch job.Status {
+				case Success, Failure, Cancel:
+					f.reuseJob(stage, action, job, outputs)
+				case Pending, Running:
+					if job.Pod != "" {
+						job.attach = true
+					} else {
+						job.reset()
+					}
+				default:
+					job.reset()
+				}
+			}
+		}
+	}
+}
+
+// orphan deletes the Kubernetes Jobs and pods of the run, and saves the run failed.
+func (f *Flow) orphan(verbose, timestamp bool) {
+	for i, _ := range f.Stages {
+		stage := &f.Stages[i]
+		for j, _ := range stage.Actions {
+			action := &stage.Actions[j]
+			for k, _ := range action.Jobs {
+				job := &action.Jobs[k]
+				if job.Status != Pending && job.Status != Running {
+					continue
+				}
+
+				job.Status = Failure
+			}
+
+			if action.Status == Pending || action.Status == Running {
+				action.Status = Failure
+			}
+		}
+
+		if stage.Status == Pending || stage.Status == Running {
+			stage.Status = Failure
+		}
+	}
+
+	if err := f.DeleteOwner(); err != nil {
+		f.Log(fmt.Sprintf("Delete the Kubernetes Jobs of orphaned run error: %s", err.Error()), verbose, timestamp)
+	}
+
+	f.Status = Failure
+	f.save(Failure)
+}
+
+// succeeded is true when all jobs of the stage succeeded.
+func (s *Stage) succeeded() bool {
+	for i, _ := range s.Actions {
+		if s.Actions[i].succeeded() == false {
+			return false
+		}
+	}
+
+	return true
+}
+
+// succeeded is true when all jobs of the action succeeded.
+func (a *Action) succeeded() bool {
+	for i, _ := range a.Jobs {
+		if a.Jobs[i].Status != Success {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Huawei/containerops/pilotage/model"
+)
+
+// restartedRun is the snapshot checkpointed by a daemon stopped while the test stage is running.
+const restartedRun = `{
+  "uri": "cncf/demo/hello", "tag": "latest", "status": "running",
+  "stages": [
+    {"type": "normal", "name": "build", "sequencing": "sequence", "status": "success", "actions": [
+      {"name": "compile", "status": "success", "jobs": [{"type": "component", "name": "go-build", "status": "success", "cluster": "missing"}]}
+    ]},
+    {"type": "pause", "name": "approve", "status": "success"},
+    {"type": "normal", "name": "test", "sequencing": "parallel", "status": "running", "actions": [
+      {"name": "lint", "status": "failure", "jobs": [{"type": "component", "name": "go-vet", "status": "failure", "cluster": "missing"}]},
+      {"name": "unit", "status": "running", "jobs": [
+        {"type": "component", "name": "go-test", "status": "running", "pod": "unit-go-test-x1", "cluster": "missing"},
+        {"type": "component", "name": "go-cover", "status": "pending", "cluster": "missing"},
+        {"type": "component", "name": "go-report", "cluster": "missing"}
+      ]}
+    ]}
+  ]
+}`
+
+func recordedRun(t *testing.T) *Flow {
+	f := new(Flow)
+	if err := json.Unmarshal([]byte(restartedRun), f); err != nil {
+		t.Fatalf("Unmarshal the recorded run error: %s", err.Error())
+	}
+	return f
+}
+
+func TestRecoverAfterRestart(t *testing.T) {
+	f := recordedRun(t)
+	f.recover(map[string]string{"build.compile.go-build[image]": "hub.opshub.sh/cncf/hello:1"})
+
+	if f.Status != Pending {
+		t.Errorf("Recovered run is %s, want %s", f.Status, Pending)
+	}
+
+	build, approve, test := &f.Stages[0], &f.Stages[1], &f.Stages[2]
+	if build.skip == false || build.Actions[0].skip == false {
+		t.Errorf("The succeeded stage runs again after restart")
+	}
+	if f.GetOutputs()["build.compile.go-build[image]"] == "" {
+		t.Errorf("The outputs of the succeeded stage aren't restored")
+	}
+	if approve.skip == false {
+		t.Errorf("The approved pause stage waits approval again")
+	}
+	if test.skip || test.Status != "" {
+		t.Errorf("The running stage is reused with status %s", test.Status)
+	}
+
+	lint, unit := &test.Actions[0], &test.Actions[1]
+	if lint.Jobs[0].skip == false || lint.Jobs[0].Status != Failure {
+		t.Errorf("The finished job [%s] runs again after restart", lint.Jobs[0].Name)
+	}
+
+	running, pending, waiting := &unit.Jobs[0], &unit.Jobs[1], &unit.Jobs[2]
+	if running.attach == false || running.Pod != "unit-go-test-x1" {
+		t.Errorf("The running job doesn't attach to its pod %s", running.Pod)
+	}
+	// A pending job without a pod of its Kubernetes Job is created again.
+	if pending.attach || pending.Status != "" {
+		t.Errorf("The pending job without pod isn't reset")
+	}
+	if waiting.attach || waiting.skip {
+		t.Errorf("The job not started is reused or attached")
+	}
+}
+
+func TestRecoverCheckPods(t *testing.T) {
+	// The finished jobs don't need their clusters.
+	f := recordedRun(t)
+	f.Stages[2].Actions[1].Jobs = f.Stages[2].Actions[1].Jobs[2:]
+	if err := f.checkPods(); err != nil {
+		t.Errorf("Check the pods of finished jobs error: %s", err.Error())
+	}
+
+	// The running job on a cluster removed from the config orphans the run.
+	if err := recordedRun(t).checkPods(); err == nil {
+		t.Errorf("The running job on an unknown cluster is recovered")
+	}
+}
+
+func TestOrphanAfterRestart(t *testing.T) {
+	model.DisableDB = true
+
+	f := recordedRun(t)
+	f.orphan(false, false)
+
+	if f.Status != Failure {
+		t.Errorf("Orphaned run is %s, want %s", f.Status, Failure)
+	}
+	test := &f.Stages[2]
+	if test.Status != Failure || test.Actions[1].Status != Failure {
+		t.Errorf("The running stage and action are %s and %s, want %s", test.Status, test.Actions[1].Status, Failure)
+	}
+	for _, job := range test.Actions[1].Jobs[:2] {
+		if job.Status != Failure {
+			t.Errorf("The unfinished job [%s] is %s, want %s", job.Name, job.Status, Failure)
+		}
+	}
+	// The succeeded units keep their status.
+	if f.Stages[0].Status != Success || test.Actions[0].Status != Failure {
+		t.Errorf("The finished units are changed by the orphan")
+	}
+}
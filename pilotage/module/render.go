@@ -0,0 +1,95 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/ghodss/yaml"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+const (
+	// RenderAPIServer is the placeholder of API server when the local kube config is unavailable in render.
+	RenderAPIServer = "$(api-server)"
+)
+
+// RenderOutput returns the placeholder of a job output which is unknown before the flow runs.
+func RenderOutput(key string) string {
+	return fmt.Sprintf("$(%s)", key)
+}
+
+// Render resolves the environments and subscriptions of all jobs, and writes the Kubernetes
+// Jobs in execution order as YAML documents. It never connects the Kubernetes cluster, and
+// the outputs of jobs are rendered with placeholders.
+func (f *Flow) Render(w io.Writer) error {
+	namespace := "default"
+	if f.Namespace != "" {
+		namespace = f.Namespace
+	}
+
+	// The run isn't created, a fixed run id keeps the rendered labels stable.
+	f.runID = "dry-run"
+
+	outputs := map[string]string{}
+	for si, stage := range f.Stages {
+		if stage.T != NormalStage {
+			continue
+		}
+
+		for ai, action := range stage.Actions {
+			for i, _ := range action.Jobs {
+				job := &action.Jobs[i]
+
+				var pod *apiv1.Pod
+				var base64Yaml, apiServer string
+				var err error
+				if job.Kubectl != "" {
+					if base64Yaml, err = job.KubectlYaml(f, outputs); err != nil {
+						return fmt.Errorf("Read kubectl YAML of job [%s.%s.%d] error: %s", stage.Name, action.Name, i, err.Error())
+					}
+
+					if apiServer, err = KubectlAPIServer(f.ClusterOf(si, job)); err != nil {
+						apiServer = RenderAPIServer
+					}
+
+					pod, err = job.KubectlPodTemplates(fmt.Sprintf("kubectl-create-%s-%d", action.Name, i), apiServer, namespace, base64Yaml, f, outputs)
+				} else {
+					pod, err = job.PodTemplates(fmt.Sprintf("%s-%d", action.Name, i), f, outputs)
+				}
+				if err != nil {
+					return fmt.Errorf("Render job [%s.%s.%d] error: %s", stage.Name, action.Name, i, err.Error())
+				}
+
+				data, err := yaml.Marshal(job.JobTemplates(pod, f, si, ai))
+				if err != nil {
+					return err
+				}
+
+				fmt.Fprintf(w, "---\n# stage: %s, action: %s, job: %d\n%s", stage.Name, action.Name, i, string(data))
+
+				for _, o := range job.Outputs {
+					key := fmt.Sprintf("%s.%s.%s[%s]", stage.Name, action.Name, job.Name, o)
+					outputs[key] = RenderOutput(key)
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func renderFlow(kubectl string, environments []map[string]string) *Flow {
+	return &Flow{
+		URI:          "cncf/demo/deploy",
+		Tag:          "latest",
+		Environments: environments,
+		Stages: []Stage{
+			{T: StartStage, Name: "start"},
+			{T: NormalStage, Name: "deploy", Sequencing: Sequencing, Actions: []Action{
+				{Name: "apply", Jobs: []Job{{T: ComponentJob, Name: "kubectl", Kubectl: kubectl}}},
+			}},
+			{T: EndStage, Name: "end"},
+		},
+	}
+}
+
+func TestRenderKubectl(t *testing.T) {
+	file, err := ioutil.TempFile("", "kubectl")
+	if err != nil {
+		t.Fatalf("Create kubectl YAML error: %s", err.Error())
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("apiVersion: v1\nkind: Namespace\nmetadata:\n  name: demo\n")
+	file.Close()
+
+	cases := []struct {
+		name         string
+		kubectl      string
+		environments []map[string]string
+		err          string
+	}{
+		{"kubectl", file.Name(), nil, ""},
+		{"unresolved flow environment", file.Name(), []map[string]string{{"VERSION": "${{ outputs.build.docker.image[TAG] }}"}}, "Render job [deploy.apply.0]"},
+		{"missing kubectl YAML", file.Name() + ".missing", nil, "Read kubectl YAML of job [deploy.apply.0]"},
+	}
+
+	for _, c := range cases {
+		buf := new(bytes.Buffer)
+		err := renderFlow(c.kubectl, c.environments).Render(buf)
+
+		if c.err == "" {
+			if err != nil {
+				t.Errorf("%s: render error: %s", c.name, err.Error())
+			} else if strings.Contains(buf.String(), "kubectl-create-apply-0") == false {
+				t.Errorf("%s: kubectl job isn't rendered:\n%s", c.name, buf.String())
+			}
+			continue
+		}
+
+		if err == nil || strings.Contains(err.Error(), c.err) == false {
+			t.Errorf("%s: render error is %v, it should contain %q", c.name, err, c.err)
+		}
+	}
+}
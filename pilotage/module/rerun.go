@@ -0,0 +1,247 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Huawei/containerops/pilotage/model"
+)
+
+// NewRerun loads the recorded run of a flow, and returns a flow which reruns from the given
+// stage, action or job. The units before the start point are reused with the outputs of the
+// parent run. When the stage is empty, the rerun starts from the first stage not succeeded.
+func NewRerun(uri, tag string, number int64, stage, action, job string) (*Flow, error) {
+	array := strings.Split(uri, "/")
+	if len(array) != 3 {
+		return nil, fmt.Errorf("Invalid flow URI: %s", uri)
+	}
+
+	flow := new(model.FlowV1)
+	if err := flow.Get(array[0], array[1], array[2], tag); err != nil {
+		return nil, err
+	}
+
+	flowData := new(model.FlowDataV1)
+	if err := flowData.Get(flow.ID, number); err != nil {
+		return nil, err
+	}
+
+	if flowData.Content == "" {
+		return nil, fmt.Errorf("Flow run %d of [%s] has no recorded content to rerun", number, uri)
+	}
+
+	f := new(Flow)
+	if err := json.Unmarshal([]byte(flowData.Content), f); err != nil {
+		return nil, fmt.Errorf("Unmarshal the recorded flow run %d error: %s", number, err.Error())
+	}
+	if errs := f.ValidatePosted(nil); len(errs) > 0 {
+		return nil, fmt.Errorf("Invalid recorded flow run %d: %s", number, errs.Error())
+	}
+
+	outputs := map[string]string{}
+	if flowData.Outputs != "" {
+		if err := json.Unmarshal([]byte(flowData.Outputs), &outputs); err != nil {
+			return nil, fmt.Errorf("Unmarshal the outputs of flow run %d error: %s", number, err.Error())
+		}
+	}
+
+	if err := f.Resume(stage, action, job, outputs); err != nil {
+		return nil, err
+	}
+
+	f.Parent, f.Number = flowData.ID, 1
+	return f, nil
+}
+
+// Resume marks the units before the start point as reused, and resets the status of the
+// units from the start point. Only the outputs of reused jobs are restored, so the jobs
+// rerun always subscribe the fresh outputs.
+func (f *Flow) Resume(stageName, actionName, jobName string, outputs map[string]string) error {
+	if stageName == "" && (actionName != "" || jobName != "") {
+		return fmt.Errorf("The stage is required when rerun from an action or job")
+	}
+	if actionName == "" && jobName != "" {
+		return fmt.Errorf("The action is required when rerun from a job")
+	}
+
+	f.Status, f.Logs, f.Outputs = Pending, nil, nil
+
+	start := -1
+	for i, _ := range f.Stages {
+		if stageName == "" && f.Stages[i].T == NormalStage && f.Stages[i].IsCleanup() == false && f.Stages[i].Status != Success {
+			start = i
+			break
+		}
+		if stageName != "" && f.Stages[i].Name == stageName {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		if stageName == "" {
+			return fmt.Errorf("All stages of the flow run succeeded, nothing to rerun")
+		}
+		return fmt.Errorf("Stage [%s] not found in the flow run", stageName)
+	}
+
+	for i, _ := range f.Stages {
+		stage := &f.Stages[i]
+
+		switch {
+		case i < start && stage.IsCleanup():
+			// The cleanup stages are never reused, they run again by the result of rerun.
+			stage.reset()
+		case i < start:
+			if stage.T == NormalStage && stage.Status != Success {
+				return fmt.Errorf("Stage [%s] of the parent run is %s, could not be reused", stage.Name, stage.Status)
+			}
+			stage.skip = true
+			for j, _ := range stage.Actions {
+				f.reuseAction(stage, &stage.Actions[j], outputs)
+			}
+		case i == start && actionName != "":
+			if err := f.resumeStage(stage, actionName, jobName, outputs); err != nil {
+				return err
+			}
+		default:
+			stage.reset()
+		}
+	}
+
+	return nil
+}
+
+// resumeStage reruns the stage from an action. In a sequencing stage the actions before are
+// reused, and in a parallel stage all the other succeeded actions are reused.
+func (f *Flow) resumeStage(stage *Stage, actionName, jobName string, outputs map[string]string) error {
+	start := -1
+	for i, _ := range stage.Actions {
+		if stage.Actions[i].Name == actionName {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return fmt.Errorf("Action [%s] not found in stage [%s]", actionName, stage.Name)
+	}
+
+	stage.Status, stage.Logs = "", nil
+	for i, _ := range stage.Actions {
+		action := &stage.Actions[i]
+
+		switch {
+		case i == start:
+			if err := f.resumeAction(stage, action, jobName, outputs); err != nil {
+				return err
+			}
+		case stage.Sequencing == Parallel:
+			if action.Status == Success {
+				f.reuseAction(stage, action, outputs)
+			} else {
+				action.reset()
+			}
+		case i < start:
+			if action.Status != Success {
+				return fmt.Errorf("Action [%s] of the parent run is %s, could not be reused", action.Name, action.Status)
+			}
+			f.reuseAction(stage, action, outputs)
+		default:
+			action.reset()
+		}
+	}
+
+	return nil
+}
+
+// resumeAction reruns the action from a job, the jobs before are reused.
+func (f *Flow) resumeAction(stage *Stage, action *Action, jobName string, outputs map[string]string) error {
+	if jobName == "" {
+		action.reset()
+		return nil
+	}
+
+	start := -1
+	for i, _ := range action.Jobs {
+		if action.Jobs[i].Name == jobName {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return fmt.Errorf("Job [%s] not found in action [%s]", jobName, action.Name)
+	}
+
+	action.Status, action.Logs = "", nil
+	for i, _ := range action.Jobs {
+		job := &action.Jobs[i]
+
+		if i < start {
+			if job.Status != Success {
+				return fmt.Errorf("Job [%s] of the parent run is %s, could not be reused", job.Name, job.Status)
+			}
+			f.reuseJob(stage, action, job, outputs)
+		} else {
+			job.reset()
+		}
+	}
+
+	return nil
+}
+
+// reuseAction marks the action and its jobs reused.
+func (f *Flow) reuseAction(stage *Stage, action *Action, outputs map[string]string) {
+	action.skip = true
+	for i, _ := range action.Jobs {
+		f.reuseJob(stage, action, &action.Jobs[i], outputs)
+	}
+}
+
+// reuseJob marks the job reused, and restores its outputs from the parent run.
+func (f *Flow) reuseJob(stage *Stage, action *Action, job *Job, outputs map[string]string) {
+	job.skip = true
+
+	prefix := fmt.Sprintf("%s.%s.%s[", stage.Name, action.Name, job.Name)
+	for key, value := range outputs {
+		if strings.HasPrefix(key, prefix) {
+			f.SetOutput(key, value)
+		}
+	}
+}
+
+// reset clears the status and logs of the stage and its actions.
+func (s *Stage) reset() {
+	s.Status, s.Logs, s.skip = "", nil, false
+	for i, _ := range s.Actions {
+		s.Actions[i].reset()
+	}
+}
+
+// reset clears the status and logs of the action and its jobs.
+func (a *Action) reset() {
+	a.Status, a.Logs, a.skip = "", nil, false
+	for i, _ := range a.Jobs {
+		a.Jobs[i].reset()
+	}
+}
+
+// reset clears the status, logs, pod, tests and cache hit of the job.
+func (j *Job) reset() {
+	j.Status, j.Logs, j.Pod, j.Tests, j.Cached, j.skip, j.attach = "", nil, "", nil, false, false, false
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"testing"
+)
+
+// failedRun is a recorded run whose unit test failed in the test stage, the deploy stage never
+// ran and the teardown stage ran after the failure.
+func failedRun() *Flow {
+	return &Flow{URI: "cncf/demo/hello", Tag: "latest", Status: Failure, Stages: []Stage{
+		{T: NormalStage, Name: "build", Sequencing: Sequencing, Status: Success, Actions: []Action{
+			{Name: "compile", Status: Success, Jobs: []Job{{Name: "go-build", Status: Success}}},
+		}},
+		{T: NormalStage, Name: "test", Sequencing: Parallel, Status: Failure, Actions: []Action{
+			{Name: "lint", Status: Success, Jobs: []Job{{Name: "go-vet", Status: Success}}},
+			{Name: "unit", Status: Failure, Jobs: []Job{
+				{Name: "go-generate", Status: Success},
+				{Name: "go-test", Status: Failure, Pod: "unit-go-test-x1", Logs: []string{"FAIL"}},
+			}},
+		}},
+		{T: NormalStage, Name: "deploy", Sequencing: Sequencing, Actions: []Action{
+			{Name: "helm", Jobs: []Job{{Name: "helm-upgrade"}}},
+		}},
+		{T: NormalStage, Name: "teardown", Sequencing: Sequencing, Run: RunAlways, Status: Success, Actions: []Action{
+			{Name: "clean", Status: Success, Jobs: []Job{{Name: "kubectl-delete", Status: Success}}},
+		}},
+	}}
+}
+
+var failedOutputs = map[string]string{
+	"build.compile.go-build[image]":    "hub.opshub.sh/cncf/hello:1",
+	"test.lint.go-vet[report]":         "vet.txt",
+	"test.unit.go-generate[generated]": "mocks",
+	"test.unit.go-test[coverage]":      "12%",
+}
+
+func TestResumeFromFailedStage(t *testing.T) {
+	f := failedRun()
+	if err := f.Resume("", "", "", failedOutputs); err != nil {
+		t.Fatalf("Resume error: %s", err.Error())
+	}
+
+	build, test, teardown := &f.Stages[0], &f.Stages[1], &f.Stages[3]
+	if build.skip == false || build.Actions[0].Jobs[0].skip == false {
+		t.Errorf("The succeeded stage before the failed stage isn't reused")
+	}
+	if test.skip || test.Status != "" || test.Actions[0].skip || test.Actions[1].Jobs[1].Pod != "" {
+		t.Errorf("The failed stage isn't reset: %+v", test)
+	}
+	// The cleanup stage runs again by the result of rerun, even if it succeeded in the parent run.
+	if teardown.skip || teardown.Status != "" {
+		t.Errorf("The cleanup stage is reused with status %s", teardown.Status)
+	}
+
+	outputs := f.GetOutputs()
+	if outputs["build.compile.go-build[image]"] != "hub.opshub.sh/cncf/hello:1" {
+		t.Errorf("The outputs of reused job aren't restored: %v", outputs)
+	}
+	if _, ok := outputs["test.unit.go-test[coverage]"]; ok {
+		t.Errorf("The outputs of a rerun job are restored from the parent run")
+	}
+	if f.Status != Pending {
+		t.Errorf("Resumed flow is %s, want %s", f.Status, Pending)
+	}
+}
+
+func TestResumeFromParallelAction(t *testing.T) {
+	f := failedRun()
+	if err := f.Resume("test", "unit", "", failedOutputs); err != nil {
+		t.Fatalf("Resume error: %s", err.Error())
+	}
+
+	// The other succeeded action of a parallel stage is reused, the action rerun from its first job.
+	lint, unit := &f.Stages[1].Actions[0], &f.Stages[1].Actions[1]
+	if lint.skip == false {
+		t.Errorf("The succeeded parallel action [%s] isn't reused", lint.Name)
+	}
+	if unit.skip || unit.Jobs[0].skip || unit.Jobs[0].Status != "" {
+		t.Errorf("The action [%s] isn't rerun from its first job", unit.Name)
+	}
+	if f.GetOutputs()["test.lint.go-vet[report]"] != "vet.txt" {
+		t.Errorf("The outputs of the reused parallel action aren't restored")
+	}
+}
+
+func TestResumeFromJob(t *testing.T) {
+	f := failedRun()
+	if err := f.Resume("test", "unit", "go-test", failedOutputs); err != nil {
+		t.Fatalf("Resume error: %s", err.Error())
+	}
+
+	generate, test := &f.Stages[1].Actions[1].Jobs[0], &f.Stages[1].Actions[1].Jobs[1]
+	if generate.skip == false || generate.Status != Success {
+		t.Errorf("The job [%s] before the start job isn't reused", generate.Name)
+	}
+	if test.skip || test.Status != "" || test.Logs != nil {
+		t.Errorf("The start job [%s] isn't reset", test.Name)
+	}
+	if f.GetOutputs()["test.unit.go-generate[generated]"] != "mocks" {
+		t.Errorf("The outputs of the job before the start job aren't restored")
+	}
+	if f.Stages[2].skip || f.Stages[2].Actions[0].skip {
+		t.Errorf("The stage after the start job is reused")
+	}
+}
+
+func TestResumeInvalidStart(t *testing.T) {
+	// The failed stage can't be reused by a rerun starting after it.
+	if err := failedRun().Resume("deploy", "", "", failedOutputs); err == nil {
+		t.Errorf("Rerun after the failed stage succeeded")
+	}
+	if err := failedRun().Resume("test", "unit", "go-lint", failedOutputs); err == nil {
+		t.Errorf("Rerun from an unknown job succeeded")
+	}
+	if err := failedRun().Resume("", "unit", "", failedOutputs); err == nil {
+		t.Errorf("Rerun from an action without its stage succeeded")
+	}
+
+	succeeded := failedRun()
+	succeeded.Stages[1].Status = Success
+	succeeded.Stages[2].Status = Success
+	if err := succeeded.Resume("", "", "", failedOutputs); err == nil {
+		t.Errorf("Rerun of a succeeded run succeeded")
+	}
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"fmt"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// DefaultServiceTimeout is the seconds waiting a service ready when the timeout is empty.
+	DefaultServiceTimeout = 120
+
+	// ServicePrefix is the prefix of service container names.
+	ServicePrefix = "svc-"
+
+	// serviceReadyEnv is the environment of the ready command in the service container.
+	serviceReadyEnv = "CO_SERVICE_READY"
+)
+
+// Service is a sidecar container of job, like the MySQL or etcd of integration tests. The
+// services start before the job container in the same pod, and the job reaches them at
+// localhost. The Ready is a shell command in the service image, the job container starts after
+// it succeeds in Timeout seconds.
+type Service struct {
+	Name         string              `json:"name" yaml:"name"`
+	Image        string              `json:"image" yaml:"image"`
+	Command      []string            `json:"command,omitempty" yaml:"command,omitempty"`
+	Args         []string            `json:"args,omitempty" yaml:"args,omitempty"`
+	Environments []map[string]string `json:"environments,omitempty" yaml:"environments,omitempty"`
+	Resources    Resource            `json:"resources,omitempty" yaml:"resources,omitempty"`
+	Ready        string              `json:"ready,omitempty" yaml:"ready,omitempty"`
+	Timeout      int64               `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+}
+
+// Container returns the sidecar container of service with the expressions resolved.
+//
+// The kubelet starts the containers of pod in order, and waits the post start hook of a
+// container before starting the next one. The hook retries the ready command, so the job
+// container after the services starts when they are ready, and the pod fails when a service
+// isn't ready in time.
+func (s *Service) Container(scope *Scope) (apiv1.Container, error) {
+	image, err := scope.Interpolate(s.Image)
+	if err != nil {
+		return apiv1.Container{}, fmt.Errorf("service %s image: %s", s.Name, err.Error())
+	}
+
+	container := apiv1.Container{
+		Name:    ServicePrefix + s.Name,
+		Image:   image,
+		Command: s.Command,
+		Args:    s.Args,
+	}
+
+	environments, err := scope.interpolateEnvironments(s.Environments)
+	if err != nil {
+		return apiv1.Container{}, fmt.Errorf("service %s: %s", s.Name, err.Error())
+	}
+	for _, environment := range environments {
+		for k, v := range environment {
+			container.Env = append(container.Env, apiv1.EnvVar{Name: k, Value: v})
+		}
+	}
+
+	if s.Resources.CPU != "" || s.Resources.Memory != "" {
+		container.Resources.Requests = apiv1.ResourceList{}
+		if s.Resources.CPU != "" {
+			cpu, err := resource.ParseQuantity(s.Resources.CPU)
+			if err != nil {
+				return apiv1.Container{}, fmt.Errorf("service %s: invalid cpu quantity %q", s.Name, s.Resources.CPU)
+			}
+			container.Resources.Requests[apiv1.ResourceCPU] = cpu
+		}
+		if s.Resources.Memory != "" {
+			memory, err := resource.ParseQuantity(s.Resources.Memory)
+			if err != nil {
+				return apiv1.Container{}, fmt.Errorf("service %s: invalid memory quantity %q", s.Name, s.Resources.Memory)
+			}
+			container.Resources.Requests[apiv1.ResourceMemory] = memory
+		}
+	}
+
+	if s.Ready != "" {
+		timeout := s.Timeout
+		if timeout <= 0 {
+			timeout = DefaultServiceTimeout
+		}
+
+		container.Env = append(container.Env, apiv1.EnvVar{Name: serviceReadyEnv, Value: s.Ready})
+		container.Lifecycle = &apiv1.Lifecycle{
+			PostStart: &apiv1.Handler{
+				Exec: &apiv1.ExecAction{
+					Command: []string{ScriptShell, "-c", fmt.Sprintf(
+						`i=0; until %s -c "$%s" >/dev/null 2>&1; do i=$((i+1)); if [ $i -ge %d ]; then exit 1; fi; sleep 1; done`,
+						ScriptShell, serviceReadyEnv, timeout)},
+				},
+			},
+		}
+	}
+
+	return container, nil
+}
+
+// ServiceContainers returns the sidecar containers of the job services.
+func (j *Job) ServiceContainers(scope *Scope) ([]apiv1.Container, error) {
+	containers := []apiv1.Container{}
+	for i, _ := range j.Services {
+		container, err := j.Services[i].Container(scope)
+		if err != nil {
+			return nil, err
+		}
+		containers = append(containers, container)
+	}
+
+	return containers, nil
+}
+
+// servicesTimeout returns the time waiting the services of job ready.
+func (j *Job) servicesTimeout() time.Duration {
+	var timeout time.Duration
+	for _, s := range j.Services {
+		if s.Ready == "" {
+			continue
+		}
+
+		if s.Timeout > 0 {
+			timeout += time.Duration(s.Timeout) * time.Second
+		} else {
+			timeout += DefaultServiceTimeout * time.Second
+		}
+	}
+
+	return timeout
+}
+
+// jobContainer returns the name of the job container, it's the last container after the services.
+func jobContainer(pod *apiv1.Pod) string {
+	if len(pod.Spec.Containers) == 0 {
+		return ""
+	}
+	return pod.Spec.Containers[len(pod.Spec.Containers)-1].Name
+}
+
+// StopServices deletes the Kubernetes Job of pod after the job container terminated, so the
+// services which never exit are stopped.
+func (j *Job) StopServices(cluster *Cluster, podName string, verbose, timestamp bool) {
+	jobName := podJobName(cluster, podName)
+	j.Log(fmt.Sprintf("Job %s finished, stop the services with %s", j.Name, jobName), verbose, timestamp)
+	if err := countKubeError("delete_job", cluster.Client().BatchV1().Jobs(cluster.Namespace).Delete(jobName, backgroundDeletion())); err != nil {
+		j.Log(fmt.Sprintf("Delete job %s error: %s", jobName, err.Error()), verbose, timestamp)
+	}
+}
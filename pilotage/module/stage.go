@@ -1,10 +1,13 @@
 package module
 
 import (
+	"context"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/Huawei/containerops/pilotage/config"
 	"github.com/Huawei/containerops/pilotage/model"
 	. "github.com/logrusorgru/aurora"
 )
@@ -15,6 +18,16 @@ const (
 	EndStage    = "end"
 	NormalStage = "normal"
 	PauseStage  = "pause"
+
+	// Stage Run Condition
+	RunOnSuccess = "on_success"
+	RunAlways    = "always"
+	RunOnFailure = "on_failure"
+)
+
+var (
+	// CleanupTimeout is the time the cleanup stages keep running after the flow is canceled.
+	CleanupTimeout = 10 * time.Minute
 )
 
 // Stage is
@@ -24,16 +37,25 @@ type Stage struct {
 	Name       string   `json:"name" yaml:"name"`
 	Title      string   `json:"title" yaml:"title"`
 	Sequencing string   `json:"sequencing,omitempty" yaml:"sequencing,omitempty"`
+	FailFast   *bool    `json:"fail_fast,omitempty" yaml:"fail_fast,omitempty"`
+	Cluster    string   `json:"cluster,omitempty" yaml:"cluster,omitempty"`
+	Run        string   `json:"run,omitempty" yaml:"run,omitempty"`
 	Status     string   `json:"status,omitempty" yaml:"status,omitempty"`
 	Logs       []string `json:"logs,omitempty" yaml:"logs,omitempty"`
 	Actions    []Action `json:"actions,omitempty" yaml:"actions,omitempty"`
+
+	// skip is true when the stage is reused from the parent run.
+	skip bool
+	// run is the flow data id of the run, the logs are pruned with it.
+	run int64
 }
 
 // TODO filter the log print with different color.
 func (s *Stage) Log(log string, verbose, timestamp bool) {
-	s.Logs = append(s.Logs, fmt.Sprintf("[%s] %s", time.Now().String(), log))
-	l := new(model.LogV1)
-	l.Create(model.INFO, model.STAGE, s.ID, log)
+	logsLock.Lock()
+	s.Logs, _ = appendLog(s.Logs, fmt.Sprintf("[%s] %s", time.Now().String(), log))
+	logsLock.Unlock()
+	persistLog(model.STAGE, s.ID, s.run, log)
 
 	if verbose == true {
 		if timestamp == true {
@@ -44,8 +66,89 @@ func (s *Stage) Log(log string, verbose, timestamp bool) {
 	}
 }
 
+// ShouldRun decides whether the stage runs after the stages before. The stage runs on success by
+// default, the always and on_failure stages run after a stage failed or the flow is canceled.
+func (s *Stage) ShouldRun(failed bool) bool {
+	switch s.Run {
+	case RunAlways:
+		return true
+	case RunOnFailure:
+		return failed
+	default:
+		return failed == false
+	}
+}
+
+// IsCleanup is true when the stage runs after a stage failed or the flow is canceled.
+func (s *Stage) IsCleanup() bool {
+	return s.Run == RunAlways || s.Run == RunOnFailure
+}
+
+// Pause waits the pause stage approved by the daemon API, or the flow canceled. The flow out of
+// the daemon start mode has no API to approve, so it never pauses.
+func (s *Stage) Pause(verbose, timestamp bool, f *Flow) string {
+	if f.Model != DaemonStart {
+		setStatus(&s.Status, Success)
+		f.Log(fmt.Sprintf("Pause stage [%s] is approved automatically in %s mode", s.Name, f.Model), verbose, timestamp)
+		return s.Status
+	}
+
+	approval := make(chan struct{})
+	f.lock.Lock()
+	if f.approvals == nil {
+		f.approvals = map[string]chan struct{}{}
+	}
+	f.approvals[s.Name] = approval
+	f.lock.Unlock()
+
+	setStatus(&s.Status, Pending)
+	s.Log(fmt.Sprintf("Stage [%s] is waiting approval", s.Name), false, timestamp)
+	f.Log(fmt.Sprintf("Stage [%s] is waiting approval", s.Name), verbose, timestamp)
+	f.Checkpoint()
+
+	select {
+	case <-approval:
+		setStatus(&s.Status, Success)
+		f.Log(fmt.Sprintf("Stage [%s] is approved", s.Name), verbose, timestamp)
+	case <-f.Context().Done():
+		f.lock.Lock()
+		delete(f.approvals, s.Name)
+		f.lock.Unlock()
+
+		setStatus(&s.Status, Cancel)
+		f.Log(fmt.Sprintf("Stage [%s] is canceled while waiting approval", s.Name), verbose, timestamp)
+	}
+
+	return s.Status
+}
+
+// Approve continues the flow paused by the stage.
+func (f *Flow) Approve(stageName string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	approval, ok := f.approvals[stageName]
+	if ok == false {
+		return fmt.Errorf("Stage [%s] isn't waiting approval", stageName)
+	}
+
+	close(approval)
+	delete(f.approvals, stageName)
+	return nil
+}
+
+// Context returns the context of the stage run. The cleanup stages are not canceled with the
+// flow, so the teardown work finishes after the flow is canceled, but in CleanupTimeout.
+func (s *Stage) Context(f *Flow) context.Context {
+	if s.IsCleanup() {
+		return f.CleanupContext()
+	}
+
+	return f.Context()
+}
+
 func (s *Stage) SequencingRun(verbose, timestamp bool, f *Flow, stageIndex int) (string, error) {
-	s.Status = Running
+	setStatus(&s.Status, Running)
 
 	s.Log(fmt.Sprintf("Stage [%s] status change to %s", s.Name, s.Status), false, timestamp)
 	f.Log(fmt.Sprintf("Stage [%s] status change to %s", s.Name, s.Status), verbose, timestamp)
@@ -56,7 +159,7 @@ func (s *Stage) SequencingRun(verbose, timestamp bool, f *Flow, stageIndex int)
 	if err != nil {
 		s.Log(fmt.Sprintf("Save Stage [%s] error: %s", s.Name, err.Error()), false, timestamp)
 	}
-	s.ID = stageID
+	s.ID, s.run = stageID, f.dataID()
 
 	// Record stage data
 	stageData := new(model.StageDataV1)
@@ -65,19 +168,30 @@ func (s *Stage) SequencingRun(verbose, timestamp bool, f *Flow, stageIndex int)
 	for i, _ := range s.Actions {
 		action := &s.Actions[i]
 
+		if action.skip {
+			setStatus(&s.Status, action.Status)
+			f.Log(fmt.Sprintf("Action [%s] is reused from the recorded run with status %s", action.Name, action.Status), verbose, timestamp)
+			if s.Status == Failure || s.Status == Cancel {
+				break
+			}
+			continue
+		}
+
 		s.Log(fmt.Sprintf("The Number [%d] action is running: %s", i, s.Title), false, timestamp)
 		f.Log(fmt.Sprintf("The Number [%d] action is running: %s", i, s.Title), verbose, timestamp)
 
-		if status, err := action.Run(verbose, timestamp, f, stageIndex, i); err != nil {
-			s.Status = Failure
+		if status, err := action.Run(s.Context(f), verbose, timestamp, f, stageIndex, i); err != nil {
+			setStatus(&s.Status, Failure)
 
 			s.Log(fmt.Sprintf("Action [%s] run error: %s", action.Name, err.Error()), false, timestamp)
 			f.Log(fmt.Sprintf("Action [%s] run error: %s", action.Name, err.Error()), verbose, timestamp)
 
 		} else {
-			s.Status = status
+			setStatus(&s.Status, status)
 		}
 
+		f.Checkpoint()
+
 		if s.Status == Failure || s.Status == Cancel {
 			break
 		}
@@ -87,15 +201,26 @@ func (s *Stage) SequencingRun(verbose, timestamp bool, f *Flow, stageIndex int)
 	if err != nil {
 		s.Log(fmt.Sprintf("Get Stage Data [%s] Numbers error: %s", s.Name, err.Error()), verbose, timestamp)
 	}
-	if err := stageData.Put(s.ID, currentNumber+1, s.Status, startTime, time.Now()); err != nil {
+	if err := stageData.Put(s.ID, currentNumber+1, s.run, s.Status, startTime, time.Now()); err != nil {
 		s.Log(fmt.Sprintf("Save Stage Data [%s] error: %s", s.Name, err.Error()), false, timestamp)
 	}
+	stageDuration.since(startTime, f.URI, s.Name, s.Status)
 
 	return s.Status, nil
 }
 
+// IsFailFast is true when the parallel stage cancels the other actions after an action failed.
+// The default is true, set fail_fast false to wait all actions complete.
+func (s *Stage) IsFailFast() bool {
+	if s.FailFast == nil {
+		return true
+	}
+
+	return *s.FailFast
+}
+
 func (s *Stage) ParallelRun(verbose, timestamp bool, f *Flow, stageIndex int) (string, error) {
-	s.Status = Running
+	setStatus(&s.Status, Running)
 
 	s.Log(fmt.Sprintf("Stage [%s] status change to %s", s.Name, s.Status), false, timestamp)
 	f.Log(fmt.Sprintf("Stage [%s] status change to %s", s.Name, s.Status), verbose, timestamp)
@@ -106,24 +231,54 @@ func (s *Stage) ParallelRun(verbose, timestamp bool, f *Flow, stageIndex int) (s
 	if err != nil {
 		s.Log(fmt.Sprintf("Save Stage [%s] error: %s", s.Name, err.Error()), false, timestamp)
 	}
-	s.ID = stageID
+	s.ID, s.run = stageID, f.dataID()
 
 	// Record stage data
 	stageData := new(model.StageDataV1)
 	startTime := time.Now()
 
-	resultChan := make(chan string)
-	defer close(resultChan)
-	count := 0
+	// Limit the number of actions running at the same time.
+	var limit chan struct{}
+	if config.Queue.Actions > 0 {
+		limit = make(chan struct{}, config.Queue.Actions)
+	}
+
+	// The stage context cancels the running actions in fail fast mode.
+	ctx, cancel := context.WithCancel(s.Context(f))
+	defer cancel()
+
+	// The result channel is buffered, so no action goroutine blocks on sending after the stage returns.
+	resultChan := make(chan string, len(s.Actions))
+	var wg sync.WaitGroup
 	for i, _ := range s.Actions {
+		wg.Add(1)
 		go func(index int) {
+			defer wg.Done()
+
+			if s.Actions[index].skip {
+				f.Log(fmt.Sprintf("Action [%s] is reused from the recorded run with status %s", s.Actions[index].Name, s.Actions[index].Status), verbose, timestamp)
+				resultChan <- s.Actions[index].Status
+				return
+			}
+
+			if limit != nil {
+				select {
+				case limit <- struct{}{}:
+					defer func() { <-limit }()
+				case <-ctx.Done():
+					setStatus(&s.Actions[index].Status, Cancel)
+					resultChan <- Cancel
+					return
+				}
+			}
+
 			action := &s.Actions[index]
 			var tempStaus string
 
 			s.Log(fmt.Sprintf("The Number [%d] action is running: %s", index, s.Title), false, timestamp)
 			f.Log(fmt.Sprintf("The Number [%d] action is running: %s", index, s.Title), verbose, timestamp)
 
-			if status, err := action.Run(verbose, timestamp, f, stageIndex, index); err != nil {
+			if status, err := action.Run(ctx, verbose, timestamp, f, stageIndex, index); err != nil {
 				tempStaus = Failure
 
 				s.Log(fmt.Sprintf("Action [%s] run error: %s", action.Name, err.Error()), false, timestamp)
@@ -136,23 +291,42 @@ func (s *Stage) ParallelRun(verbose, timestamp bool, f *Flow, stageIndex int) (s
 		}(i)
 	}
 
-	for {
-		if result, ok := <-resultChan; ok {
-			count++
-			s.Status = result
-			if result == Failure || result == Cancel || count == len(s.Actions) {
-
-				currentNumber, err := stageData.GetNumbers(stageID)
-				if err != nil {
-					s.Log(fmt.Sprintf("Get Stage Data [%s] Numbers error: %s", s.Name, err.Error()), verbose, timestamp)
-				}
-				if err := stageData.Put(s.ID, currentNumber+1, s.Status, startTime, time.Now()); err != nil {
-					s.Log(fmt.Sprintf("Save Stage Data [%s] error: %s", s.Name, err.Error()), false, timestamp)
-				}
+	go func() {
+		wg.Wait()
+		close(resultChan)
+	}()
 
-				return s.Status, nil
+	// Aggregate the status of all actions, failure is prior to cancel, and cancel is prior to success.
+	// The stage keeps running status until all actions finish, so the checkpoint never records a
+	// stage finished with actions still running.
+	status := Success
+	for result := range resultChan {
+		switch result {
+		case Failure:
+			if status != Failure && s.IsFailFast() {
+				s.Log(fmt.Sprintf("Stage [%s] is failed, cancel the other running actions", s.Name), false, timestamp)
+				f.Log(fmt.Sprintf("Stage [%s] is failed, cancel the other running actions", s.Name), verbose, timestamp)
+				cancel()
+			}
+			status = Failure
+		case Cancel:
+			if status != Failure {
+				status = Cancel
 			}
 		}
 
+		f.Checkpoint()
+	}
+	setStatus(&s.Status, status)
+
+	currentNumber, err := stageData.GetNumbers(stageID)
+	if err != nil {
+		s.Log(fmt.Sprintf("Get Stage Data [%s] Numbers error: %s", s.Name, err.Error()), verbose, timestamp)
 	}
+	if err := stageData.Put(s.ID, currentNumber+1, s.run, s.Status, startTime, time.Now()); err != nil {
+		s.Log(fmt.Sprintf("Save Stage Data [%s] error: %s", s.Name, err.Error()), false, timestamp)
+	}
+	stageDuration.since(startTime, f.URI, s.Name, s.Status)
+
+	return s.Status, nil
 }
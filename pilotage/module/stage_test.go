@@ -0,0 +1,215 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Huawei/containerops/pilotage/config"
+	"github.com/Huawei/containerops/pilotage/model"
+)
+
+func TestStageContextAfterCancel(t *testing.T) {
+	timeout := CleanupTimeout
+	defer func() { CleanupTimeout = timeout }()
+	CleanupTimeout = 50 * time.Millisecond
+
+	build := &Stage{T: NormalStage, Name: "build"}
+	teardown := &Stage{T: NormalStage, Name: "teardown", Run: RunAlways}
+
+	f := &Flow{URI: "cncf/demo/hello"}
+	f.Cancel()
+
+	if build.Context(f).Err() == nil {
+		t.Errorf("Stage [%s] isn't canceled with the flow", build.Name)
+	}
+	if teardown.Context(f).Err() != nil {
+		t.Errorf("Cleanup stage [%s] is canceled with the flow", teardown.Name)
+	}
+
+	select {
+	case <-teardown.Context(f).Done():
+	case <-time.After(time.Second):
+		t.Errorf("Cleanup stage [%s] isn't canceled after %s", teardown.Name, CleanupTimeout)
+	}
+}
+
+func TestStageContextCanceledAgain(t *testing.T) {
+	teardown := &Stage{T: NormalStage, Name: "teardown", Run: RunOnFailure}
+
+	f := &Flow{URI: "cncf/demo/hello"}
+	f.Cancel()
+	if teardown.Context(f).Err() != nil {
+		t.Errorf("Cleanup stage [%s] is canceled with the flow", teardown.Name)
+	}
+
+	f.Cancel()
+	if teardown.Context(f).Err() == nil {
+		t.Errorf("Cleanup stage [%s] isn't canceled when the flow is canceled again", teardown.Name)
+	}
+}
+
+// The status of a pause stage changes while the daemon API reads the snapshot of run.
+func TestPauseStatusWithSnapshot(t *testing.T) {
+	f := &Flow{URI: "cncf/demo/hello", Model: DaemonStart, Stages: []Stage{{T: PauseStage, Name: "approve"}}}
+
+	done := make(chan string)
+	go func() {
+		done <- f.Stages[0].Pause(false, false, f)
+	}()
+
+	for {
+		if _, err := f.Snapshot(); err != nil {
+			t.Fatalf("Snapshot error: %s", err.Error())
+		}
+		if f.Approve("approve") == nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if status := <-done; status != Success {
+		t.Errorf("Approved stage is %s, want %s", status, Success)
+	}
+}
+
+// blockedCluster registers a cluster whose only slot is taken, so its jobs wait for a slot until
+// they're canceled. The returned func removes the cluster.
+func blockedCluster(name string) func() {
+	c := &Cluster{Name: name, Concurrency: 1, slots: make(chan struct{}, 1), checked: time.Now(), interval: time.Hour}
+	c.slots <- struct{}{}
+
+	clustersLock.Lock()
+	clusters[name] = c
+	clustersLock.Unlock()
+
+	return func() {
+		clustersLock.Lock()
+		delete(clusters, name)
+		clustersLock.Unlock()
+	}
+}
+
+// parallelJob is a job on the cluster, a cluster not in the config fails the job at once.
+func parallelJob(name, cluster string) Job {
+	return Job{T: ComponentJob, Name: name, Endpoint: "hub.opshub.sh/containerops/" + name + ":latest",
+		Resources: Resource{CPU: "1", Memory: "1Gi"}, Cluster: cluster}
+}
+
+// runParallel runs the parallel stage of flow, and returns its result or fails the test after a
+// second. The act func is called while the stage is running.
+func runParallel(t *testing.T, f *Flow, act func()) string {
+	model.DisableDB = true
+
+	done := make(chan string, 1)
+	go func() {
+		status, _ := f.Stages[0].ParallelRun(false, false, f, 0)
+		done <- status
+	}()
+
+	if act != nil {
+		act()
+	}
+
+	select {
+	case status := <-done:
+		return status
+	case <-time.After(time.Second):
+		t.Fatalf("Parallel stage [%s] isn't finished", f.Stages[0].Name)
+	}
+	return ""
+}
+
+func TestParallelRunFailFast(t *testing.T) {
+	defer blockedCluster("busy")()
+
+	f := &Flow{URI: "cncf/demo/hello", Stages: []Stage{{T: NormalStage, Name: "test", Sequencing: Parallel, Actions: []Action{
+		{Name: "lint", Jobs: []Job{parallelJob("go-vet", "missing")}},
+		{Name: "unit", Jobs: []Job{parallelJob("go-test", "busy")}},
+	}}}}
+
+	if status := runParallel(t, f, nil); status != Failure {
+		t.Errorf("Stage is %s after an action failed, want %s", status, Failure)
+	}
+
+	lint, unit := f.Stages[0].Actions[0], f.Stages[0].Actions[1]
+	if lint.Status != Failure {
+		t.Errorf("Failed action is %s, want %s", lint.Status, Failure)
+	}
+	// The job is canceled while waiting for the cluster, or never started when the failure comes first.
+	if unit.Status != Cancel || (unit.Jobs[0].Status != Cancel && unit.Jobs[0].Status != "") {
+		t.Errorf("Running action is %s with job %s after the other failed, want %s", unit.Status, unit.Jobs[0].Status, Cancel)
+	}
+}
+
+func TestParallelRunCompleteAll(t *testing.T) {
+	defer blockedCluster("busy")()
+
+	failFast := false
+	f := &Flow{URI: "cncf/demo/hello", Stages: []Stage{{T: NormalStage, Name: "test", Sequencing: Parallel, FailFast: &failFast, Actions: []Action{
+		{Name: "lint", Jobs: []Job{parallelJob("go-vet", "missing")}},
+		{Name: "unit", Jobs: []Job{parallelJob("go-test", "busy")}},
+	}}}}
+
+	status := runParallel(t, f, func() {
+		// The running action isn't canceled by the failed one, it's only canceled with the flow.
+		time.Sleep(200 * time.Millisecond)
+		logsLock.RLock()
+		status := f.Stages[0].Actions[1].Status
+		logsLock.RUnlock()
+		if status != Running {
+			t.Errorf("Running action is %s after the other failed, want %s", status, Running)
+		}
+		f.Cancel()
+	})
+
+	if status != Failure {
+		t.Errorf("Stage is %s with a failed and a canceled action, want %s", status, Failure)
+	}
+	if unit := f.Stages[0].Actions[1]; unit.Status != Cancel {
+		t.Errorf("Action is %s after the flow is canceled, want %s", unit.Status, Cancel)
+	}
+}
+
+func TestParallelRunCanceled(t *testing.T) {
+	defer blockedCluster("busy")()
+
+	actions := config.Queue.Actions
+	defer func() { config.Queue.Actions = actions }()
+	config.Queue.Actions = 1
+
+	f := &Flow{URI: "cncf/demo/hello", Stages: []Stage{{T: NormalStage, Name: "test", Sequencing: Parallel, Actions: []Action{
+		{Name: "unit", Jobs: []Job{parallelJob("go-test", "busy")}},
+		{Name: "e2e", Jobs: []Job{parallelJob("e2e-test", "busy")}},
+	}}}}
+
+	status := runParallel(t, f, func() {
+		time.Sleep(100 * time.Millisecond)
+		f.Cancel()
+	})
+
+	if status != Cancel {
+		t.Errorf("Stage is %s after the flow is canceled, want %s", status, Cancel)
+	}
+	// One action waits for the job slot and the other waits for the action limit, both are canceled.
+	for _, action := range f.Stages[0].Actions {
+		if action.Status != Cancel {
+			t.Errorf("Action [%s] is %s after the flow is canceled, want %s", action.Name, action.Status, Cancel)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/Huawei/containerops/common"
+)
+
+const (
+	// TemplateScope is the scope of template parameters, ${{ template.NAME }} is replaced when
+	// the template expands.
+	TemplateScope = "template"
+
+	// DockyardScheme is the include of Dockyard binary repository dockyard://namespace/repository/tag/binary.
+	DockyardScheme = "dockyard://"
+)
+
+// Fragment is a flow definition included by other flows, it shares the environments,
+// parameters and action templates.
+type Fragment struct {
+	Include      []string            `json:"include,omitempty" yaml:"include,omitempty"`
+	Environments []map[string]string `json:"environments,omitempty" yaml:"environments,omitempty"`
+	Parameters   map[string]string   `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+	Templates    map[string]Template `json:"templates,omitempty" yaml:"templates,omitempty"`
+}
+
+// Template is the jobs of a parameterised action, the action uses it with `template` and the
+// parameter values in `with`.
+type Template struct {
+	Title      string              `json:"title,omitempty" yaml:"title,omitempty"`
+	Parameters []TemplateParameter `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+	Jobs       []Job               `json:"jobs" yaml:"jobs"`
+}
+
+// TemplateParameter is a parameter of template, the Default is used when the action doesn't set it.
+type TemplateParameter struct {
+	Name     string `json:"name" yaml:"name"`
+	Default  string `json:"default,omitempty" yaml:"default,omitempty"`
+	Required bool   `json:"required,omitempty" yaml:"required,omitempty"`
+}
+
+// Expand merges the included fragments into the flow, and replaces the template actions with
+// the jobs of templates. The location is the file path or URL of the flow to resolve relative
+// includes, it's empty for the flow posted to the daemon which only includes URLs. After
+// expansion the flow has no include or template, so the runtime flow is the expanded one.
+func (f *Flow) Expand(location string, data []byte) ValidationErrors {
+	v := &validator{lines: NewYAMLLines(data)}
+
+	fragment := &Fragment{Parameters: map[string]string{}, Templates: map[string]Template{}}
+	for i, include := range f.Include {
+		if err := fragment.include(location, include, []string{location}); err != nil {
+			v.add(fmt.Sprintf("include[%d]", i), err.Error())
+		}
+	}
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+
+	// The definitions of flow override the included ones.
+	for name, t := range f.Templates {
+		fragment.Templates[name] = t
+	}
+	for k, value := range f.Parameters {
+		fragment.Parameters[k] = value
+	}
+	if len(fragment.Parameters) > 0 {
+		f.Parameters = fragment.Parameters
+	}
+	if len(fragment.Environments) > 0 {
+		f.Environments = append(fragment.Environments, f.Environments...)
+	}
+
+	for si, _ := range f.Stages {
+		for ai, _ := range f.Stages[si].Actions {
+			action := &f.Stages[si].Actions[ai]
+			if action.Template == "" {
+				if len(action.With) > 0 {
+					v.add(fmt.Sprintf("stages[%d].actions[%d].with", si, ai), "with is only used by template action")
+				}
+				continue
+			}
+
+			path := fmt.Sprintf("stages[%d].actions[%d].template", si, ai)
+			t, ok := fragment.Templates[action.Template]
+			if ok == false {
+				v.add(path, fmt.Sprintf("unknown template %q", action.Template))
+				continue
+			}
+			if len(action.Jobs) > 0 {
+				v.add(path, fmt.Sprintf("action with template %q could not define jobs", action.Template))
+				continue
+			}
+
+			jobs, err := t.Instantiate(action.With)
+			if err != nil {
+				v.add(path, fmt.Sprintf("template %q: %s", action.Template, err.Error()))
+				continue
+			}
+
+			action.Jobs = jobs
+			if action.Title == "" {
+				action.Title = t.Title
+			}
+			action.Template, action.With = "", nil
+		}
+	}
+
+	f.Include, f.Templates = nil, nil
+	return v.errs
+}
+
+// include loads the fragment and its includes, the later definitions override the earlier ones.
+// The stack is the locations being included to find the include cycle.
+func (fr *Fragment) include(base, include string, stack []string) error {
+	location, err := ResolveInclude(base, include)
+	if err != nil {
+		return err
+	}
+
+	for _, l := range stack {
+		if l == location {
+			chain := append(append([]string{}, stack...), location)
+			if chain[0] == "" {
+				chain = chain[1:]
+			}
+			return fmt.Errorf("Include cycle: %s", strings.Join(chain, " -> "))
+		}
+	}
+
+	data, err := readInclude(location)
+	if err != nil {
+		return err
+	}
+
+	included := new(Fragment)
+	if err := yaml.UnmarshalStrict(data, included); err != nil {
+		return fmt.Errorf("Invalid fragment %s: %s", location, err.Error())
+	}
+
+	for _, i := range included.Include {
+		if err := fr.include(location, i, append(stack, location)); err != nil {
+			return err
+		}
+	}
+
+	fr.Environments = append(fr.Environments, included.Environments...)
+	for k, value := range included.Parameters {
+		fr.Parameters[k] = value
+	}
+	for name, t := range included.Templates {
+		fr.Templates[name] = t
+	}
+
+	return nil
+}
+
+// ResolveInclude returns the file path or URL of an include. The relative path is resolved
+// from the location of the flow or fragment including it, and the Dockyard binary is resolved
+// with the Warship domain.
+func ResolveInclude(base, include string) (string, error) {
+	if strings.HasPrefix(include, DockyardScheme) {
+		array := strings.Split(strings.TrimPrefix(include, DockyardScheme), "/")
+		if len(array) != 4 {
+			return "", fmt.Errorf("Invalid Dockyard include %q, it should be %snamespace/repository/tag/binary", include, DockyardScheme)
+		}
+		if common.Warship.Domain == "" {
+			return "", fmt.Errorf("The warship domain is required to include %q", include)
+		}
+
+		return fmt.Sprintf("https://%s/binary/v1/%s/%s/binary/%s/%s",
+			common.Warship.Domain, array[0], array[1], array[2], array[3]), nil
+	}
+
+	u, err := url.Parse(include)
+	if err != nil {
+		return "", fmt.Errorf("Invalid include %q: %s", include, err.Error())
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		return include, nil
+	case "":
+	default:
+		return "", fmt.Errorf("Unsupported include scheme %q", u.Scheme)
+	}
+
+	// The relative include of a remote fragment is remote too.
+	if b, err := url.Parse(base); err == nil && (b.Scheme == "http" || b.Scheme == "https") {
+		return b.ResolveReference(u).String(), nil
+	}
+
+	if base == "" {
+		return "", fmt.Errorf("Local include %q is only allowed in a flow file", include)
+	}
+
+	if filepath.IsAbs(include) {
+		return include, nil
+	}
+	return filepath.Join(filepath.Dir(base), include), nil
+}
+
+// readInclude reads the fragment from a file or URL.
+func readInclude(location string) ([]byte, error) {
+	if strings.HasPrefix(location, "http://") == false && strings.HasPrefix(location, "https://") == false {
+		data, err := ioutil.ReadFile(location)
+		if err != nil {
+			return nil, fmt.Errorf("Read fragment %s error: %s", location, err.Error())
+		}
+		return data, nil
+	}
+
+	resp, err := http.Get(location)
+	if err != nil {
+		return nil, fmt.Errorf("Download fragment %s error: %s", location, err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Download fragment %s error: %s", location, resp.Status)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// Instantiate returns the jobs of template with the parameters replaced by the values.
+func (t *Template) Instantiate(with map[string]string) ([]Job, error) {
+	values := map[string]string{}
+	for _, p := range t.Parameters {
+		if value, ok := with[p.Name]; ok {
+			values[p.Name] = value
+		} else if p.Required {
+			return nil, fmt.Errorf("parameter %q is required", p.Name)
+		} else {
+			values[p.Name] = p.Default
+		}
+	}
+
+	unknown := []string{}
+	for k := range with {
+		if _, ok := values[k]; ok == false {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown parameters %s", strings.Join(unknown, ", "))
+	}
+
+	// The jobs are copied through YAML, so the instances never share the maps and slices.
+	data, err := yaml.Marshal(t.Jobs)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	messages := []string{}
+	raw = replaceTemplate(raw, values, &messages)
+	if len(messages) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(messages, "; "))
+	}
+
+	if data, err = yaml.Marshal(raw); err != nil {
+		return nil, err
+	}
+
+	jobs := []Job{}
+	if err := yaml.UnmarshalStrict(data, &jobs); err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// replaceTemplate replaces ${{ template.NAME }} in the keys and values of the YAML value,
+// the other expressions are kept for the run.
+func replaceTemplate(v interface{}, values map[string]string, messages *[]string) interface{} {
+	switch value := v.(type) {
+	case string:
+		return expressionRegexp.ReplaceAllStringFunc(value, func(m string) string {
+			expression := strings.TrimSpace(m[3 : len(m)-2])
+			if strings.HasPrefix(expression, TemplateScope+".") == false {
+				return m
+			}
+
+			name := strings.TrimPrefix(expression, TemplateScope+".")
+			if result, ok := values[name]; ok {
+				return result
+			}
+
+			*messages = append(*messages, fmt.Sprintf("reference %q to an undeclared template parameter", expression))
+			return m
+		})
+	case []interface{}:
+		for i, _ := range value {
+			value[i] = replaceTemplate(value[i], values, messages)
+		}
+		return value
+	case map[interface{}]interface{}:
+		result := map[interface{}]interface{}{}
+		for k, item := range value {
+			result[replaceTemplate(k, values, messages)] = replaceTemplate(item, values, messages)
+		}
+		return result
+	}
+
+	return v
+}
@@ -0,0 +1,217 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTemplateInstantiate(t *testing.T) {
+	template := &Template{
+		Parameters: []TemplateParameter{
+			{Name: "image", Required: true},
+			{Name: "env", Default: "test"},
+		},
+		Jobs: []Job{
+			{
+				T:            ComponentJob,
+				Name:         "deploy-${{ template.env }}",
+				Endpoint:     "${{ template.image }}",
+				Environments: []map[string]string{{"CO_ENV": "${{ template.env }}"}, {"CO_URL": "${{ outputs.build.compile.go-build[CO_URL] }}"}},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		with     map[string]string
+		job      string
+		endpoint string
+		env      string
+		errors   string
+	}{
+		{"default", map[string]string{"image": "hub.opshub.sh/deploy:1.0"}, "deploy-test", "hub.opshub.sh/deploy:1.0", "test", ""},
+		{"override", map[string]string{"image": "deploy", "env": "prod"}, "deploy-prod", "deploy", "prod", ""},
+		{"required", map[string]string{"env": "prod"}, "", "", "", `parameter "image" is required`},
+		{"unknown", map[string]string{"image": "deploy", "region": "us", "cluster": "prod"}, "", "", "", "unknown parameters cluster, region"},
+	}
+
+	for _, test := range tests {
+		jobs, err := template.Instantiate(test.with)
+		if test.errors != "" {
+			if err == nil || err.Error() != test.errors {
+				t.Errorf("%s: Instantiate() error = %v, want %q", test.name, err, test.errors)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: Instantiate() error: %s", test.name, err.Error())
+			continue
+		}
+		if len(jobs) != 1 {
+			t.Errorf("%s: Instantiate() returns %d jobs, want 1", test.name, len(jobs))
+			continue
+		}
+		if jobs[0].Name != test.job || jobs[0].Endpoint != test.endpoint || jobs[0].Environments[0]["CO_ENV"] != test.env {
+			t.Errorf("%s: Instantiate() = %s %s %s, want %s %s %s", test.name,
+				jobs[0].Name, jobs[0].Endpoint, jobs[0].Environments[0]["CO_ENV"], test.job, test.endpoint, test.env)
+		}
+		// The run expressions are kept.
+		if url := jobs[0].Environments[1]["CO_URL"]; url != "${{ outputs.build.compile.go-build[CO_URL] }}" {
+			t.Errorf("%s: Instantiate() replaces the output expression with %q", test.name, url)
+		}
+	}
+
+	// The instances never share the environments of template.
+	jobs, _ := template.Instantiate(map[string]string{"image": "deploy"})
+	jobs[0].Environments[0]["CO_ENV"] = "changed"
+	if template.Jobs[0].Environments[0]["CO_ENV"] != "${{ template.env }}" {
+		t.Errorf("Instantiate() shares the environments with the template")
+	}
+}
+
+func TestTemplateUndeclaredParameter(t *testing.T) {
+	template := &Template{
+		Parameters: []TemplateParameter{{Name: "image", Default: "deploy"}},
+		Jobs:       []Job{{T: ComponentJob, Name: "deploy", Endpoint: "${{ template.image }}:${{ template.version }}"}},
+	}
+
+	want := `reference "template.version" to an undeclared template parameter`
+	if _, err := template.Instantiate(nil); err == nil || err.Error() != want {
+		t.Errorf("Instantiate() error = %v, want %q", err, want)
+	}
+}
+
+func TestResolveInclude(t *testing.T) {
+	tests := []struct {
+		base     string
+		include  string
+		location string
+		errors   bool
+	}{
+		{"/flows/demo/hello.yml", "common.yml", "/flows/demo/common.yml", false},
+		{"/flows/demo/hello.yml", "../shared/common.yml", "/flows/shared/common.yml", false},
+		{"/flows/demo/hello.yml", "/shared/common.yml", "/shared/common.yml", false},
+		{"/flows/demo/hello.yml", "https://example.com/common.yml", "https://example.com/common.yml", false},
+		{"https://example.com/flows/hello.yml", "common.yml", "https://example.com/flows/common.yml", false},
+		{"", "common.yml", "", true},
+		{"/flows/demo/hello.yml", "ftp://example.com/common.yml", "", true},
+		{"/flows/demo/hello.yml", "dockyard://cncf/demo/latest", "", true},
+	}
+
+	for _, test := range tests {
+		location, err := ResolveInclude(test.base, test.include)
+		if (err != nil) != test.errors {
+			t.Errorf("ResolveInclude(%q, %q) error = %v, want error %t", test.base, test.include, err, test.errors)
+		} else if location != test.location {
+			t.Errorf("ResolveInclude(%q, %q) = %q, want %q", test.base, test.include, location, test.location)
+		}
+	}
+}
+
+// A flow file includes a fragment which includes another one, the template of the deepest
+// fragment builds the action with the parameters overridden on the way.
+func TestExpandIncludedTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "include")
+	if err != nil {
+		t.Fatalf("Create directory error: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"shared/build.yml": `
+parameters:
+  tag: "1.0"
+  registry: hub.opshub.sh
+templates:
+  build:
+    title: Build a component
+    parameters:
+      - name: component
+        required: true
+    jobs:
+      - type: component
+        name: build-${{ template.component }}
+        endpoint: ${{ parameters.registry }}/containerops/${{ template.component }}:${{ parameters.tag }}
+        resources:
+          cpu: "1"
+          memory: 1G
+`,
+		"demo/common.yml": `
+include:
+  - ../shared/build.yml
+parameters:
+  tag: "2.0"
+`,
+		"demo/hello.yml": `
+uri: cncf/demo/hello
+tag: latest
+title: Hello
+version: 1
+include:
+  - common.yml
+stages:
+  - type: normal
+    name: build
+    sequencing: parallel
+    actions:
+      - name: etcd
+        template: build
+        with:
+          component: etcd
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		ioutil.WriteFile(path, []byte(content), 0644)
+	}
+
+	location := filepath.Join(dir, "demo/hello.yml")
+	data, _ := ioutil.ReadFile(location)
+	f := new(Flow)
+	if errs := f.ParseYAML(data, location); len(errs) > 0 {
+		t.Fatalf("Parse the flow error: %s", errs.Error())
+	}
+
+	if f.Include != nil || f.Templates != nil || f.Stages[0].Actions[0].Template != "" {
+		t.Errorf("The includes and templates are left in the expanded flow")
+	}
+	if f.Parameters["tag"] != "2.0" || f.Parameters["registry"] != "hub.opshub.sh" {
+		t.Errorf("The parameters are %v, the nearer include should override", f.Parameters)
+	}
+
+	action := f.Stages[0].Actions[0]
+	if action.Title != "Build a component" || len(action.Jobs) != 1 || action.Jobs[0].Name != "build-etcd" {
+		t.Fatalf("The template action isn't expanded: %+v", action)
+	}
+	// The flow parameters are resolved when the job runs, not when the template is expanded.
+	if action.Jobs[0].Endpoint != "${{ parameters.registry }}/containerops/etcd:${{ parameters.tag }}" {
+		t.Errorf("The endpoint is %s", action.Jobs[0].Endpoint)
+	}
+
+	// The fragment including the flow file again is a cycle.
+	ioutil.WriteFile(filepath.Join(dir, "demo/common.yml"), []byte("include:\n  - hello.yml\n"), 0644)
+	if errs := new(Flow).ParseYAML(data, location); len(errs) == 0 || strings.Contains(errs.Error(), "Include cycle") == false {
+		t.Errorf("The include cycle error = %v", errs)
+	}
+}
@@ -0,0 +1,222 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fernet/fernet-go"
+
+	"github.com/Huawei/containerops/common/utils"
+	"github.com/Huawei/containerops/pilotage/config"
+	"github.com/Huawei/containerops/pilotage/model"
+)
+
+const (
+	// Actions of API tokens
+	ActionRun     = "run"
+	ActionCancel  = "cancel"
+	ActionApprove = "approve"
+	ActionRead    = "read"
+
+	// AllNamespaces is the token namespace allowing all namespaces, and the global APIs.
+	AllNamespaces = "*"
+
+	// tokenTTL is the age of token signature accepted by fernet, the expiration of token is
+	// checked with the Expires of token instead. The ttl 0 isn't used, the old fernet versions
+	// reject all tokens with it.
+	tokenTTL = 100 * 365 * 24 * time.Hour
+)
+
+var (
+	// TokenActions are the actions of API tokens.
+	TokenActions = []string{ActionRun, ActionCancel, ActionApprove, ActionRead}
+)
+
+// Token is the content of an API token signed by the auth key. The token is allowed to do the
+// Actions in the Namespaces until Expires, the unix time, and it never expires when Expires is 0.
+type Token struct {
+	ID         int64    `json:"id"`
+	Name       string   `json:"name"`
+	Namespaces []string `json:"namespaces"`
+	Actions    []string `json:"actions"`
+	Expires    int64    `json:"expires,omitempty"`
+}
+
+// AuthEnabled is true when the daemon requires API tokens.
+func AuthEnabled() bool {
+	return config.Auth.Key != ""
+}
+
+// GenerateTokenKey returns a new fernet key for the auth config.
+func GenerateTokenKey() (string, error) {
+	key := new(fernet.Key)
+	if err := key.Generate(); err != nil {
+		return "", err
+	}
+
+	return key.Encode(), nil
+}
+
+// NewToken records and signs a token of the namespaces and actions, it never expires when the
+// ttl is 0. It returns the signed token, which is only shown once.
+func NewToken(name string, namespaces, actions []string, ttl time.Duration) (string, *Token, error) {
+	if config.Auth.Key == "" {
+		return "", nil, fmt.Errorf("The auth key is required to sign tokens")
+	}
+	if len(namespaces) == 0 {
+		return "", nil, fmt.Errorf("The token namespaces are required")
+	}
+	if len(actions) == 0 {
+		return "", nil, fmt.Errorf("The token actions are required")
+	}
+	for _, action := range actions {
+		if validAction(action) == false {
+			return "", nil, fmt.Errorf("Unknown token action %q, it should be one of %s", action, strings.Join(TokenActions, ", "))
+		}
+	}
+
+	var expires *time.Time
+	if ttl > 0 {
+		t := time.Now().Add(ttl)
+		expires = &t
+	}
+
+	record := new(model.TokenV1)
+	if err := record.Create(name, strings.Join(namespaces, ","), strings.Join(actions, ","), expires); err != nil {
+		return "", nil, err
+	}
+
+	token := &Token{ID: record.ID, Name: name, Namespaces: namespaces, Actions: actions}
+	if expires != nil {
+		token.Expires = expires.Unix()
+	}
+
+	signed, err := utils.TokenMarshal(token, config.Auth.Key)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return string(signed), token, nil
+}
+
+// ParseToken verifies the signed token, it's rejected when it's expired or revoked.
+func ParseToken(signed string) (*Token, error) {
+	token := new(Token)
+	if err := utils.TokenUnmarshalTTL(signed, config.Auth.Key, tokenTTL, token); err != nil {
+		return nil, fmt.Errorf("Invalid token")
+	}
+
+	if token.Expires > 0 && time.Now().Unix() > token.Expires {
+		return nil, fmt.Errorf("Token %s is expired", token.Name)
+	}
+
+	if model.DisableDB == false {
+		record := new(model.TokenV1)
+		if err := record.Get(token.ID); err != nil {
+			return nil, fmt.Errorf("Token %s isn't found", token.Name)
+		}
+		if record.RevokedAt != nil {
+			return nil, fmt.Errorf("Token %s is revoked", token.Name)
+		}
+	}
+
+	return token, nil
+}
+
+// Allows is true when the token is allowed to do the action in the namespace, the namespace
+// is empty for the global APIs which are only allowed with all namespaces.
+func (t *Token) Allows(namespace, action string) bool {
+	if t.HasAction(action) == false {
+		return false
+	}
+
+	if containsString(t.Namespaces, AllNamespaces) {
+		return true
+	}
+
+	return namespace != "" && containsString(t.Namespaces, namespace)
+}
+
+// HasAction is true when the token is allowed to do the action in any of its namespaces.
+func (t *Token) HasAction(action string) bool {
+	return containsString(t.Actions, action)
+}
+
+// AnonymousAllows is true when the action is allowed without token.
+func AnonymousAllows(action string) bool {
+	return containsString(config.Auth.Anonymous, action)
+}
+
+func validAction(action string) bool {
+	return containsString(TokenActions, action)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckURI returns error when the flow URI isn't the namespace/repository/name of route. The
+// token is authorized with the namespace of route, so the flow must be in the namespace too.
+func (f *Flow) CheckURI(namespace, repository, name string) error {
+	ns, repo, flowName, err := f.URIs()
+	if err != nil {
+		return err
+	}
+
+	if ns != namespace || repo != repository || flowName != name {
+		return fmt.Errorf("The flow URI %s isn't %s/%s/%s of the request", f.URI, namespace, repository, name)
+	}
+	return nil
+}
+
+// LimitNamespaces limits the flows triggered by the run to the namespaces of the token
+// submitting the run.
+func (f *Flow) LimitNamespaces(namespaces []string) {
+	f.namespaces = append([]string{}, namespaces...)
+}
+
+// CanTrigger is true when the run is allowed to trigger the flows of the namespace.
+func (f *Flow) CanTrigger(namespace string) bool {
+	if f.namespaces == nil {
+		return true
+	}
+
+	return containsString(f.namespaces, AllNamespaces) || containsString(f.namespaces, namespace)
+}
+
+// CheckTriggers returns error when a trigger of flow is out of the namespaces of run.
+func (f *Flow) CheckTriggers() error {
+	for _, t := range f.Triggers {
+		if t.Flow != nil && f.CanTrigger(Namespace(t.Flow.URI)) == false {
+			return fmt.Errorf("The trigger of flow %s is out of the namespaces %s of token", t.Flow.URI, strings.Join(f.namespaces, ", "))
+		}
+	}
+	return nil
+}
+
+// Namespace returns the namespace of the flow URI namespace/repository/name.
+func Namespace(uri string) string {
+	return strings.SplitN(uri, "/", 2)[0]
+}
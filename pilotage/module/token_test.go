@@ -0,0 +1,142 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Huawei/containerops/common/utils"
+	"github.com/Huawei/containerops/pilotage/config"
+	"github.com/Huawei/containerops/pilotage/model"
+)
+
+func signToken(t *testing.T, token *Token) string {
+	signed, err := utils.TokenMarshal(token, config.Auth.Key)
+	if err != nil {
+		t.Fatalf("Sign token error: %s", err.Error())
+	}
+	return string(signed)
+}
+
+func TestParseToken(t *testing.T) {
+	key, err := GenerateTokenKey()
+	if err != nil {
+		t.Fatalf("Generate key error: %s", err.Error())
+	}
+	config.Auth.Key, model.DisableDB = key, true
+
+	cases := []struct {
+		name    string
+		token   Token
+		invalid bool
+	}{
+		{"never expires", Token{ID: 1, Name: "ci", Namespaces: []string{"cncf"}, Actions: []string{ActionRun}}, false},
+		{"not expired", Token{ID: 2, Name: "ci", Namespaces: []string{"cncf"}, Actions: []string{ActionRead}, Expires: time.Now().Add(time.Hour).Unix()}, false},
+		{"expired", Token{ID: 3, Name: "ci", Namespaces: []string{"cncf"}, Actions: []string{ActionRead}, Expires: time.Now().Add(-time.Hour).Unix()}, true},
+	}
+
+	for _, c := range cases {
+		token, err := ParseToken(signToken(t, &c.token))
+		if c.invalid {
+			if err == nil {
+				t.Errorf("%s: token is accepted", c.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: parse token error: %s", c.name, err.Error())
+		} else if token.ID != c.token.ID || token.Allows("cncf", c.token.Actions[0]) == false {
+			t.Errorf("%s: token is %+v", c.name, token)
+		}
+	}
+
+	for _, malformed := range []string{"", "gAAAAABaaaa", "gAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA", "not base64!"} {
+		if _, err := ParseToken(malformed); err == nil {
+			t.Errorf("Invalid token %q is accepted", malformed)
+		}
+	}
+
+	other, _ := GenerateTokenKey()
+	signed, _ := utils.TokenMarshal(&Token{ID: 4, Name: "other"}, other)
+	if _, err := ParseToken(string(signed)); err == nil {
+		t.Errorf("Token signed by other key is accepted")
+	}
+}
+
+func TestTokenAllows(t *testing.T) {
+	token := &Token{Namespaces: []string{"cncf"}, Actions: []string{ActionRun, ActionRead}}
+	all := &Token{Namespaces: []string{AllNamespaces}, Actions: []string{ActionRead}}
+
+	cases := []struct {
+		token     *Token
+		namespace string
+		action    string
+		allowed   bool
+	}{
+		{token, "cncf", ActionRun, true},
+		{token, "cncf", ActionCancel, false},
+		{token, "other", ActionRead, false},
+		{token, "", ActionRead, false},
+		{all, "other", ActionRead, true},
+		{all, "", ActionRead, true},
+		{all, "cncf", ActionRun, false},
+	}
+
+	for i, c := range cases {
+		if allowed := c.token.Allows(c.namespace, c.action); allowed != c.allowed {
+			t.Errorf("Case %d: token %v allows %s in %q is %v", i, c.token.Namespaces, c.action, c.namespace, allowed)
+		}
+	}
+}
+
+func TestCanTrigger(t *testing.T) {
+	f := &Flow{URI: "cncf/demo/build", Triggers: []Trigger{{Flow: &FlowTrigger{URI: "other/demo/deploy"}}}}
+	if f.CanTrigger("other") == false || f.CheckTriggers() != nil {
+		t.Errorf("Run without token can't trigger other namespace")
+	}
+
+	f.LimitNamespaces([]string{"cncf"})
+	if f.CanTrigger("cncf") == false {
+		t.Errorf("Run can't trigger its namespace")
+	}
+	if f.CheckTriggers() == nil {
+		t.Errorf("Trigger out of the token namespaces is allowed")
+	}
+	if _, err := f.NewTriggered(f.Triggers[0].Flow); err == nil {
+		t.Errorf("Flow out of the token namespaces is triggered")
+	}
+
+	f.LimitNamespaces([]string{AllNamespaces})
+	if f.CheckTriggers() != nil {
+		t.Errorf("Token of all namespaces can't trigger other namespace")
+	}
+}
+
+func TestCheckURI(t *testing.T) {
+	f := &Flow{URI: "cncf/demo/build"}
+	if err := f.CheckURI("cncf", "demo", "build"); err != nil {
+		t.Errorf("Check flow URI error: %s", err.Error())
+	}
+	if err := f.CheckURI("other", "demo", "build"); err == nil {
+		t.Errorf("Flow of other namespace is accepted")
+	}
+	if err := (&Flow{URI: "cncf/build"}).CheckURI("cncf", "demo", "build"); err == nil {
+		t.Errorf("Invalid flow URI is accepted")
+	}
+}
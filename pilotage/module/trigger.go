@@ -0,0 +1,162 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Huawei/containerops/pilotage/model"
+)
+
+const (
+	// Trigger conditions of the flow result
+	TriggerOnSuccess = "success"
+	TriggerOnFailure = "failure"
+	TriggerOnAlways  = "always"
+
+	// MaxTriggerChain is the max flows in a trigger chain, it stops the endless triggers.
+	MaxTriggerChain = 10
+)
+
+// Trigger starts another flow after the flow finished.
+type Trigger struct {
+	Flow *FlowTrigger `json:"flow,omitempty" yaml:"flow,omitempty"`
+}
+
+// FlowTrigger runs the flow of URI namespace/repository/flow with the definition recorded by its
+// last run. The Tag is the tag of triggering flow by default, and the trigger fires On success by
+// default. The Parameters override the parameters of the triggered flow, and could reference the
+// outputs of the triggering flow like ${{ outputs.stage.action.job[KEY] }}.
+type FlowTrigger struct {
+	URI        string            `json:"uri" yaml:"uri"`
+	Tag        string            `json:"tag,omitempty" yaml:"tag,omitempty"`
+	On         string            `json:"on,omitempty" yaml:"on,omitempty"`
+	Parameters map[string]string `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+}
+
+// Fires is true when the trigger fires with the result of flow.
+func (t *FlowTrigger) Fires(result string) bool {
+	switch t.On {
+	case TriggerOnAlways:
+		return true
+	case TriggerOnFailure:
+		return result == Failure
+	default:
+		return result == Success
+	}
+}
+
+// RunTriggers starts the flows triggered by the result of flow. In the daemon start mode the
+// flows are submitted to the run queue, otherwise they run one by one after the flow.
+func (f *Flow) RunTriggers(verbose, timestamp bool) {
+	for _, trigger := range f.Triggers {
+		if trigger.Flow == nil || trigger.Flow.Fires(f.Status) == false {
+			continue
+		}
+
+		next, err := f.NewTriggered(trigger.Flow)
+		if err != nil {
+			f.Log(fmt.Sprintf("Trigger Flow [%s] error: %s", trigger.Flow.URI, err.Error()), verbose, timestamp)
+			continue
+		}
+
+		if f.Model == DaemonStart && RunQueue != nil {
+			run, err := RunQueue.Submit(next)
+			if err != nil {
+				f.Log(fmt.Sprintf("Trigger Flow [%s] error: %s", next.URI, err.Error()), verbose, timestamp)
+				continue
+			}
+			f.Log(fmt.Sprintf("Flow [%s] triggers Flow [%s] run [%s]", f.URI, next.URI, run.ID), verbose, timestamp)
+			continue
+		}
+
+		f.Log(fmt.Sprintf("Flow [%s] triggers Flow [%s]", f.URI, next.URI), verbose, timestamp)
+		next.Model = f.Model
+		next.LocalRun(verbose, timestamp)
+	}
+}
+
+// NewTriggered loads the flow of trigger with the definition recorded by its last run, and
+// links the new run to the run of flow triggering it.
+func (f *Flow) NewTriggered(t *FlowTrigger) (*Flow, error) {
+	tag := t.Tag
+	if tag == "" {
+		tag = f.Tag
+	}
+
+	chain := append(append([]string{}, f.chain...), fmt.Sprintf("%s:%s", f.URI, f.Tag))
+	target := fmt.Sprintf("%s:%s", t.URI, tag)
+	for _, c := range chain {
+		if c == target {
+			return nil, fmt.Errorf("Trigger cycle: %s -> %s", strings.Join(chain, " -> "), target)
+		}
+	}
+	if len(chain) >= MaxTriggerChain {
+		return nil, fmt.Errorf("The trigger chain exceeds %d flows: %s", MaxTriggerChain, strings.Join(chain, " -> "))
+	}
+
+	array := strings.Split(t.URI, "/")
+	if len(array) != 3 {
+		return nil, fmt.Errorf("Invalid flow URI: %s", t.URI)
+	}
+
+	// The run submitted with a token only triggers the flows in the namespaces of token.
+	if f.CanTrigger(array[0]) == false {
+		return nil, fmt.Errorf("Flow [%s] is out of the namespaces %s of the run", target, strings.Join(f.namespaces, ", "))
+	}
+
+	flow := new(model.FlowV1)
+	if err := flow.Get(array[0], array[1], array[2], tag); err != nil {
+		return nil, err
+	}
+
+	return f.triggered(t, []byte(flow.Content), chain)
+}
+
+// triggered returns the new run of the flow definition recorded by its last run, the chain is
+// the flows triggering it.
+func (f *Flow) triggered(t *FlowTrigger, content []byte, chain []string) (*Flow, error) {
+	next := new(Flow)
+	if err := json.Unmarshal(content, next); err != nil {
+		return nil, fmt.Errorf("Unmarshal the recorded flow [%s] error: %s", t.URI, err.Error())
+	}
+
+	// The recorded definition has the status of its last run.
+	next.Status, next.Logs, next.Outputs, next.Number = Pending, nil, nil, 1
+	next.Parent, next.Commit = 0, nil
+	for i, _ := range next.Stages {
+		next.Stages[i].reset()
+	}
+
+	scope := f.Scope(&Job{}, f.GetOutputs())
+	for k, v := range t.Parameters {
+		value, err := scope.Interpolate(v)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %s", k, err.Error())
+		}
+
+		if next.Parameters == nil {
+			next.Parameters = map[string]string{}
+		}
+		next.Parameters[k] = value
+	}
+
+	next.TriggeredBy, next.chain, next.namespaces = f.dataID(), chain, f.namespaces
+	return next, nil
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Huawei/containerops/pilotage/model"
+)
+
+func TestTriggerFires(t *testing.T) {
+	tests := []struct {
+		on     string
+		result string
+		fires  bool
+	}{
+		{"", Success, true},
+		{"", Failure, false},
+		{TriggerOnSuccess, Cancel, false},
+		{TriggerOnFailure, Failure, true},
+		{TriggerOnFailure, Success, false},
+		{TriggerOnFailure, Cancel, false},
+		{TriggerOnAlways, Cancel, true},
+		{TriggerOnAlways, Success, true},
+	}
+
+	for _, test := range tests {
+		trigger := &FlowTrigger{URI: "cncf/demo/deploy", On: test.on}
+		if fires := trigger.Fires(test.result); fires != test.fires {
+			t.Errorf("Trigger on %q fires with %s = %t, want %t", test.on, test.result, fires, test.fires)
+		}
+	}
+}
+
+func TestNewTriggeredChain(t *testing.T) {
+	model.DisableDB = true
+
+	long := []string{}
+	for i := 0; i < MaxTriggerChain-1; i++ {
+		long = append(long, fmt.Sprintf("cncf/demo/flow-%d:latest", i))
+	}
+
+	tests := []struct {
+		name       string
+		chain      []string
+		namespaces []string
+		trigger    FlowTrigger
+		errors     string
+	}{
+		{"self", nil, nil, FlowTrigger{URI: "cncf/demo/build"},
+			"Trigger cycle: cncf/demo/build:latest -> cncf/demo/build:latest"},
+		{"cycle", []string{"cncf/demo/test:latest", "cncf/demo/deploy:latest"}, nil, FlowTrigger{URI: "cncf/demo/test"},
+			"Trigger cycle: cncf/demo/test:latest -> cncf/demo/deploy:latest -> cncf/demo/build:latest -> cncf/demo/test:latest"},
+		{"cycle of the tag", []string{"cncf/demo/test:v1"}, nil, FlowTrigger{URI: "cncf/demo/test", Tag: "v1"},
+			"Trigger cycle: cncf/demo/test:v1 -> cncf/demo/build:latest -> cncf/demo/test:v1"},
+		{"other tag isn't a cycle", []string{"cncf/demo/test:v1"}, nil, FlowTrigger{URI: "cncf/demo/test"}, ""},
+		{"long chain", long, nil, FlowTrigger{URI: "cncf/demo/deploy"},
+			fmt.Sprintf("The trigger chain exceeds %d flows", MaxTriggerChain)},
+		{"chain under the max", long[1:], nil, FlowTrigger{URI: "cncf/demo/deploy"}, ""},
+		{"invalid uri", nil, nil, FlowTrigger{URI: "cncf/deploy"}, "Invalid flow URI: cncf/deploy"},
+		{"out of namespaces", nil, []string{"team"}, FlowTrigger{URI: "cncf/demo/deploy"},
+			"Flow [cncf/demo/deploy:latest] is out of the namespaces team of the run"},
+		{"in namespaces", nil, []string{"team", "cncf"}, FlowTrigger{URI: "cncf/demo/deploy"}, ""},
+	}
+
+	for _, test := range tests {
+		f := &Flow{URI: "cncf/demo/build", Tag: "latest", chain: test.chain}
+		if test.namespaces != nil {
+			f.LimitNamespaces(test.namespaces)
+		}
+
+		_, err := f.NewTriggered(&test.trigger)
+		if err == nil {
+			t.Errorf("%s: NewTriggered() succeeded without the recorded flow", test.name)
+			continue
+		}
+
+		if test.errors == "" {
+			// The chain is allowed, the trigger fails on loading the recorded flow.
+			if strings.HasPrefix(err.Error(), "Trigger cycle") || strings.HasPrefix(err.Error(), "The trigger chain") || strings.Contains(err.Error(), "namespaces") {
+				t.Errorf("%s: NewTriggered() error: %s", test.name, err.Error())
+			}
+		} else if strings.HasPrefix(err.Error(), test.errors) == false {
+			t.Errorf("%s: NewTriggered() error = %q, want %q", test.name, err.Error(), test.errors)
+		}
+	}
+}
+
+// The build triggers the deploy with its image, the deploy starts from its recorded definition
+// like a new run, and it can't trigger the build back.
+func TestTriggerChain(t *testing.T) {
+	model.DisableDB = true
+
+	build := &Flow{URI: "cncf/demo/build", Tag: "latest", Status: Success, data: &model.FlowDataV1{ID: 42}}
+	build.LimitNamespaces([]string{"cncf"})
+	build.SetOutput("build.compile.go-build[CO_IMAGE]", "hub.opshub.sh/cncf/hello:2")
+
+	// The deploy recorded after a failed run of an old image pushed to a commit.
+	recorded := &Flow{URI: "cncf/demo/deploy", Tag: "latest", Status: Failure, Number: 3, Parent: 7,
+		Commit:     &Commit{Repository: "cncf/demo", SHA: "8b3f2e1"},
+		Parameters: map[string]string{"IMAGE": "hub.opshub.sh/cncf/hello:1", "REPLICAS": "2"},
+		Logs:       []string{"Flow [cncf/demo/deploy] run failed"},
+		Outputs:    map[string]string{"deploy.upgrade.helm[REVISION]": "3"},
+		Stages: []Stage{
+			{T: NormalStage, Name: "deploy", Status: Failure, Logs: []string{"Stage failed"}, Actions: []Action{
+				{Name: "upgrade", Status: Failure, Jobs: []Job{
+					{T: ComponentJob, Name: "helm", Status: Failure, Pod: "helm-1", Logs: []string{"Error: timed out"}},
+				}},
+			}},
+		},
+	}
+	content, _ := json.Marshal(recorded)
+
+	trigger := &FlowTrigger{URI: "cncf/demo/deploy", Parameters: map[string]string{
+		"IMAGE": "${{ outputs.build.compile.go-build[CO_IMAGE] }}",
+	}}
+	deploy, err := build.triggered(trigger, content, []string{"cncf/demo/build:latest"})
+	if err != nil {
+		t.Fatalf("Trigger the deploy error: %s", err.Error())
+	}
+
+	if deploy.Status != Pending || deploy.Number != 1 || deploy.Parent != 0 || deploy.Commit != nil || deploy.Logs != nil || deploy.Outputs != nil {
+		t.Errorf("The triggered deploy keeps the last run: %s, number %d, parent %d, commit %v, logs %v, outputs %v",
+			deploy.Status, deploy.Number, deploy.Parent, deploy.Commit, deploy.Logs, deploy.Outputs)
+	}
+	job := deploy.Stages[0].Actions[0].Jobs[0]
+	if deploy.Stages[0].Status != "" || deploy.Stages[0].Actions[0].Status != "" || job.Status != "" || job.Pod != "" || job.Logs != nil {
+		t.Errorf("The triggered deploy keeps the units of the last run: %+v", deploy.Stages[0])
+	}
+	if deploy.Parameters["IMAGE"] != "hub.opshub.sh/cncf/hello:2" || deploy.Parameters["REPLICAS"] != "2" {
+		t.Errorf("The triggered deploy has the parameters %v, want the built image and 2 replicas", deploy.Parameters)
+	}
+	if deploy.TriggeredBy != 42 || deploy.CanTrigger("team") || deploy.CanTrigger("cncf") == false {
+		t.Errorf("The triggered deploy isn't linked to the build run %d with its namespaces", deploy.TriggeredBy)
+	}
+
+	// The deploy can't trigger the build back, before loading the build.
+	_, err = deploy.NewTriggered(&FlowTrigger{URI: "cncf/demo/build"})
+	if err == nil || err.Error() != "Trigger cycle: cncf/demo/build:latest -> cncf/demo/deploy:latest -> cncf/demo/build:latest" {
+		t.Errorf("The deploy triggers the build back: %v", err)
+	}
+
+	// A trigger referencing an output the build didn't produce doesn't start the deploy.
+	trigger.Parameters["VERSION"] = "${{ outputs.build.compile.go-build[VERSION] }}"
+	if _, err := build.triggered(trigger, content, nil); err == nil {
+		t.Errorf("The deploy is triggered with an unresolved parameter")
+	}
+}
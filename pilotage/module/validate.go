@@ -0,0 +1,718 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/Huawei/containerops/common/utils"
+)
+
+var (
+	// uriRegexp matches the flow URI namespace/repository/name.
+	uriRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*/[a-zA-Z0-9][a-zA-Z0-9._-]*/[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+	// imageRegexp matches the image reference [domain[:port]/]path[:tag][@digest].
+	imageRegexp = regexp.MustCompile(`^(?:[a-zA-Z0-9.-]+(?::[0-9]+)?/)?[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*(?::[\w][\w.-]{0,127})?(?:@sha256:[a-f0-9]{64})?$`)
+	// serviceRegexp matches the service name, it's in the container name.
+	serviceRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,57}[a-z0-9])?$`)
+	// subscriptionRegexp matches the output key stage.action.job[KEY].
+	subscriptionRegexp = regexp.MustCompile(`^([^.\[\]]+)\.([^.\[\]]+)\.([^.\[\]]*)\[([^\[\]]+)\]$`)
+	// yamlErrorRegexp matches the line number in the errors of YAML parser.
+	yamlErrorRegexp = regexp.MustCompile(`line (\d+): (.*)`)
+)
+
+// ValidationError is a problem of flow definition, Line is 0 when the definition isn't YAML
+// or the position is unknown.
+type ValidationError struct {
+	Line    int    `json:"line,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e ValidationError) Error() string {
+	switch {
+	case e.Line > 0 && e.Path != "":
+		return fmt.Sprintf("line %d: %s: %s", e.Line, e.Path, e.Message)
+	case e.Line > 0:
+		return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+	case e.Path != "":
+		return fmt.Sprintf("%s: %s", e.Path, e.Message)
+	}
+
+	return e.Message
+}
+
+// ValidationErrors is all problems of a flow definition.
+type ValidationErrors []ValidationError
+
+func (es ValidationErrors) Error() string {
+	messages := []string{}
+	for _, e := range es {
+		messages = append(messages, e.Error())
+	}
+
+	return strings.Join(messages, "\n")
+}
+
+// ParseYAML parses the YAML flow definition strictly, expands the includes and templates with
+// the location of definition, and validates it. All the problems are reported with the YAML
+// line number. The location is empty for the flow posted to the daemon, and its local files
+// aren't checked.
+func (f *Flow) ParseYAML(data []byte, location string) ValidationErrors {
+	if err := yaml.UnmarshalStrict(data, f); err != nil {
+		errs := ValidationErrors{}
+		for _, message := range strings.Split(err.Error(), "\n") {
+			if matches := yamlErrorRegexp.FindStringSubmatch(message); matches != nil {
+				line, _ := strconv.Atoi(matches[1])
+				errs = append(errs, ValidationError{Line: line, Message: matches[2]})
+			} else if strings.HasPrefix(message, "yaml: unmarshal errors:") == false {
+				errs = append(errs, ValidationError{Message: strings.TrimSpace(message)})
+			}
+		}
+		return errs
+	}
+
+	if errs := f.Expand(location, data); len(errs) > 0 {
+		return errs
+	}
+
+	if location == "" {
+		return f.ValidatePosted(data)
+	}
+	return f.Validate(data)
+}
+
+// Validate checks the schema and semantics of the flow. The data is the YAML definition
+// used to locate the line number of problems, it could be nil.
+func (f *Flow) Validate(data []byte) ValidationErrors {
+	return f.validate(data, true)
+}
+
+// ValidatePosted checks the flow like Validate except the local files, like the kubectl file
+// of job. The files of flow posted to the daemon are on the client, not the daemon.
+func (f *Flow) ValidatePosted(data []byte) ValidationErrors {
+	return f.validate(data, false)
+}
+
+func (f *Flow) validate(data []byte, local bool) ValidationErrors {
+	v := &validator{lines: NewYAMLLines(data), local: local}
+
+	if f.URI == "" {
+		v.add("uri", "URI is required")
+	} else if uriRegexp.MatchString(f.URI) == false {
+		v.add("uri", fmt.Sprintf("invalid URI %q, it should be namespace/repository/name", f.URI))
+	}
+
+	if f.Timeout < 0 {
+		v.add("timeout", fmt.Sprintf("invalid timeout %d", f.Timeout))
+	}
+
+	v.cluster("cluster", f.Cluster)
+
+	if f.Concurrency != nil {
+		if f.Concurrency.Max < 0 {
+			v.add("concurrency.max", fmt.Sprintf("invalid concurrency max %d", f.Concurrency.Max))
+		}
+		switch f.Concurrency.OnConflict {
+		case "", QueueConflict, CancelPreviousConflict:
+		default:
+			v.add("concurrency.on_conflict", fmt.Sprintf("unknown conflict policy %q, it should be %s or %s",
+				f.Concurrency.OnConflict, QueueConflict, CancelPreviousConflict))
+		}
+	}
+
+	for i, environment := range f.Environments {
+		for k, value := range environment {
+			for _, expression := range Expressions(value) {
+				v.reference(fmt.Sprintf("environments[%d].%s", i, k), f, nil, expression, nil)
+			}
+		}
+	}
+
+	for i, receiver := range f.Receivers {
+		if _, ok := Notifiers[receiver.Type]; ok == false {
+			v.add(fmt.Sprintf("receivers[%d].type", i), fmt.Sprintf("unknown receiver type %q", receiver.Type))
+		}
+	}
+
+	if f.Commit != nil {
+		if f.Commit.Repository == "" {
+			v.add("commit.repository", "commit repository is required")
+		}
+		if f.Commit.SHA == "" {
+			v.add("commit.sha", "commit sha is required")
+		}
+	}
+
+	// The outputs declared by the jobs before, the key is stage.action.job[KEY].
+	outputs := map[string]bool{}
+	stageNames := map[string]bool{}
+
+	for si, stage := range f.Stages {
+		stagePath := fmt.Sprintf("stages[%d]", si)
+
+		if stage.Name == "" {
+			v.add(stagePath+".name", "stage name is required")
+		} else if stageNames[stage.Name] {
+			v.add(stagePath+".name", fmt.Sprintf("duplicate stage name %q", stage.Name))
+		}
+		stageNames[stage.Name] = true
+
+		switch stage.T {
+		case StartStage, EndStage, PauseStage:
+		case NormalStage:
+			if stage.Sequencing != Sequencing && stage.Sequencing != Parallel {
+				v.add(stagePath+".sequencing", fmt.Sprintf("unknown sequencing %q, it should be %s or %s",
+					stage.Sequencing, Sequencing, Parallel))
+			}
+			if len(stage.Actions) == 0 {
+				v.add(stagePath, "normal stage requires actions")
+			}
+		default:
+			v.add(stagePath+".type", fmt.Sprintf("unknown stage type %q", stage.T))
+		}
+
+		v.cluster(stagePath+".cluster", stage.Cluster)
+
+		switch stage.Run {
+		case "", RunOnSuccess, RunAlways, RunOnFailure:
+		default:
+			v.add(stagePath+".run", fmt.Sprintf("unknown run condition %q, it should be %s, %s or %s",
+				stage.Run, RunOnSuccess, RunAlways, RunOnFailure))
+		}
+
+		// The outputs of actions in a parallel stage are invisible to each other.
+		stageOutputs := map[string]bool{}
+		actionNames := map[string]bool{}
+
+		for ai, action := range stage.Actions {
+			actionPath := fmt.Sprintf("%s.actions[%d]", stagePath, ai)
+
+			if action.Name == "" {
+				v.add(actionPath+".name", "action name is required")
+			} else if actionNames[action.Name] {
+				v.add(actionPath+".name", fmt.Sprintf("duplicate action name %q in stage %q", action.Name, stage.Name))
+			}
+			actionNames[action.Name] = true
+
+			actionOutputs := map[string]bool{}
+			jobNames := map[string]bool{}
+
+			for ji, job := range action.Jobs {
+				jobPath := fmt.Sprintf("%s.jobs[%d]", actionPath, ji)
+
+				if job.Name != "" {
+					if jobNames[job.Name] {
+						v.add(jobPath+".name", fmt.Sprintf("duplicate job name %q in action %q", job.Name, action.Name))
+					}
+					jobNames[job.Name] = true
+				}
+
+				v.job(jobPath, &job)
+				v.expressions(jobPath, f, &job, outputs, actionOutputs)
+
+				for i, subscription := range job.Subscriptions {
+					for key := range subscription {
+						if subscriptionRegexp.MatchString(key) == false {
+							v.add(fmt.Sprintf("%s.subscriptions[%d].%s", jobPath, i, key),
+								fmt.Sprintf("invalid subscription %q, it should be stage.action.job[KEY]", key))
+						} else if outputs[key] == false && actionOutputs[key] == false {
+							v.add(fmt.Sprintf("%s.subscriptions[%d].%s", jobPath, i, key),
+								fmt.Sprintf("subscription %q doesn't reference an output of the jobs run before", key))
+						}
+					}
+				}
+
+				for _, o := range job.Outputs {
+					actionOutputs[fmt.Sprintf("%s.%s.%s[%s]", stage.Name, action.Name, job.Name, o)] = true
+				}
+			}
+
+			for key := range actionOutputs {
+				if stage.Sequencing == Parallel {
+					stageOutputs[key] = true
+				} else {
+					outputs[key] = true
+				}
+			}
+		}
+
+		for key := range stageOutputs {
+			outputs[key] = true
+		}
+	}
+
+	for i, trigger := range f.Triggers {
+		path := fmt.Sprintf("triggers[%d].flow", i)
+		if trigger.Flow == nil {
+			v.add(path, "flow trigger is required")
+			continue
+		}
+
+		if uriRegexp.MatchString(trigger.Flow.URI) == false {
+			v.add(path+".uri", fmt.Sprintf("invalid flow uri %q, it should be namespace/repository/flow", trigger.Flow.URI))
+		}
+
+		switch trigger.Flow.On {
+		case "", TriggerOnSuccess, TriggerOnFailure, TriggerOnAlways:
+		default:
+			v.add(path+".on", fmt.Sprintf("invalid trigger condition %q, it should be %s, %s or %s",
+				trigger.Flow.On, TriggerOnSuccess, TriggerOnFailure, TriggerOnAlways))
+		}
+
+		// The parameters are resolved after all stages finished.
+		for k, value := range trigger.Flow.Parameters {
+			for _, expression := range Expressions(value) {
+				v.reference(fmt.Sprintf("%s.parameters.%s", path, k), f, &Job{}, expression, []map[string]bool{outputs})
+			}
+		}
+	}
+
+	return v.errs
+}
+
+// validator collects the problems of flow with line numbers, the local files are checked when
+// local is true.
+type validator struct {
+	lines *YAMLLines
+	local bool
+	errs  ValidationErrors
+}
+
+func (v *validator) add(path, message string) {
+	v.errs = append(v.errs, ValidationError{Line: v.lines.Line(path), Path: path, Message: message})
+}
+
+func (v *validator) cluster(path, name string) {
+	if ClusterConfigured(name) == false {
+		v.add(path, fmt.Sprintf("unknown cluster %q, it isn't in the cluster config", name))
+	}
+}
+
+func (v *validator) job(path string, job *Job) {
+	switch job.T {
+	case "", ComponentJob, ScriptJob:
+	default:
+		v.add(path+".type", fmt.Sprintf("unknown job type %q, it should be %s or %s", job.T, ComponentJob, ScriptJob))
+		return
+	}
+
+	if job.Timeout < 0 {
+		v.add(path+".timeout", fmt.Sprintf("invalid timeout %d", job.Timeout))
+	}
+
+	v.cluster(path+".cluster", job.Cluster)
+
+	if job.Cache != nil && strings.TrimSpace(job.Cache.Key) == "" {
+		v.add(path+".cache.key", "cache key is required")
+	}
+
+	if job.JUnit != "" {
+		declared := false
+		for _, o := range job.Outputs {
+			if o == job.JUnit {
+				declared = true
+			}
+		}
+		if declared == false {
+			v.add(path+".junit", fmt.Sprintf("JUnit report %q isn't an output of the job", job.JUnit))
+		}
+	}
+
+	if job.Kubectl != "" && job.T == ScriptJob {
+		v.add(path+".kubectl", "script job doesn't create kubectl resources")
+		return
+	}
+
+	if job.Kubectl != "" && len(job.Services) > 0 {
+		v.add(path+".services", "kubectl job doesn't run services")
+		return
+	}
+
+	services := map[string]bool{}
+	for i, service := range job.Services {
+		v.service(fmt.Sprintf("%s.services[%d]", path, i), &service, services)
+	}
+
+	if job.Kubectl != "" {
+		// The path with expressions is only known when the job runs.
+		if HasExpressions(job.Kubectl) {
+			return
+		}
+
+		if u, err := url.Parse(job.Kubectl); err != nil {
+			v.add(path+".kubectl", fmt.Sprintf("invalid kubectl path %q: %s", job.Kubectl, err.Error()))
+		} else if u.Scheme == "" && v.local && utils.IsFileExist(job.Kubectl) == false {
+			v.add(path+".kubectl", fmt.Sprintf("kubectl file %q doesn't exist", job.Kubectl))
+		} else if u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https" {
+			v.add(path+".kubectl", fmt.Sprintf("unsupported kubectl URL scheme %q", u.Scheme))
+		}
+		return
+	}
+
+	switch job.T {
+	case ScriptJob:
+		if job.Endpoint != "" {
+			v.add(path+".endpoint", "script job runs the image, the endpoint isn't allowed")
+		}
+		if job.Image == "" {
+			v.add(path+".image", "image is required by script job")
+		} else if HasExpressions(job.Image) == false && imageRegexp.MatchString(job.Image) == false {
+			v.add(path+".image", fmt.Sprintf("invalid image reference %q", job.Image))
+		}
+		if strings.TrimSpace(job.Script) == "" {
+			v.add(path+".run", "run script is required by script job")
+		}
+	default:
+		if job.Endpoint == "" {
+			v.add(path+".endpoint", "endpoint image is required")
+		} else if HasExpressions(job.Endpoint) == false && imageRegexp.MatchString(job.Endpoint) == false {
+			v.add(path+".endpoint", fmt.Sprintf("invalid endpoint image reference %q", job.Endpoint))
+		}
+		if job.Image != "" {
+			v.add(path+".image", fmt.Sprintf("image is only allowed in %s job", ScriptJob))
+		}
+		if job.Script != "" {
+			v.add(path+".run", fmt.Sprintf("run is only allowed in %s job", ScriptJob))
+		}
+	}
+
+	if _, err := resource.ParseQuantity(job.Resources.CPU); err != nil {
+		v.add(path+".resources.cpu", fmt.Sprintf("invalid cpu quantity %q", job.Resources.CPU))
+	}
+	if _, err := resource.ParseQuantity(job.Resources.Memory); err != nil {
+		v.add(path+".resources.memory", fmt.Sprintf("invalid memory quantity %q", job.Resources.Memory))
+	}
+}
+
+func (v *validator) service(path string, service *Service, names map[string]bool) {
+	if service.Name == "" {
+		v.add(path+".name", "service name is required")
+	} else if serviceRegexp.MatchString(service.Name) == false {
+		v.add(path+".name", fmt.Sprintf("invalid service name %q, it should be lower case letters, digits and '-'", service.Name))
+	} else if names[service.Name] {
+		v.add(path+".name", fmt.Sprintf("duplicate service name %q", service.Name))
+	}
+	names[service.Name] = true
+
+	if service.Image == "" {
+		v.add(path+".image", "service image is required")
+	} else if HasExpressions(service.Image) == false && imageRegexp.MatchString(service.Image) == false {
+		v.add(path+".image", fmt.Sprintf("invalid service image reference %q", service.Image))
+	}
+
+	if service.Timeout < 0 {
+		v.add(path+".timeout", fmt.Sprintf("invalid timeout %d", service.Timeout))
+	}
+
+	if service.Resources.CPU != "" {
+		if _, err := resource.ParseQuantity(service.Resources.CPU); err != nil {
+			v.add(path+".resources.cpu", fmt.Sprintf("invalid cpu quantity %q", service.Resources.CPU))
+		}
+	}
+	if service.Resources.Memory != "" {
+		if _, err := resource.ParseQuantity(service.Resources.Memory); err != nil {
+			v.add(path+".resources.memory", fmt.Sprintf("invalid memory quantity %q", service.Resources.Memory))
+		}
+	}
+}
+
+// expressions checks the references of expressions in the endpoint, image, run, kubectl, cache
+// key, environments and services of job. The outputs are the outputs declared by the jobs run before.
+func (v *validator) expressions(path string, f *Flow, job *Job, outputs ...map[string]bool) {
+	fields := []string{path + ".endpoint", path + ".image", path + ".run", path + ".kubectl"}
+	values := map[string]string{path + ".endpoint": job.Endpoint, path + ".image": job.Image, path + ".run": job.Script, path + ".kubectl": job.Kubectl}
+	if job.Cache != nil {
+		fields, values[path+".cache.key"] = append(fields, path+".cache.key"), job.Cache.Key
+	}
+	for i, environment := range job.Environments {
+		keys := []string{}
+		for k := range environment {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			field := fmt.Sprintf("%s.environments[%d].%s", path, i, k)
+			fields, values[field] = append(fields, field), environment[k]
+		}
+	}
+
+	for i, service := range job.Services {
+		servicePath := fmt.Sprintf("%s.services[%d]", path, i)
+		fields, values[servicePath+".image"] = append(fields, servicePath+".image"), service.Image
+		for j, environment := range service.Environments {
+			keys := []string{}
+			for k := range environment {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+
+			for _, k := range keys {
+				field := fmt.Sprintf("%s.environments[%d].%s", servicePath, j, k)
+				fields, values[field] = append(fields, field), environment[k]
+			}
+		}
+	}
+
+	for _, field := range fields {
+		for _, expression := range Expressions(values[field]) {
+			v.reference(field, f, job, expression, outputs)
+		}
+	}
+}
+
+// reference checks the reference of an expression. The job is nil for the flow environments,
+// and their outputs references are resolved for each job.
+func (v *validator) reference(path string, f *Flow, job *Job, expression string, outputs []map[string]bool) {
+	scope, name, err := ParseExpression(expression)
+	if err != nil {
+		v.add(path, err.Error())
+		return
+	}
+
+	switch scope {
+	case OutputsScope:
+		if job == nil {
+			return
+		}
+		for _, o := range outputs {
+			if o[name] {
+				return
+			}
+		}
+		v.add(path, fmt.Sprintf("reference %q doesn't reference an output of the jobs run before", expression))
+	case ParametersScope:
+		if _, ok := f.Parameters[name]; ok == false {
+			v.add(path, fmt.Sprintf("reference %q to an undeclared parameter", expression))
+		}
+	case FlowScope:
+		for _, field := range FlowFields {
+			if field == name {
+				return
+			}
+		}
+		v.add(path, fmt.Sprintf("reference %q to an unknown flow field, it should be one of %s", expression, strings.Join(FlowFields, ", ")))
+	case EnvScope:
+		environments := f.Environments
+		if job != nil {
+			environments = append(append([]map[string]string{}, f.Environments...), job.Environments...)
+		}
+		for _, environment := range environments {
+			if _, ok := environment[name]; ok {
+				return
+			}
+		}
+		v.add(path, fmt.Sprintf("reference %q to an undefined environment", expression))
+	}
+}
+
+// YAMLLines locates the line number of path like stages[1].actions[0].name in a YAML
+// document. It supports the block style mappings and sequences used by flow definitions, the
+// flow style collections are located at their keys and the multi-line strings are skipped.
+type YAMLLines struct {
+	lines map[string]int
+}
+
+// yamlFrame is a mapping or sequence being scanned. The indent is -1 before the first
+// child line is found, and parent is the indent of the key or item owning the frame.
+type yamlFrame struct {
+	path   string
+	indent int
+	parent int
+	seq    bool
+	scalar bool
+	index  int
+}
+
+// NewYAMLLines scans the YAML data, it returns a empty locator when data is nil.
+func NewYAMLLines(data []byte) *YAMLLines {
+	y := &YAMLLines{lines: map[string]int{}}
+	stack := []*yamlFrame{{indent: 0, parent: -1}}
+
+	for number, line := range strings.Split(string(data), "\n") {
+		text := strings.TrimLeft(line, " ")
+		indent := len(line) - len(text)
+		text = strings.TrimRight(text, " \r\t")
+
+		if text == "" || strings.HasPrefix(text, "#") || text == "---" {
+			continue
+		}
+
+		for len(stack) > 1 {
+			top := stack[len(stack)-1]
+			item := strings.HasPrefix(text, "-")
+
+			if top.scalar {
+				if indent > top.parent {
+					break
+				}
+			} else if top.indent < 0 {
+				if indent > top.parent || (indent == top.parent && item) {
+					top.indent, top.seq = indent, item
+					break
+				}
+			} else if indent == top.indent && top.seq == item {
+				break
+			} else if indent > top.indent {
+				break
+			}
+
+			stack = stack[:len(stack)-1]
+		}
+
+		top := stack[len(stack)-1]
+		if top.scalar {
+			continue
+		}
+		y.scan(&stack, top, indent, text, number+1)
+	}
+
+	return y
+}
+
+// scan records the line of a sequence item or mapping key, and pushes the frame of its children.
+func (y *YAMLLines) scan(stack *[]*yamlFrame, top *yamlFrame, indent int, text string, number int) {
+	if top.seq {
+		if strings.HasPrefix(text, "-") == false {
+			return
+		}
+
+		top.index++
+		path := fmt.Sprintf("%s[%d]", top.path, top.index-1)
+		y.record(path, number)
+
+		rest := strings.TrimLeft(text[1:], " ")
+		if rest == "" {
+			*stack = append(*stack, &yamlFrame{path: path, indent: -1, parent: indent})
+			return
+		}
+
+		if _, _, ok := yamlKey(rest); ok {
+			frame := &yamlFrame{path: path, indent: indent + len(text) - len(rest), parent: indent}
+			*stack = append(*stack, frame)
+			y.scan(stack, frame, frame.indent, rest, number)
+		}
+		return
+	}
+
+	key, value, ok := yamlKey(text)
+	if ok == false {
+		return
+	}
+
+	path := key
+	if top.path != "" {
+		path = fmt.Sprintf("%s.%s", top.path, key)
+	}
+	y.record(path, number)
+
+	switch {
+	case value == "":
+		*stack = append(*stack, &yamlFrame{path: path, indent: -1, parent: indent})
+	case strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">") || yamlQuoteOpen(value):
+		*stack = append(*stack, &yamlFrame{path: path, parent: indent, scalar: true})
+	}
+}
+
+// yamlQuoteOpen is true when the value is a quoted string continued on the next lines.
+func yamlQuoteOpen(value string) bool {
+	if value == "" || (value[0] != '"' && value[0] != '\'') {
+		return false
+	}
+
+	quote := value[0]
+	for i := 1; i < len(value); i++ {
+		switch {
+		case quote == '"' && value[i] == '\\':
+			i++
+		case quote == '\'' && value[i] == '\'' && i+1 < len(value) && value[i+1] == '\'':
+			i++
+		case value[i] == quote:
+			return false
+		}
+	}
+
+	return true
+}
+
+func (y *YAMLLines) record(path string, number int) {
+	if _, ok := y.lines[path]; ok == false {
+		y.lines[path] = number
+	}
+}
+
+// Line returns the line of path, or the line of its nearest parent. It's 0 when unknown.
+func (y *YAMLLines) Line(path string) int {
+	for path != "" {
+		if number, ok := y.lines[path]; ok {
+			return number
+		}
+
+		if i := strings.LastIndexAny(path, ".["); i > 0 {
+			path = path[:i]
+		} else {
+			break
+		}
+	}
+
+	return 0
+}
+
+// yamlKey splits the key and value of a mapping line, the comment of value is removed.
+func yamlKey(text string) (key, value string, ok bool) {
+	if strings.HasPrefix(text, "-") || strings.HasPrefix(text, "[") || strings.HasPrefix(text, "{") {
+		return "", "", false
+	}
+
+	end := -1
+	if text[0] == '"' || text[0] == '\'' {
+		if i := strings.IndexByte(text[1:], text[0]); i >= 0 {
+			key, text = text[1:i+1], text[i+2:]
+			end = 0
+		}
+	}
+
+	if end < 0 {
+		for i := 0; i < len(text); i++ {
+			if text[i] == ':' && (i == len(text)-1 || text[i+1] == ' ') {
+				key, text = text[:i], text[i:]
+				end = 0
+				break
+			}
+		}
+	}
+
+	if end < 0 || strings.HasPrefix(text, ":") == false {
+		return "", "", false
+	}
+
+	value = strings.TrimSpace(text[1:])
+	if strings.HasPrefix(value, "#") {
+		value = ""
+	}
+
+	return strings.TrimSpace(key), value, true
+}
@@ -0,0 +1,271 @@
+/*
+Copyright 2016 - 2017 Huawei Technologies Co., Ltd. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package module
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestYAMLLines(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		lines map[string]int
+	}{
+		{
+			name: "block mappings and sequences",
+			data: `uri: cncf/demo/hello
+tag: latest
+stages:
+  - type: start
+    name: start
+  - type: normal
+    name: build
+    actions:
+      - name: compile
+        jobs:
+          - type: component
+            name: go-build
+`,
+			lines: map[string]int{
+				"uri":                                1,
+				"tag":                                2,
+				"stages":                             3,
+				"stages[0]":                          4,
+				"stages[0].type":                     4,
+				"stages[0].name":                     5,
+				"stages[1].name":                     7,
+				"stages[1].actions[0].name":          9,
+				"stages[1].actions[0].jobs[0].type":  11,
+				"stages[1].actions[0].jobs[0].name":  12,
+				"stages[1].actions[0].jobs[0].image": 11,
+			},
+		},
+		{
+			name: "sequence at the indent of its key",
+			data: `stages:
+- type: start
+  name: start
+- type: normal
+  name: build
+timeout: 60
+`,
+			lines: map[string]int{
+				"stages[0].name": 3,
+				"stages[1].type": 4,
+				"stages[1].name": 5,
+				"timeout":        6,
+			},
+		},
+		{
+			name: "flow sequences and mappings",
+			data: `uri: cncf/demo/hello
+environments: [{CO_DATA: "go-build"}, {CO_ENV: test}]
+include: [common.yml, services.yml]
+stages:
+  - {type: start, name: start}
+  - type: normal
+    name: build
+`,
+			lines: map[string]int{
+				"environments":    2,
+				"environments[1]": 2,
+				"include[0]":      3,
+				"stages[0]":       5,
+				"stages[0].name":  5,
+				"stages[1].name":  7,
+			},
+		},
+		{
+			name: "quoted keys and comments",
+			data: `# The demo flow
+"uri": cncf/demo/hello # the uri
+'tag': latest
+parameters:
+  "CO_URL": http://example.com/a:b
+  # a comment: not a key
+  'CO_NAME': hello
+title: "Hello: World"
+`,
+			lines: map[string]int{
+				"uri":                2,
+				"tag":                3,
+				"parameters.CO_URL":  5,
+				"parameters.CO_NAME": 7,
+				"title":              8,
+			},
+		},
+		{
+			name: "multi-line strings",
+			data: `stages:
+  - type: normal
+    name: test
+    actions:
+      - name: script
+        jobs:
+          - type: script
+            run: |
+              echo "name: not a key"
+              - not an item
+            title: >-
+              a folded
+              title: not a key
+            image: "alpine:3.7
+              name: in a quoted string"
+            name: lint
+  - type: end
+    name: end
+`,
+			lines: map[string]int{
+				"stages[0].actions[0].jobs[0].run":   8,
+				"stages[0].actions[0].jobs[0].title": 11,
+				"stages[0].actions[0].jobs[0].image": 14,
+				"stages[0].actions[0].jobs[0].name":  16,
+				"stages[1].name":                     18,
+			},
+		},
+		{
+			name: "empty document",
+			data: ``,
+			lines: map[string]int{
+				"uri": 0,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		y := NewYAMLLines([]byte(test.data))
+		for path, want := range test.lines {
+			if got := y.Line(path); got != want {
+				t.Errorf("%s: Line(%q) = %d, want %d", test.name, path, got, want)
+			}
+		}
+	}
+}
+
+func TestValidateTriggerURI(t *testing.T) {
+	tests := []struct {
+		uri   string
+		valid bool
+	}{
+		{"cncf/demo/deploy", true},
+		{"cncf/demo.v2/deploy_prod", true},
+		{"cncf/demo", false},
+		{"cncf//deploy", false},
+		{"cncf/demo/deploy/", false},
+		{"cncf/demo/deploy prod", false},
+		{"/demo/deploy", false},
+	}
+
+	for _, test := range tests {
+		data := fmt.Sprintf("uri: cncf/demo/build\ntriggers:\n  - flow:\n      uri: %q\n", test.uri)
+		f := &Flow{URI: "cncf/demo/build", Triggers: []Trigger{{Flow: &FlowTrigger{URI: test.uri}}}}
+
+		found := false
+		for _, e := range f.Validate([]byte(data)) {
+			if strings.Contains(e.Message, "invalid flow uri") {
+				found = true
+				if e.Line != 4 {
+					t.Errorf("Trigger uri %q is reported at line %d, want 4", test.uri, e.Line)
+				}
+			}
+		}
+		if found == test.valid {
+			t.Errorf("Trigger uri %q valid = %t, want %t", test.uri, found == false, test.valid)
+		}
+	}
+}
+
+func TestValidateJobType(t *testing.T) {
+	tests := []struct {
+		jobType string
+		valid   bool
+	}{
+		{"", true},
+		{ComponentJob, true},
+		{ScriptJob, true},
+		{"shell", false},
+		{"Component", false},
+	}
+
+	for _, test := range tests {
+		data := fmt.Sprintf(`uri: cncf/demo/build
+stages:
+  - type: normal
+    name: build
+    sequencing: sequence
+    actions:
+      - name: compile
+        jobs:
+          - type: %q
+            name: go-build
+            image: golang:1.9
+            endpoint: hub.opshub.sh/containerops/go-build:latest
+            run: go build ./...
+            resources:
+              cpu: "1"
+              memory: 1Gi
+`, test.jobType)
+
+		errs := new(Flow).ParseYAML([]byte(data), "flow.yml")
+		found := false
+		for _, e := range errs {
+			if e.Path == "stages[0].actions[0].jobs[0].type" {
+				found = true
+				if e.Line != 9 {
+					t.Errorf("Job type %q is reported at line %d, want 9", test.jobType, e.Line)
+				}
+			}
+		}
+		if found == test.valid {
+			t.Errorf("Job type %q valid = %t, want %t: %v", test.jobType, found == false, test.valid, errs)
+		}
+	}
+}
+
+func TestValidatePostedKubectl(t *testing.T) {
+	data := []byte(`uri: cncf/demo/deploy
+stages:
+  - type: normal
+    name: deploy
+    sequencing: sequence
+    actions:
+      - name: apply
+        jobs:
+          - type: component
+            name: kubectl
+            kubectl: /nonexistent/deployment.yaml
+`)
+
+	missing := func(errs ValidationErrors) bool {
+		for _, e := range errs {
+			if e.Path == "stages[0].actions[0].jobs[0].kubectl" {
+				return true
+			}
+		}
+		return false
+	}
+
+	if errs := new(Flow).ParseYAML(data, "flow.yml"); missing(errs) == false {
+		t.Errorf("Missing kubectl file of the local flow isn't reported: %v", errs)
+	}
+	if errs := new(Flow).ParseYAML(data, ""); missing(errs) {
+		t.Errorf("Kubectl file of the posted flow is checked on the daemon: %v", errs)
+	}
+}
@@ -20,28 +20,51 @@ import (
 	"gopkg.in/macaron.v1"
 
 	"github.com/Huawei/containerops/pilotage/handler"
+	"github.com/Huawei/containerops/pilotage/middleware"
+	"github.com/Huawei/containerops/pilotage/module"
 )
 
 // SetRunDaemonRouters is
 func SetRunDaemonRouters(m *macaron.Macaron) {
+	m.Get("/metrics", middleware.Authorize(module.ActionRead), handler.GetMetrics)
+
 	m.Group("/flow", func() {
 		m.Group("/v1", func() {
-			m.Get("/:namespace/:repository/:flow/:tag/:number/runtime/:type", handler.GetFlowRuntime)
+			m.Get("/:namespace/:repository/:flow/:tag/:number/runtime/:type", middleware.Authorize(module.ActionRead), handler.GetFlowRuntime)
 		})
 	})
 }
 
 // SetStartDaemonRouters is
 func SetStartDaemonRouters(m *macaron.Macaron) {
+	m.Get("/metrics", middleware.Authorize(module.ActionRead), handler.GetMetrics)
+
 	m.Group("/flow", func() {
 		m.Group("/v1", func() {
-			m.Post("/:namespace/:repository/:flow/:tag/:type", handler.PostFlowRuntime)
+			m.Get("/runs", middleware.Authenticate(module.ActionRead), handler.GetFlowRuns)
+			m.Get("/runs/:id", middleware.Authorize(module.ActionRead), handler.GetFlowRun)
+			m.Get("/runs/:id/logs", middleware.Authorize(module.ActionRead), handler.GetFlowRunLogs)
+			m.Get("/runs/:id/outputs", middleware.Authorize(module.ActionRead), handler.GetFlowRunOutputs)
+			m.Post("/runs/:id/cancel", middleware.Authorize(module.ActionCancel), handler.PostFlowRunCancel)
+			m.Post("/runs/:id/approve", middleware.Authorize(module.ActionApprove), handler.PostFlowRunApprove)
+			m.Get("/analytics", middleware.Authorize(module.ActionRead), handler.GetAnalytics)
+			m.Get("/clusters", middleware.Authorize(module.ActionRead), handler.GetClusters)
+			m.Get("/sync", middleware.Authorize(module.ActionRead), handler.GetSync)
+			m.Post("/sync", middleware.Authorize(module.ActionRun), handler.PostSync)
+			m.Get("/:namespace/:repository/:flow/:tag/runs", middleware.Authorize(module.ActionRead), handler.GetFlowHistory)
+			m.Get("/:namespace/:repository/:flow/:tag/analytics", middleware.Authorize(module.ActionRead), handler.GetFlowAnalytics)
+			m.Get("/:namespace/:repository/:flow/:tag/badge.svg", middleware.Authorize(module.ActionRead), handler.GetFlowBadge)
+			m.Get("/:namespace/:repository/:flow/:tag/tests", middleware.Authorize(module.ActionRead), handler.GetFlowTests)
+			m.Get("/:namespace/:repository/:flow/:tag/:number/tests", middleware.Authorize(module.ActionRead), handler.GetFlowRunTests)
+			m.Get("/:namespace/:repository/:flow/:tag/:number/archive", middleware.Authorize(module.ActionRead), handler.GetFlowRunArchive)
+			m.Post("/:namespace/:repository/:flow/:tag/:number/rerun", middleware.Authorize(module.ActionRun), handler.PostFlowRerun)
+			m.Post("/:namespace/:repository/:flow/:tag/:type", middleware.Authorize(module.ActionRun), handler.PostFlowRuntime)
 		})
 	})
 
 	m.Group("/hook", func() {
 		m.Group("/v1", func() {
-			m.Post("/:namespace/:repository/:flow/:tag", handler.WebHook)
+			m.Post("/:namespace/:repository/:flow/:tag", middleware.Authorize(module.ActionRun), handler.WebHook)
 		})
 	})
 }